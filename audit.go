@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/internal/audit"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+const auditUsage = `Usage:
+  thermostat [-d <name>] audit [--limit 20] [--since 24h]
+
+Lists the changes made to the thermostats, newest first: when, who made
+them and from where, what was asked for and how the thermostat changed.
+Changes from this command, the web server, its scheduler and the MQTT
+bridge are all recorded. Without -d every thermostat is listed. --since
+only lists changes newer than that; 0 lists them all.`
+
+func run_audit(log *audit.Log, device string, unit ct50.Unit, args []string) error {
+	flags := flag.NewFlagSet("audit", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Println(auditUsage) }
+	limit := flags.Int("limit", 20, "most changes to list")
+	since := flags.Duration("since", 24*time.Hour, "list changes newer than this")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return errors.New(auditUsage)
+	}
+
+	q := audit.Query{Device: device, Limit: *limit}
+	if *since > 0 {
+		q.Since = time.Now().Add(-*since)
+	}
+	entries, err := log.Read(q)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Println("No changes")
+		return nil
+	}
+
+	for _, e := range entries {
+		who := e.Actor.String()
+		if e.IP != "" {
+			who += " from " + e.IP
+		}
+		fmt.Printf("%s  %-12s %s: %s\n", e.Time.Local().Format("2006-01-02 15:04:05"), e.Device, who, e.Action)
+		fmt.Println("    " + audit_change(e, unit))
+	}
+	return nil
+}
+
+// audit_change describes what a change did, such as "Heat 68°F → 72°F,
+// Hold Off → On".
+func audit_change(e audit.Entry, unit ct50.Unit) string {
+	if e.Error != "" {
+		return "failed: " + e.Error
+	}
+	if e.Before == nil || e.After == nil {
+		return "sent " + string(e.Change)
+	}
+
+	// The thermostat only reports the setpoints the mode uses, so a
+	// setpoint missing on either side is left out.
+	temp := func(f float64) string {
+		if f == 0 {
+			return ""
+		}
+		return unit.Format(unit.Setpoint(f))
+	}
+	var changes []string
+	for _, field := range []struct{ name, before, after string }{
+		{"Mode", e.Before.Tmode.String(), e.After.Tmode.String()},
+		{"Heat", temp(e.Before.THeat), temp(e.After.THeat)},
+		{"Cool", temp(e.Before.TCool), temp(e.After.TCool)},
+		{"Fan", e.Before.Fmode.String(), e.After.Fmode.String()},
+		{"Hold", e.Before.Hold.String(), e.After.Hold.String()},
+	} {
+		if field.before != field.after && field.before != "" && field.after != "" {
+			changes = append(changes, field.name+" "+field.before+" → "+field.after)
+		}
+	}
+	if len(changes) == 0 {
+		return "no change"
+	}
+	return strings.Join(changes, ", ")
+}
+
+// cli_origin is who is running this command, for the audit log: their
+// login and the machine they are on.
+func cli_origin() audit.Origin {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		name += "@" + host
+	}
+	return audit.Origin{
+		Actor:  audit.Actor{Kind: audit.CLI, Name: name},
+		Action: "thermostat " + strings.Join(os.Args[1:], " "),
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/internal/boost"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+const boostUsage = `Usage:
+  thermostat boost --temp 74 --for 2h [--mode heat|cool]
+  thermostat boost
+  thermostat boost cancel
+
+A boost holds the thermostat at --temp for the time given with --for, up to
+24h, and then puts back the mode, setpoint and hold it had before. With no
+flags, boost shows the running boost. Cancel ends it now.
+
+The web server ends boosts on time. Without it, run 'thermostat boost' once
+the boost is over to put the old setting back.`
+
+func run_boost(ctx context.Context, client *ct50.Client, store *boost.Store, device string, unit ct50.Unit, args []string) error {
+	if len(args) > 0 && args[0] == "cancel" {
+		return boost_end(ctx, client, store, device, unit, true)
+	}
+
+	flags := flag.NewFlagSet("boost", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Println(boostUsage) }
+	temp := flags.Float64("temp", 0, "temperature to hold")
+	length := flags.Duration("for", 0, "how long to hold it, such as 90m or 2h")
+	mode := flags.String("mode", "", "heat or cool (default: the current mode)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return errors.New(boostUsage)
+	}
+
+	if *temp == 0 && *length == 0 && *mode == "" {
+		return boost_end(ctx, client, store, device, unit, false)
+	}
+
+	if *temp == 0 || *length <= 0 {
+		return errors.New("boost needs both --temp and --for")
+	}
+	if *length > boost.MaxDuration {
+		return fmt.Errorf("a boost can last at most %v", boost.MaxDuration)
+	}
+
+	tmode := ct50.ModeOff
+	if *mode != "" {
+		var err error
+		if tmode, err = ct50.ParseMode(*mode); err != nil {
+			return err
+		}
+		if tmode != ct50.ModeHeat && tmode != ct50.ModeCool {
+			return errors.New("boost --mode must be heat or cool")
+		}
+	}
+
+	running, err := store.Get(device)
+	if err != nil {
+		return err
+	}
+
+	until := time.Now().Add(*length)
+	b, err := boost.Start(ctx, client, device, tmode, unit.ToDevice(*temp), until, running)
+	if err != nil {
+		return err
+	}
+	if err := store.Put(b); err != nil {
+		return err
+	}
+
+	fmt.Println("Boosting " + b.Mode.String() + " to " + unit.Format(unit.Setpoint(b.Temp)) + " until " + until.Format("Mon 15:04"))
+	return nil
+}
+
+// boost_end shows the running boost, ending it if it is over or if cancel
+// is set.
+func boost_end(ctx context.Context, client *ct50.Client, store *boost.Store, device string, unit ct50.Unit, cancel bool) error {
+	b, err := store.Get(device)
+	if err != nil {
+		return err
+	}
+	if b == nil {
+		fmt.Println("No boost running")
+		return nil
+	}
+
+	now := time.Now()
+	if !cancel && !b.Expired(now) {
+		fmt.Printf("Boosted %s to %s until %s (%v left)\n", b.Mode, unit.Format(unit.Setpoint(b.Temp)), b.Until.Format("Mon 15:04"), b.Remaining(now).Round(time.Minute))
+		return nil
+	}
+
+	restored, err := store.Finish(ctx, client, b)
+	if err != nil {
+		return err
+	}
+	if restored {
+		fmt.Println("Boost ended; restored " + b.Prior.Tmode.String() + " mode")
+	} else {
+		fmt.Println("Boost ended; the thermostat was changed during the boost, so it was left as it is")
+	}
+	return nil
+}
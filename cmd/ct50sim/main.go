@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50sim"
+)
+
+const SimVersion = "1.0.0"
+
+// logRequests logs each request the emulator answers.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("%s %s", r.Method, r.URL.Path)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeTraceOnExit saves the house model's trace to path when the emulator
+// is stopped with Ctrl+C.
+func writeTraceOnExit(dev *ct50sim.Device, path string) {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-stop
+		f, err := os.Create(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := ct50sim.WriteTraceCSV(f, dev.Trace()); err != nil {
+			log.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Temperature trace written to " + path)
+		os.Exit(0)
+	}()
+}
+
+func main() {
+	var port string
+	var scenarioFile string
+	var traceFile string
+	var opts ct50sim.Options
+
+	// Parse CLI Flags
+	flag.StringVar(&port, "port", "8081", "port to serve the emulated thermostat on")
+	flag.Float64Var(&opts.Temp, "temp", 68, "starting room temperature in degrees F")
+	flag.Float64Var(&opts.Humidity, "humidity", 45, "relative humidity to report")
+	flag.StringVar(&opts.Name, "name", "Thermostat", "device name to report from /sys/name")
+	flag.StringVar(&opts.Model, "model", "CT50 V1.94", "model to report from /tstat/model")
+	flag.StringVar(&opts.UUID, "uuid", "5cdad4000000", "UUID to report from /sys")
+	flag.StringVar(&scenarioFile, "scenario", "", "YAML file of faults to inject into replies")
+	house := flag.Bool("house", false, "model a house heated and cooled by the thermostat instead of a fixed temperature")
+	outdoorLow := flag.Float64("outdoor-low", 30, "overnight low outdoor temperature for -house")
+	outdoorHigh := flag.Float64("outdoor-high", 50, "daytime high outdoor temperature for -house")
+	speed := flag.Float64("speed", 1, "run the clock this many times faster than real time (1440 passes a day in a minute)")
+	flag.StringVar(&traceFile, "trace", "", "write the -house temperature trace to this CSV file on exit")
+	showVer := flag.Bool("v", false, "Show Version")
+	flag.Parse()
+
+	// Print Version of app
+	if *showVer {
+		fmt.Println("CT50 Thermostat Emulator Version: " + SimVersion)
+		return
+	}
+
+	if scenarioFile != "" {
+		scenario, err := ct50sim.LoadScenario(scenarioFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.Scenario = scenario
+		opts.Logf = log.Printf
+	}
+
+	if *house {
+		opts.House = ct50sim.DefaultHouse(*outdoorLow, *outdoorHigh)
+	}
+	if *speed <= 0 {
+		log.Fatal("-speed must be greater than zero")
+	}
+	if *speed != 1 {
+		opts.Now = ct50sim.Accelerated(time.Now(), *speed)
+	}
+
+	dev := ct50sim.New(opts)
+
+	if traceFile != "" {
+		writeTraceOnExit(dev, traceFile)
+	}
+
+	addr := ":" + port
+	fmt.Printf("Starting CT50 Thermostat Emulator v%s\n", SimVersion)
+	fmt.Printf("Emulated thermostat listening on http://localhost%s\n", addr)
+	if opts.House != nil {
+		fmt.Printf("Modelling a house with outdoor temperatures from %v to %v, trace at http://localhost%s/sim/trace\n", *outdoorLow, *outdoorHigh, addr)
+	}
+	if *speed != 1 {
+		fmt.Printf("Clock running at %vx real time\n", *speed)
+	}
+	if opts.Scenario != nil {
+		fmt.Printf("Injecting %d fault rule(s) from %s\n", len(opts.Scenario.Faults), scenarioFile)
+	}
+	fmt.Printf("Point the web server at it with: -ip localhost%s\n", addr)
+	fmt.Println("Press Ctrl+C to stop")
+
+	log.Fatal(http.ListenAndServe(addr, logRequests(dev)))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/internal/audit"
+	"github.com/EntropySynthetica/Thermostat/internal/config"
+	"github.com/EntropySynthetica/Thermostat/internal/mqttbridge"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+const BridgeVersion = "1.0.0"
+
+func main() {
+	var configFile string
+	var unitFlag string
+	var opts mqttbridge.Options
+
+	// Parse CLI Flags
+	flag.StringVar(&configFile, "c", config.DefaultPath(), "specify path of config file")
+	flag.StringVar(&opts.Broker, "broker", "tcp://localhost:1883", "MQTT broker URL (tcp://, ssl://, ws:// or wss://)")
+	flag.StringVar(&opts.ClientID, "client-id", mqttbridge.DefaultClientID, "MQTT client ID, unique on the broker")
+	flag.StringVar(&opts.Username, "username", "", "MQTT username (the password is read from MQTT_PASSWORD)")
+	flag.StringVar(&opts.Prefix, "prefix", mqttbridge.DefaultPrefix, "topic prefix for state and commands")
+	flag.StringVar(&opts.DiscoveryPrefix, "discovery-prefix", "homeassistant", "Home Assistant discovery prefix (empty turns discovery off)")
+	flag.DurationVar(&opts.Interval, "interval", mqttbridge.DefaultInterval, "how often to poll each thermostat")
+	flag.StringVar(&unitFlag, "unit", "", "temperature unit for MQTT: F or C (overrides config file)")
+	showVer := flag.Bool("v", false, "Show Version")
+	flag.Parse()
+
+	// Print Version of app
+	if *showVer {
+		fmt.Println("CT50 Thermostat MQTT Bridge Version: " + BridgeVersion)
+		return
+	}
+
+	// Kept out of the flags so it does not show up in the process list.
+	opts.Password = os.Getenv("MQTT_PASSWORD")
+
+	configData, err := config.Load(configFile)
+	if err != nil {
+		log.Fatalf("Error loading config file: %v\nRun 'thermostat --new' to create one", err)
+	}
+
+	opts.Unit = configData.TempUnit()
+	if unitFlag != "" {
+		if opts.Unit, err = ct50.ParseUnit(unitFlag); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	auditLog := audit.NewLog(audit.DefaultPath())
+	var devices []mqttbridge.Device
+	for _, dev := range configData.DeviceList() {
+		client := ct50.New(dev.IP)
+		client.Deadband = configData.MinDeadband()
+		auditLog.Watch(client, dev.Name)
+		devices = append(devices, mqttbridge.Device{Name: dev.Name, Client: client})
+	}
+
+	bridge, err := mqttbridge.New(opts, devices)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Starting Thermostat MQTT Bridge v%s\n", BridgeVersion)
+	for _, dev := range configData.DeviceList() {
+		fmt.Printf("Thermostat %s: %s on %s/%s\n", dev.Name, dev.IP, opts.Prefix, mqttbridge.Slug(dev.Name))
+	}
+	fmt.Printf("Broker: %s, polling every %v\n", opts.Broker, opts.Interval.Round(time.Second))
+	fmt.Println("Press Ctrl+C to stop")
+
+	// Stopping cleanly marks the bridge offline on the broker.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := bridge.Run(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/internal/audit"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+// auditLog records every change sent to the thermostats. The CLI and the
+// MQTT bridge write to the same file.
+var auditLog = audit.NewLog(audit.DefaultPath())
+
+// automation is the audit actor for changes the web server makes on its
+// own, such as ending a boost.
+func automation(name string) audit.Actor {
+	return audit.Actor{Kind: audit.Automation, Name: name}
+}
+
+// requestOrigin returns who made a request and from where, for the audit
+// log: the API token it used, the signed in user, or no one if the server
+// has no users.
+func requestOrigin(r *http.Request) audit.Origin {
+	o := audit.Origin{IP: remoteIP(r), Action: r.Method + " " + r.URL.Path}
+	if t := requestToken(r); t != nil {
+		o.Actor = audit.Actor{Kind: audit.Token, Name: t.Name + " (" + t.ID + ")"}
+	} else if u := requestUser(r); u != nil {
+		o.Actor = audit.Actor{Kind: audit.User, Name: u.Name}
+	} else {
+		o.Actor = audit.Actor{Kind: audit.Anonymous}
+	}
+	return o
+}
+
+// remoteIP returns the address a request came from, without its port.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// AuditState is a thermostat's state in an /api/audit entry.
+// Temperatures are in the entry's unit.
+type AuditState struct {
+	Mode     string  `json:"mode"`
+	HeatTemp float64 `json:"heatTemp,omitempty"`
+	CoolTemp float64 `json:"coolTemp,omitempty"`
+	FanMode  string  `json:"fanMode"`
+	Hold     string  `json:"hold"`
+}
+
+func auditState(s *audit.State, unit ct50.Unit) *AuditState {
+	if s == nil {
+		return nil
+	}
+	a := &AuditState{
+		Mode:    s.Tmode.String(),
+		FanMode: s.Fmode.String(),
+		Hold:    s.Hold.String(),
+	}
+	if s.THeat != 0 {
+		a.HeatTemp = unit.Setpoint(s.THeat)
+	}
+	if s.TCool != 0 {
+		a.CoolTemp = unit.Setpoint(s.TCool)
+	}
+	return a
+}
+
+// AuditEntry is one entry in the /api/audit list.
+type AuditEntry struct {
+	Time   time.Time       `json:"time"`
+	Device string          `json:"device"`
+	Actor  audit.Actor     `json:"actor"`
+	Who    string          `json:"who"`
+	IP     string          `json:"ip,omitempty"`
+	Action string          `json:"action,omitempty"`
+	Change json.RawMessage `json:"change"`
+	Unit   ct50.Unit       `json:"unit"`
+	Before *AuditState     `json:"before,omitempty"`
+	After  *AuditState     `json:"after,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// handleAudit lists the changes made to the thermostats, newest first.
+// ?device= picks one thermostat, ?since= (RFC 3339) drops older changes
+// and ?limit= caps how many are returned, 100 by default. Viewers cannot
+// see the log, since it names everyone who uses the thermostats and where
+// from.
+func handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if u := requestUser(r); u != nil && !u.Role.CanWrite() {
+		http.Error(w, "Your account can only view the thermostats", http.StatusForbidden)
+		return
+	}
+	unit, err := requestUnit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	q := audit.Query{Device: r.URL.Query().Get("device"), Limit: 100}
+	if q.Device != "" && findDevice(q.Device) == nil {
+		http.Error(w, "Unknown thermostat "+q.Device, http.StatusNotFound)
+		return
+	}
+	if s := r.URL.Query().Get("since"); s != "" {
+		if q.Since, err = time.Parse(time.RFC3339, s); err != nil {
+			http.Error(w, "Invalid since time: use RFC 3339, such as 2024-01-15T08:00:00Z", http.StatusBadRequest)
+			return
+		}
+	}
+	if s := r.URL.Query().Get("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil || q.Limit < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := auditLog.Read(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	list := make([]AuditEntry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, AuditEntry{
+			Time:   e.Time,
+			Device: e.Device,
+			Actor:  e.Actor,
+			Who:    e.Actor.String(),
+			IP:     e.IP,
+			Action: e.Action,
+			Change: e.Change,
+			Unit:   unit,
+			Before: auditState(e.Before, unit),
+			After:  auditState(e.After, unit),
+			Error:  e.Error,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func handleAuditPage(w http.ResponseWriter, r *http.Request) {
+	tmpl := template.Must(template.New("audit").Parse(auditHTML))
+	tmpl.Execute(w, nil)
+}
+
+const auditHTML = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Audit Log - Thermostat</title>
+    <script src="/auth.js"></script>
+    <style>
+        * {
+            margin: 0;
+            padding: 0;
+            box-sizing: border-box;
+        }
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Oxygen, Ubuntu, Cantarell, sans-serif;
+            background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
+            min-height: 100vh;
+            display: flex;
+            justify-content: center;
+            align-items: flex-start;
+            padding: 20px;
+        }
+        .container {
+            background: white;
+            border-radius: 20px;
+            box-shadow: 0 20px 60px rgba(0, 0, 0, 0.3);
+            padding: 40px;
+            max-width: 900px;
+            width: 100%;
+        }
+        h1 {
+            text-align: center;
+            color: #333;
+            margin-bottom: 30px;
+            font-size: 2em;
+        }
+        .filter {
+            display: flex;
+            gap: 10px;
+            align-items: center;
+            color: #666;
+            margin-bottom: 20px;
+        }
+        .filter select {
+            padding: 8px;
+            border: 2px solid #ddd;
+            border-radius: 10px;
+            font-size: 1em;
+            background: white;
+        }
+        table {
+            width: 100%;
+            border-collapse: collapse;
+            font-size: 0.9em;
+        }
+        th {
+            text-align: left;
+            color: #666;
+            font-weight: 600;
+            padding: 8px;
+            border-bottom: 2px solid #ddd;
+        }
+        td {
+            padding: 8px;
+            border-bottom: 1px solid #eee;
+            vertical-align: top;
+            color: #333;
+        }
+        .detail {
+            color: #666;
+            font-size: 0.85em;
+        }
+        .failed {
+            color: #721c24;
+            font-weight: 600;
+        }
+        .empty {
+            color: #666;
+            text-align: center;
+            padding: 20px;
+        }
+        .message {
+            padding: 15px;
+            border-radius: 10px;
+            margin-top: 15px;
+            text-align: center;
+            font-weight: 600;
+            display: none;
+        }
+        .message.error {
+            background: #f8d7da;
+            color: #721c24;
+        }
+        .nav-link {
+            display: block;
+            text-align: center;
+            color: #667eea;
+            text-decoration: none;
+            font-weight: 600;
+            margin-top: 20px;
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>📜 Audit Log</h1>
+        <div class="filter">
+            <label for="auditDevice">Thermostat</label>
+            <select id="auditDevice" onchange="loadAudit()">
+                <option value="">All</option>
+            </select>
+        </div>
+        <table>
+            <thead>
+                <tr><th>When</th><th>Who</th><th>What</th><th>Change</th></tr>
+            </thead>
+            <tbody id="entries"></tbody>
+        </table>
+        <div class="message" id="message"></div>
+        <a class="nav-link" href="/">← Back to Thermostat</a>
+    </div>
+    <script>
+        const currentUnit = localStorage.getItem('thermostatUnit') || '';
+
+        function showMessage(text, type) {
+            const msg = document.getElementById('message');
+            msg.textContent = text;
+            msg.className = 'message ' + type;
+            msg.style.display = 'block';
+        }
+
+        async function loadDevices() {
+            const response = await fetch('/api/devices');
+            if (!response.ok) throw new Error('Failed to load thermostats');
+            const picker = document.getElementById('auditDevice');
+            (await response.json()).forEach(d => {
+                const option = document.createElement('option');
+                option.value = d.name;
+                option.textContent = d.name;
+                picker.appendChild(option);
+            });
+        }
+
+        // describeChange lists what differs between the state before and
+        // after a change, such as "Heat 68°F → 72°F".
+        function describeChange(e) {
+            if (e.error) return 'Failed: ' + e.error;
+            if (!e.before || !e.after) return 'Sent ' + JSON.stringify(e.change);
+            const symbol = e.unit === 'C' ? '°C' : '°F';
+            const fields = [
+                ['Mode', 'mode', ''],
+                ['Heat', 'heatTemp', symbol],
+                ['Cool', 'coolTemp', symbol],
+                ['Fan', 'fanMode', ''],
+                ['Hold', 'hold', '']
+            ];
+            const changes = [];
+            fields.forEach(([label, key, suffix]) => {
+                // Only the setpoints the mode uses are reported.
+                const before = e.before[key], after = e.after[key];
+                if (before !== undefined && after !== undefined && before !== after) {
+                    changes.push(label + ' ' + before + suffix + ' → ' + after + suffix);
+                }
+            });
+            return changes.length ? changes.join(', ') : 'No change';
+        }
+
+        function cell(row, text, detail, className) {
+            const td = document.createElement('td');
+            td.textContent = text;
+            if (className) td.className = className;
+            if (detail) {
+                const div = document.createElement('div');
+                div.className = 'detail';
+                div.textContent = detail;
+                td.appendChild(div);
+            }
+            row.appendChild(td);
+        }
+
+        async function loadAudit() {
+            const params = new URLSearchParams();
+            const device = document.getElementById('auditDevice').value;
+            if (device) params.set('device', device);
+            if (currentUnit) params.set('unit', currentUnit);
+            try {
+                const response = await fetch('/api/audit?' + params);
+                if (!response.ok) throw new Error(await response.text());
+                const list = await response.json();
+                const body = document.getElementById('entries');
+                body.innerHTML = '';
+                if (list.length === 0) {
+                    body.innerHTML = '<tr><td colspan="4" class="empty">No changes recorded</td></tr>';
+                }
+                list.forEach(e => {
+                    const row = document.createElement('tr');
+                    cell(row, new Date(e.time).toLocaleString(), e.device);
+                    cell(row, e.who, e.ip);
+                    cell(row, e.action || '');
+                    cell(row, describeChange(e), '', e.error ? 'failed' : '');
+                    body.appendChild(row);
+                });
+            } catch (error) {
+                showMessage('Failed to load the audit log: ' + error.message, 'error');
+            }
+        }
+
+        loadDevices().then(loadAudit).catch(error => showMessage(error.message, 'error'));
+    </script>
+</body>
+</html>
+`
@@ -0,0 +1,574 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/internal/auth"
+	"github.com/EntropySynthetica/Thermostat/internal/config"
+)
+
+const (
+	sessionCookie = "thermostat_session"
+	csrfCookie    = "thermostat_csrf"
+	csrfHeader    = "X-CSRF-Token"
+)
+
+// users holds the accounts from the config file. It is nil when the
+// config file has none, and then no one is asked to sign in.
+var users *userList
+
+// publicMetrics leaves /metrics open to Prometheus when users are
+// configured.
+var publicMetrics bool
+
+// sessions keeps who is signed in.
+var sessions = auth.NewSessions(auth.DefaultSessionsPath())
+
+// tokens keeps the API tokens made with 'thermostat token'.
+var tokens = auth.NewTokens(auth.DefaultTokensPath())
+
+// tokenUseInterval is how often the last use of each API token is written
+// to the tokens file, so checking a token never has to write it.
+const tokenUseInterval = time.Minute
+
+// saveTokenUses writes the tokens' last uses every tokenUseInterval.
+func saveTokenUses(ctx context.Context) {
+	ticker := time.NewTicker(tokenUseInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		if err := tokens.SaveUses(); err != nil {
+			log.Printf("tokens: %v", err)
+		}
+	}
+}
+
+// writeScopes lists the scopes a token needs to change things through each
+// device action. Reading anything takes auth.StatusRead.
+var writeScopes = map[string][]auth.Scope{
+	"settemp":        {auth.SetpointWrite},
+	"setmode":        {auth.ModeWrite},
+	"setfan":         {auth.FanWrite},
+	"program":        {auth.ScheduleWrite},
+	"schedule":       {auth.ScheduleWrite},
+	"scheduler":      {auth.ScheduleWrite},
+	"scheduler/hold": {auth.ScheduleWrite},
+
+	// Both can switch the mode as well as the setpoints.
+	"boost":    {auth.SetpointWrite, auth.ModeWrite},
+	"vacation": {auth.SetpointWrite, auth.ModeWrite},
+}
+
+// userList reads the accounts from the config file, and again whenever
+// the file changes, so 'thermostat user' takes effect without a restart.
+type userList struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	users   map[string]config.User
+}
+
+func newUserList(path string, list []config.User) *userList {
+	l := &userList{path: path}
+	if info, err := os.Stat(path); err == nil {
+		l.modTime = info.ModTime()
+	}
+	l.set(list)
+	return l
+}
+
+func (l *userList) set(list []config.User) {
+	l.users = make(map[string]config.User)
+	for _, u := range list {
+		l.users[u.Name] = u
+	}
+}
+
+// lookup returns the named account. If the config file has changed but no
+// longer loads, the accounts read before stay in use.
+func (l *userList) lookup(name string) (config.User, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if info, err := os.Stat(l.path); err == nil && !info.ModTime().Equal(l.modTime) {
+		l.modTime = info.ModTime()
+		if configData, err := config.Load(l.path); err != nil {
+			log.Printf("users: %v", err)
+		} else {
+			l.set(configData.Users)
+		}
+	}
+
+	u, ok := l.users[name]
+	return u, ok
+}
+
+type userKey struct{}
+
+type tokenKey struct{}
+
+// requestUser returns the account a request was made by, or nil when no
+// one has to sign in.
+func requestUser(r *http.Request) *config.User {
+	u, _ := r.Context().Value(userKey{}).(*config.User)
+	return u
+}
+
+// requestToken returns the API token a request was made with, if any.
+func requestToken(r *http.Request) *auth.Token {
+	t, _ := r.Context().Value(tokenKey{}).(*auth.Token)
+	return t
+}
+
+// requireLogin lets only signed-in users through to next. Pages send
+// everyone else to the sign-in page, and the API answers 401. Anything but
+// a GET must carry the session's CSRF token and, except for signing out,
+// come from a controller. API requests may carry a token instead, which is
+// checked even when no one has to sign in.
+func requireLogin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Guest links are checked by handleGuest.
+		public := r.URL.Path == "/login" || r.URL.Path == "/auth.js" || strings.HasPrefix(r.URL.Path, "/guest/") ||
+			(publicMetrics && r.URL.Path == "/metrics")
+		if public {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if token, ok := bearerToken(r); ok {
+			serveToken(w, r, next, token)
+			return
+		}
+		if users == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		u, sess := currentSession(r)
+		if sess == nil {
+			if r.Method == http.MethodGet && !isAPI(r.URL.Path) {
+				http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+				return
+			}
+			http.Error(w, "Sign in first", http.StatusUnauthorized)
+			return
+		}
+
+		if !safeMethod(r.Method) {
+			token := r.Header.Get(csrfHeader)
+			if token == "" {
+				token = r.PostFormValue("csrf")
+			}
+			if subtle.ConstantTimeCompare([]byte(token), []byte(sess.CSRF)) != 1 {
+				http.Error(w, "Missing or wrong CSRF token; reload the page", http.StatusForbidden)
+				return
+			}
+			if r.URL.Path != "/logout" && !u.Role.CanWrite() {
+				http.Error(w, "Your account can only view the thermostats", http.StatusForbidden)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, &u)))
+	})
+}
+
+// serveToken passes a request made with an API token on to next if the
+// token has the scopes it needs on the thermostat it acts on.
+func serveToken(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+	t, err := tokens.Check(token, time.Now())
+	if err != nil {
+		log.Printf("tokens: %v", err)
+		http.Error(w, "Cannot check the token", http.StatusInternalServerError)
+		return
+	}
+	if t == nil {
+		http.Error(w, "Unknown or revoked token", http.StatusUnauthorized)
+		return
+	}
+
+	scopes, device, ok := tokenScopes(r)
+	if !ok {
+		http.Error(w, "API tokens cannot be used for "+r.Method+" "+r.URL.Path, http.StatusForbidden)
+		return
+	}
+	for _, scope := range scopes {
+		if !t.Allows(scope, device) {
+			on := "every thermostat"
+			if device != "" {
+				on = device
+			}
+			http.Error(w, fmt.Sprintf("Token %s does not allow %s on %s", t.ID, scope, on), http.StatusForbidden)
+			return
+		}
+	}
+
+	next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenKey{}, t)))
+}
+
+// tokenScopes returns the scopes a token needs for r, and the thermostat
+// the request acts on, or "" for all of them. It reports false for
+// anything tokens may not be used for, such as pages.
+func tokenScopes(r *http.Request) ([]auth.Scope, string, bool) {
+	path := r.URL.Path
+	switch path {
+	case "/metrics":
+		return []auth.Scope{auth.StatusRead}, "", true
+	case "/api/audit":
+		return []auth.Scope{auth.AuditRead}, "", true
+	case "/api/devices", "/api/session":
+		return nil, "", true
+	}
+	if path == "/api/guests" || strings.HasPrefix(path, "/api/guests/") {
+		return nil, "", false
+	}
+
+	var device, action string
+	switch {
+	case strings.HasPrefix(path, "/api/devices/"):
+		device, action, _ = strings.Cut(strings.TrimPrefix(path, "/api/devices/"), "/")
+	case strings.HasPrefix(path, "/api/"):
+		device, action = devices[0].Name, strings.TrimPrefix(path, "/api/")
+	default:
+		return nil, "", false
+	}
+
+	if safeMethod(r.Method) {
+		return []auth.Scope{auth.StatusRead}, device, true
+	}
+	if strings.HasPrefix(action, "program/") {
+		action = "program"
+	}
+	scopes, ok := writeScopes[action]
+	return scopes, device, ok
+}
+
+// bearerToken returns the token in an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// currentSession returns the signed-in account and its session, or a nil
+// session if the request has none or its user has been removed.
+func currentSession(r *http.Request) (config.User, *auth.Session) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return config.User{}, nil
+	}
+	sess, err := sessions.Get(cookie.Value, time.Now())
+	if err != nil {
+		log.Printf("sessions: %v", err)
+		return config.User{}, nil
+	}
+	if sess == nil {
+		return config.User{}, nil
+	}
+	u, ok := users.lookup(sess.User)
+	if !ok {
+		return config.User{}, nil
+	}
+	return u, sess
+}
+
+func isAPI(path string) bool {
+	return strings.HasPrefix(path, "/api/") || path == "/metrics"
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// LoginPage is the data for the sign-in page.
+type LoginPage struct {
+	Next  string
+	Error string
+}
+
+// handleLogin shows the sign-in page and signs users in. The session
+// cookie is only readable by the server; the CSRF cookie is read by
+// auth.js and sent back in the X-CSRF-Token header.
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	tmpl := template.Must(template.New("login").Parse(loginHTML))
+	page := LoginPage{Next: safeNext(r.FormValue("next"))}
+
+	switch r.Method {
+	case http.MethodGet:
+		if users == nil {
+			http.Redirect(w, r, page.Next, http.StatusSeeOther)
+			return
+		}
+		tmpl.Execute(w, page)
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if users == nil {
+		http.Error(w, "No users are configured", http.StatusNotFound)
+		return
+	}
+
+	// Another site must not be able to sign the browser in as someone
+	// else.
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+			http.Error(w, "Cross-site sign in refused", http.StatusForbidden)
+			return
+		}
+	}
+
+	name := r.PostFormValue("name")
+	u, ok := users.lookup(name)
+	if !auth.CheckPassword(u.PasswordHash, r.PostFormValue("password")) || !ok {
+		log.Printf("login: failed sign in as %q from %s", name, r.RemoteAddr)
+		w.WriteHeader(http.StatusUnauthorized)
+		page.Error = "Wrong user name or password"
+		tmpl.Execute(w, page)
+		return
+	}
+
+	token, sess, err := sessions.Create(u.Name, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setCookie(w, r, sessionCookie, token, sess.Expires, true)
+	setCookie(w, r, csrfCookie, sess.CSRF, sess.Expires, false)
+	http.Redirect(w, r, page.Next, http.StatusSeeOther)
+}
+
+// handleLogout ends the session and goes back to the sign-in page.
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		if err := sessions.Delete(cookie.Value); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	setCookie(w, r, sessionCookie, "", time.Unix(0, 0), true)
+	setCookie(w, r, csrfCookie, "", time.Unix(0, 0), false)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func setCookie(w http.ResponseWriter, r *http.Request, name, value string, expires time.Time, httpOnly bool) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: httpOnly,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// safeNext returns where to go after signing in: a path on this server,
+// or the home page.
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// SessionInfo is the /api/session response: the signed-in user, or the
+// name of the API token used. Every field is empty when no one has to
+// sign in.
+type SessionInfo struct {
+	User  string      `json:"user,omitempty"`
+	Role  config.Role `json:"role,omitempty"`
+	Token string      `json:"token,omitempty"`
+}
+
+func handleSession(w http.ResponseWriter, r *http.Request) {
+	var info SessionInfo
+	if u := requestUser(r); u != nil {
+		info = SessionInfo{User: u.Name, Role: u.Role}
+	}
+	if t := requestToken(r); t != nil {
+		info.Token = t.Name
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// handleAuthScript serves auth.js, which every page loads. It adds the
+// CSRF token to the page's requests, goes to the sign-in page when the
+// session runs out and shows who is signed in.
+func handleAuthScript(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	w.Write([]byte(authScript))
+}
+
+const authScript = `(function () {
+    const plainFetch = window.fetch.bind(window);
+
+    function csrfToken() {
+        const match = document.cookie.match(/(?:^|; )` + csrfCookie + `=([^;]*)/);
+        return match ? decodeURIComponent(match[1]) : '';
+    }
+
+    window.fetch = async function (url, options) {
+        options = Object.assign({}, options);
+        const method = (options.method || 'GET').toUpperCase();
+        if (method !== 'GET' && method !== 'HEAD') {
+            options.headers = Object.assign({}, options.headers, { '` + csrfHeader + `': csrfToken() });
+        }
+        const response = await plainFetch(url, options);
+        if (response.status === 401) {
+            location.href = '/login?next=' + encodeURIComponent(location.pathname + location.search);
+        }
+        return response;
+    };
+
+    async function signOut() {
+        await fetch('/logout', { method: 'POST' });
+        location.href = '/login';
+    }
+
+    async function showUser() {
+        const response = await plainFetch('/api/session');
+        if (!response.ok) return;
+        const session = await response.json();
+        if (!session.user) return;
+
+        const badge = document.createElement('div');
+        badge.style.cssText = 'position: fixed; top: 10px; right: 10px; background: rgba(255, 255, 255, 0.9); border-radius: 20px; padding: 6px 14px; font-size: 0.85em; color: #333; box-shadow: 0 4px 12px rgba(0, 0, 0, 0.2);';
+        badge.textContent = '👤 ' + session.user + (session.role === 'viewer' ? ' (view only) ' : ' ');
+        const link = document.createElement('a');
+        link.href = '#';
+        link.textContent = 'Sign out';
+        link.style.color = '#667eea';
+        link.addEventListener('click', event => {
+            event.preventDefault();
+            signOut();
+        });
+        badge.appendChild(link);
+        document.body.appendChild(badge);
+    }
+
+    document.addEventListener('DOMContentLoaded', showUser);
+})();
+`
+
+const loginHTML = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Sign In - Thermostat</title>
+    <style>
+        * {
+            margin: 0;
+            padding: 0;
+            box-sizing: border-box;
+        }
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Oxygen, Ubuntu, Cantarell, sans-serif;
+            background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
+            min-height: 100vh;
+            display: flex;
+            justify-content: center;
+            align-items: center;
+            padding: 20px;
+        }
+        .container {
+            background: white;
+            border-radius: 20px;
+            box-shadow: 0 20px 60px rgba(0, 0, 0, 0.3);
+            padding: 40px;
+            max-width: 360px;
+            width: 100%;
+        }
+        h1 {
+            text-align: center;
+            color: #333;
+            margin-bottom: 30px;
+            font-size: 1.8em;
+        }
+        label {
+            display: block;
+            color: #666;
+            margin-bottom: 6px;
+        }
+        input {
+            width: 100%;
+            padding: 12px;
+            border: 2px solid #ddd;
+            border-radius: 10px;
+            font-size: 1em;
+            margin-bottom: 20px;
+        }
+        input:focus {
+            outline: none;
+            border-color: #667eea;
+        }
+        button {
+            width: 100%;
+            padding: 14px;
+            border: none;
+            border-radius: 10px;
+            background: #667eea;
+            color: white;
+            font-size: 1.1em;
+            cursor: pointer;
+        }
+        button:hover {
+            background: #5568d3;
+        }
+        .message {
+            padding: 12px;
+            border-radius: 10px;
+            margin-bottom: 20px;
+            text-align: center;
+            background: #f8d7da;
+            color: #721c24;
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>🌡️ Thermostat</h1>
+        {{if .Error}}<div class="message">{{.Error}}</div>{{end}}
+        <form method="POST" action="/login">
+            <input type="hidden" name="next" value="{{.Next}}">
+            <label for="name">User name</label>
+            <input id="name" name="name" autocomplete="username" autofocus required>
+            <label for="password">Password</label>
+            <input id="password" name="password" type="password" autocomplete="current-password" required>
+            <button type="submit">Sign In</button>
+        </form>
+    </div>
+</body>
+</html>
+`
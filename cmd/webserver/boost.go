@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/internal/audit"
+	"github.com/EntropySynthetica/Thermostat/internal/boost"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+// boostCheckInterval is how often running boosts are checked for expiry.
+const boostCheckInterval = 15 * time.Second
+
+// boosts keeps running boosts, shared with the thermostat CLI.
+var boosts = boost.NewStore(boost.DefaultPath())
+
+// BoostInfo describes a running boost for the status card.
+type BoostInfo struct {
+	Mode      string    `json:"mode"`
+	Temp      float64   `json:"temp"`
+	Until     time.Time `json:"until"`
+	Remaining int       `json:"remaining"`
+}
+
+func boostInfo(b *boost.Boost, unit ct50.Unit, now time.Time) *BoostInfo {
+	return &BoostInfo{
+		Mode:      b.Mode.String(),
+		Temp:      unit.Setpoint(b.Temp),
+		Until:     b.Until,
+		Remaining: int(b.Remaining(now).Seconds()),
+	}
+}
+
+// handleBoost starts a boost with POST {"temp": n, "for": "2h"}, and an
+// optional "mode" of 1 (Heat) or 2 (Cool). GET shows the running boost and
+// DELETE ends it early.
+func handleBoost(w http.ResponseWriter, r *http.Request, dev *device) {
+	unit, err := requestUnit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	running, err := boosts.Get(dev.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		if running == nil {
+			json.NewEncoder(w).Encode(nil)
+			return
+		}
+		json.NewEncoder(w).Encode(boostInfo(running, unit, time.Now()))
+
+	case http.MethodPost:
+		var req struct {
+			Temp *float64 `json:"temp"`
+			For  string   `json:"for"`
+			Mode int      `json:"mode"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Temp == nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		length, err := time.ParseDuration(req.For)
+		if err != nil || length <= 0 || length > boost.MaxDuration {
+			http.Error(w, "Boost length must be a duration such as 90m or 2h, up to 24h", http.StatusBadRequest)
+			return
+		}
+
+		mode := ct50.Mode(req.Mode)
+		if mode != ct50.ModeOff && mode != ct50.ModeHeat && mode != ct50.ModeCool {
+			http.Error(w, "Mode must be 1 (Heat) or 2 (Cool)", http.StatusBadRequest)
+			return
+		}
+
+		temp := unit.ToDevice(*req.Temp)
+		if !validTemp(temp) {
+			http.Error(w, "Temperature must be between "+setpointRange(unit), http.StatusBadRequest)
+			return
+		}
+
+		b, err := boost.Start(r.Context(), dev.client, dev.Name, mode, temp, time.Now().Add(length), running)
+		if errors.Is(err, boost.ErrNoMode) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err == nil {
+			err = boosts.Put(b)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+
+	case http.MethodDelete:
+		if running == nil {
+			http.Error(w, "No boost running", http.StatusNotFound)
+			return
+		}
+
+		if _, err := boosts.Finish(r.Context(), dev.client, running); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// watchBoosts ends boosts as they expire, until ctx is done. It checks once
+// straight away, so boosts that ran out while the server was down end at
+// startup.
+func watchBoosts(ctx context.Context) {
+	ticker := time.NewTicker(boostCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		endExpiredBoosts(ctx, time.Now())
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// endExpiredBoosts ends every boost that has expired at now. A thermostat
+// that cannot be reached keeps its boost, to be tried again next time.
+func endExpiredBoosts(ctx context.Context, now time.Time) {
+	all, err := boosts.All()
+	if err != nil {
+		log.Printf("boost: %v", err)
+		return
+	}
+
+	for name, b := range all {
+		if !b.Expired(now) {
+			continue
+		}
+
+		dev := findDevice(name)
+		if dev == nil {
+			log.Printf("boost: %s is no longer configured; leaving its boost alone", name)
+			continue
+		}
+
+		ctx := audit.WithOrigin(ctx, audit.Origin{Actor: automation("boost"), Action: "boost ended"})
+		restored, err := boosts.Finish(ctx, dev.client, b)
+		if err == nil {
+			dev.events.poke()
+		}
+		switch {
+		case err != nil:
+			log.Printf("boost: %s: %v", name, err)
+		case restored:
+			log.Printf("boost: %s: ended, restored %s mode", name, b.Prior.Tmode)
+		default:
+			log.Printf("boost: %s: ended, left as set at the thermostat", name)
+		}
+	}
+}
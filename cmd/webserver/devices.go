@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/EntropySynthetica/Thermostat/internal/audit"
+	"github.com/EntropySynthetica/Thermostat/internal/config"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+// device is one configured thermostat.
+type device struct {
+	Name     string
+	IP       string
+	client   *ct50.Client
+	programs *programCache
+	run      *schedRun
+	events   *eventHub
+}
+
+// deviceHandler is an API handler that acts on a single thermostat.
+type deviceHandler func(w http.ResponseWriter, r *http.Request, dev *device)
+
+// DeviceInfo is one entry in the /api/devices list.
+type DeviceInfo struct {
+	Name string `json:"name"`
+	IP   string `json:"ip"`
+}
+
+// devices holds every configured thermostat in config file order. The
+// first one is the default for the original single-device routes.
+var devices []*device
+
+// deadband is the smallest gap allowed between the heat and cool setpoints
+// in auto mode, from the config file.
+var deadband = ct50.DefaultDeadband
+
+// defaultUnit is the temperature unit API requests use when they do not
+// ask for one with ?unit=.
+var defaultUnit = ct50.Fahrenheit
+
+// deviceActions maps the last part of /api/devices/{name}/{action} to its
+// handler. Program routes are matched separately since they carry a mode.
+var deviceActions = map[string]deviceHandler{
+	"status":   handleStatus,
+	"settemp":  handleSetTemp,
+	"setmode":  handleSetMode,
+	"setfan":   handleSetFan,
+	"schedule": handleSchedule,
+	"boost":    handleBoost,
+	"vacation": handleVacation,
+	"history":  handleHistory,
+	"events":   handleEvents,
+
+	"scheduler":      handleScheduler,
+	"scheduler/hold": handleSchedulerHold,
+}
+
+// setDevices replaces the thermostats, after stopping the event pollers
+// of the old ones.
+func setDevices(list []config.Device) {
+	closeDevices()
+	devices = nil
+	for _, dev := range list {
+		client := ct50.New(dev.IP)
+		client.Deadband = deadband
+		client.Observe = observeRequests(dev.Name)
+		auditLog.Watch(client, dev.Name)
+
+		d := &device{
+			Name:     dev.Name,
+			IP:       dev.IP,
+			client:   client,
+			programs: newProgramCache(),
+			run:      &schedRun{},
+		}
+		d.events = newEventHub(d)
+		devices = append(devices, d)
+	}
+}
+
+// closeDevices stops every thermostat's event poller and waits for them.
+func closeDevices() {
+	for _, dev := range devices {
+		dev.events.shutdown()
+	}
+}
+
+func findDevice(name string) *device {
+	for _, dev := range devices {
+		if dev.Name == name {
+			return dev
+		}
+	}
+	return nil
+}
+
+// onDefaultDevice adapts a device handler to act on the default thermostat.
+func onDefaultDevice(h deviceHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveDevice(w, r, devices[0], h)
+	}
+}
+
+// serveDevice runs h on dev. Anything but a GET may have changed the
+// thermostat, so it is recorded as made by whoever sent the request, and
+// its event streams are brought up to date straight away.
+func serveDevice(w http.ResponseWriter, r *http.Request, dev *device, h deviceHandler) {
+	if r.Method == http.MethodGet {
+		h(w, r, dev)
+		return
+	}
+	if audit.OriginFrom(r.Context()).Actor.Kind == "" {
+		r = r.WithContext(audit.WithOrigin(r.Context(), requestOrigin(r)))
+	}
+	h(w, r, dev)
+	dev.events.poke()
+}
+
+func handleDevices(w http.ResponseWriter, r *http.Request) {
+	list := make([]DeviceInfo, 0, len(devices))
+	for _, dev := range devices {
+		list = append(list, DeviceInfo{Name: dev.Name, IP: dev.IP})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// handleDeviceAPI routes /api/devices/{name}/{action} to the handler for
+// action, acting on the named thermostat.
+func handleDeviceAPI(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/devices/")
+	name, action, _ := strings.Cut(rest, "/")
+
+	dev := findDevice(name)
+	if dev == nil {
+		http.Error(w, "Unknown thermostat "+name, http.StatusNotFound)
+		return
+	}
+
+	if strings.HasPrefix(action, "program/") {
+		serveDevice(w, r, dev, handleProgram)
+		return
+	}
+
+	handler, ok := deviceActions[action]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	serveDevice(w, r, dev, handler)
+}
+
+func handleOverviewPage(w http.ResponseWriter, r *http.Request) {
+	tmpl := template.Must(template.New("overview").Parse(overviewHTML))
+	tmpl.Execute(w, nil)
+}
+
+const overviewHTML = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>All Thermostats</title>
+    <script src="/auth.js"></script>
+    <style>
+        * {
+            margin: 0;
+            padding: 0;
+            box-sizing: border-box;
+        }
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Oxygen, Ubuntu, Cantarell, sans-serif;
+            background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
+            min-height: 100vh;
+            padding: 20px;
+        }
+        h1 {
+            text-align: center;
+            color: white;
+            margin: 20px 0 30px;
+            font-size: 2em;
+        }
+        .grid {
+            display: grid;
+            grid-template-columns: repeat(auto-fit, minmax(240px, 1fr));
+            gap: 20px;
+            max-width: 1200px;
+            margin: 0 auto;
+        }
+        .card {
+            background: white;
+            border-radius: 20px;
+            box-shadow: 0 20px 60px rgba(0, 0, 0, 0.3);
+            padding: 25px;
+            color: #333;
+            text-decoration: none;
+            transition: all 0.3s;
+        }
+        .card:hover {
+            transform: translateY(-4px);
+        }
+        .card-name {
+            font-size: 1.3em;
+            font-weight: 600;
+        }
+        .card-ip {
+            color: #999;
+            font-size: 0.85em;
+        }
+        .temp-display {
+            text-align: center;
+            font-size: 3em;
+            font-weight: bold;
+            color: #667eea;
+            margin: 15px 0;
+        }
+        .row {
+            display: flex;
+            justify-content: space-between;
+            padding: 4px 0;
+            font-size: 0.95em;
+        }
+        .row-label {
+            color: #666;
+        }
+        .row-value {
+            font-weight: bold;
+        }
+        .error {
+            color: #721c24;
+            background: #f8d7da;
+            border-radius: 10px;
+            padding: 10px;
+            margin-top: 10px;
+            font-size: 0.9em;
+        }
+        .heating {
+            color: #d35400;
+        }
+        .cooling {
+            color: #2980b9;
+        }
+    </style>
+</head>
+<body>
+    <h1>🌡️ All Thermostats</h1>
+    <div class="grid" id="grid"></div>
+
+    <script>
+        const currentUnit = localStorage.getItem('thermostatUnit') || '';
+
+        function row(label, value, cls) {
+            const div = document.createElement('div');
+            div.className = 'row';
+            const l = document.createElement('span');
+            l.className = 'row-label';
+            l.textContent = label;
+            const v = document.createElement('span');
+            v.className = 'row-value' + (cls ? ' ' + cls : '');
+            v.textContent = value;
+            div.appendChild(l);
+            div.appendChild(v);
+            return div;
+        }
+
+        function renderCard(dev, data, error) {
+            const card = document.createElement('a');
+            card.className = 'card';
+            card.href = '/?device=' + encodeURIComponent(dev.name);
+
+            const name = document.createElement('div');
+            name.className = 'card-name';
+            name.textContent = dev.name;
+            card.appendChild(name);
+
+            const ip = document.createElement('div');
+            ip.className = 'card-ip';
+            ip.textContent = dev.ip;
+            card.appendChild(ip);
+
+            const temp = document.createElement('div');
+            temp.className = 'temp-display';
+            const symbol = data && data.unit === 'C' ? '°C' : '°F';
+            temp.textContent = data ? data.currentTemp.toFixed(1) + symbol : '--';
+            card.appendChild(temp);
+
+            if (data) {
+                const stateClass = data.operatingState === 'Heating' ? 'heating' : data.operatingState === 'Cooling' ? 'cooling' : '';
+                const target = data.modeCode === 3
+                    ? data.heatTemp.toFixed(1) + '–' + data.coolTemp.toFixed(1) + symbol
+                    : data.modeCode === 0 ? '--' : data.targetTemp.toFixed(1) + symbol;
+                card.appendChild(row('Target', target));
+                card.appendChild(row('Mode', data.mode));
+                card.appendChild(row('Status', data.operatingState, stateClass));
+                card.appendChild(row('Fan', data.fanMode + (data.fanState === 'On' ? ' (running)' : '')));
+                card.appendChild(row('Hold', data.hold));
+                if (data.boost) {
+                    const left = Math.ceil(data.boost.remaining / 60);
+                    card.appendChild(row('Boost', data.boost.temp + symbol + ', ' + left + ' min left'));
+                }
+                if (data.vacation && data.vacation.started) {
+                    card.appendChild(row('Vacation', 'until ' + new Date(data.vacation.to).toLocaleDateString()));
+                }
+            } else {
+                const err = document.createElement('div');
+                err.className = 'error';
+                err.textContent = error;
+                card.appendChild(err);
+            }
+            return card;
+        }
+
+        async function loadStatus(dev) {
+            try {
+                const unit = currentUnit ? '?unit=' + currentUnit : '';
+                const response = await fetch('/api/devices/' + encodeURIComponent(dev.name) + '/status' + unit);
+                if (!response.ok) throw new Error(await response.text());
+                return renderCard(dev, await response.json(), null);
+            } catch (error) {
+                return renderCard(dev, null, 'Unreachable: ' + error.message);
+            }
+        }
+
+        async function loadOverview() {
+            try {
+                const response = await fetch('/api/devices');
+                const list = await response.json();
+                const cards = await Promise.all(list.map(loadStatus));
+                const grid = document.getElementById('grid');
+                grid.innerHTML = '';
+                cards.forEach(card => grid.appendChild(card));
+            } catch (error) {
+                console.error(error);
+            }
+        }
+
+        loadOverview();
+        setInterval(loadOverview, 30000);
+    </script>
+</body>
+</html>
+`
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+// eventPollInterval is how often a thermostat is read while anyone is
+// watching its events.
+var eventPollInterval = 5 * time.Second
+
+// eventKeepAlive is how often an idle event stream gets a comment, so
+// proxies do not time it out.
+const eventKeepAlive = 25 * time.Second
+
+// eventHub reads one thermostat for every open event stream, so that any
+// number of browser tabs cost the device one request per poll. It only
+// polls while someone is listening.
+type eventHub struct {
+	dev *device
+
+	// ctx ends when the hub is closed, which stops any poller;
+	// pollers counts the ones still running.
+	ctx     context.Context
+	cancel  context.CancelFunc
+	pollers sync.WaitGroup
+
+	mu      sync.Mutex
+	subs    map[chan struct{}]bool
+	stop    context.CancelFunc
+	wake    chan struct{}
+	last    *ct50.Status
+	lastErr error
+	polled  bool
+}
+
+func newEventHub(dev *device) *eventHub {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &eventHub{
+		dev:    dev,
+		ctx:    ctx,
+		cancel: cancel,
+		subs:   make(map[chan struct{}]bool),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// shutdown stops the poller for good and waits for it to finish. Streams
+// still open get no more updates.
+func (h *eventHub) shutdown() {
+	h.mu.Lock()
+	h.cancel()
+	h.mu.Unlock()
+	h.pollers.Wait()
+}
+
+// subscribe returns a channel that is signalled after every poll, and a
+// function to stop listening. The first listener starts the poller and
+// the last one to leave stops it.
+func (h *eventHub) subscribe() (<-chan struct{}, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan struct{}, 1)
+	h.subs[ch] = true
+	if h.stop == nil && h.ctx.Err() == nil {
+		ctx, cancel := context.WithCancel(h.ctx)
+		h.stop = cancel
+		h.pollers.Add(1)
+		go h.run(ctx)
+	} else if h.polled {
+		ch <- struct{}{}
+	}
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		delete(h.subs, ch)
+		if len(h.subs) == 0 && h.stop != nil {
+			h.stop()
+			h.stop = nil
+			h.polled = false
+		}
+	}
+}
+
+// poke asks for a poll now, after something has changed the thermostat.
+// It does nothing when no one is listening.
+func (h *eventHub) poke() {
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+}
+
+// latest returns the result of the last poll.
+func (h *eventHub) latest() (*ct50.Status, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.last, h.lastErr
+}
+
+func (h *eventHub) run(ctx context.Context) {
+	defer h.pollers.Done()
+
+	ticker := time.NewTicker(eventPollInterval)
+	defer ticker.Stop()
+
+	for {
+		stats, err := h.dev.client.Status(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		h.mu.Lock()
+		h.last, h.lastErr, h.polled = stats, err, true
+		for ch := range h.subs {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+		h.mu.Unlock()
+
+		select {
+		case <-ticker.C:
+		case <-h.wake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handleEvents streams the thermostat's status card as server-sent
+// events. The first "status" event has every field and later ones only
+// the fields that changed, with null for ones that went away. An
+// "unavailable" event carries {"error": "..."} while the thermostat does
+// not answer.
+func handleEvents(w http.ResponseWriter, r *http.Request, dev *device) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	unit, err := requestUnit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprint(w, "retry: 5000\n\n")
+	flusher.Flush()
+
+	updates, unsubscribe := dev.events.subscribe()
+	defer unsubscribe()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	var sent map[string]json.RawMessage
+	var sentErr string
+	for {
+		select {
+		case <-updates:
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+			continue
+		case <-r.Context().Done():
+			return
+		}
+
+		stats, err := dev.events.latest()
+		if err != nil {
+			if err.Error() != sentErr {
+				sentErr = err.Error()
+				writeEvent(w, "unavailable", map[string]string{"error": sentErr})
+				flusher.Flush()
+			}
+			continue
+		}
+		sentErr = ""
+
+		fields, err := statusFields(statusResponse(r.Context(), dev, stats, unit))
+		if err != nil {
+			log.Printf("events: %s: %v", dev.Name, err)
+			continue
+		}
+		if changed := changedFields(sent, fields); len(changed) > 0 {
+			writeEvent(w, "status", changed)
+			flusher.Flush()
+		}
+		sent = fields
+	}
+}
+
+// statusFields splits a status card into its JSON fields.
+func statusFields(status *StatusResponse) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(data, &fields)
+	return fields, err
+}
+
+// changedFields returns the fields of next that differ from prev, and
+// null for fields in prev that next no longer has.
+func changedFields(prev, next map[string]json.RawMessage) map[string]json.RawMessage {
+	changed := make(map[string]json.RawMessage)
+	for name, value := range next {
+		if old, ok := prev[name]; !ok || !bytes.Equal(old, value) {
+			changed[name] = value
+		}
+	}
+	for name := range prev {
+		if _, ok := next[name]; !ok {
+			changed[name] = json.RawMessage("null")
+		}
+	}
+	return changed
+}
+
+func writeEvent(w http.ResponseWriter, event string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("events: %v", err)
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
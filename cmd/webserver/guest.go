@@ -0,0 +1,972 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/internal/audit"
+	"github.com/EntropySynthetica/Thermostat/internal/auth"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+// guests keeps the guest links made here or with 'thermostat guest'.
+var guests = auth.NewGuests(auth.DefaultGuestsPath(), auth.DefaultGuestKeyPath())
+
+// guestActions maps the last part of /guest/{link}/{action} to its
+// handler. Guests can watch their thermostat and change the setpoints,
+// and the mode if their link allows it; nothing else.
+var guestActions = map[string]deviceHandler{
+	"status":  handleStatus,
+	"events":  handleEvents,
+	"settemp": handleGuestSetTemp,
+	"setmode": handleSetMode,
+}
+
+type guestKey struct{}
+
+// requestGuest returns the guest link a request was made with, if any.
+func requestGuest(r *http.Request) *auth.Guest {
+	g, _ := r.Context().Value(guestKey{}).(*auth.Guest)
+	return g
+}
+
+// GuestInfo is one entry in the /api/guests list. Temperatures are in
+// Unit.
+type GuestInfo struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Device  string    `json:"device"`
+	Unit    ct50.Unit `json:"unit"`
+	MinTemp float64   `json:"minTemp"`
+	MaxTemp float64   `json:"maxTemp"`
+	Mode    bool      `json:"mode"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+	Expired bool      `json:"expired"`
+	URL     string    `json:"url"`
+}
+
+func guestInfo(r *http.Request, g *auth.Guest, link string, unit ct50.Unit, now time.Time) GuestInfo {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return GuestInfo{
+		ID:      g.ID,
+		Name:    g.Name,
+		Device:  g.Device,
+		Unit:    unit,
+		MinTemp: lowerBound(unit, g.MinTemp),
+		MaxTemp: upperBound(unit, g.MaxTemp),
+		Mode:    g.Mode,
+		Created: g.Created,
+		Expires: g.Expires,
+		Expired: !now.Before(g.Expires),
+		URL:     scheme + "://" + r.Host + "/guest/" + link,
+	}
+}
+
+// handleGuests lists guest links with GET and makes one with POST
+// {"name": "sitter", "device": "upstairs", "min": 66, "max": 74,
+// "mode": false, "days": 7}. The device defaults to the first thermostat
+// and days to 7. DELETE /api/guests/{id} revokes a link. Viewers cannot
+// see the links, since anyone holding one can change the thermostat.
+func handleGuests(w http.ResponseWriter, r *http.Request) {
+	if u := requestUser(r); u != nil && !u.Role.CanWrite() {
+		http.Error(w, "Your account can only view the thermostats", http.StatusForbidden)
+		return
+	}
+
+	unit, err := requestUnit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+
+	if id := strings.TrimPrefix(r.URL.Path, "/api/guests/"); id != r.URL.Path {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := guests.Revoke(id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("guest %s: revoked by %s", id, requestOrigin(r).Actor)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		list, err := guests.List()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		infos := make([]GuestInfo, 0, len(list))
+		for _, g := range list {
+			link, err := guests.Link(g.ID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			infos = append(infos, guestInfo(r, g, link, unit, now))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(infos)
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Name   string   `json:"name"`
+		Device string   `json:"device"`
+		Min    *float64 `json:"min"`
+		Max    *float64 `json:"max"`
+		Mode   bool     `json:"mode"`
+		Days   int      `json:"days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.Min == nil || req.Max == nil {
+		http.Error(w, "A guest link needs a min and max setpoint", http.StatusBadRequest)
+		return
+	}
+
+	dev := devices[0]
+	if req.Device != "" {
+		if dev = findDevice(req.Device); dev == nil {
+			http.Error(w, "Unknown thermostat "+req.Device, http.StatusNotFound)
+			return
+		}
+	}
+
+	lifetime := auth.DefaultGuestLifetime
+	if req.Days != 0 {
+		lifetime = time.Duration(req.Days) * 24 * time.Hour
+	}
+
+	g := &auth.Guest{
+		Name:    req.Name,
+		Device:  dev.Name,
+		MinTemp: unit.ToDevice(*req.Min),
+		MaxTemp: unit.ToDevice(*req.Max),
+		Mode:    req.Mode,
+		Created: now,
+		Expires: now.Add(lifetime),
+	}
+	if !validTemp(g.MinTemp) || !validTemp(g.MaxTemp) {
+		http.Error(w, "Guest setpoints must be between "+setpointRange(unit), http.StatusBadRequest)
+		return
+	}
+	link, err := guests.Create(g)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("guest %s (%s): link for %s made by %s, until %s", g.ID, g.Name, g.Device, requestOrigin(r).Actor, g.Expires.Format(time.RFC3339))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(guestInfo(r, g, link, unit, now))
+}
+
+// handleGuest serves a guest link: the guest page at /guest/{link} and
+// its actions under it. The link stands in for a sign in, so it needs no
+// cookie and no CSRF token. Everything a guest changes, or tries to, is
+// logged.
+func handleGuest(w http.ResponseWriter, r *http.Request) {
+	link, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/guest/"), "/")
+
+	g, err := guests.Check(link, time.Now())
+	if err != nil {
+		log.Printf("guests: %v", err)
+		http.Error(w, "Cannot check the link", http.StatusInternalServerError)
+		return
+	}
+	if g == nil {
+		log.Printf("guest: unknown, revoked or expired link from %s", r.RemoteAddr)
+		http.Error(w, "This link has expired or been revoked", http.StatusNotFound)
+		return
+	}
+	dev := findDevice(g.Device)
+	if dev == nil {
+		http.Error(w, "Unknown thermostat "+g.Device, http.StatusNotFound)
+		return
+	}
+	// The link itself stays out of the audit log.
+	ctx := context.WithValue(r.Context(), guestKey{}, g)
+	r = r.WithContext(audit.WithOrigin(ctx, audit.Origin{
+		Actor:  audit.Actor{Kind: audit.Guest, Name: g.Name + " (" + g.ID + ")"},
+		IP:     remoteIP(r),
+		Action: r.Method + " /guest/" + g.ID + "/" + action,
+	}))
+
+	if action == "" {
+		log.Printf("guest %s (%s): opened %s from %s", g.ID, g.Name, dev.Name, r.RemoteAddr)
+		handleGuestPage(w, r, g, link)
+		return
+	}
+
+	handler, ok := guestActions[action]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if safeMethod(r.Method) {
+		serveDevice(w, r, dev, handler)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<16))
+	if err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	if action == "setmode" && !g.Mode {
+		http.Error(rec, "This link cannot change the mode", http.StatusForbidden)
+	} else {
+		serveDevice(rec, r, dev, handler)
+	}
+	log.Printf("guest %s (%s): %s %s on %s from %s: %d %s", g.ID, g.Name, action, bytes.TrimSpace(body), dev.Name, r.RemoteAddr,
+		rec.status, http.StatusText(rec.status))
+}
+
+// handleGuestSetTemp is handleSetTemp held to the guest's setpoint range.
+func handleGuestSetTemp(w http.ResponseWriter, r *http.Request, dev *device) {
+	g := requestGuest(r)
+	unit, err := requestUnit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Temp *float64 `json:"temp"`
+		Heat *float64 `json:"heat"`
+		Cool *float64 `json:"cool"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	for _, temp := range []*float64{req.Temp, req.Heat, req.Cool} {
+		if temp != nil && !g.Allows(unit.ToDevice(*temp)) {
+			http.Error(w, "This link only allows setpoints between "+guestRange(g, unit), http.StatusForbidden)
+			return
+		}
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	handleSetTemp(w, r, dev)
+}
+
+// guestRange describes a guest's setpoints in unit, such as "66 and
+// 74°F".
+func guestRange(g *auth.Guest, unit ct50.Unit) string {
+	return fmt.Sprintf("%v and %s", lowerBound(unit, g.MinTemp), unit.Format(upperBound(unit, g.MaxTemp)))
+}
+
+// statusRecorder remembers the status a handler answered with.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// GuestPage is the data for the guest page. Temperatures are in Symbol's
+// unit.
+type GuestPage struct {
+	Name    string
+	Device  string
+	Base    string
+	Symbol  string
+	MinTemp float64
+	MaxTemp float64
+	Mode    bool
+	Expires string
+}
+
+func handleGuestPage(w http.ResponseWriter, r *http.Request, g *auth.Guest, link string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tmpl := template.Must(template.New("guest").Parse(guestHTML))
+	tmpl.Execute(w, GuestPage{
+		Name:    g.Name,
+		Device:  g.Device,
+		Base:    "/guest/" + link + "/",
+		Symbol:  defaultUnit.Symbol(),
+		MinTemp: lowerBound(defaultUnit, g.MinTemp),
+		MaxTemp: upperBound(defaultUnit, g.MaxTemp),
+		Mode:    g.Mode,
+		Expires: g.Expires.Local().Format("Mon Jan 2 15:04"),
+	})
+}
+
+const guestHTML = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta name="referrer" content="no-referrer">
+    <title>Thermostat</title>
+    <style>
+        * {
+            margin: 0;
+            padding: 0;
+            box-sizing: border-box;
+        }
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Oxygen, Ubuntu, Cantarell, sans-serif;
+            background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
+            min-height: 100vh;
+            display: flex;
+            justify-content: center;
+            align-items: center;
+            padding: 20px;
+        }
+        .container {
+            background: white;
+            border-radius: 20px;
+            box-shadow: 0 20px 60px rgba(0, 0, 0, 0.3);
+            padding: 40px;
+            max-width: 500px;
+            width: 100%;
+        }
+        h1 {
+            text-align: center;
+            color: #333;
+            margin-bottom: 10px;
+            font-size: 2em;
+        }
+        .guest-note {
+            text-align: center;
+            color: #666;
+            font-size: 0.9em;
+            margin-bottom: 25px;
+        }
+        .status-card {
+            background: #f8f9fa;
+            border-radius: 15px;
+            padding: 25px;
+            margin-bottom: 30px;
+        }
+        .temp-display {
+            text-align: center;
+            font-size: 3.5em;
+            font-weight: bold;
+            color: #667eea;
+            margin: 20px 0;
+        }
+        .status-grid {
+            display: grid;
+            grid-template-columns: 1fr 1fr;
+            gap: 15px;
+            margin-top: 20px;
+        }
+        .status-item {
+            background: white;
+            padding: 15px;
+            border-radius: 10px;
+            text-align: center;
+        }
+        .status-label {
+            font-size: 0.9em;
+            color: #666;
+            margin-bottom: 5px;
+        }
+        .status-value {
+            font-weight: bold;
+            color: #333;
+            font-size: 1.1em;
+        }
+        .control-section {
+            margin-bottom: 30px;
+        }
+        .control-title {
+            font-size: 1.2em;
+            color: #333;
+            margin-bottom: 15px;
+            font-weight: 600;
+        }
+        .temp-control {
+            display: flex;
+            align-items: center;
+            justify-content: space-between;
+            background: #f8f9fa;
+            padding: 15px;
+            border-radius: 10px;
+            margin-bottom: 10px;
+        }
+        .temp-value {
+            font-size: 2em;
+            font-weight: bold;
+            color: #333;
+        }
+        .range-label {
+            font-weight: 600;
+            width: 70px;
+        }
+        .range-label.heat {
+            color: #d35400;
+        }
+        .range-label.cool {
+            color: #2980b9;
+        }
+        .temp-button {
+            background: #667eea;
+            color: white;
+            border: none;
+            border-radius: 50%;
+            width: 50px;
+            height: 50px;
+            font-size: 1.5em;
+            cursor: pointer;
+        }
+        .temp-button:hover {
+            background: #5568d3;
+        }
+        .mode-buttons {
+            display: grid;
+            grid-template-columns: repeat(2, 1fr);
+            gap: 10px;
+        }
+        .mode-button {
+            padding: 15px;
+            border: 2px solid #ddd;
+            background: white;
+            border-radius: 10px;
+            cursor: pointer;
+            font-size: 1em;
+            font-weight: 600;
+        }
+        .mode-button.active {
+            background: #667eea;
+            color: white;
+            border-color: #667eea;
+        }
+        .set-temp-button {
+            width: 100%;
+            padding: 15px;
+            background: #667eea;
+            color: white;
+            border: none;
+            border-radius: 10px;
+            font-size: 1.1em;
+            font-weight: 600;
+            cursor: pointer;
+            margin-top: 5px;
+        }
+        .set-temp-button:hover {
+            background: #5568d3;
+        }
+        .message {
+            padding: 15px;
+            border-radius: 10px;
+            margin-top: 15px;
+            text-align: center;
+            font-weight: 600;
+            display: none;
+        }
+        .message.success {
+            background: #d4edda;
+            color: #155724;
+        }
+        .message.error {
+            background: #f8d7da;
+            color: #721c24;
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>🌡️ {{.Device}}</h1>
+        <div class="guest-note">Guest access for {{.Name}} until {{.Expires}}.<br>Setpoints from {{.MinTemp}} to {{.MaxTemp}}{{.Symbol}}.</div>
+
+        <div class="status-card">
+            <div class="status-label">Current Temperature</div>
+            <div class="temp-display" id="currentTemp">--</div>
+            <div class="status-grid">
+                <div class="status-item">
+                    <div class="status-label">Target</div>
+                    <div class="status-value" id="targetTemp">--</div>
+                </div>
+                <div class="status-item">
+                    <div class="status-label">Mode</div>
+                    <div class="status-value" id="mode">--</div>
+                </div>
+                <div class="status-item">
+                    <div class="status-label">Status</div>
+                    <div class="status-value" id="operatingState">--</div>
+                </div>
+                <div class="status-item">
+                    <div class="status-label">Fan Status</div>
+                    <div class="status-value" id="fanState">--</div>
+                </div>
+            </div>
+        </div>
+
+        <div class="control-section" id="setpoints" style="display: none;">
+            <div class="control-title">Set Temperature</div>
+            <div class="temp-control" id="singleSetpoint">
+                <button class="temp-button" onclick="adjust('temp', -0.5)">−</button>
+                <span class="temp-value" id="tempValue">--</span>
+                <button class="temp-button" onclick="adjust('temp', 0.5)">+</button>
+            </div>
+            <div id="rangeSetpoint">
+                <div class="temp-control">
+                    <span class="range-label heat">Heat to</span>
+                    <button class="temp-button" onclick="adjust('heat', -0.5)">−</button>
+                    <span class="temp-value" id="heatValue">--</span>
+                    <button class="temp-button" onclick="adjust('heat', 0.5)">+</button>
+                </div>
+                <div class="temp-control">
+                    <span class="range-label cool">Cool to</span>
+                    <button class="temp-button" onclick="adjust('cool', -0.5)">−</button>
+                    <span class="temp-value" id="coolValue">--</span>
+                    <button class="temp-button" onclick="adjust('cool', 0.5)">+</button>
+                </div>
+            </div>
+            <button class="set-temp-button" onclick="setTemperature()">Set Temperature</button>
+        </div>
+
+        {{if .Mode}}
+        <div class="control-section">
+            <div class="control-title">Operating Mode</div>
+            <div class="mode-buttons">
+                <button class="mode-button" data-mode="0" onclick="setMode(0)">Off</button>
+                <button class="mode-button" data-mode="1" onclick="setMode(1)">Heat</button>
+                <button class="mode-button" data-mode="2" onclick="setMode(2)">Cool</button>
+                <button class="mode-button" data-mode="3" onclick="setMode(3)">Auto</button>
+            </div>
+        </div>
+        {{end}}
+        <div class="message" id="message"></div>
+    </div>
+    <script>
+        const base = '{{.Base}}';
+        const minTemp = {{.MinTemp}};
+        const maxTemp = {{.MaxTemp}};
+        const symbol = '{{.Symbol}}';
+        let status = {};
+        let chosen = { temp: minTemp, heat: minTemp, cool: maxTemp };
+
+        function showMessage(text, type) {
+            const msg = document.getElementById('message');
+            msg.textContent = text;
+            msg.className = 'message ' + type;
+            msg.style.display = 'block';
+            setTimeout(() => {
+                msg.style.display = 'none';
+            }, 3000);
+        }
+
+        // clamp keeps a setpoint within what the link allows.
+        function clamp(value) {
+            return Math.max(minTemp, Math.min(maxTemp, Math.round(value * 2) / 2));
+        }
+
+        function adjust(which, delta) {
+            chosen[which] = clamp(chosen[which] + delta);
+            showChosen();
+        }
+
+        function showChosen() {
+            ['temp', 'heat', 'cool'].forEach(which => {
+                document.getElementById(which + 'Value').textContent = chosen[which] + symbol;
+            });
+        }
+
+        async function post(action, body, done) {
+            try {
+                const response = await fetch(base + action, {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify(body)
+                });
+                if (!response.ok) throw new Error(await response.text());
+                showMessage(done, 'success');
+            } catch (error) {
+                showMessage(error.message, 'error');
+            }
+        }
+
+        function setTemperature() {
+            if (status.modeCode === 3) {
+                post('settemp', { heat: chosen.heat, cool: chosen.cool }, 'Range set to ' + chosen.heat + '–' + chosen.cool + symbol);
+            } else {
+                post('settemp', { temp: chosen.temp }, 'Temperature set to ' + chosen.temp + symbol);
+            }
+        }
+
+        function setMode(mode) {
+            post('setmode', { mode: mode }, 'Mode changed');
+        }
+
+        // render shows the status. The chosen setpoints only follow the
+        // thermostat when its setpoints or mode change.
+        function render(changed) {
+            const auto = status.modeCode === 3;
+            document.getElementById('currentTemp').textContent = status.currentTemp.toFixed(1) + symbol;
+            document.getElementById('targetTemp').textContent = status.modeCode === 0 ? '--'
+                : auto ? status.heatTemp.toFixed(1) + '–' + status.coolTemp.toFixed(1) + symbol
+                : status.targetTemp.toFixed(1) + symbol;
+            document.getElementById('mode').textContent = status.mode;
+            document.getElementById('operatingState').textContent = status.operatingState;
+            document.getElementById('fanState').textContent = status.fanState === 'On' ? 'Running' : 'Idle';
+            document.querySelectorAll('.mode-button').forEach(btn => {
+                btn.classList.toggle('active', parseInt(btn.getAttribute('data-mode')) === status.modeCode);
+            });
+
+            document.getElementById('setpoints').style.display = status.modeCode === 0 ? 'none' : 'block';
+            document.getElementById('singleSetpoint').style.display = auto ? 'none' : 'flex';
+            document.getElementById('rangeSetpoint').style.display = auto ? 'block' : 'none';
+            if (['modeCode', 'targetTemp', 'heatTemp', 'coolTemp'].some(name => name in changed)) {
+                chosen = { temp: clamp(status.targetTemp), heat: clamp(status.heatTemp), cool: clamp(status.coolTemp) };
+                showChosen();
+            }
+        }
+
+        const events = new EventSource(base + 'events');
+        events.addEventListener('status', e => {
+            const changed = JSON.parse(e.data);
+            Object.assign(status, changed);
+            render(changed);
+        });
+        events.addEventListener('unavailable', e => {
+            showMessage('Thermostat not answering: ' + JSON.parse(e.data).error, 'error');
+        });
+    </script>
+</body>
+</html>
+`
+
+func handleGuestsPage(w http.ResponseWriter, r *http.Request) {
+	tmpl := template.Must(template.New("guests").Parse(guestsHTML))
+	tmpl.Execute(w, nil)
+}
+
+const guestsHTML = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Guest Links - Thermostat</title>
+    <script src="/auth.js"></script>
+    <style>
+        * {
+            margin: 0;
+            padding: 0;
+            box-sizing: border-box;
+        }
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Oxygen, Ubuntu, Cantarell, sans-serif;
+            background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
+            min-height: 100vh;
+            display: flex;
+            justify-content: center;
+            align-items: flex-start;
+            padding: 20px;
+        }
+        .container {
+            background: white;
+            border-radius: 20px;
+            box-shadow: 0 20px 60px rgba(0, 0, 0, 0.3);
+            padding: 40px;
+            max-width: 600px;
+            width: 100%;
+        }
+        h1 {
+            text-align: center;
+            color: #333;
+            margin-bottom: 30px;
+            font-size: 2em;
+        }
+        .control-title {
+            font-size: 1.2em;
+            color: #333;
+            margin-bottom: 15px;
+            font-weight: 600;
+        }
+        .guest-form {
+            display: grid;
+            grid-template-columns: 1fr 1fr;
+            gap: 10px;
+            margin-bottom: 30px;
+        }
+        .guest-form label {
+            display: flex;
+            flex-direction: column;
+            gap: 4px;
+            color: #666;
+            font-size: 0.85em;
+        }
+        .guest-form label.check {
+            flex-direction: row;
+            align-items: center;
+            gap: 8px;
+        }
+        .guest-form input,
+        .guest-form select {
+            padding: 10px;
+            border: 2px solid #ddd;
+            border-radius: 10px;
+            font-size: 1em;
+            background: white;
+        }
+        .set-temp-button {
+            grid-column: 1 / -1;
+            padding: 15px;
+            background: #667eea;
+            color: white;
+            border: none;
+            border-radius: 10px;
+            font-size: 1.1em;
+            font-weight: 600;
+            cursor: pointer;
+        }
+        .set-temp-button:hover {
+            background: #5568d3;
+        }
+        .guest {
+            background: #f8f9fa;
+            border-radius: 10px;
+            padding: 15px;
+            margin-bottom: 10px;
+        }
+        .guest.expired {
+            opacity: 0.6;
+        }
+        .guest-name {
+            font-weight: 600;
+            color: #333;
+        }
+        .guest-detail {
+            color: #666;
+            font-size: 0.9em;
+            margin: 4px 0 10px;
+        }
+        .guest-url {
+            width: 100%;
+            padding: 8px;
+            border: 2px solid #ddd;
+            border-radius: 8px;
+            font-size: 0.85em;
+            margin-bottom: 8px;
+        }
+        .boost-cancel {
+            background: white;
+            border: 2px solid #e0b070;
+            border-radius: 8px;
+            padding: 6px 12px;
+            cursor: pointer;
+            font-weight: 600;
+            margin-right: 6px;
+        }
+        .empty {
+            color: #666;
+            text-align: center;
+        }
+        .message {
+            padding: 15px;
+            border-radius: 10px;
+            margin-top: 15px;
+            text-align: center;
+            font-weight: 600;
+            display: none;
+        }
+        .message.success {
+            background: #d4edda;
+            color: #155724;
+        }
+        .message.error {
+            background: #f8d7da;
+            color: #721c24;
+        }
+        .nav-link {
+            display: block;
+            text-align: center;
+            color: #667eea;
+            text-decoration: none;
+            font-weight: 600;
+            margin-top: 20px;
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>👋 Guest Links</h1>
+
+        <div class="control-title">New link</div>
+        <div class="guest-form">
+            <label>For <input id="guestName" placeholder="house-sitter"></label>
+            <label>Thermostat <select id="guestDevice"></select></label>
+            <label>Lowest setpoint <input type="number" id="guestMin" step="0.5"></label>
+            <label>Highest setpoint <input type="number" id="guestMax" step="0.5"></label>
+            <label>Days <input type="number" id="guestDays" value="7" min="1"></label>
+            <label class="check"><input type="checkbox" id="guestMode"> Can change the mode</label>
+            <button class="set-temp-button" onclick="createGuest()">Make link</button>
+        </div>
+
+        <div class="control-title">Links</div>
+        <div id="guests"></div>
+        <div class="message" id="message"></div>
+        <a class="nav-link" href="/">← Back to Thermostat</a>
+    </div>
+    <script>
+        const currentUnit = localStorage.getItem('thermostatUnit') || '';
+        const unitQuery = currentUnit ? '?unit=' + currentUnit : '';
+
+        function showMessage(text, type) {
+            const msg = document.getElementById('message');
+            msg.textContent = text;
+            msg.className = 'message ' + type;
+            msg.style.display = 'block';
+            setTimeout(() => {
+                msg.style.display = 'none';
+            }, 3000);
+        }
+
+        async function loadDevices() {
+            const response = await fetch('/api/devices');
+            if (!response.ok) throw new Error('Failed to load thermostats');
+            const picker = document.getElementById('guestDevice');
+            (await response.json()).forEach(d => {
+                const option = document.createElement('option');
+                option.value = d.name;
+                option.textContent = d.name;
+                picker.appendChild(option);
+            });
+            const celsius = currentUnit === 'C';
+            document.getElementById('guestMin').value = celsius ? 19 : 66;
+            document.getElementById('guestMax').value = celsius ? 23.5 : 74;
+        }
+
+        function renderGuest(g) {
+            const symbol = g.unit === 'C' ? '°C' : '°F';
+            const div = document.createElement('div');
+            div.className = 'guest' + (g.expired ? ' expired' : '');
+
+            const name = document.createElement('div');
+            name.className = 'guest-name';
+            name.textContent = g.name + ' on ' + g.device;
+            div.appendChild(name);
+
+            const detail = document.createElement('div');
+            detail.className = 'guest-detail';
+            detail.textContent = g.minTemp + '–' + g.maxTemp + symbol + (g.mode ? ', can change the mode' : ', setpoints only') +
+                (g.expired ? ', expired ' : ', until ') + new Date(g.expires).toLocaleString();
+            div.appendChild(detail);
+
+            if (!g.expired) {
+                const url = document.createElement('input');
+                url.className = 'guest-url';
+                url.readOnly = true;
+                url.value = g.url;
+                div.appendChild(url);
+
+                const copy = document.createElement('button');
+                copy.className = 'boost-cancel';
+                copy.textContent = 'Copy';
+                copy.addEventListener('click', () => {
+                    url.select();
+                    navigator.clipboard.writeText(g.url).then(() => showMessage('Link copied', 'success'));
+                });
+                div.appendChild(copy);
+            }
+
+            const revoke = document.createElement('button');
+            revoke.className = 'boost-cancel';
+            revoke.textContent = g.expired ? 'Remove' : 'Revoke';
+            revoke.addEventListener('click', () => revokeGuest(g));
+            div.appendChild(revoke);
+            return div;
+        }
+
+        async function loadGuests() {
+            try {
+                const response = await fetch('/api/guests' + unitQuery);
+                if (!response.ok) throw new Error(await response.text());
+                const list = await response.json();
+                const el = document.getElementById('guests');
+                el.innerHTML = '';
+                if (list.length === 0) {
+                    el.innerHTML = '<div class="empty">No guest links</div>';
+                }
+                list.reverse().forEach(g => el.appendChild(renderGuest(g)));
+            } catch (error) {
+                showMessage('Failed to load guest links: ' + error.message, 'error');
+            }
+        }
+
+        async function createGuest() {
+            try {
+                const response = await fetch('/api/guests' + unitQuery, {
+                    method: 'POST',
+                    headers: {
+                        'Content-Type': 'application/json',
+                    },
+                    body: JSON.stringify({
+                        name: document.getElementById('guestName').value.trim(),
+                        device: document.getElementById('guestDevice').value,
+                        min: parseFloat(document.getElementById('guestMin').value),
+                        max: parseFloat(document.getElementById('guestMax').value),
+                        days: parseInt(document.getElementById('guestDays').value) || 7,
+                        mode: document.getElementById('guestMode').checked
+                    })
+                });
+                if (!response.ok) throw new Error(await response.text());
+                showMessage('Link made', 'success');
+                loadGuests();
+            } catch (error) {
+                showMessage('Failed to make link: ' + error.message, 'error');
+            }
+        }
+
+        async function revokeGuest(g) {
+            if (!g.expired && !confirm('Revoke the link for ' + g.name + '? It stops working straight away.')) return;
+            try {
+                const response = await fetch('/api/guests/' + encodeURIComponent(g.id), { method: 'DELETE' });
+                if (!response.ok) throw new Error(await response.text());
+                loadGuests();
+            } catch (error) {
+                showMessage('Failed to revoke link: ' + error.message, 'error');
+            }
+        }
+
+        loadDevices().then(loadGuests).catch(error => showMessage(error.message, 'error'));
+    </script>
+</body>
+</html>
+`
@@ -0,0 +1,611 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/internal/history"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+// historyPruneInterval is how often samples past the retention period are
+// removed.
+const historyPruneInterval = time.Hour
+
+// historyDB is where recordHistory keeps samples. It is nil when recording
+// is turned off or its file could not be opened.
+var historyDB *history.Store
+
+// historyRange is a span of history the charts can show, and the bucket
+// size that keeps it to a few hundred points.
+type historyRange struct {
+	span, step time.Duration
+}
+
+var historyRanges = map[string]historyRange{
+	"day":   {24 * time.Hour, 5 * time.Minute},
+	"week":  {7 * 24 * time.Hour, 30 * time.Minute},
+	"month": {30 * 24 * time.Hour, 2 * time.Hour},
+}
+
+// HistoryPoint is one bucket of a history series, in the request's unit.
+// Heat and Cool are null when the thermostat's mode did not use them.
+type HistoryPoint struct {
+	Time    time.Time `json:"time"`
+	Temp    float64   `json:"temp"`
+	MinTemp float64   `json:"minTemp"`
+	MaxTemp float64   `json:"maxTemp"`
+	Heat    *float64  `json:"heat"`
+	Cool    *float64  `json:"cool"`
+	Heating float64   `json:"heating"`
+	Cooling float64   `json:"cooling"`
+	Fan     float64   `json:"fan"`
+}
+
+// HistoryResponse is the reply from GET .../history. Step is the bucket
+// size in seconds.
+type HistoryResponse struct {
+	Unit   ct50.Unit      `json:"unit"`
+	Range  string         `json:"range"`
+	From   time.Time      `json:"from"`
+	To     time.Time      `json:"to"`
+	Step   int            `json:"step"`
+	Points []HistoryPoint `json:"points"`
+}
+
+func historyPoint(p history.Point, unit ct50.Unit) HistoryPoint {
+	hp := HistoryPoint{
+		Time:    p.Time,
+		Temp:    unit.Reading(p.Temp),
+		MinTemp: unit.Reading(p.MinTemp),
+		MaxTemp: unit.Reading(p.MaxTemp),
+		Heating: p.Heating,
+		Cooling: p.Cooling,
+		Fan:     p.Fan,
+	}
+	if (p.Tmode == ct50.ModeHeat || p.Tmode == ct50.ModeAuto) && p.THeat != 0 {
+		heat := unit.Setpoint(p.THeat)
+		hp.Heat = &heat
+	}
+	if (p.Tmode == ct50.ModeCool || p.Tmode == ct50.ModeAuto) && p.TCool != 0 {
+		cool := unit.Setpoint(p.TCool)
+		hp.Cool = &cool
+	}
+	return hp
+}
+
+// handleHistory returns the thermostat's recorded history for
+// ?range=day (the default), week or month, ending now or at ?end= (RFC
+// 3339), downsampled to a few hundred points.
+func handleHistory(w http.ResponseWriter, r *http.Request, dev *device) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if historyDB == nil {
+		http.Error(w, "History is not being recorded", http.StatusNotFound)
+		return
+	}
+
+	unit, err := requestUnit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := r.URL.Query().Get("range")
+	if name == "" {
+		name = "day"
+	}
+	rng, ok := historyRanges[name]
+	if !ok {
+		http.Error(w, "Range must be day, week or month", http.StatusBadRequest)
+		return
+	}
+
+	to := time.Now()
+	if s := r.URL.Query().Get("end"); s != "" {
+		if to, err = time.Parse(time.RFC3339, s); err != nil {
+			http.Error(w, "End must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+	}
+	// Buckets line up with the step, so refreshing does not shift them.
+	to = to.Truncate(rng.step).Add(rng.step)
+	from := to.Add(-rng.span)
+
+	points, err := historyDB.Query(dev.Name, from, to, rng.step)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := HistoryResponse{
+		Unit:   unit,
+		Range:  name,
+		From:   from,
+		To:     to,
+		Step:   int(rng.step.Seconds()),
+		Points: make([]HistoryPoint, len(points)),
+	}
+	for i, p := range points {
+		resp.Points[i] = historyPoint(p, unit)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// recordHistory samples every thermostat each interval and drops samples
+// older than retention, until ctx is done.
+func recordHistory(ctx context.Context, store *history.Store, interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var pruned time.Time
+	for {
+		now := time.Now()
+		recordSamples(ctx, store, now, interval)
+
+		if retention > 0 && now.Sub(pruned) >= historyPruneInterval {
+			if n, err := store.Prune(now.Add(-retention)); err != nil {
+				log.Printf("history: %v", err)
+			} else if n > 0 {
+				log.Printf("history: removed %d samples older than %v", n, retention)
+			}
+			pruned = now
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// recordSamples adds a sample taken at now for every thermostat. A
+// thermostat that does not answer within interval is skipped this time.
+func recordSamples(ctx context.Context, store *history.Store, now time.Time, interval time.Duration) {
+	for _, dev := range devices {
+		reqCtx, cancel := context.WithTimeout(ctx, interval)
+		stats, err := dev.client.Status(reqCtx)
+		cancel()
+		if err != nil {
+			log.Printf("history: %s: %v", dev.Name, err)
+			continue
+		}
+
+		if err := store.Add(dev.Name, history.NewSample(stats, now)); err != nil {
+			log.Printf("history: %s: %v", dev.Name, err)
+		}
+	}
+}
+
+func handleHistoryPage(w http.ResponseWriter, r *http.Request) {
+	tmpl := template.Must(template.New("history").Parse(historyHTML))
+	tmpl.Execute(w, nil)
+}
+
+const historyHTML = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Thermostat History</title>
+    <script src="/auth.js"></script>
+    <style>
+        * {
+            margin: 0;
+            padding: 0;
+            box-sizing: border-box;
+        }
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Oxygen, Ubuntu, Cantarell, sans-serif;
+            background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
+            min-height: 100vh;
+            display: flex;
+            justify-content: center;
+            align-items: center;
+            padding: 20px;
+        }
+        .container {
+            background: white;
+            border-radius: 20px;
+            box-shadow: 0 20px 60px rgba(0, 0, 0, 0.3);
+            padding: 40px;
+            max-width: 1000px;
+            width: 100%;
+        }
+        h1 {
+            text-align: center;
+            color: #333;
+            margin-bottom: 30px;
+            font-size: 2em;
+        }
+        .nav-link {
+            display: inline-block;
+            margin-bottom: 20px;
+            color: #667eea;
+            text-decoration: none;
+            font-weight: 600;
+        }
+        .device-picker {
+            display: block;
+            margin: -15px auto 25px;
+            padding: 8px 12px;
+            border: 2px solid #ddd;
+            border-radius: 8px;
+            font-size: 1em;
+            background: white;
+        }
+        .range-tabs {
+            display: grid;
+            grid-template-columns: repeat(3, 1fr);
+            gap: 10px;
+            margin-bottom: 20px;
+        }
+        .mode-button {
+            padding: 15px;
+            border: 2px solid #ddd;
+            background: white;
+            border-radius: 10px;
+            cursor: pointer;
+            font-size: 1em;
+            font-weight: 600;
+            transition: all 0.3s;
+        }
+        .mode-button:hover {
+            border-color: #667eea;
+        }
+        .mode-button.active {
+            background: #667eea;
+            color: white;
+            border-color: #667eea;
+        }
+        .readout {
+            min-height: 1.4em;
+            color: #333;
+            font-size: 0.95em;
+            margin-bottom: 8px;
+        }
+        #chart {
+            display: block;
+            width: 100%;
+            height: 360px;
+            background: #f8f9fa;
+            border-radius: 10px;
+        }
+        .legend {
+            display: flex;
+            flex-wrap: wrap;
+            gap: 15px;
+            margin-top: 12px;
+            color: #666;
+            font-size: 0.9em;
+        }
+        .swatch {
+            display: inline-block;
+            width: 14px;
+            height: 14px;
+            border-radius: 3px;
+            vertical-align: middle;
+            margin-right: 4px;
+        }
+        .hint {
+            color: #666;
+            font-size: 0.9em;
+            margin-top: 10px;
+        }
+        .message {
+            padding: 15px;
+            border-radius: 10px;
+            margin-top: 15px;
+            text-align: center;
+            font-weight: 600;
+            display: none;
+        }
+        .message.error {
+            background: #f8d7da;
+            color: #721c24;
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <a class="nav-link" href="/">← Back to Thermostat</a>
+        <h1>📈 History</h1>
+        <select class="device-picker" id="devicePicker" style="display: none;" onchange="selectDevice(this.value)"></select>
+
+        <div class="range-tabs">
+            <button class="mode-button active" data-range="day" onclick="showRange('day')">Day</button>
+            <button class="mode-button" data-range="week" onclick="showRange('week')">Week</button>
+            <button class="mode-button" data-range="month" onclick="showRange('month')">Month</button>
+        </div>
+
+        <div class="readout" id="readout"></div>
+        <canvas id="chart"></canvas>
+        <div class="legend">
+            <span><span class="swatch" style="background: #333;"></span>Indoor</span>
+            <span><span class="swatch" style="background: #d35400;"></span>Heat setpoint</span>
+            <span><span class="swatch" style="background: #2980b9;"></span>Cool setpoint</span>
+            <span><span class="swatch" style="background: rgba(230, 126, 34, 0.35);"></span>Heating</span>
+            <span><span class="swatch" style="background: rgba(41, 128, 185, 0.35);"></span>Cooling</span>
+            <span><span class="swatch" style="background: rgba(46, 125, 50, 0.6);"></span>Fan</span>
+        </div>
+        <div class="hint" id="summary"></div>
+        <div class="message" id="message"></div>
+    </div>
+
+    <script>
+        const pad = { left: 48, right: 12, top: 12, bottom: 30 };
+        let currentDevice = new URLSearchParams(location.search).get('device') || localStorage.getItem('thermostatDevice') || '';
+        let currentUnit = localStorage.getItem('thermostatUnit') || '';
+        let currentRange = 'day';
+        let series = null;
+        let symbol = '°F';
+
+        function historyURL() {
+            let url = '/api/devices/' + encodeURIComponent(currentDevice) + '/history?range=' + currentRange;
+            return currentUnit ? url + '&unit=' + currentUnit : url;
+        }
+
+        async function loadDevices() {
+            const response = await fetch('/api/devices');
+            if (!response.ok) throw new Error('Failed to load thermostats');
+            const list = await response.json();
+
+            if (!list.some(d => d.name === currentDevice)) {
+                currentDevice = list.length > 0 ? list[0].name : '';
+            }
+
+            const picker = document.getElementById('devicePicker');
+            picker.innerHTML = '';
+            list.forEach(d => {
+                const option = document.createElement('option');
+                option.value = d.name;
+                option.textContent = d.name;
+                option.selected = d.name === currentDevice;
+                picker.appendChild(option);
+            });
+            picker.style.display = list.length > 1 ? 'block' : 'none';
+        }
+
+        function selectDevice(name) {
+            currentDevice = name;
+            localStorage.setItem('thermostatDevice', name);
+            history.replaceState(null, '', '?device=' + encodeURIComponent(name));
+            loadHistory();
+        }
+
+        function showRange(range) {
+            currentRange = range;
+            document.querySelectorAll('.mode-button[data-range]').forEach(btn => {
+                btn.classList.toggle('active', btn.getAttribute('data-range') === range);
+            });
+            loadHistory();
+        }
+
+        function showMessage(text, type) {
+            const msg = document.getElementById('message');
+            msg.textContent = text;
+            msg.className = 'message ' + type;
+            msg.style.display = 'block';
+        }
+
+        async function loadHistory() {
+            try {
+                const response = await fetch(historyURL());
+                if (!response.ok) {
+                    const error = await response.text();
+                    throw new Error(error);
+                }
+                series = await response.json();
+                symbol = series.unit === 'C' ? '°C' : '°F';
+                document.getElementById('message').style.display = 'none';
+                drawChart();
+                showSummary();
+            } catch (error) {
+                showMessage('Failed to load history: ' + error.message, 'error');
+            }
+        }
+
+        // scales maps times and temperatures to canvas coordinates for the
+        // loaded series.
+        function scales(width, height) {
+            const from = Date.parse(series.from);
+            const to = Date.parse(series.to);
+            let lo = Infinity;
+            let hi = -Infinity;
+            series.points.forEach(p => {
+                [p.minTemp, p.maxTemp, p.heat, p.cool].forEach(v => {
+                    if (v === null || v === undefined) return;
+                    lo = Math.min(lo, v);
+                    hi = Math.max(hi, v);
+                });
+            });
+            if (!isFinite(lo)) {
+                lo = symbol === '°C' ? 15 : 60;
+                hi = symbol === '°C' ? 25 : 80;
+            }
+            lo = Math.floor(lo - 1);
+            hi = Math.ceil(hi + 1);
+
+            const plotW = width - pad.left - pad.right;
+            const plotH = height - pad.top - pad.bottom;
+            return {
+                from: from,
+                to: to,
+                lo: lo,
+                hi: hi,
+                plotH: plotH,
+                x: t => pad.left + (t - from) / (to - from) * plotW,
+                y: v => pad.top + (hi - v) / (hi - lo) * plotH,
+                time: px => from + (px - pad.left) / plotW * (to - from),
+            };
+        }
+
+        function timeTicks(from, to) {
+            const ticks = [];
+            const d = new Date(from);
+            d.setMinutes(0, 0, 0);
+            if (currentRange === 'day') {
+                for (d.setHours(d.getHours() + 1); d.getTime() <= to; d.setHours(d.getHours() + 1)) {
+                    if (d.getHours() % 3 === 0) {
+                        ticks.push({ t: d.getTime(), label: d.toLocaleTimeString([], { hour: '2-digit', minute: '2-digit' }) });
+                    }
+                }
+                return ticks;
+            }
+            const every = currentRange === 'week' ? 1 : 5;
+            const format = currentRange === 'week' ? { weekday: 'short', day: 'numeric' } : { month: 'short', day: 'numeric' };
+            d.setHours(0);
+            for (d.setDate(d.getDate() + 1); d.getTime() <= to; d.setDate(d.getDate() + every)) {
+                ticks.push({ t: d.getTime(), label: d.toLocaleDateString([], format) });
+            }
+            return ticks;
+        }
+
+        function drawChart() {
+            if (!series) return;
+            const canvas = document.getElementById('chart');
+            const width = canvas.clientWidth;
+            const height = canvas.clientHeight;
+            const ratio = window.devicePixelRatio || 1;
+            canvas.width = width * ratio;
+            canvas.height = height * ratio;
+
+            const ctx = canvas.getContext('2d');
+            ctx.setTransform(ratio, 0, 0, ratio, 0, 0);
+            ctx.clearRect(0, 0, width, height);
+
+            const s = scales(width, height);
+            const step = series.step * 1000;
+
+            // Shade each bucket by how much of it the heat or cooling ran,
+            // with the fan as a strip along the bottom.
+            series.points.forEach(p => {
+                const t = Date.parse(p.time);
+                const x0 = s.x(t);
+                const w = Math.max(1, s.x(t + step) - x0);
+                if (p.heating > 0) {
+                    ctx.fillStyle = 'rgba(230, 126, 34, ' + (p.heating * 0.35) + ')';
+                    ctx.fillRect(x0, pad.top, w, s.plotH);
+                }
+                if (p.cooling > 0) {
+                    ctx.fillStyle = 'rgba(41, 128, 185, ' + (p.cooling * 0.35) + ')';
+                    ctx.fillRect(x0, pad.top, w, s.plotH);
+                }
+                if (p.fan > 0) {
+                    ctx.fillStyle = 'rgba(46, 125, 50, ' + (0.2 + p.fan * 0.4) + ')';
+                    ctx.fillRect(x0, pad.top + s.plotH - 6, w, 6);
+                }
+            });
+
+            ctx.font = '12px sans-serif';
+            ctx.lineWidth = 1;
+            ctx.strokeStyle = '#e0e0e0';
+            ctx.fillStyle = '#666';
+            const span = s.hi - s.lo;
+            const yStep = span > 40 ? 10 : span > 16 ? 5 : span > 8 ? 2 : 1;
+            ctx.textAlign = 'right';
+            ctx.textBaseline = 'middle';
+            for (let v = Math.ceil(s.lo / yStep) * yStep; v <= s.hi; v += yStep) {
+                ctx.beginPath();
+                ctx.moveTo(pad.left, s.y(v));
+                ctx.lineTo(width - pad.right, s.y(v));
+                ctx.stroke();
+                ctx.fillText(v + symbol, pad.left - 6, s.y(v));
+            }
+            ctx.textAlign = 'center';
+            ctx.textBaseline = 'top';
+            timeTicks(s.from, s.to).forEach(tick => {
+                ctx.beginPath();
+                ctx.moveTo(s.x(tick.t), pad.top);
+                ctx.lineTo(s.x(tick.t), pad.top + s.plotH);
+                ctx.stroke();
+                ctx.fillText(tick.label, s.x(tick.t), pad.top + s.plotH + 8);
+            });
+
+            drawLine(ctx, s, 'heat', '#d35400', true);
+            drawLine(ctx, s, 'cool', '#2980b9', true);
+            drawLine(ctx, s, 'temp', '#333', false);
+
+            if (series.points.length === 0) {
+                ctx.fillStyle = '#999';
+                ctx.textBaseline = 'middle';
+                ctx.fillText('Nothing recorded in this range yet', pad.left + (width - pad.left - pad.right) / 2, pad.top + s.plotH / 2);
+            }
+        }
+
+        // drawLine draws one series, breaking it where a value is missing
+        // or nothing was recorded. Setpoints are drawn as steps.
+        function drawLine(ctx, s, key, color, stepped) {
+            const step = series.step * 1000;
+            ctx.save();
+            ctx.strokeStyle = color;
+            ctx.lineWidth = 2;
+            ctx.beginPath();
+            let prev = null;
+            series.points.forEach(p => {
+                const v = p[key];
+                const t = Date.parse(p.time) + step / 2;
+                if (v === null || v === undefined) {
+                    prev = null;
+                    return;
+                }
+                if (prev && t - prev.t <= step * 1.5) {
+                    if (stepped) ctx.lineTo(s.x(t), s.y(prev.v));
+                    ctx.lineTo(s.x(t), s.y(v));
+                } else {
+                    ctx.moveTo(s.x(t), s.y(v));
+                }
+                prev = { t: t, v: v };
+            });
+            ctx.stroke();
+            ctx.restore();
+        }
+
+        function showSummary() {
+            const hours = key => series.points.reduce((sum, p) => sum + p[key] * series.step, 0) / 3600;
+            document.getElementById('summary').textContent = series.points.length === 0 ? '' :
+                'Heating ran ' + hours('heating').toFixed(1) + ' h, cooling ' + hours('cooling').toFixed(1) + ' h, fan ' + hours('fan').toFixed(1) + ' h over this ' + currentRange + '.';
+        }
+
+        function showReadout(event) {
+            if (!series || series.points.length === 0) return;
+            const canvas = document.getElementById('chart');
+            const s = scales(canvas.clientWidth, canvas.clientHeight);
+            const t = s.time(event.offsetX);
+            let best = series.points[0];
+            series.points.forEach(p => {
+                if (Math.abs(Date.parse(p.time) - t) < Math.abs(Date.parse(best.time) - t)) best = p;
+            });
+
+            const when = new Date(best.time).toLocaleString([], { weekday: 'short', month: 'short', day: 'numeric', hour: '2-digit', minute: '2-digit' });
+            let text = when + ': ' + best.temp.toFixed(1) + symbol;
+            if (best.heat !== null) text += ', heat to ' + best.heat + symbol;
+            if (best.cool !== null) text += ', cool to ' + best.cool + symbol;
+            if (best.heating > 0) text += ', heating ' + Math.round(best.heating * 100) + '%';
+            if (best.cooling > 0) text += ', cooling ' + Math.round(best.cooling * 100) + '%';
+            document.getElementById('readout').textContent = text;
+        }
+
+        document.getElementById('chart').addEventListener('mousemove', showReadout);
+        document.getElementById('chart').addEventListener('click', showReadout);
+        window.addEventListener('resize', drawChart);
+
+        loadDevices().then(loadHistory).catch(error => showMessage(error.message, 'error'));
+
+        // New samples come in every minute or so; there is no need to chase
+        // them more often than this.
+        setInterval(loadHistory, 5 * 60 * 1000);
+    </script>
+</body>
+</html>
+`
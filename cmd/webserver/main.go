@@ -1,226 +1,248 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
-	"strings"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/internal/config"
+	"github.com/EntropySynthetica/Thermostat/internal/history"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
 )
 
 const WebServerVersion = "1.0.0"
 
-// ThermoStats represents the thermostat status
-type ThermoStats struct {
-	Temp     float64 `json:"temp"`
-	Tmode    int     `json:"tmode"`
-	Fmode    int     `json:"fmode"`
-	Override int     `json:"override"`
-	Hold     int     `json:"hold"`
-	THeat    float64 `json:"t_heat"`
-	TCool    float64 `json:"t_cool"`
-	Tstate   int     `json:"tstate"`
-	Fstate   int     `json:"fstate"`
-	Time     struct {
-		Day    int `json:"day"`
-		Hour   int `json:"hour"`
-		Minute int `json:"minute"`
-	} `json:"time"`
-	TTypePost int `json:"t_type_post"`
-}
-
-// Config represents the application configuration
-type Config struct {
-	ThermostatIP string `json:"ThermostatIP"`
-}
+// minSetpoint and maxSetpoint bound the setpoints the UI and API accept, in
+// degrees F.
+const (
+	minSetpoint = 50
+	maxSetpoint = 90
+)
 
-// StatusResponse represents the formatted status for the web UI
+// StatusResponse represents the formatted status for the web UI. Every
+// temperature is in Unit.
 type StatusResponse struct {
-	CurrentTemp    float64 `json:"currentTemp"`
-	TargetTemp     float64 `json:"targetTemp"`
-	Mode           string  `json:"mode"`
-	ModeCode       int     `json:"modeCode"`
-	OperatingState string  `json:"operatingState"`
-	Override       string  `json:"override"`
-	Hold           string  `json:"hold"`
+	Unit           ct50.Unit      `json:"unit"`
+	MinTemp        float64        `json:"minTemp"`
+	MaxTemp        float64        `json:"maxTemp"`
+	CurrentTemp    float64        `json:"currentTemp"`
+	TargetTemp     float64        `json:"targetTemp"`
+	HeatTemp       float64        `json:"heatTemp"`
+	CoolTemp       float64        `json:"coolTemp"`
+	Deadband       float64        `json:"deadband"`
+	Mode           string         `json:"mode"`
+	ModeCode       int            `json:"modeCode"`
+	OperatingState string         `json:"operatingState"`
+	FanMode        string         `json:"fanMode"`
+	FanModeCode    int            `json:"fanModeCode"`
+	FanState       string         `json:"fanState"`
+	Override       string         `json:"override"`
+	Hold           string         `json:"hold"`
+	Next           []NextChange   `json:"next"`
+	Boost          *BoostInfo     `json:"boost,omitempty"`
+	Vacation       *VacationInfo  `json:"vacation,omitempty"`
+	Scheduler      *SchedulerInfo `json:"scheduler,omitempty"`
 }
 
-var thermostatIP string
-
-// getStats retrieves the current thermostat status
-func getStats(ip string) (*ThermoStats, error) {
-	pollURL := "http://" + ip + "/tstat"
+// formatStats converts raw stats to a user-friendly format in unit
+func formatStats(stats *ct50.Status, unit ct50.Unit) *StatusResponse {
+	return &StatusResponse{
+		Unit:           unit,
+		MinTemp:        lowerBound(unit, minSetpoint),
+		MaxTemp:        upperBound(unit, maxSetpoint),
+		CurrentTemp:    unit.Reading(stats.Temp),
+		TargetTemp:     unit.Setpoint(stats.Target()),
+		HeatTemp:       unit.Setpoint(stats.THeat),
+		CoolTemp:       unit.Setpoint(stats.TCool),
+		Mode:           stats.Tmode.String(),
+		ModeCode:       int(stats.Tmode),
+		OperatingState: stats.Tstate.String(),
+		FanMode:        stats.Fmode.String(),
+		FanModeCode:    int(stats.Fmode),
+		FanState:       stats.Fstate.String(),
+		Override:       stats.Override.String(),
+		Hold:           stats.Hold.String(),
+	}
+}
 
-	response, err := http.Get(pollURL)
-	if err != nil {
-		return nil, err
+// requestUnit returns the temperature unit asked for with ?unit=, or
+// defaultUnit.
+func requestUnit(r *http.Request) (ct50.Unit, error) {
+	if s := r.URL.Query().Get("unit"); s != "" {
+		return ct50.ParseUnit(s)
 	}
-	defer response.Body.Close()
+	return defaultUnit, nil
+}
 
-	responseData, err := ioutil.ReadAll(response.Body)
+// API Handlers
+
+func handleStatus(w http.ResponseWriter, r *http.Request, dev *device) {
+	unit, err := requestUnit(r)
 	if err != nil {
-		return nil, err
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	var stats ThermoStats
-	err = json.Unmarshal(responseData, &stats)
+	stats, err := dev.client.Status(r.Context())
 	if err != nil {
-		return nil, err
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	return &stats, nil
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statusResponse(r.Context(), dev, stats, unit))
 }
 
-// formatStats converts raw stats to a user-friendly format
-func formatStats(stats *ThermoStats) *StatusResponse {
-	status := &StatusResponse{
-		CurrentTemp: stats.Temp,
-		ModeCode:    stats.Tmode,
-	}
-
-	// Determine the Thermostat Mode
-	switch stats.Tmode {
-	case 0:
-		status.Mode = "Off"
-	case 1:
-		status.Mode = "Heat"
-	case 2:
-		status.Mode = "Cool"
-	case 3:
-		status.Mode = "Auto"
-	default:
-		status.Mode = "Unknown"
-	}
-
-	// Get target temp based on mode
-	if stats.THeat != 0 {
-		status.TargetTemp = stats.THeat
-	} else if stats.TCool != 0 {
-		status.TargetTemp = stats.TCool
-	}
-
-	// Show the Operational State
-	switch stats.Tstate {
-	case 0:
-		status.OperatingState = "Off"
-	case 1:
-		status.OperatingState = "Heating"
-	case 2:
-		status.OperatingState = "Cooling"
-	default:
-		status.OperatingState = "Unknown"
-	}
-
-	// Show if an Override is active
-	switch stats.Override {
-	case 0:
-		status.Override = "Off"
-	case 1:
-		status.Override = "On"
-	default:
-		status.Override = "Unknown"
-	}
-
-	// Show if a manual hold is active
-	switch stats.Hold {
-	case 0:
-		status.Hold = "Off"
-	case 1:
-		status.Hold = "On"
-	default:
-		status.Hold = "Unknown"
+// statusResponse fills in the status card for stats: the device status
+// plus any boost, vacation and schedule the server is running for it.
+func statusResponse(ctx context.Context, dev *device, stats *ct50.Status, unit ct50.Unit) *StatusResponse {
+	status := formatStats(stats, unit)
+	status.Deadband = unit.Delta(dev.client.Deadband)
+	now := time.Now()
+	if b, err := boosts.Get(dev.Name); err == nil && b != nil {
+		status.Boost = boostInfo(b, unit, now)
+	}
+	if v, err := vacations.Get(dev.Name); err == nil && v != nil {
+		status.Vacation = vacationInfo(v, unit)
 	}
 
+	// A server-side schedule holds the device program off, so show its
+	// changes instead.
+	sched, err := schedules.Get(dev.Name)
+	if err != nil {
+		log.Printf("scheduler: %v", err)
+	}
+	if info := schedulerInfo(dev, sched, unit, now); info != nil {
+		status.Scheduler = info
+		status.Next = scheduledChanges(sched, stats, unit, now)
+	} else {
+		status.Next = nextChanges(ctx, dev, stats, unit)
+	}
 	return status
 }
 
-// setTemp sets the target temperature
-func setTemp(ip string, temp int) error {
-	pollURL := "http://" + ip + "/tstat"
+// handleSetTemp sets the target in heat or cool mode from {"temp": n}, or
+// the auto mode range from {"heat": n, "cool": n}. Temperatures are in the
+// request's unit and may be given to the half degree.
+func handleSetTemp(w http.ResponseWriter, r *http.Request, dev *device) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-	// Get current mode
-	stats, err := getStats(ip)
+	unit, err := requestUnit(r)
 	if err != nil {
-		return err
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	// Craft payload based on current mode
-	var query string
-	if stats.Tmode == 1 {
-		query = fmt.Sprintf("{\"tmode\":1,\"t_heat\":%d}", temp)
-	} else if stats.Tmode == 2 {
-		query = fmt.Sprintf("{\"tmode\":2,\"t_cool\":%d}", temp)
-	} else {
-		return fmt.Errorf("thermostat must be in heat or cool mode to set temperature")
+	// Pointers tell a missing setpoint from 0°C.
+	var req struct {
+		Temp *float64 `json:"temp"`
+		Heat *float64 `json:"heat"`
+		Cool *float64 `json:"cool"`
 	}
 
-	payload := strings.NewReader(query)
-	req, err := http.NewRequest("POST", pollURL, payload)
+	err = json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		return err
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
 	}
 
-	req.Header.Add("Content-Type", "application/json")
-
-	postResponse, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer postResponse.Body.Close()
+	if req.Heat != nil || req.Cool != nil {
+		if req.Heat == nil || req.Cool == nil {
+			http.Error(w, "Heat and cool setpoints must be set together", http.StatusBadRequest)
+			return
+		}
 
-	return nil
-}
+		heat, cool := unit.ToDevice(*req.Heat), unit.ToDevice(*req.Cool)
+		if !validTemp(heat) || !validTemp(cool) {
+			http.Error(w, "Heat and cool setpoints must both be between "+setpointRange(unit), http.StatusBadRequest)
+			return
+		}
 
-// setMode sets the thermostat operating mode
-func setMode(ip string, mode int) error {
-	pollURL := "http://" + ip + "/tstat"
+		err = dev.client.SetRange(r.Context(), heat, cool)
+		var deadbandErr *ct50.DeadbandError
+		if errors.As(err, &deadbandErr) {
+			http.Error(w, fmt.Sprintf("%v (cool must be at least %s above heat)", err, unit.Format(unit.Delta(deadbandErr.Min))), http.StatusBadRequest)
+			return
+		}
+	} else {
+		if req.Temp == nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
 
-	query := fmt.Sprintf("{\"tmode\":%d}", mode)
-	payload := strings.NewReader(query)
+		temp := unit.ToDevice(*req.Temp)
+		if !validTemp(temp) {
+			http.Error(w, "Temperature must be between "+setpointRange(unit), http.StatusBadRequest)
+			return
+		}
 
-	req, err := http.NewRequest("POST", pollURL, payload)
-	if err != nil {
-		return err
+		err = dev.client.SetTarget(r.Context(), temp)
+		if errors.Is(err, ct50.ErrNoTarget) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
 	}
-
-	req.Header.Add("Content-Type", "application/json")
-
-	postResponse, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return err
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	defer postResponse.Body.Close()
+	dev.run.changedByWeb()
 
-	return nil
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
 }
 
-// API Handlers
+// validTemp reports whether temp, in degrees F, is a setpoint the UI and
+// API accept.
+func validTemp(temp float64) bool {
+	return temp >= minSetpoint && temp <= maxSetpoint
+}
 
-func handleStatus(w http.ResponseWriter, r *http.Request) {
-	stats, err := getStats(thermostatIP)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+// setpointRange describes the accepted setpoints in unit, such as
+// "10 and 32°C".
+func setpointRange(unit ct50.Unit) string {
+	return fmt.Sprintf("%v and %s", lowerBound(unit, minSetpoint), unit.Format(upperBound(unit, maxSetpoint)))
+}
 
-	status := formatStats(stats)
+// lowerBound converts the lower limit f, in degrees F, to the smallest
+// setpoint in unit that does not fall below it.
+func lowerBound(unit ct50.Unit, f float64) float64 {
+	v := unit.Setpoint(f)
+	if unit.ToDevice(v) < f {
+		v += ct50.SetpointStep
+	}
+	return v
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(status)
+// upperBound converts the upper limit f, in degrees F, to the largest
+// setpoint in unit that does not rise above it.
+func upperBound(unit ct50.Unit, f float64) float64 {
+	v := unit.Setpoint(f)
+	if unit.ToDevice(v) > f {
+		v -= ct50.SetpointStep
+	}
+	return v
 }
 
-func handleSetTemp(w http.ResponseWriter, r *http.Request) {
+func handleSetMode(w http.ResponseWriter, r *http.Request, dev *device) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	var req struct {
-		Temp int `json:"temp"`
+		Mode int `json:"mode"`
 	}
 
 	err := json.NewDecoder(r.Body).Decode(&req)
@@ -229,29 +251,30 @@ func handleSetTemp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Temp < 50 || req.Temp > 90 {
-		http.Error(w, "Temperature must be between 50 and 90", http.StatusBadRequest)
+	if !ct50.Mode(req.Mode).Valid() {
+		http.Error(w, "Mode must be 0 (Off), 1 (Heat), 2 (Cool), or 3 (Auto)", http.StatusBadRequest)
 		return
 	}
 
-	err = setTemp(thermostatIP, req.Temp)
+	err = dev.client.SetMode(r.Context(), ct50.Mode(req.Mode))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	dev.run.changedByWeb()
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
 }
 
-func handleSetMode(w http.ResponseWriter, r *http.Request) {
+func handleSetFan(w http.ResponseWriter, r *http.Request, dev *device) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	var req struct {
-		Mode int `json:"mode"`
+		Fan int `json:"fan"`
 	}
 
 	err := json.NewDecoder(r.Body).Decode(&req)
@@ -260,16 +283,17 @@ func handleSetMode(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Mode < 0 || req.Mode > 3 {
-		http.Error(w, "Mode must be 0 (Off), 1 (Heat), 2 (Cool), or 3 (Auto)", http.StatusBadRequest)
+	if !ct50.FanMode(req.Fan).Valid() {
+		http.Error(w, "Fan must be 0 (Auto), 1 (Circulate), or 2 (On)", http.StatusBadRequest)
 		return
 	}
 
-	err = setMode(thermostatIP, req.Mode)
+	err = dev.client.SetFanMode(r.Context(), ct50.FanMode(req.Fan))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	dev.run.changedByWeb()
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
@@ -287,6 +311,7 @@ const indexHTML = `
     <meta charset="UTF-8">
     <meta name="viewport" content="width=device-width, initial-scale=1.0">
     <title>Thermostat Control</title>
+    <script src="/auth.js"></script>
     <style>
         * {
             margin: 0;
@@ -418,6 +443,90 @@ const indexHTML = `
             color: white;
             border-color: #667eea;
         }
+        .range-control {
+            margin-bottom: 10px;
+        }
+        .range-label {
+            font-weight: 600;
+            width: 70px;
+        }
+        .range-label.heat {
+            color: #d35400;
+        }
+        .range-label.cool {
+            color: #2980b9;
+        }
+        .range-hint {
+            color: #666;
+            font-size: 0.85em;
+            text-align: center;
+        }
+        .boost-control {
+            display: flex;
+            gap: 10px;
+            align-items: center;
+        }
+        .boost-control select {
+            padding: 12px;
+            border: 2px solid #ddd;
+            border-radius: 10px;
+            font-size: 1em;
+            background: white;
+        }
+        .boost-control .set-temp-button {
+            margin-top: 0;
+        }
+        .boost-status {
+            display: flex;
+            align-items: center;
+            justify-content: space-between;
+            background: #fff4e5;
+            color: #8a4b00;
+            padding: 15px;
+            border-radius: 10px;
+            font-weight: 600;
+            margin-bottom: 10px;
+        }
+        .boost-cancel {
+            background: white;
+            border: 2px solid #e0b070;
+            border-radius: 8px;
+            padding: 6px 12px;
+            cursor: pointer;
+            font-weight: 600;
+        }
+        .vacation-form {
+            display: grid;
+            grid-template-columns: 1fr 1fr;
+            gap: 10px;
+        }
+        .vacation-form label {
+            display: flex;
+            flex-direction: column;
+            gap: 4px;
+            color: #666;
+            font-size: 0.85em;
+        }
+        .vacation-form input,
+        .vacation-form select {
+            padding: 10px;
+            border: 2px solid #ddd;
+            border-radius: 10px;
+            font-size: 1em;
+            background: white;
+        }
+        .vacation-form .set-temp-button {
+            grid-column: 1 / -1;
+            margin-top: 0;
+        }
+        .fan-buttons {
+            display: grid;
+            grid-template-columns: repeat(3, 1fr);
+            gap: 10px;
+        }
+        .fan-running {
+            color: #2e7d32;
+        }
         .set-temp-button {
             width: 100%;
             padding: 15px;
@@ -470,21 +579,83 @@ const indexHTML = `
         .refresh-button:hover {
             transform: rotate(180deg);
         }
+        .unit-toggle {
+            position: absolute;
+            top: 20px;
+            left: 20px;
+            background: rgba(255, 255, 255, 0.9);
+            border: 2px solid #ddd;
+            border-radius: 20px;
+            padding: 8px 12px;
+            cursor: pointer;
+            font-size: 1em;
+            font-weight: 600;
+        }
+        .device-picker {
+            display: block;
+            margin: -15px auto 25px;
+            padding: 8px 12px;
+            border: 2px solid #ddd;
+            border-radius: 8px;
+            font-size: 1em;
+            background: white;
+        }
+        .next-change {
+            margin-top: 15px;
+            text-align: center;
+            color: #666;
+            font-size: 0.95em;
+        }
+        .scheduler-info {
+            margin-top: 15px;
+            padding: 12px;
+            background: white;
+            border-radius: 10px;
+            color: #444;
+            font-size: 0.95em;
+            text-align: center;
+        }
+        .scheduler-info .wall-change {
+            color: #8a4b00;
+            margin-top: 6px;
+        }
+        .scheduler-hold {
+            display: flex;
+            gap: 8px;
+            justify-content: center;
+            align-items: center;
+            margin-top: 10px;
+        }
+        .scheduler-hold input {
+            padding: 6px;
+            border: 2px solid #ddd;
+            border-radius: 8px;
+        }
+        .nav-link {
+            display: block;
+            text-align: center;
+            color: #667eea;
+            text-decoration: none;
+            font-weight: 600;
+            margin-top: 10px;
+        }
     </style>
 </head>
 <body>
     <div class="container" style="position: relative;">
+        <button class="unit-toggle" id="unitToggle" onclick="toggleUnit()" title="Switch between °F and °C">°F</button>
         <button class="refresh-button" onclick="loadStatus()">🔄</button>
         <h1>🌡️ Thermostat Control</h1>
+        <select class="device-picker" id="devicePicker" style="display: none;" onchange="selectDevice(this.value)"></select>
         
         <div class="status-card">
             <div class="status-label">Current Temperature</div>
-            <div class="temp-display" id="currentTemp">--°F</div>
+            <div class="temp-display" id="currentTemp">--</div>
             
             <div class="status-grid">
                 <div class="status-item">
                     <div class="status-label">Target</div>
-                    <div class="status-value" id="targetTemp">--°F</div>
+                    <div class="status-value" id="targetTemp">--</div>
                 </div>
                 <div class="status-item">
                     <div class="status-label">Mode</div>
@@ -498,19 +669,99 @@ const indexHTML = `
                     <div class="status-label">Hold</div>
                     <div class="status-value" id="hold">--</div>
                 </div>
+                <div class="status-item">
+                    <div class="status-label">Fan</div>
+                    <div class="status-value" id="fanMode">--</div>
+                </div>
+                <div class="status-item">
+                    <div class="status-label">Fan Status</div>
+                    <div class="status-value" id="fanState">--</div>
+                </div>
+            </div>
+
+            <div class="next-change" id="nextChange"></div>
+
+            <div class="scheduler-info" id="schedulerInfo" style="display: none;">
+                <div id="schedulerText"></div>
+                <div class="wall-change" id="wallChange"></div>
+                <div class="scheduler-hold">
+                    <input type="time" id="holdUntil" value="18:00">
+                    <button class="boost-cancel" onclick="holdSchedule()">Hold until</button>
+                    <button class="boost-cancel" id="resumeButton" onclick="resumeSchedule()">Resume schedule</button>
+                </div>
             </div>
         </div>
 
-        <div class="control-section">
+        <div class="control-section" id="singleSetpoint">
             <div class="control-title">Set Temperature</div>
             <div class="temp-control">
-                <button class="temp-button" onclick="adjustTemp(-1)">−</button>
-                <input type="number" id="tempInput" class="temp-input" value="70" min="50" max="90">
-                <button class="temp-button" onclick="adjustTemp(1)">+</button>
+                <button class="temp-button" onclick="adjustTemp(-0.5)">−</button>
+                <input type="number" id="tempInput" class="temp-input" value="70" min="50" max="90" step="0.5">
+                <button class="temp-button" onclick="adjustTemp(0.5)">+</button>
             </div>
             <button class="set-temp-button" onclick="setTemperature()">Set Temperature</button>
         </div>
 
+        <div class="control-section" id="rangeSetpoint" style="display: none;">
+            <div class="control-title">Auto Range</div>
+            <div class="temp-control range-control">
+                <span class="range-label heat">Heat to</span>
+                <button class="temp-button" onclick="adjustRange('heat', -0.5)">−</button>
+                <input type="number" id="heatInput" class="temp-input" value="68" min="50" max="90" step="0.5">
+                <button class="temp-button" onclick="adjustRange('heat', 0.5)">+</button>
+            </div>
+            <div class="temp-control range-control">
+                <span class="range-label cool">Cool to</span>
+                <button class="temp-button" onclick="adjustRange('cool', -0.5)">−</button>
+                <input type="number" id="coolInput" class="temp-input" value="76" min="50" max="90" step="0.5">
+                <button class="temp-button" onclick="adjustRange('cool', 0.5)">+</button>
+            </div>
+            <div class="range-hint" id="rangeHint"></div>
+            <button class="set-temp-button" onclick="setRange()">Set Range</button>
+        </div>
+
+        <div class="control-section" id="boostSection" style="display: none;">
+            <div class="control-title">Boost</div>
+            <div class="boost-status" id="boostStatus" style="display: none;">
+                <span id="boostText"></span>
+                <button class="boost-cancel" onclick="cancelBoost()">Cancel</button>
+            </div>
+            <div class="boost-control" id="boostControl">
+                <select id="boostFor">
+                    <option value="30m">30 min</option>
+                    <option value="1h">1 hour</option>
+                    <option value="2h" selected>2 hours</option>
+                    <option value="4h">4 hours</option>
+                    <option value="8h">8 hours</option>
+                </select>
+                <button class="set-temp-button" onclick="startBoost()">Boost to set temperature</button>
+            </div>
+        </div>
+
+        <div class="control-section">
+            <div class="control-title">Vacation</div>
+            <div class="boost-status" id="vacationStatus" style="display: none;">
+                <span id="vacationText"></span>
+                <button class="boost-cancel" id="vacationCancel" onclick="cancelVacation()">Cancel</button>
+            </div>
+            <div class="vacation-form" id="vacationForm">
+                <label>Leaving <input type="datetime-local" id="vacationFrom"></label>
+                <label>Back <input type="datetime-local" id="vacationTo"></label>
+                <label>Heat to <input type="number" id="vacationHeat" step="0.5"></label>
+                <label>Cool to <input type="number" id="vacationCool" step="0.5"></label>
+                <label>Get comfortable
+                    <select id="vacationRecover">
+                        <option value="">when back</option>
+                        <option value="1h">1 hour early</option>
+                        <option value="2h">2 hours early</option>
+                        <option value="3h" selected>3 hours early</option>
+                        <option value="6h">6 hours early</option>
+                    </select>
+                </label>
+                <button class="set-temp-button" onclick="setVacation()">Set vacation</button>
+            </div>
+        </div>
+
         <div class="control-section">
             <div class="control-title">Operating Mode</div>
             <div class="mode-buttons">
@@ -521,11 +772,92 @@ const indexHTML = `
             </div>
         </div>
 
+        <div class="control-section">
+            <div class="control-title">Fan</div>
+            <div class="fan-buttons">
+                <button class="mode-button fan-button" data-fan="0" onclick="setFan(0)">Auto</button>
+                <button class="mode-button fan-button" data-fan="1" onclick="setFan(1)">Circulate</button>
+                <button class="mode-button fan-button" data-fan="2" onclick="setFan(2)">On</button>
+            </div>
+        </div>
+
+        <a class="nav-link" href="/schedule">📅 Edit Weekly Schedule</a>
+        <a class="nav-link" href="/history">📈 History</a>
+        <a class="nav-link" href="/guests">👋 Guest Links</a>
+        <a class="nav-link" href="/audit">📜 Audit Log</a>
+        <a class="nav-link multi-device" href="/overview" style="display: none;">🏠 All Thermostats</a>
+
         <div class="message" id="message"></div>
     </div>
 
     <script>
         let currentMode = 0;
+        let currentFan = 0;
+        let deadband = 3;
+        let minTemp = 50;
+        let maxTemp = 90;
+        let symbol = '°F';
+        let boost = null;
+        let boostEnds = 0;
+        let vacationUnit = '';
+        let status = {};
+        let events = null;
+        let currentDevice = new URLSearchParams(location.search).get('device') || localStorage.getItem('thermostatDevice') || '';
+
+        // An empty unit leaves the choice to the server's default.
+        let currentUnit = localStorage.getItem('thermostatUnit') || '';
+
+        // api builds the URL for an action on the selected thermostat, with
+        // temperatures in the chosen unit.
+        function api(action) {
+            const url = '/api/devices/' + encodeURIComponent(currentDevice) + '/' + action;
+            return currentUnit ? url + '?unit=' + currentUnit : url;
+        }
+
+        function toggleUnit() {
+            currentUnit = symbol === '°C' ? 'F' : 'C';
+            localStorage.setItem('thermostatUnit', currentUnit);
+            connectEvents();
+        }
+
+        // setpointFields are the status fields the setpoint inputs show.
+        const setpointFields = ['modeCode', 'targetTemp', 'heatTemp', 'coolTemp', 'minTemp', 'maxTemp', 'deadband'];
+
+        // halfStep rounds a temperature to the nearest half degree.
+        function halfStep(value) {
+            return Math.round(value * 2) / 2;
+        }
+
+        async function loadDevices() {
+            const response = await fetch('/api/devices');
+            if (!response.ok) throw new Error('Failed to load thermostats');
+            const list = await response.json();
+
+            if (!list.some(d => d.name === currentDevice)) {
+                currentDevice = list.length > 0 ? list[0].name : '';
+            }
+
+            const picker = document.getElementById('devicePicker');
+            picker.innerHTML = '';
+            list.forEach(d => {
+                const option = document.createElement('option');
+                option.value = d.name;
+                option.textContent = d.name;
+                option.selected = d.name === currentDevice;
+                picker.appendChild(option);
+            });
+            picker.style.display = list.length > 1 ? 'block' : 'none';
+            document.querySelectorAll('.multi-device').forEach(el => {
+                el.style.display = list.length > 1 ? 'block' : 'none';
+            });
+        }
+
+        function selectDevice(name) {
+            currentDevice = name;
+            localStorage.setItem('thermostatDevice', name);
+            history.replaceState(null, '', '?device=' + encodeURIComponent(name));
+            connectEvents();
+        }
 
         function showMessage(text, type) {
             const msg = document.getElementById('message');
@@ -539,38 +871,123 @@ const indexHTML = `
 
         function adjustTemp(delta) {
             const input = document.getElementById('tempInput');
-            let value = parseInt(input.value) || 70;
+            let value = halfStep(parseFloat(input.value) || minTemp);
             value += delta;
-            value = Math.max(50, Math.min(90, value));
+            value = Math.max(minTemp, Math.min(maxTemp, value));
             input.value = value;
         }
 
+        // adjustRange moves one auto setpoint, pushing the other along to keep
+        // the deadband between them.
+        function adjustRange(which, delta) {
+            const heatInput = document.getElementById('heatInput');
+            const coolInput = document.getElementById('coolInput');
+            let heat = halfStep(parseFloat(heatInput.value) || minTemp);
+            let cool = halfStep(parseFloat(coolInput.value) || maxTemp);
+
+            if (which === 'heat') {
+                heat = Math.max(minTemp, Math.min(maxTemp - deadband, heat + delta));
+                cool = Math.max(cool, Math.ceil((heat + deadband) * 2) / 2);
+            } else {
+                cool = Math.max(minTemp + deadband, Math.min(maxTemp, cool + delta));
+                heat = Math.min(heat, Math.floor((cool - deadband) * 2) / 2);
+            }
+
+            heatInput.value = heat;
+            coolInput.value = cool;
+        }
+
+        function updateSetpointControls(data) {
+            const auto = data.modeCode === 3;
+            document.getElementById('singleSetpoint').style.display = auto ? 'none' : 'block';
+            document.getElementById('rangeSetpoint').style.display = auto ? 'block' : 'none';
+            document.getElementById('rangeHint').textContent = 'Keep at least ' + deadband + symbol + ' between heat and cool';
+
+            ['tempInput', 'heatInput', 'coolInput'].forEach(id => {
+                const input = document.getElementById(id);
+                input.min = minTemp;
+                input.max = maxTemp;
+            });
+
+            // Zero is a real setpoint in Celsius, so only a missing target
+            // (off mode) leaves the inputs alone.
+            if (auto) {
+                document.getElementById('heatInput').value = data.heatTemp;
+                document.getElementById('coolInput').value = data.coolTemp;
+            } else if (data.modeCode !== 0) {
+                document.getElementById('tempInput').value = data.targetTemp;
+            }
+        }
+
         async function loadStatus() {
             try {
-                const response = await fetch('/api/status');
+                const response = await fetch(api('status'));
                 if (!response.ok) throw new Error('Failed to load status');
-                
-                const data = await response.json();
-                
-                document.getElementById('currentTemp').textContent = data.currentTemp.toFixed(1) + '°F';
-                document.getElementById('targetTemp').textContent = data.targetTemp.toFixed(1) + '°F';
-                document.getElementById('mode').textContent = data.mode;
-                document.getElementById('operatingState').textContent = data.operatingState;
-                document.getElementById('hold').textContent = data.hold;
-                
-                currentMode = data.modeCode;
-                updateModeButtons();
-                
-                if (data.targetTemp > 0) {
-                    document.getElementById('tempInput').value = Math.round(data.targetTemp);
-                }
+
+                status = await response.json();
+                renderStatus(status, status);
             } catch (error) {
                 showMessage('Failed to load status: ' + error.message, 'error');
             }
         }
 
+        // connectEvents follows the selected thermostat's event stream. The
+        // server sends every field first and then only the ones that change.
+        function connectEvents() {
+            if (events) events.close();
+            status = {};
+            events = new EventSource(api('events'));
+            events.addEventListener('status', e => {
+                const changed = JSON.parse(e.data);
+                Object.assign(status, changed);
+                renderStatus(status, changed);
+            });
+            events.addEventListener('unavailable', e => {
+                showMessage('Thermostat not answering: ' + JSON.parse(e.data).error, 'error');
+            });
+        }
+
+        // renderStatus shows data on the page. The setpoint inputs are only
+        // reset when changed has new setpoints, so an event does not undo
+        // what is being typed.
+        function renderStatus(data, changed) {
+            symbol = data.unit === 'C' ? '°C' : '°F';
+            minTemp = data.minTemp;
+            maxTemp = data.maxTemp;
+            document.getElementById('unitToggle').textContent = symbol;
+
+            document.getElementById('currentTemp').textContent = data.currentTemp.toFixed(1) + symbol;
+            document.getElementById('targetTemp').textContent = data.modeCode === 0 ? '--'
+                : data.modeCode === 3 ? data.heatTemp.toFixed(1) + '–' + data.coolTemp.toFixed(1) + symbol
+                : data.targetTemp.toFixed(1) + symbol;
+            document.getElementById('mode').textContent = data.mode;
+            document.getElementById('operatingState').textContent = data.operatingState;
+            document.getElementById('hold').textContent = data.hold;
+            document.getElementById('fanMode').textContent = data.fanMode;
+
+            const fanState = document.getElementById('fanState');
+            fanState.textContent = data.fanState === 'On' ? 'Running' : 'Idle';
+            fanState.classList.toggle('fan-running', data.fanState === 'On');
+            
+            currentMode = data.modeCode;
+            updateModeButtons();
+
+            currentFan = data.fanModeCode;
+            updateFanButtons();
+
+            updateNextChange(data.next || []);
+
+            deadband = data.deadband || deadband;
+            if (setpointFields.some(name => name in changed)) {
+                updateSetpointControls(data);
+            }
+            updateBoost(data);
+            updateVacation(data.vacation);
+            updateScheduler(data.scheduler);
+        }
+
         function updateModeButtons() {
-            document.querySelectorAll('.mode-button').forEach(btn => {
+            document.querySelectorAll('.mode-button[data-mode]').forEach(btn => {
                 const mode = parseInt(btn.getAttribute('data-mode'));
                 if (mode === currentMode) {
                     btn.classList.add('active');
@@ -580,16 +997,257 @@ const indexHTML = `
             });
         }
 
+        function updateNextChange(next) {
+            const el = document.getElementById('nextChange');
+            if (next.length === 0) {
+                el.textContent = '';
+                return;
+            }
+            el.textContent = 'Next: ' + next.map(n =>
+                n.program + ' ' + n.temp + symbol + ' at ' + n.time + ' ' + n.day
+            ).join(', ');
+        }
+
+        function clockTime(iso) {
+            return new Date(iso).toLocaleTimeString([], { hour: '2-digit', minute: '2-digit' });
+        }
+
+        // updateScheduler shows what the server-side schedule is doing, if
+        // the thermostat has one.
+        function updateScheduler(info) {
+            document.getElementById('schedulerInfo').style.display = info ? 'block' : 'none';
+            if (!info) return;
+
+            let text = 'Server schedule';
+            if (info.holdUntil) {
+                text += ' on hold until ' + clockTime(info.holdUntil);
+            } else if (info.period) {
+                text += ': heat ' + info.period.heat + symbol + ', cool ' + info.period.cool + symbol + ' since ' + info.period.at;
+            }
+            document.getElementById('schedulerText').textContent = text;
+            document.getElementById('resumeButton').style.display = info.holdUntil ? 'inline-block' : 'none';
+
+            const wall = info.wallChange;
+            document.getElementById('wallChange').textContent = wall
+                ? wall.mode + ' changed ' + (wall.source === 'web' ? 'here' : 'at the thermostat') + ' at ' + clockTime(wall.at) +
+                  ' (' + wall.want + ' → ' + wall.got + symbol + '); the schedule takes over again at the next period'
+                : '';
+        }
+
+        async function holdSchedule() {
+            const until = document.getElementById('holdUntil').value;
+            if (!until) {
+                showMessage('Pick a time to hold until', 'error');
+                return;
+            }
+
+            try {
+                const response = await fetch(api('scheduler/hold'), {
+                    method: 'POST',
+                    headers: {
+                        'Content-Type': 'application/json',
+                    },
+                    body: JSON.stringify({ until: until })
+                });
+
+                if (!response.ok) {
+                    const error = await response.text();
+                    throw new Error(error);
+                }
+
+                showMessage('Schedule held until ' + until, 'success');
+            } catch (error) {
+                showMessage('Failed to hold schedule: ' + error.message, 'error');
+            }
+        }
+
+        async function resumeSchedule() {
+            try {
+                const response = await fetch(api('scheduler/hold'), { method: 'DELETE' });
+
+                if (!response.ok) {
+                    const error = await response.text();
+                    throw new Error(error);
+                }
+
+                showMessage('Schedule resumed', 'success');
+            } catch (error) {
+                showMessage('Failed to resume schedule: ' + error.message, 'error');
+            }
+        }
+
+        // updateBoost shows the running boost, or the controls to start one
+        // in heat or cool mode.
+        function updateBoost(data) {
+            boost = data.boost || null;
+            boostEnds = boost ? Date.now() + boost.remaining * 1000 : 0;
+            const canBoost = data.modeCode === 1 || data.modeCode === 2;
+            document.getElementById('boostSection').style.display = boost || canBoost ? 'block' : 'none';
+            document.getElementById('boostStatus').style.display = boost ? 'flex' : 'none';
+            document.getElementById('boostControl').style.display = canBoost ? 'flex' : 'none';
+            updateBoostCountdown();
+        }
+
+        function updateBoostCountdown() {
+            if (!boost) return;
+            const left = Math.max(0, Math.round((boostEnds - Date.now()) / 1000));
+            if (left === 0) {
+                document.getElementById('boostText').textContent = boost.mode + ' boost to ' + boost.temp + symbol + ' is ending…';
+                return;
+            }
+            const h = Math.floor(left / 3600);
+            const m = Math.floor(left / 60) % 60;
+            const s = left % 60;
+            const clock = h + ':' + String(m).padStart(2, '0') + ':' + String(s).padStart(2, '0');
+            document.getElementById('boostText').textContent = boost.mode + ' boost to ' + boost.temp + symbol + ', ' + clock + ' left';
+        }
+
+        async function startBoost() {
+            const temp = halfStep(parseFloat(document.getElementById('tempInput').value));
+            const length = document.getElementById('boostFor').value;
+
+            if (isNaN(temp) || temp < minTemp || temp > maxTemp) {
+                showMessage('Temperature must be between ' + minTemp + ' and ' + maxTemp + symbol, 'error');
+                return;
+            }
+
+            try {
+                const response = await fetch(api('boost'), {
+                    method: 'POST',
+                    headers: {
+                        'Content-Type': 'application/json',
+                    },
+                    body: JSON.stringify({ temp: temp, for: length })
+                });
+
+                if (!response.ok) {
+                    const error = await response.text();
+                    throw new Error(error);
+                }
+
+                showMessage('Boosting to ' + temp + symbol, 'success');
+            } catch (error) {
+                showMessage('Failed to start boost: ' + error.message, 'error');
+            }
+        }
+
+        async function cancelBoost() {
+            try {
+                const response = await fetch(api('boost'), { method: 'DELETE' });
+
+                if (!response.ok) {
+                    const error = await response.text();
+                    throw new Error(error);
+                }
+
+                showMessage('Boost cancelled', 'success');
+            } catch (error) {
+                showMessage('Failed to cancel boost: ' + error.message, 'error');
+            }
+        }
+
+        function dateTime(iso) {
+            return new Date(iso).toLocaleString([], { weekday: 'short', month: 'short', day: 'numeric', hour: '2-digit', minute: '2-digit' });
+        }
+
+        // updateVacation shows the vacation, or the form to set one with
+        // away setpoints suited to the unit in use.
+        function updateVacation(vacation) {
+            document.getElementById('vacationStatus').style.display = vacation ? 'flex' : 'none';
+            document.getElementById('vacationForm').style.display = vacation ? 'none' : 'grid';
+
+            if (vacationUnit !== symbol) {
+                vacationUnit = symbol;
+                document.getElementById('vacationHeat').value = symbol === '°C' ? 13 : 55;
+                document.getElementById('vacationCool').value = symbol === '°C' ? 29.5 : 85;
+            }
+            if (!vacation) return;
+
+            let text = vacation.started ? 'Away' : 'Vacation from ' + dateTime(vacation.from);
+            text += ': heat ' + vacation.heat + symbol + ', cool ' + vacation.cool + symbol + ' until ' + dateTime(vacation.to);
+            if (vacation.recoverAt !== vacation.to) {
+                text += ', back to normal from ' + dateTime(vacation.recoverAt);
+            }
+            document.getElementById('vacationText').textContent = text;
+            document.getElementById('vacationCancel').textContent = vacation.started ? 'End now' : 'Cancel';
+        }
+
+        async function setVacation() {
+            const from = document.getElementById('vacationFrom').value;
+            const to = document.getElementById('vacationTo').value;
+            const heat = halfStep(parseFloat(document.getElementById('vacationHeat').value));
+            const cool = halfStep(parseFloat(document.getElementById('vacationCool').value));
+            const recover = document.getElementById('vacationRecover').value;
+
+            if (!to) {
+                showMessage('Pick when you will be back', 'error');
+                return;
+            }
+            if (isNaN(heat) || isNaN(cool)) {
+                showMessage('Enter the away heat and cool setpoints', 'error');
+                return;
+            }
+            if (cool - heat < deadband) {
+                showMessage('Cool must be at least ' + deadband + symbol + ' above heat', 'error');
+                return;
+            }
+
+            try {
+                const response = await fetch(api('vacation'), {
+                    method: 'POST',
+                    headers: {
+                        'Content-Type': 'application/json',
+                    },
+                    body: JSON.stringify({ from: from, to: to, heat: heat, cool: cool, recover: recover })
+                });
+
+                if (!response.ok) {
+                    const error = await response.text();
+                    throw new Error(error);
+                }
+
+                showMessage('Vacation set until ' + dateTime(to), 'success');
+            } catch (error) {
+                showMessage('Failed to set vacation: ' + error.message, 'error');
+            }
+        }
+
+        async function cancelVacation() {
+            try {
+                const response = await fetch(api('vacation'), { method: 'DELETE' });
+
+                if (!response.ok) {
+                    const error = await response.text();
+                    throw new Error(error);
+                }
+
+                showMessage('Vacation ended', 'success');
+            } catch (error) {
+                showMessage('Failed to end vacation: ' + error.message, 'error');
+            }
+        }
+
+        function updateFanButtons() {
+            document.querySelectorAll('.fan-button').forEach(btn => {
+                const fan = parseInt(btn.getAttribute('data-fan'));
+                if (fan === currentFan) {
+                    btn.classList.add('active');
+                } else {
+                    btn.classList.remove('active');
+                }
+            });
+        }
+
         async function setTemperature() {
-            const temp = parseInt(document.getElementById('tempInput').value);
-            
-            if (temp < 50 || temp > 90) {
-                showMessage('Temperature must be between 50 and 90', 'error');
+            const temp = halfStep(parseFloat(document.getElementById('tempInput').value));
+
+            if (isNaN(temp) || temp < minTemp || temp > maxTemp) {
+                showMessage('Temperature must be between ' + minTemp + ' and ' + maxTemp + symbol, 'error');
                 return;
             }
 
             try {
-                const response = await fetch('/api/settemp', {
+                const response = await fetch(api('settemp'), {
                     method: 'POST',
                     headers: {
                         'Content-Type': 'application/json',
@@ -602,16 +1260,48 @@ const indexHTML = `
                     throw new Error(error);
                 }
 
-                showMessage('Temperature set to ' + temp + '°F', 'success');
-                setTimeout(loadStatus, 1000);
+                showMessage('Temperature set to ' + temp + symbol, 'success');
             } catch (error) {
                 showMessage('Failed to set temperature: ' + error.message, 'error');
             }
         }
 
+        async function setRange() {
+            const heat = halfStep(parseFloat(document.getElementById('heatInput').value));
+            const cool = halfStep(parseFloat(document.getElementById('coolInput').value));
+
+            if (isNaN(heat) || isNaN(cool) || heat < minTemp || heat > maxTemp || cool < minTemp || cool > maxTemp) {
+                showMessage('Setpoints must be between ' + minTemp + ' and ' + maxTemp + symbol, 'error');
+                return;
+            }
+            if (cool - heat < deadband) {
+                showMessage('Cool must be at least ' + deadband + symbol + ' above heat', 'error');
+                return;
+            }
+
+            try {
+                const response = await fetch(api('settemp'), {
+                    method: 'POST',
+                    headers: {
+                        'Content-Type': 'application/json',
+                    },
+                    body: JSON.stringify({ heat: heat, cool: cool })
+                });
+
+                if (!response.ok) {
+                    const error = await response.text();
+                    throw new Error(error);
+                }
+
+                showMessage('Auto range set to ' + heat + '–' + cool + symbol, 'success');
+            } catch (error) {
+                showMessage('Failed to set range: ' + error.message, 'error');
+            }
+        }
+
         async function setMode(mode) {
             try {
-                const response = await fetch('/api/setmode', {
+                const response = await fetch(api('setmode'), {
                     method: 'POST',
                     headers: {
                         'Content-Type': 'application/json',
@@ -626,53 +1316,107 @@ const indexHTML = `
 
                 const modeNames = ['Off', 'Heat', 'Cool', 'Auto'];
                 showMessage('Mode set to ' + modeNames[mode], 'success');
-                setTimeout(loadStatus, 1000);
             } catch (error) {
                 showMessage('Failed to set mode: ' + error.message, 'error');
             }
         }
 
-        // Load initial status
-        loadStatus();
-        
-        // Auto-refresh every 30 seconds
-        setInterval(loadStatus, 30000);
+        async function setFan(fan) {
+            try {
+                const response = await fetch(api('setfan'), {
+                    method: 'POST',
+                    headers: {
+                        'Content-Type': 'application/json',
+                    },
+                    body: JSON.stringify({ fan: fan })
+                });
+
+                if (!response.ok) {
+                    const error = await response.text();
+                    throw new Error(error);
+                }
+
+                const fanNames = ['Auto', 'Circulate', 'On'];
+                showMessage('Fan set to ' + fanNames[fan], 'success');
+            } catch (error) {
+                showMessage('Failed to set fan: ' + error.message, 'error');
+            }
+        }
+
+        // The server pushes every change, so there is nothing to poll.
+        loadDevices().then(connectEvents).catch(error => showMessage(error.message, 'error'));
+        setInterval(updateBoostCountdown, 1000);
     </script>
 </body>
 </html>
 `
 
-func loadConfig(configFile string) (*Config, error) {
-	jsonFile, err := os.Open(configFile)
-	if err != nil {
-		return nil, err
-	}
-	defer jsonFile.Close()
-
-	byteValue, err := ioutil.ReadAll(jsonFile)
-	if err != nil {
-		return nil, err
-	}
-
-	var config Config
-	err = json.Unmarshal(byteValue, &config)
-	if err != nil {
-		return nil, err
-	}
-
-	return &config, nil
+// newMux sets up the HTTP routes, behind a sign in if users are
+// configured.
+func newMux() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", handleHome)
+	mux.HandleFunc("/login", handleLogin)
+	mux.HandleFunc("/logout", handleLogout)
+	mux.HandleFunc("/auth.js", handleAuthScript)
+	mux.HandleFunc("/api/session", handleSession)
+	mux.HandleFunc("/schedule", handleSchedulePage)
+	mux.HandleFunc("/overview", handleOverviewPage)
+	mux.HandleFunc("/history", handleHistoryPage)
+	mux.HandleFunc("/guests", handleGuestsPage)
+	mux.HandleFunc("/guest/", handleGuest)
+	mux.HandleFunc("/audit", handleAuditPage)
+	mux.HandleFunc("/metrics", handleMetrics)
+	mux.HandleFunc("/api/devices", handleDevices)
+	mux.HandleFunc("/api/devices/", handleDeviceAPI)
+	mux.HandleFunc("/api/guests", handleGuests)
+	mux.HandleFunc("/api/guests/", handleGuests)
+	mux.HandleFunc("/api/audit", handleAudit)
+
+	// The original single-thermostat routes act on the default device.
+	mux.HandleFunc("/api/status", onDefaultDevice(handleStatus))
+	mux.HandleFunc("/api/settemp", onDefaultDevice(handleSetTemp))
+	mux.HandleFunc("/api/setmode", onDefaultDevice(handleSetMode))
+	mux.HandleFunc("/api/setfan", onDefaultDevice(handleSetFan))
+	mux.HandleFunc("/api/program/", onDefaultDevice(handleProgram))
+	mux.HandleFunc("/api/schedule", onDefaultDevice(handleSchedule))
+	mux.HandleFunc("/api/boost", onDefaultDevice(handleBoost))
+	mux.HandleFunc("/api/vacation", onDefaultDevice(handleVacation))
+	mux.HandleFunc("/api/history", onDefaultDevice(handleHistory))
+	mux.HandleFunc("/api/events", onDefaultDevice(handleEvents))
+	mux.HandleFunc("/api/scheduler", onDefaultDevice(handleScheduler))
+	mux.HandleFunc("/api/scheduler/hold", onDefaultDevice(handleSchedulerHold))
+
+	return requireLogin(mux)
 }
 
 func main() {
-	homedir, _ := os.UserHomeDir()
 	var configFile string
 	var port string
 	var thermostatIPFlag string
+	var unitFlag string
+	var historyFile string
+	var historyInterval time.Duration
+	var historyRetention time.Duration
+	var tlsPort string
+	var tlsCert string
+	var tlsKey string
+	var tlsSelfSigned bool
 
 	// Parse CLI Flags
-	flag.StringVar(&configFile, "c", homedir+"/.config/thermostat/config.json", "specify path of config file")
-	flag.StringVar(&port, "port", "8080", "port to run the web server on")
+	flag.StringVar(&configFile, "c", config.DefaultPath(), "specify path of config file")
+	flag.StringVar(&port, "port", "8080", "port to run the web server on (with HTTPS, the port that redirects to it; empty for none)")
+	flag.StringVar(&tlsPort, "tls-port", "8443", "port to serve HTTPS on")
+	flag.StringVar(&tlsCert, "tls-cert", "", "TLS certificate file (PEM), turns HTTPS on")
+	flag.StringVar(&tlsKey, "tls-key", "", "TLS private key file (PEM)")
+	flag.BoolVar(&tlsSelfSigned, "tls-self-signed", false, "serve HTTPS with a self-signed certificate, made on first start")
 	flag.StringVar(&thermostatIPFlag, "ip", "", "thermostat IP address (overrides config file)")
+	flag.StringVar(&unitFlag, "unit", "", "default temperature unit: F or C (overrides config file)")
+	flag.StringVar(&historyFile, "history", history.DefaultPath(), "file to record status history in")
+	flag.DurationVar(&historyInterval, "history-interval", time.Minute, "how often to record each thermostat's status (0 turns recording off)")
+	flag.DurationVar(&historyRetention, "history-retention", 90*24*time.Hour, "how long to keep status history (0 keeps it forever)")
+	flag.DurationVar(&eventPollInterval, "events-interval", eventPollInterval, "how often to read a thermostat while a browser is watching it")
+	flag.BoolVar(&publicMetrics, "public-metrics", false, "serve /metrics without a sign in when users are configured")
 	showVer := flag.Bool("v", false, "Show Version")
 	flag.Parse()
 
@@ -683,37 +1427,132 @@ func main() {
 	}
 
 	// Priority: 1. Environment variable, 2. Command line flag, 3. Config file
-	thermostatIP = os.Getenv("THERMOSTAT_IP")
+	thermostatIP := os.Getenv("THERMOSTAT_IP")
 
 	if thermostatIP == "" && thermostatIPFlag != "" {
 		thermostatIP = thermostatIPFlag
 	}
 
-	if thermostatIP == "" {
+	var deviceList []config.Device
+	if thermostatIP != "" {
+		deviceList = []config.Device{{Name: config.DefaultDeviceName, IP: thermostatIP}}
+
+		// The unit, deadband and users still come from the config file,
+		// if there is one.
+		configData, err := config.Load(configFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Fatalf("Error loading config file: %v", err)
+		}
+		if configData != nil {
+			deadband = configData.MinDeadband()
+			defaultUnit = configData.TempUnit()
+			if len(configData.Users) > 0 {
+				users = newUserList(configFile, configData.Users)
+			}
+		}
+	} else {
 		// Load configuration from file
-		config, err := loadConfig(configFile)
+		configData, err := config.Load(configFile)
 		if err != nil {
 			log.Fatalf("Error loading config file: %v\nPlease set THERMOSTAT_IP environment variable, use -ip flag, or run 'thermostat --new' to create a config file", err)
 		}
-		thermostatIP = config.ThermostatIP
+		deviceList = configData.DeviceList()
+		deadband = configData.MinDeadband()
+		defaultUnit = configData.TempUnit()
+		if len(configData.Users) > 0 {
+			users = newUserList(configFile, configData.Users)
+		}
+	}
+
+	if unitFlag != "" {
+		unit, err := ct50.ParseUnit(unitFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defaultUnit = unit
 	}
 
-	if thermostatIP == "" {
+	if eventPollInterval <= 0 {
+		log.Fatal("-events-interval must be more than zero")
+	}
+
+	if len(deviceList) == 0 {
 		log.Fatal("Thermostat IP not configured. Set THERMOSTAT_IP environment variable, use -ip flag, or configure in config file")
 	}
 
-	// Set up HTTP routes
-	http.HandleFunc("/", handleHome)
-	http.HandleFunc("/api/status", handleStatus)
-	http.HandleFunc("/api/settemp", handleSetTemp)
-	http.HandleFunc("/api/setmode", handleSetMode)
+	setDevices(deviceList)
+	go watchBoosts(context.Background())
+	go watchSchedules(context.Background())
+	go watchVacations(context.Background())
+	go saveTokenUses(context.Background())
+
+	if historyInterval > 0 {
+		// Without history the thermostats still work, so a read-only
+		// config directory, as in some containers, is not fatal.
+		store, err := history.Open(historyFile)
+		if err != nil {
+			log.Printf("Not recording history: %v (set -history to a writable file, or -history-interval 0 to turn it off)", err)
+		} else {
+			historyDB = store
+			go recordHistory(context.Background(), store, historyInterval, historyRetention)
+		}
+	}
+
+	var certs *certReloader
+	if tlsSelfSigned || tlsCert != "" || tlsKey != "" {
+		if tlsSelfSigned {
+			if tlsCert == "" {
+				tlsCert = defaultCertPath()
+			}
+			if tlsKey == "" {
+				tlsKey = defaultKeyPath()
+			}
+			if err := ensureSelfSigned(tlsCert, tlsKey); err != nil {
+				log.Fatalf("Error making a self-signed certificate: %v", err)
+			}
+		}
+		if tlsCert == "" || tlsKey == "" {
+			log.Fatal("-tls-cert and -tls-key must be set together")
+		}
+
+		var err error
+		if certs, err = newCertReloader(tlsCert, tlsKey); err != nil {
+			log.Fatalf("Error loading the TLS certificate: %v", err)
+		}
+		go certs.reloadOnHangup()
+	}
 
 	// Start server
-	addr := ":" + port
 	fmt.Printf("Starting Thermostat Web Server v%s\n", WebServerVersion)
-	fmt.Printf("Thermostat IP: %s\n", thermostatIP)
-	fmt.Printf("Server listening on http://localhost%s\n", addr)
-	fmt.Println("Press Ctrl+C to stop")
+	for _, dev := range devices {
+		fmt.Printf("Thermostat %s: %s\n", dev.Name, dev.IP)
+	}
+	if users == nil {
+		fmt.Println("No users configured: anyone on the network can change the thermostats. Add one with 'thermostat user add'.")
+	}
+
+	if certs == nil {
+		addr := ":" + port
+		fmt.Printf("Server listening on http://localhost%s\n", addr)
+		fmt.Println("Press Ctrl+C to stop")
+		log.Fatal(http.ListenAndServe(addr, newMux()))
+	}
 
-	log.Fatal(http.ListenAndServe(addr, nil))
+	server := &http.Server{
+		Addr:    ":" + tlsPort,
+		Handler: newMux(),
+		TLSConfig: &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.getCertificate,
+		},
+	}
+	if port != "" {
+		go func() {
+			log.Fatal(http.ListenAndServe(":"+port, redirectToHTTPS(tlsPort)))
+		}()
+		fmt.Printf("Redirecting http://localhost:%s to HTTPS\n", port)
+	}
+	fmt.Printf("Server listening on https://localhost:%s with %s\n", tlsPort, tlsCert)
+	fmt.Println("Send SIGHUP to reload the certificate. Press Ctrl+C to stop")
+	log.Fatal(server.ListenAndServeTLS("", ""))
 }
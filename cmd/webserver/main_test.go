@@ -0,0 +1,1575 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/internal/audit"
+	"github.com/EntropySynthetica/Thermostat/internal/auth"
+	"github.com/EntropySynthetica/Thermostat/internal/boost"
+	"github.com/EntropySynthetica/Thermostat/internal/config"
+	"github.com/EntropySynthetica/Thermostat/internal/history"
+	"github.com/EntropySynthetica/Thermostat/internal/scheduler"
+	"github.com/EntropySynthetica/Thermostat/internal/vacation"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50sim"
+)
+
+// newTestServer starts the web server in front of two emulated
+// thermostats, "upstairs" (the default) and "downstairs".
+func newTestServer(t *testing.T) (*httptest.Server, map[string]*ct50sim.Device) {
+	t.Helper()
+
+	sims := make(map[string]*ct50sim.Device)
+	var list []config.Device
+	for _, name := range []string{"upstairs", "downstairs"} {
+		dev := ct50sim.New(ct50sim.Options{Name: name})
+		srv := httptest.NewServer(dev)
+		t.Cleanup(srv.Close)
+
+		sims[name] = dev
+		list = append(list, config.Device{Name: name, IP: srv.URL})
+	}
+	auditLog = audit.NewLog(filepath.Join(t.TempDir(), "audit.log"))
+	setDevices(list)
+	boosts = boost.NewStore(filepath.Join(t.TempDir(), "boosts.json"))
+	schedules = scheduler.NewStore(filepath.Join(t.TempDir(), "schedules.json"))
+	vacations = vacation.NewStore(filepath.Join(t.TempDir(), "vacations.json"))
+	guests = auth.NewGuests(filepath.Join(t.TempDir(), "guests.json"), filepath.Join(t.TempDir(), "guest-key"))
+	requestDuration.Reset()
+	requestErrors.Reset()
+
+	// Cleanups run last first: the server closes its event streams, then
+	// the pollers are waited for before the next test swaps the globals.
+	t.Cleanup(closeDevices)
+	srv := httptest.NewServer(newMux())
+	t.Cleanup(srv.Close)
+	return srv, sims
+}
+
+func post(t *testing.T, url, body string) (int, string) {
+	t.Helper()
+
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func getJSON(t *testing.T, url string, v interface{}) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(resp.Body)
+		t.Fatalf("GET %s: %d %s", url, resp.StatusCode, data)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	srv, sims := newTestServer(t)
+	sims["upstairs"].SetTemp(66)
+
+	var status StatusResponse
+	getJSON(t, srv.URL+"/api/status", &status)
+
+	if status.CurrentTemp != 66 || status.Mode != "Heat" {
+		t.Errorf("status = %+v, want 66 in Heat", status)
+	}
+	if len(status.Next) == 0 {
+		t.Error("status has no upcoming program change")
+	}
+}
+
+func TestSetTempModeAndFan(t *testing.T) {
+	srv, sims := newTestServer(t)
+
+	if code, body := post(t, srv.URL+"/api/setmode", `{"mode":2}`); code != http.StatusOK {
+		t.Fatalf("setmode: %d %s", code, body)
+	}
+	if code, body := post(t, srv.URL+"/api/settemp", `{"temp":73}`); code != http.StatusOK {
+		t.Fatalf("settemp: %d %s", code, body)
+	}
+	if code, body := post(t, srv.URL+"/api/setfan", `{"fan":1}`); code != http.StatusOK {
+		t.Fatalf("setfan: %d %s", code, body)
+	}
+
+	stats := sims["upstairs"].Status()
+	if stats.Tmode != ct50.ModeCool || stats.TCool != 73 || stats.Fmode != ct50.FanCirculate {
+		t.Errorf("device = mode %v t_cool %v fan %v, want Cool 73 Circulate", stats.Tmode, stats.TCool, stats.Fmode)
+	}
+}
+
+func TestBadRequests(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	tests := []struct {
+		path, body string
+		want       int
+	}{
+		{"/api/settemp", `{"temp":120}`, http.StatusBadRequest},
+		{"/api/setmode", `{"mode":9}`, http.StatusBadRequest},
+		{"/api/setfan", `not json`, http.StatusBadRequest},
+		{"/api/schedule", `{"heat":{}}`, http.StatusBadRequest},
+		{"/api/devices/attic/status", ``, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if code, body := post(t, srv.URL+tt.path, tt.body); code != tt.want {
+			t.Errorf("POST %s %s: %d %s, want %d", tt.path, tt.body, code, body, tt.want)
+		}
+	}
+}
+
+func TestSetTempWhenOff(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	post(t, srv.URL+"/api/setmode", `{"mode":0}`)
+	if code, body := post(t, srv.URL+"/api/settemp", `{"temp":70}`); code != http.StatusConflict {
+		t.Errorf("settemp in off mode: %d %s, want 409", code, body)
+	}
+}
+
+func TestPerDeviceRoutes(t *testing.T) {
+	srv, sims := newTestServer(t)
+
+	var list []DeviceInfo
+	getJSON(t, srv.URL+"/api/devices", &list)
+	if len(list) != 2 || list[0].Name != "upstairs" || list[1].Name != "downstairs" {
+		t.Fatalf("devices = %+v", list)
+	}
+
+	if code, body := post(t, srv.URL+"/api/devices/downstairs/setmode", `{"mode":2}`); code != http.StatusOK {
+		t.Fatalf("setmode: %d %s", code, body)
+	}
+	if got := sims["downstairs"].Status().Tmode; got != ct50.ModeCool {
+		t.Errorf("downstairs mode = %v, want Cool", got)
+	}
+	if got := sims["upstairs"].Status().Tmode; got != ct50.ModeHeat {
+		t.Errorf("upstairs mode = %v, want it left in Heat", got)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	srv, sims := newTestServer(t)
+
+	var sched ScheduleRequest
+	getJSON(t, srv.URL+"/api/devices/downstairs/schedule", &sched)
+	if sched.Heat == nil || sched.Cool == nil {
+		t.Fatalf("schedule = %+v, want both programs", sched)
+	}
+
+	sched.Heat[2] = ct50.Day{{Minute: 300, Temp: 71}}
+	body, _ := json.Marshal(sched)
+	if code, resp := post(t, srv.URL+"/api/devices/downstairs/schedule", string(body)); code != http.StatusOK {
+		t.Fatalf("schedule: %d %s", code, resp)
+	}
+
+	prog := sims["downstairs"].Program(ct50.ProgramHeat)
+	if len(prog[2]) != 1 || prog[2][0].Temp != 71 {
+		t.Errorf("wednesday = %v, want one period at 71", prog[2])
+	}
+
+	// The per-mode route reads back the same program.
+	var heat ct50.Program
+	getJSON(t, srv.URL+"/api/devices/downstairs/program/heat", &heat)
+	if len(heat[2]) != 1 {
+		t.Errorf("program/heat wednesday = %v", heat[2])
+	}
+}
+
+func TestAutoRange(t *testing.T) {
+	srv, sims := newTestServer(t)
+
+	if code, body := post(t, srv.URL+"/api/settemp", `{"heat":67,"cool":77}`); code != http.StatusOK {
+		t.Fatalf("settemp range: %d %s", code, body)
+	}
+
+	var status StatusResponse
+	getJSON(t, srv.URL+"/api/status", &status)
+	if status.Mode != "Auto" || status.HeatTemp != 67 || status.CoolTemp != 77 || status.TargetTemp != 0 {
+		t.Errorf("status = %+v, want Auto with 67-77 and no single target", status)
+	}
+	if status.Deadband != ct50.DefaultDeadband {
+		t.Errorf("deadband = %v, want %v", status.Deadband, ct50.DefaultDeadband)
+	}
+
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"heat":70,"cool":71}`, http.StatusBadRequest},
+		{`{"heat":70}`, http.StatusBadRequest},
+		{`{"heat":40,"cool":80}`, http.StatusBadRequest},
+		{`{"temp":70}`, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		if code, body := post(t, srv.URL+"/api/settemp", tt.body); code != tt.want {
+			t.Errorf("settemp %s: %d %s, want %d", tt.body, code, body, tt.want)
+		}
+	}
+
+	if stats := sims["upstairs"].Status(); stats.THeat != 67 || stats.TCool != 77 {
+		t.Errorf("device range = %v-%v after rejected requests, want 67-77", stats.THeat, stats.TCool)
+	}
+}
+
+func TestCelsius(t *testing.T) {
+	srv, sims := newTestServer(t)
+	sims["upstairs"].SetTemp(68)
+
+	if code, body := post(t, srv.URL+"/api/settemp?unit=C", `{"temp":21.5}`); code != http.StatusOK {
+		t.Fatalf("settemp: %d %s", code, body)
+	}
+	if stats := sims["upstairs"].Status(); stats.THeat != 70.5 {
+		t.Errorf("device t_heat = %v, want 70.5", stats.THeat)
+	}
+
+	var status StatusResponse
+	getJSON(t, srv.URL+"/api/status?unit=c", &status)
+	if status.Unit != ct50.Celsius || status.CurrentTemp != 20 || status.TargetTemp != 21.5 {
+		t.Errorf("status = %+v, want 20°C now and 21.5°C target", status)
+	}
+	if status.MinTemp != 10 || status.MaxTemp != 32 || status.Deadband != 1.7 {
+		t.Errorf("limits = %v-%v deadband %v, want 10-32 and 1.7", status.MinTemp, status.MaxTemp, status.Deadband)
+	}
+
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"temp":0}`, http.StatusBadRequest},
+		{`{"temp":33}`, http.StatusBadRequest},
+		{`{"heat":20,"cool":21}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if code, body := post(t, srv.URL+"/api/settemp?unit=C", tt.body); code != tt.want {
+			t.Errorf("settemp %s: %d %s, want %d", tt.body, code, body, tt.want)
+		}
+	}
+	if code, _ := post(t, srv.URL+"/api/settemp?unit=K", `{"temp":21}`); code != http.StatusBadRequest {
+		t.Errorf("settemp with unknown unit: %d, want 400", code)
+	}
+
+	var sched ScheduleRequest
+	getJSON(t, srv.URL+"/api/schedule?unit=C", &sched)
+	if sched.Unit != ct50.Celsius || sched.MinTemp != 2 || sched.MaxTemp != 35 {
+		t.Errorf("schedule unit and limits = %v %v-%v, want C 2-35", sched.Unit, sched.MinTemp, sched.MaxTemp)
+	}
+
+	sched.Heat[0][0].Temp = 19.5
+	body, _ := json.Marshal(ScheduleRequest{Heat: sched.Heat, Cool: sched.Cool})
+	if code, resp := post(t, srv.URL+"/api/schedule?unit=C", string(body)); code != http.StatusOK {
+		t.Fatalf("save schedule: %d %s", code, resp)
+	}
+	if got := sims["upstairs"].Program(ct50.ProgramHeat)[0][0].Temp; got != 67 {
+		t.Errorf("device program temp = %v, want 67", got)
+	}
+}
+
+func TestBoost(t *testing.T) {
+	srv, sims := newTestServer(t)
+	ctx := context.Background()
+
+	if code, body := post(t, srv.URL+"/api/boost", `{"temp":74,"for":"2h"}`); code != http.StatusOK {
+		t.Fatalf("boost: %d %s", code, body)
+	}
+	if stats := sims["upstairs"].Status(); stats.THeat != 74 || stats.Hold != ct50.On {
+		t.Errorf("device = %v hold %v, want 74 with hold on", stats.THeat, stats.Hold)
+	}
+
+	var status StatusResponse
+	getJSON(t, srv.URL+"/api/status", &status)
+	if status.Boost == nil || status.Boost.Temp != 74 || status.Boost.Remaining <= 7100 {
+		t.Fatalf("status boost = %+v, want 74 with about 2h left", status.Boost)
+	}
+
+	// Nothing happens before the boost runs out.
+	endExpiredBoosts(ctx, time.Now().Add(time.Hour))
+	if stats := sims["upstairs"].Status(); stats.THeat != 74 {
+		t.Errorf("t_heat = %v an hour in, want 74", stats.THeat)
+	}
+
+	// The boost survives a restart: a new store on the same file still
+	// knows about it, and ends it once it is over.
+	boosts = boost.NewStore(boosts.Path())
+	endExpiredBoosts(ctx, time.Now().Add(3*time.Hour))
+
+	stats := sims["upstairs"].Status()
+	if stats.THeat == 74 || stats.Hold != ct50.Off || stats.Tmode != ct50.ModeHeat {
+		t.Errorf("after boost: t_heat %v hold %v mode %v, want the program setpoint, hold off, heat", stats.THeat, stats.Hold, stats.Tmode)
+	}
+	if b, _ := boosts.Get("upstairs"); b != nil {
+		t.Errorf("boost still stored after it ended: %+v", b)
+	}
+
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"temp":74}`, http.StatusBadRequest},
+		{`{"temp":74,"for":"48h"}`, http.StatusBadRequest},
+		{`{"temp":95,"for":"1h"}`, http.StatusBadRequest},
+		{`{"temp":74,"for":"1h","mode":3}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if code, body := post(t, srv.URL+"/api/boost", tt.body); code != tt.want {
+			t.Errorf("boost %s: %d %s, want %d", tt.body, code, body, tt.want)
+		}
+	}
+}
+
+func TestCancelBoost(t *testing.T) {
+	srv, sims := newTestServer(t)
+	down := sims["downstairs"]
+	before := down.Status()
+
+	url := srv.URL + "/api/devices/downstairs/boost"
+	if code, body := post(t, url, `{"temp":65,"for":"1h","mode":2}`); code != http.StatusOK {
+		t.Fatalf("boost: %d %s", code, body)
+	}
+	if stats := down.Status(); stats.Tmode != ct50.ModeCool || stats.TCool != 65 {
+		t.Errorf("device = %v %v, want Cool 65", stats.Tmode, stats.TCool)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("cancel: %d", resp.StatusCode)
+	}
+
+	if stats := down.Status(); stats.Tmode != before.Tmode || stats.THeat != before.THeat || stats.Hold != before.Hold {
+		t.Errorf("after cancel = %v %v hold %v, want %v %v hold %v", stats.Tmode, stats.THeat, stats.Hold, before.Tmode, before.THeat, before.Hold)
+	}
+}
+
+func put(t *testing.T, url, body string) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPut, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func TestScheduler(t *testing.T) {
+	srv, sims := newTestServer(t)
+	sim := sims["upstairs"]
+	client := findDevice("upstairs").client
+	ctx := context.Background()
+
+	day := func(clock string) time.Time {
+		minute, _ := ct50.ParseClock(clock)
+		return time.Date(2024, 1, 1, minute/60, minute%60, 0, 0, time.Local)
+	}
+
+	rules := `{"enabled": true, "week": {
+		"mon": [{"at": "06:00", "heat": 68, "cool": 76}, {"at": "09:00", "heat": 62, "cool": 80},
+		        {"at": "12:00", "heat": 66, "cool": 78}, {"at": "15:00", "heat": 70, "cool": 75},
+		        {"at": "21:00", "heat": 61, "cool": 79}]}}`
+	if code, body := put(t, srv.URL+"/api/scheduler", rules); code != http.StatusOK {
+		t.Fatalf("put schedule: %d %s", code, body)
+	}
+
+	runSchedules(ctx, day("09:30"))
+	if stats := sim.Status(); stats.THeat != 62 || stats.Hold != ct50.On {
+		t.Fatalf("after first run t_heat %v hold %v, want 62 with hold on", stats.THeat, stats.Hold)
+	}
+
+	// Someone turns it up at the wall. The change is reported and left
+	// alone until the next period.
+	if err := client.SetHeat(ctx, 71); err != nil {
+		t.Fatal(err)
+	}
+	runSchedules(ctx, day("10:00"))
+	if stats := sim.Status(); stats.THeat != 71 {
+		t.Errorf("wall change undone: t_heat = %v", stats.THeat)
+	}
+
+	var status StatusResponse
+	getJSON(t, srv.URL+"/api/status", &status)
+	if status.Scheduler == nil || status.Scheduler.WallChange == nil {
+		t.Fatalf("status scheduler = %+v, want a wall change", status.Scheduler)
+	}
+	if wall := status.Scheduler.WallChange; wall.Want != 62 || wall.Got != 71 || wall.Source != "thermostat" {
+		t.Errorf("wall change = %+v, want 62 -> 71 at the thermostat", wall)
+	}
+
+	runSchedules(ctx, day("12:00"))
+	if stats := sim.Status(); stats.THeat != 66 {
+		t.Errorf("at the next period t_heat = %v, want 66", stats.THeat)
+	}
+
+	// A hold keeps the schedule off until it runs out.
+	sched, _ := schedules.Get("upstairs")
+	until := day("16:00")
+	sched.HoldUntil = &until
+	schedules.Put("upstairs", sched)
+
+	if err := client.SetHeat(ctx, 73); err != nil {
+		t.Fatal(err)
+	}
+	runSchedules(ctx, day("15:30"))
+	if stats := sim.Status(); stats.THeat != 73 {
+		t.Errorf("during hold t_heat = %v, want 73", stats.THeat)
+	}
+	runSchedules(ctx, day("16:00"))
+	if stats := sim.Status(); stats.THeat != 70 {
+		t.Errorf("after hold t_heat = %v, want 70", stats.THeat)
+	}
+
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"enabled": true, "week": {"funday": []}}`, http.StatusBadRequest},
+		{`{"enabled": true, "week": {"mon": [{"at": "07:00", "heat": 74, "cool": 75}]}}`, http.StatusBadRequest},
+		{`{"enabled": true, "holidays": ["someday"]}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if code, body := put(t, srv.URL+"/api/scheduler", tt.body); code != tt.want {
+			t.Errorf("put %s: %d %s, want %d", tt.body, code, body, tt.want)
+		}
+	}
+}
+
+func TestSchedulerWebModeChange(t *testing.T) {
+	srv, sims := newTestServer(t)
+	sim := sims["upstairs"]
+	ctx := context.Background()
+	day := func(clock string) time.Time {
+		minute, _ := ct50.ParseClock(clock)
+		return time.Date(2024, 1, 1, minute/60, minute%60, 0, 0, time.Local)
+	}
+
+	rules := `{"enabled": true, "week": {"mon": [{"at": "09:00", "heat": 62, "cool": 80}, {"at": "12:00", "heat": 66, "cool": 78}]}}`
+	if code, body := put(t, srv.URL+"/api/scheduler", rules); code != http.StatusOK {
+		t.Fatalf("put schedule: %d %s", code, body)
+	}
+	runSchedules(ctx, day("09:30"))
+
+	// Switching to cool from the web keeps the cool setpoint the
+	// thermostat had, rather than the period's 80.
+	if code, body := post(t, srv.URL+"/api/setmode", `{"mode":2}`); code != http.StatusOK {
+		t.Fatalf("setmode: %d %s", code, body)
+	}
+	cool := sim.Status().TCool
+	runSchedules(ctx, day("10:00"))
+	if stats := sim.Status(); stats.Tmode != ct50.ModeCool || stats.TCool != cool {
+		t.Errorf("after a web mode change: mode %v t_cool %v, want Cool %v", stats.Tmode, stats.TCool, cool)
+	}
+	var status StatusResponse
+	getJSON(t, srv.URL+"/api/status", &status)
+	if status.Scheduler == nil || status.Scheduler.WallChange == nil || status.Scheduler.WallChange.Source != "web" || status.Scheduler.WallChange.Want != 80 {
+		t.Errorf("status scheduler = %+v, want the web change reported against the period's 80", status.Scheduler)
+	}
+
+	// A mode change at the wall still gets the period's setpoint.
+	if err := findDevice("upstairs").client.SetMode(ctx, ct50.ModeHeat); err != nil {
+		t.Fatal(err)
+	}
+	runSchedules(ctx, day("10:30"))
+	if stats := sim.Status(); stats.THeat != 62 {
+		t.Errorf("after a wall mode change t_heat = %v, want the period's 62", stats.THeat)
+	}
+
+	runSchedules(ctx, day("12:00"))
+	if stats := sim.Status(); stats.THeat != 66 {
+		t.Errorf("at the next period t_heat = %v, want 66", stats.THeat)
+	}
+}
+
+func TestSchedulerOff(t *testing.T) {
+	srv, sims := newTestServer(t)
+	sim := sims["upstairs"]
+	ctx := context.Background()
+	day := func(clock string) time.Time {
+		minute, _ := ct50.ParseClock(clock)
+		return time.Date(2024, 1, 1, minute/60, minute%60, 0, 0, time.Local)
+	}
+
+	rules := `{"enabled": true, "week": {"mon": [{"at": "09:00", "heat": 62, "cool": 80}]}}`
+	if code, body := put(t, srv.URL+"/api/scheduler", rules); code != http.StatusOK {
+		t.Fatalf("put schedule: %d %s", code, body)
+	}
+	runSchedules(ctx, day("09:30"))
+	if hold := sim.Status().Hold; hold != ct50.On {
+		t.Fatalf("after a push hold = %v, want On", hold)
+	}
+
+	// Turning the schedule off hands the thermostat back to its program.
+	off := `{"enabled": false, "week": {"mon": [{"at": "09:00", "heat": 62, "cool": 80}]}}`
+	if code, body := put(t, srv.URL+"/api/scheduler", off); code != http.StatusOK {
+		t.Fatalf("put schedule: %d %s", code, body)
+	}
+	if hold := sim.Status().Hold; hold != ct50.Off {
+		t.Errorf("after disabling the schedule hold = %v, want Off", hold)
+	}
+
+	// So does the schedule being turned off behind the server's back,
+	// once the scheduler next runs.
+	if code, body := put(t, srv.URL+"/api/scheduler", rules); code != http.StatusOK {
+		t.Fatalf("put schedule: %d %s", code, body)
+	}
+	runSchedules(ctx, day("10:00"))
+	sched, err := schedules.Get("upstairs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sched.Enabled = false
+	if err := schedules.Put("upstairs", sched); err != nil {
+		t.Fatal(err)
+	}
+	runSchedules(ctx, day("10:30"))
+	if hold := sim.Status().Hold; hold != ct50.Off {
+		t.Errorf("after the scheduler saw the schedule off hold = %v, want Off", hold)
+	}
+
+	// A hold set at the wall while no schedule runs is left alone.
+	if err := findDevice("upstairs").client.SetHold(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	runSchedules(ctx, day("11:00"))
+	if hold := sim.Status().Hold; hold != ct50.On {
+		t.Errorf("a wall hold = %v after the scheduler ran, want On", hold)
+	}
+}
+
+func TestProgramCache(t *testing.T) {
+	sim := ct50sim.New(ct50sim.Options{})
+	var mu sync.Mutex
+	requests, fail := 0, false
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		failing := fail
+		mu.Unlock()
+		<-release
+		if failing {
+			http.Error(w, "busy", http.StatusInternalServerError)
+			return
+		}
+		sim.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+
+	client := ct50.New(srv.URL)
+	client.Retries = 0
+	cache := newProgramCache()
+	ctx := context.Background()
+
+	// Callers during a slow fetch share it, and the cache stays usable.
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cache.get(ctx, client, ct50.ProgramHeat)
+			errs <- err
+		}()
+	}
+	for count() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	invalidated := make(chan struct{})
+	go func() {
+		cache.invalidate()
+		close(invalidated)
+	}()
+	select {
+	case <-invalidated:
+	case <-time.After(time.Second):
+		t.Fatal("invalidate waited for the fetch")
+	}
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// One fetch, and one more after the invalidation, however many
+	// callers there were.
+	if n := count(); n > 2 {
+		t.Errorf("%d program fetches for 10 callers, want at most 2", n)
+	}
+
+	// A failed fetch is not tried again straight away.
+	mu.Lock()
+	fail = true
+	mu.Unlock()
+	cache = newProgramCache()
+	before := count()
+	for i := 0; i < 3; i++ {
+		if _, err := cache.get(ctx, client, ct50.ProgramCool); err == nil {
+			t.Fatal("get worked with the thermostat failing")
+		}
+	}
+	if n := count() - before; n != 1 {
+		t.Errorf("%d fetches after a failure, want 1", n)
+	}
+}
+
+func TestSchedulerHold(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	if code, _ := post(t, srv.URL+"/api/scheduler/hold", `{"until": "18:00"}`); code != http.StatusConflict {
+		t.Errorf("hold without a schedule: %d, want 409", code)
+	}
+
+	rules := `{"enabled": true, "week": {"mon": [{"at": "06:00", "heat": 20, "cool": 25}]}}`
+	if code, body := put(t, srv.URL+"/api/devices/downstairs/scheduler?unit=C", rules); code != http.StatusOK {
+		t.Fatalf("put schedule: %d %s", code, body)
+	}
+	if code, body := post(t, srv.URL+"/api/devices/downstairs/scheduler/hold", `{"until": "18:00"}`); code != http.StatusOK {
+		t.Fatalf("hold: %d %s", code, body)
+	}
+	if code, _ := post(t, srv.URL+"/api/devices/downstairs/scheduler/hold", `{"until": "teatime"}`); code != http.StatusBadRequest {
+		t.Errorf("hold until teatime: %d, want 400", code)
+	}
+
+	var resp SchedulerResponse
+	getJSON(t, srv.URL+"/api/devices/downstairs/scheduler", &resp)
+	if resp.Schedule == nil || resp.Schedule.Week["mon"][0].Heat != 68 || resp.Schedule.Week["mon"][0].Cool != 77 {
+		t.Fatalf("schedule = %+v, want 20°C and 25°C stored as 68 and 77", resp.Schedule)
+	}
+	if resp.Status == nil || resp.Status.HoldUntil == nil || resp.Status.HoldUntil.Format("15:04") != "18:00" {
+		t.Errorf("status = %+v, want held until 18:00", resp.Status)
+	}
+}
+
+func TestParseUntil(t *testing.T) {
+	now := time.Date(2024, 1, 1, 19, 0, 0, 0, time.UTC)
+
+	if got, err := parseUntil("21:30", now); err != nil || !got.Equal(time.Date(2024, 1, 1, 21, 30, 0, 0, time.UTC)) {
+		t.Errorf("21:30 = %v, %v", got, err)
+	}
+	if got, err := parseUntil("07:00", now); err != nil || !got.Equal(time.Date(2024, 1, 2, 7, 0, 0, 0, time.UTC)) {
+		t.Errorf("07:00 = %v, %v; want tomorrow", got, err)
+	}
+	if _, err := parseUntil("2023-12-31T10:00:00Z", now); err == nil {
+		t.Error("a time in the past was accepted")
+	}
+}
+
+func TestVacation(t *testing.T) {
+	srv, sims := newTestServer(t)
+	sim := sims["upstairs"]
+	ctx := context.Background()
+	before := sim.Status()
+
+	rules := `{"enabled": true, "week": {"mon": [{"at": "06:00", "heat": 68, "cool": 76}]}}`
+	if code, body := put(t, srv.URL+"/api/scheduler", rules); code != http.StatusOK {
+		t.Fatalf("put schedule: %d %s", code, body)
+	}
+
+	to := time.Now().AddDate(0, 0, 3).Truncate(time.Minute)
+	body := `{"to": "` + to.Format("2006-01-02T15:04") + `", "heat": 13, "cool": 29.5, "recover": "3h"}`
+	if code, resp := post(t, srv.URL+"/api/vacation?unit=C", body); code != http.StatusOK {
+		t.Fatalf("vacation: %d %s", code, resp)
+	}
+	if stats := sim.Status(); stats.THeat != 55.5 || stats.Hold != ct50.On {
+		t.Fatalf("device = %v hold %v, want 55.5 with hold on", stats.THeat, stats.Hold)
+	}
+
+	var status StatusResponse
+	getJSON(t, srv.URL+"/api/status?unit=C", &status)
+	if v := status.Vacation; v == nil || !v.Started || v.Heat != 13 || !v.RecoverAt.Equal(to.Add(-3*time.Hour)) {
+		t.Fatalf("status vacation = %+v, want started at 13°C, recovering 3h early", status.Vacation)
+	}
+
+	// The server-side schedule stays out of the way.
+	runSchedules(ctx, time.Now())
+	if stats := sim.Status(); stats.THeat != 55.5 {
+		t.Errorf("scheduler ran during the vacation: t_heat = %v", stats.THeat)
+	}
+
+	// Nothing changes before recovery starts.
+	runVacations(ctx, to.Add(-4*time.Hour))
+	if stats := sim.Status(); stats.THeat != 55.5 {
+		t.Errorf("t_heat = %v before recovery, want 55.5", stats.THeat)
+	}
+
+	runVacations(ctx, to.Add(-2*time.Hour))
+	if stats := sim.Status(); stats.Tmode != before.Tmode || stats.Hold != before.Hold {
+		t.Errorf("after vacation = %v hold %v, want %v hold %v", stats.Tmode, stats.Hold, before.Tmode, before.Hold)
+	}
+	if v, _ := vacations.Get("upstairs"); v != nil {
+		t.Errorf("vacation still stored after it ended: %+v", v)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/api/vacation", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("cancel with no vacation: %d, want 404", resp.StatusCode)
+	}
+
+	tests := []string{
+		`{"to": "2001-01-01", "heat": 55, "cool": 85}`,
+		`{"to": "someday", "heat": 55, "cool": 85}`,
+		`{"to": "` + to.Format("2006-01-02") + `", "heat": 55}`,
+		`{"to": "` + to.Format("2006-01-02") + `", "heat": 70, "cool": 71}`,
+		`{"to": "` + to.Format("2006-01-02") + `", "heat": 55, "cool": 85, "recover": "soon"}`,
+	}
+	for _, body := range tests {
+		if code, _ := post(t, srv.URL+"/api/devices/downstairs/vacation", body); code != http.StatusBadRequest {
+			t.Errorf("vacation %s: %d, want 400", body, code)
+		}
+	}
+}
+
+func TestCancelVacation(t *testing.T) {
+	srv, sims := newTestServer(t)
+	down := sims["downstairs"]
+
+	from := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
+	to := time.Now().AddDate(0, 0, 5).Format("2006-01-02")
+	url := srv.URL + "/api/devices/downstairs/vacation"
+	if code, body := post(t, url, `{"from": "`+from+`", "to": "`+to+`", "heat": 55, "cool": 85}`); code != http.StatusOK {
+		t.Fatalf("vacation: %d %s", code, body)
+	}
+	if stats := down.Status(); stats.THeat == 55 {
+		t.Errorf("vacation started before its start date")
+	}
+
+	var info *VacationInfo
+	getJSON(t, url, &info)
+	if info == nil || info.Started {
+		t.Fatalf("vacation = %+v, want one not yet started", info)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("cancel: %d", resp.StatusCode)
+	}
+	if v, _ := vacations.Get("downstairs"); v != nil {
+		t.Errorf("vacation still stored after cancel: %+v", v)
+	}
+}
+
+func TestRecordHistory(t *testing.T) {
+	newTestServer(t)
+	store, err := history.Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	start := time.Now().Truncate(time.Minute)
+	recordSamples(ctx, store, start, time.Second)
+	if err := findDevice("upstairs").client.SetHeat(ctx, 72); err != nil {
+		t.Fatal(err)
+	}
+	recordSamples(ctx, store, start.Add(time.Minute), time.Second)
+
+	samples, err := store.Samples("upstairs", start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 2 || samples[1].THeat != 72 {
+		t.Errorf("upstairs samples = %+v, want two, the second at 72", samples)
+	}
+	if samples, _ := store.Samples("downstairs", start, start.Add(time.Hour)); len(samples) != 2 {
+		t.Errorf("downstairs has %d samples, want 2", len(samples))
+	}
+}
+
+func TestHistory(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	if code, _ := get(t, srv.URL+"/api/history"); code != http.StatusNotFound {
+		t.Errorf("history with recording off: %d, want 404", code)
+	}
+
+	store, err := history.Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	historyDB = store
+	t.Cleanup(func() { historyDB = nil })
+
+	// An hour of heating to 68, then an hour of cooling to 77.
+	start := time.Now().Add(-2 * time.Hour).Truncate(time.Hour)
+	for i := 0; i < 120; i++ {
+		s := history.Sample{Time: start.Add(time.Duration(i) * time.Minute), Temp: 68, THeat: 68, TCool: 77, Tmode: ct50.ModeHeat, Tstate: ct50.StateHeating}
+		if i >= 60 {
+			s.Temp, s.Tmode, s.Tstate = 77, ct50.ModeCool, ct50.StateCooling
+		}
+		if err := store.Add("upstairs", s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var resp HistoryResponse
+	getJSON(t, srv.URL+"/api/history?range=day&unit=C", &resp)
+	if resp.Step != 300 || len(resp.Points) != 24 || resp.To.Sub(resp.From) != 24*time.Hour {
+		t.Fatalf("history = step %d, %d points from %v to %v; want 24 five-minute points over a day", resp.Step, len(resp.Points), resp.From, resp.To)
+	}
+	first, last := resp.Points[0], resp.Points[len(resp.Points)-1]
+	if first.Temp != 20 || first.Heat == nil || *first.Heat != 20 || first.Cool != nil || first.Heating != 1 {
+		t.Errorf("first point = %+v, want 20°C heating to 20°C", first)
+	}
+	if last.Temp != 25 || last.Heat != nil || last.Cool == nil || *last.Cool != 25 || last.Cooling != 1 {
+		t.Errorf("last point = %+v, want 25°C cooling to 25°C", last)
+	}
+
+	getJSON(t, srv.URL+"/api/devices/upstairs/history?range=month", &resp)
+	if resp.Step != 7200 || len(resp.Points) == 0 || len(resp.Points) > 2 {
+		t.Errorf("month = step %d with %d points, want one or two two-hour points", resp.Step, len(resp.Points))
+	}
+
+	if code, _ := get(t, srv.URL+"/api/history?range=year"); code != http.StatusBadRequest {
+		t.Errorf("range=year: %d, want 400", code)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	srv, sims := newTestServer(t)
+	sims["upstairs"].SetTemp(68)
+	sims["downstairs"].SetScenario(&ct50sim.Scenario{Faults: []ct50sim.Fault{{Path: "/tstat", Action: ct50sim.FaultStatus}}})
+	findDevice("downstairs").client.RetryDelay = time.Millisecond
+
+	code, body := get(t, srv.URL+"/metrics")
+	if code != http.StatusOK {
+		t.Fatalf("metrics: %d %s", code, body)
+	}
+
+	for _, want := range []string{
+		"# TYPE thermostat_up gauge\n",
+		`thermostat_up{device="downstairs"} 0`,
+		`thermostat_up{device="upstairs"} 1`,
+		`thermostat_temperature_celsius{device="upstairs"} 20`,
+		`thermostat_tmode{device="upstairs"} 1`,
+		`thermostat_humidity_percent{device="upstairs"}`,
+		`thermostat_request_errors_total{device="downstairs",method="GET",path="/tstat"} 3`,
+		`thermostat_request_duration_seconds_count{device="upstairs",method="GET",path="/tstat"}`,
+		`thermostat_request_duration_seconds_bucket{device="downstairs",method="GET",path="/tstat",le="+Inf"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, `thermostat_temperature_celsius{device="downstairs"}`) {
+		t.Error("metrics report a temperature for a thermostat that did not answer")
+	}
+}
+
+// openEvents opens an event stream and returns a function that reads the
+// next event from it.
+func openEvents(t *testing.T, url string) func() (string, map[string]interface{}) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("events: Content-Type %q", ct)
+	}
+
+	r := bufio.NewReader(resp.Body)
+	return func() (string, map[string]interface{}) {
+		t.Helper()
+
+		var name string
+		var data map[string]interface{}
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("reading events: %v", err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &data); err != nil {
+					t.Fatal(err)
+				}
+			case line == "" && name != "":
+				return name, data
+			}
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	srv, sims := newTestServer(t)
+	interval := eventPollInterval
+	eventPollInterval = 20 * time.Millisecond
+	t.Cleanup(func() { eventPollInterval = interval })
+
+	sims["upstairs"].SetTemp(70)
+	first := openEvents(t, srv.URL+"/api/events")
+	second := openEvents(t, srv.URL+"/api/devices/upstairs/events")
+	for _, next := range []func() (string, map[string]interface{}){first, second} {
+		name, data := next()
+		if name != "status" || data["currentTemp"] != 70.0 || data["mode"] != "Heat" || data["unit"] != "F" {
+			t.Fatalf("first event = %s %v, want the full status", name, data)
+		}
+	}
+
+	if code, body := post(t, srv.URL+"/api/setmode", `{"mode":2}`); code != http.StatusOK {
+		t.Fatalf("setmode: %d %s", code, body)
+	}
+	for _, next := range []func() (string, map[string]interface{}){first, second} {
+		_, data := next()
+		if data["mode"] != "Cool" || data["modeCode"] != 2.0 {
+			t.Errorf("event after setmode = %v, want Cool", data)
+		}
+		if _, ok := data["currentTemp"]; ok {
+			t.Errorf("event after setmode = %v, want only the fields that changed", data)
+		}
+	}
+
+	// The furnace (here the air conditioner) switching on is pushed
+	// without anyone asking.
+	if code, body := post(t, srv.URL+"/api/settemp", `{"temp":74}`); code != http.StatusOK {
+		t.Fatalf("settemp: %d %s", code, body)
+	}
+	sims["upstairs"].SetTemp(80)
+	for {
+		_, data := first()
+		if data["operatingState"] == "Cooling" {
+			break
+		}
+	}
+
+	sims["downstairs"].SetScenario(&ct50sim.Scenario{Faults: []ct50sim.Fault{{Path: "/tstat", Action: ct50sim.FaultStatus}}})
+	findDevice("downstairs").client.RetryDelay = time.Millisecond
+	down := openEvents(t, srv.URL+"/api/devices/downstairs/events")
+	if name, data := down(); name != "unavailable" || data["error"] == "" {
+		t.Errorf("event from a thermostat that does not answer = %s %v, want unavailable", name, data)
+	}
+}
+
+func TestChangedFields(t *testing.T) {
+	prev := map[string]json.RawMessage{"temp": json.RawMessage("70"), "mode": json.RawMessage(`"Heat"`), "boost": json.RawMessage("{}")}
+	next := map[string]json.RawMessage{"temp": json.RawMessage("70"), "mode": json.RawMessage(`"Cool"`)}
+
+	data, _ := json.Marshal(changedFields(prev, next))
+	if want := `{"boost":null,"mode":"Cool"}`; string(data) != want {
+		t.Errorf("changedFields = %s, want %s", data, want)
+	}
+	if got := changedFields(next, next); len(got) != 0 {
+		t.Errorf("changedFields with no change = %v, want nothing", got)
+	}
+}
+
+// newAuthServer starts the test server with two accounts that have the
+// password "correct horse": alice, a controller, and victor, a viewer. It
+// returns the config file they are in.
+func newAuthServer(t *testing.T) (*httptest.Server, map[string]*ct50sim.Device, string) {
+	t.Helper()
+
+	srv, sims := newTestServer(t)
+
+	hash, err := auth.HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		Devices: []config.Device{{Name: "upstairs", IP: "192.168.1.20"}},
+		Users: []config.User{
+			{Name: "alice", PasswordHash: hash, Role: config.Controller},
+			{Name: "victor", PasswordHash: hash, Role: config.Viewer},
+		},
+	}
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	if err := cfg.Save(configFile); err != nil {
+		t.Fatal(err)
+	}
+
+	users = newUserList(configFile, cfg.Users)
+	sessions = auth.NewSessions(filepath.Join(t.TempDir(), "sessions.json"))
+	tokens = auth.NewTokens(filepath.Join(t.TempDir(), "tokens.json"))
+	t.Cleanup(func() { users = nil })
+	return srv, sims, configFile
+}
+
+// browser is an HTTP client with a cookie jar that does not follow
+// redirects.
+func browser(t *testing.T) *http.Client {
+	t.Helper()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{
+		Jar:           jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+// login signs c in and returns the session's CSRF token.
+func login(t *testing.T, c *http.Client, srv *httptest.Server, name string) string {
+	t.Helper()
+
+	resp, err := c.PostForm(srv.URL+"/login", url.Values{"name": {name}, "password": {"correct horse"}, "next": {"/history"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/history" {
+		t.Fatalf("login as %s: %d to %q", name, resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	u, _ := url.Parse(srv.URL)
+	for _, cookie := range c.Jar.Cookies(u) {
+		if cookie.Name == csrfCookie {
+			return cookie.Value
+		}
+	}
+	t.Fatal("login set no CSRF cookie")
+	return ""
+}
+
+// send makes a request with c and returns the status code and body.
+func send(t *testing.T, c *http.Client, method, url, csrf, body string) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if csrf != "" {
+		req.Header.Set(csrfHeader, csrf)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func TestLogin(t *testing.T) {
+	srv, sims, _ := newAuthServer(t)
+	c := browser(t)
+
+	resp, err := c.Get(srv.URL + "/schedule")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if loc := resp.Header.Get("Location"); resp.StatusCode != http.StatusSeeOther || loc != "/login?next=%2Fschedule" {
+		t.Errorf("signed out GET /schedule = %d to %q, want the sign-in page", resp.StatusCode, loc)
+	}
+	for _, path := range []string{"/api/status", "/api/devices/downstairs/status", "/metrics"} {
+		if code, _ := send(t, c, http.MethodGet, srv.URL+path, "", ""); code != http.StatusUnauthorized {
+			t.Errorf("signed out GET %s = %d, want 401", path, code)
+		}
+	}
+	if code, _ := send(t, c, http.MethodGet, srv.URL+"/auth.js", "", ""); code != http.StatusOK {
+		t.Errorf("signed out GET /auth.js = %d, want 200", code)
+	}
+	publicMetrics = true
+	if code, _ := send(t, c, http.MethodGet, srv.URL+"/metrics", "", ""); code != http.StatusOK {
+		t.Errorf("signed out GET /metrics with -public-metrics = %d, want 200", code)
+	}
+	publicMetrics = false
+
+	resp, err = c.PostForm(srv.URL+"/login", url.Values{"name": {"alice"}, "password": {"battery staple"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("login with the wrong password = %d, want 401", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/login", strings.NewReader("name=alice&password=correct+horse"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://evil.example")
+	resp, err = c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("cross-site login = %d, want 403", resp.StatusCode)
+	}
+
+	csrf := login(t, c, srv, "alice")
+
+	var info SessionInfo
+	if code, body := send(t, c, http.MethodGet, srv.URL+"/api/session", "", ""); code != http.StatusOK || json.Unmarshal([]byte(body), &info) != nil || info.User != "alice" || info.Role != config.Controller {
+		t.Errorf("session = %d %s, want alice the controller", code, body)
+	}
+	if code, body := send(t, c, http.MethodPost, srv.URL+"/api/setmode", "", `{"mode":2}`); code != http.StatusForbidden {
+		t.Errorf("setmode without a CSRF token = %d %s, want 403", code, body)
+	}
+	if code, body := send(t, c, http.MethodPost, srv.URL+"/api/setmode", "wrong", `{"mode":2}`); code != http.StatusForbidden {
+		t.Errorf("setmode with the wrong CSRF token = %d %s, want 403", code, body)
+	}
+	if got := sims["upstairs"].Status().Tmode; got == ct50.ModeCool {
+		t.Fatal("setmode without the CSRF token changed the mode")
+	}
+	if code, body := send(t, c, http.MethodPost, srv.URL+"/api/setmode", csrf, `{"mode":2}`); code != http.StatusOK {
+		t.Fatalf("setmode = %d %s", code, body)
+	}
+	if got := sims["upstairs"].Status().Tmode; got != ct50.ModeCool {
+		t.Errorf("mode = %v, want Cool", got)
+	}
+
+	if code, _ := send(t, c, http.MethodPost, srv.URL+"/logout", csrf, ""); code != http.StatusSeeOther {
+		t.Errorf("logout = %d, want a redirect", code)
+	}
+	if code, _ := send(t, c, http.MethodGet, srv.URL+"/api/status", "", ""); code != http.StatusUnauthorized {
+		t.Errorf("GET /api/status after logout = %d, want 401", code)
+	}
+}
+
+func TestViewer(t *testing.T) {
+	srv, sims, _ := newAuthServer(t)
+	c := browser(t)
+	csrf := login(t, c, srv, "victor")
+
+	for _, path := range []string{"/api/status", "/api/devices/downstairs/status", "/api/schedule", "/"} {
+		if code, body := send(t, c, http.MethodGet, srv.URL+path, "", ""); code != http.StatusOK {
+			t.Errorf("viewer GET %s = %d %s", path, code, body)
+		}
+	}
+
+	before := sims["upstairs"].Status()
+	for _, path := range []string{"/api/setmode", "/api/settemp", "/api/devices/downstairs/setfan", "/api/boost"} {
+		if code, body := send(t, c, http.MethodPost, srv.URL+path, csrf, `{"mode":2,"temp":80,"fan":1}`); code != http.StatusForbidden {
+			t.Errorf("viewer POST %s = %d %s, want 403", path, code, body)
+		}
+	}
+	if after := sims["upstairs"].Status(); after.Tmode != before.Tmode || after.Target() != before.Target() {
+		t.Errorf("a viewer changed the thermostat from %+v to %+v", before, after)
+	}
+
+	// Viewers can still sign out.
+	if code, _ := send(t, c, http.MethodPost, srv.URL+"/logout", csrf, ""); code != http.StatusSeeOther {
+		t.Errorf("viewer logout = %d, want a redirect", code)
+	}
+}
+
+func TestRemovedUser(t *testing.T) {
+	srv, _, configFile := newAuthServer(t)
+	c := browser(t)
+	login(t, c, srv, "victor")
+
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Users = cfg.Users[:1]
+	if err := cfg.Save(configFile); err != nil {
+		t.Fatal(err)
+	}
+	// Make sure the change shows on a file system with coarse times.
+	later := time.Now().Add(time.Second)
+	os.Chtimes(configFile, later, later)
+
+	if code, _ := send(t, c, http.MethodGet, srv.URL+"/api/status", "", ""); code != http.StatusUnauthorized {
+		t.Errorf("removed user GET /api/status = %d, want 401", code)
+	}
+}
+
+// bearer sends every request with an API token.
+type bearer string
+
+func (b bearer) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+string(b))
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestAPITokens(t *testing.T) {
+	srv, sims, _ := newAuthServer(t)
+
+	token, created, err := tokens.Create("cron", []auth.Scope{auth.StatusRead, auth.SetpointWrite}, []string{"upstairs"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &http.Client{Transport: bearer(token)}
+
+	if code, body := send(t, c, http.MethodGet, srv.URL+"/api/status", "", ""); code != http.StatusOK {
+		t.Errorf("GET /api/status with a token = %d %s", code, body)
+	}
+	if code, body := send(t, c, http.MethodPost, srv.URL+"/api/devices/upstairs/settemp", "", `{"temp":72}`); code != http.StatusOK {
+		t.Fatalf("settemp with a token = %d %s", code, body)
+	}
+	if got := sims["upstairs"].Status().THeat; got != 72 {
+		t.Errorf("t_heat = %v after settemp with a token, want 72", got)
+	}
+
+	var info SessionInfo
+	if code, body := send(t, c, http.MethodGet, srv.URL+"/api/session", "", ""); code != http.StatusOK || json.Unmarshal([]byte(body), &info) != nil || info.Token != "cron" {
+		t.Errorf("session with a token = %d %s, want the token name", code, body)
+	}
+
+	for _, tt := range []struct {
+		method, path, token string
+		want                int
+	}{
+		{http.MethodPost, "/api/setmode", token, http.StatusForbidden},
+		{http.MethodPost, "/api/boost", token, http.StatusForbidden},
+		{http.MethodGet, "/api/devices/downstairs/status", token, http.StatusForbidden},
+		{http.MethodPost, "/api/devices/downstairs/settemp", token, http.StatusForbidden},
+		{http.MethodGet, "/metrics", token, http.StatusForbidden},
+		{http.MethodGet, "/", token, http.StatusForbidden},
+		{http.MethodGet, "/api/status", created.ID + ".wrong", http.StatusUnauthorized},
+	} {
+		c := &http.Client{Transport: bearer(tt.token)}
+		if code, body := send(t, c, tt.method, srv.URL+tt.path, "", `{"mode":2,"temp":74,"for":"1h"}`); code != tt.want {
+			t.Errorf("%s %s with a token = %d %s, want %d", tt.method, tt.path, code, body, tt.want)
+		}
+	}
+	if got := sims["upstairs"].Status().Tmode; got == ct50.ModeCool {
+		t.Error("a token without mode:write changed the mode")
+	}
+
+	list, err := tokens.List()
+	if err != nil || len(list) != 1 || list[0].LastUsed.IsZero() {
+		t.Errorf("tokens = %+v, %v, want the last use recorded", list, err)
+	}
+
+	if err := tokens.Revoke(created.ID); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := send(t, c, http.MethodGet, srv.URL+"/api/status", "", ""); code != http.StatusUnauthorized {
+		t.Errorf("GET /api/status with a revoked token = %d, want 401", code)
+	}
+}
+
+// logBuffer collects what the server logs.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLog sends the log to a buffer until the test ends.
+func captureLog(t *testing.T) *logBuffer {
+	b := &logBuffer{}
+	log.SetOutput(b)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return b
+}
+
+func TestGuestLinks(t *testing.T) {
+	srv, sims, _ := newAuthServer(t)
+	logged := captureLog(t)
+
+	c := browser(t)
+	csrf := login(t, c, srv, "alice")
+	code, body := send(t, c, http.MethodPost, srv.URL+"/api/guests", csrf, `{"name":"sitter","min":66,"max":74}`)
+	var info GuestInfo
+	if code != http.StatusOK || json.Unmarshal([]byte(body), &info) != nil {
+		t.Fatalf("POST /api/guests = %d %s", code, body)
+	}
+	if info.Device != "upstairs" || info.MinTemp != 66 || info.MaxTemp != 74 || info.Mode || info.Expires.Sub(info.Created) != auth.DefaultGuestLifetime {
+		t.Errorf("guest = %+v, want upstairs 66-74 without mode for 7 days", info)
+	}
+	if !strings.HasPrefix(info.URL, srv.URL+"/guest/"+info.ID+".") {
+		t.Errorf("guest URL = %q, want a link on %s", info.URL, srv.URL)
+	}
+
+	// Viewers and tokens cannot see the links.
+	viewer := browser(t)
+	login(t, viewer, srv, "victor")
+	if code, _ := send(t, viewer, http.MethodGet, srv.URL+"/api/guests", "", ""); code != http.StatusForbidden {
+		t.Errorf("viewer GET /api/guests = %d, want 403", code)
+	}
+	token, _, err := tokens.Create("cron", auth.Scopes, nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := send(t, &http.Client{Transport: bearer(token)}, http.MethodGet, srv.URL+"/api/guests", "", ""); code != http.StatusForbidden {
+		t.Errorf("token GET /api/guests = %d, want 403", code)
+	}
+
+	// The guest needs no sign in or CSRF token.
+	guest := &http.Client{}
+	if code, body := send(t, guest, http.MethodGet, info.URL, "", ""); code != http.StatusOK || !strings.Contains(body, "sitter") {
+		t.Errorf("guest page = %d %s", code, body)
+	}
+	if code, body := send(t, guest, http.MethodPost, info.URL+"/settemp", "", `{"temp":72}`); code != http.StatusOK {
+		t.Fatalf("guest settemp = %d %s", code, body)
+	}
+	if got := sims["upstairs"].Status().THeat; got != 72 {
+		t.Errorf("t_heat = %v after guest settemp, want 72", got)
+	}
+	for _, tt := range []struct {
+		action, body string
+		want         int
+	}{
+		{"settemp", `{"temp":80}`, http.StatusForbidden},
+		{"settemp", `{"heat":60,"cool":74}`, http.StatusForbidden},
+		{"setmode", `{"mode":2}`, http.StatusForbidden},
+		{"setfan", `{"fan":2}`, http.StatusNotFound},
+		{"boost", `{"temp":74,"for":"1h"}`, http.StatusNotFound},
+	} {
+		if code, body := send(t, guest, http.MethodPost, info.URL+"/"+tt.action, "", tt.body); code != tt.want {
+			t.Errorf("guest %s %s = %d %s, want %d", tt.action, tt.body, code, body, tt.want)
+		}
+	}
+	if stats := sims["upstairs"].Status(); stats.THeat != 72 || stats.Tmode == ct50.ModeCool {
+		t.Errorf("device = %+v after refused guest changes", stats)
+	}
+	if !strings.Contains(logged.String(), `settemp {"temp":72} on upstairs`) || !strings.Contains(logged.String(), "setmode") {
+		t.Errorf("log = %q, want the guest's changes", logged.String())
+	}
+
+	if code, body := send(t, c, http.MethodDelete, srv.URL+"/api/guests/"+info.ID, csrf, ""); code != http.StatusOK {
+		t.Fatalf("DELETE /api/guests/%s = %d %s", info.ID, code, body)
+	}
+	if code, _ := send(t, guest, http.MethodGet, info.URL, "", ""); code != http.StatusNotFound {
+		t.Errorf("revoked guest page = %d, want 404", code)
+	}
+	if code, _ := send(t, guest, http.MethodGet, srv.URL+"/guest/nonsense/status", "", ""); code != http.StatusNotFound {
+		t.Errorf("made-up guest link = %d, want 404", code)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	srv, sims, _ := newAuthServer(t)
+	before := sims["upstairs"].Status()
+
+	c := browser(t)
+	csrf := login(t, c, srv, "alice")
+	if code, body := send(t, c, http.MethodPost, srv.URL+"/api/settemp", csrf, `{"temp":72}`); code != http.StatusOK {
+		t.Fatalf("settemp = %d %s", code, body)
+	}
+	token, _, err := tokens.Create("cron", auth.Scopes, nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &http.Client{Transport: bearer(token)}
+	if code, body := send(t, api, http.MethodPost, srv.URL+"/api/devices/downstairs/setfan", "", `{"fan":2}`); code != http.StatusOK {
+		t.Fatalf("token setfan = %d %s", code, body)
+	}
+	if code, body := send(t, c, http.MethodPost, srv.URL+"/api/boost", csrf, `{"temp":74,"for":"1h"}`); code != http.StatusOK {
+		t.Fatalf("boost = %d %s", code, body)
+	}
+	endExpiredBoosts(context.Background(), time.Now().Add(2*time.Hour))
+
+	var entries []AuditEntry
+	code, body := send(t, c, http.MethodGet, srv.URL+"/api/audit", "", "")
+	if code != http.StatusOK || json.Unmarshal([]byte(body), &entries) != nil {
+		t.Fatalf("GET /api/audit = %d %s", code, body)
+	}
+	if len(entries) < 4 {
+		t.Fatalf("got %d entries, want at least settemp, setfan and the boost starting and ending: %+v", len(entries), entries)
+	}
+	settemp, setfan, ended := entries[len(entries)-1], entries[len(entries)-2], entries[0]
+	if settemp.Who != "user alice" || settemp.IP != "127.0.0.1" || settemp.Action != "POST /api/settemp" || settemp.Device != "upstairs" {
+		t.Errorf("settemp entry = %+v, want alice's POST /api/settemp on upstairs from 127.0.0.1", settemp)
+	}
+	if settemp.Before == nil || settemp.Before.HeatTemp != before.THeat || settemp.After == nil || settemp.After.HeatTemp != 72 {
+		t.Errorf("settemp before %+v after %+v, want heat %v then 72", settemp.Before, settemp.After, before.THeat)
+	}
+	if setfan.Actor.Kind != audit.Token || !strings.HasPrefix(setfan.Who, "token cron (") || setfan.Device != "downstairs" || setfan.After == nil || setfan.After.FanMode != "On" {
+		t.Errorf("setfan entry = %+v, want the cron token turning the downstairs fan on", setfan)
+	}
+	if ended.Who != "automation boost" || ended.Action != "boost ended" {
+		t.Errorf("newest entry = %+v, want the boost ending", ended)
+	}
+
+	if code, body := send(t, api, http.MethodGet, srv.URL+"/api/audit?device=downstairs&limit=5", "", ""); code != http.StatusOK || json.Unmarshal([]byte(body), &entries) != nil || len(entries) != 1 {
+		t.Errorf("token GET /api/audit?device=downstairs = %d %s, want just the setfan", code, body)
+	}
+	reader, _, err := tokens.Create("dashboard", []auth.Scope{auth.StatusRead}, nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := send(t, &http.Client{Transport: bearer(reader)}, http.MethodGet, srv.URL+"/api/audit", "", ""); code != http.StatusForbidden {
+		t.Errorf("status:read token GET /api/audit = %d, want 403", code)
+	}
+	if code, _ := send(t, c, http.MethodGet, srv.URL+"/api/audit?since=yesterday", "", ""); code != http.StatusBadRequest {
+		t.Errorf("GET /api/audit?since=yesterday = %d, want 400", code)
+	}
+
+	viewer := browser(t)
+	login(t, viewer, srv, "victor")
+	if code, _ := send(t, viewer, http.MethodGet, srv.URL+"/api/audit", "", ""); code != http.StatusForbidden {
+		t.Errorf("viewer GET /api/audit = %d, want 403", code)
+	}
+}
+
+func TestSafeNext(t *testing.T) {
+	for next, want := range map[string]string{
+		"/schedule?device=den": "/schedule?device=den",
+		"":                     "/",
+		"https://evil.example": "/",
+		"//evil.example":       "/",
+		"/\\evil.example":      "/",
+	} {
+		if got := safeNext(next); got != want {
+			t.Errorf("safeNext(%q) = %q, want %q", next, got, want)
+		}
+	}
+}
+
+func TestTLS(t *testing.T) {
+	newTestServer(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	if err := ensureSelfSigned(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(keyFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("key file = %v, %v, want mode 0600", info, err)
+	}
+	first, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureSelfSigned(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := os.ReadFile(certFile); !bytes.Equal(again, first) {
+		t.Error("ensureSelfSigned replaced a certificate that was already there")
+	}
+
+	certs, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(newMux())
+	srv.TLS = &tls.Config{GetCertificate: certs.getCertificate}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(first)
+	// With a server name, the test server uses GetCertificate rather than
+	// its own certificate.
+	c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, ServerName: "localhost"}}}
+	if code, body := send(t, c, http.MethodGet, srv.URL+"/api/status", "", ""); code != http.StatusOK {
+		t.Errorf("GET /api/status over HTTPS = %d %s", code, body)
+	}
+
+	// A new certificate is served once reloaded, as on SIGHUP.
+	old, _ := certs.getCertificate(nil)
+	os.Remove(certFile)
+	if err := ensureSelfSigned(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := certs.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if cert, _ := certs.getCertificate(nil); bytes.Equal(cert.Certificate[0], old.Certificate[0]) {
+		t.Error("reload kept the old certificate")
+	}
+	os.WriteFile(certFile, []byte("not a certificate"), 0644)
+	if err := certs.reload(); err == nil {
+		t.Error("reload accepted a broken certificate")
+	}
+	if cert, _ := certs.getCertificate(nil); cert == nil {
+		t.Error("a failed reload dropped the certificate")
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	for _, tt := range []struct {
+		host, port, want string
+	}{
+		{"thermostat.lan:8080", "8443", "https://thermostat.lan:8443/api/status?unit=C"},
+		{"thermostat.lan", "443", "https://thermostat.lan/api/status?unit=C"},
+		{"[::1]:8080", "8443", "https://[::1]:8443/api/status?unit=C"},
+		{"[::1]:80", "443", "https://[::1]/api/status?unit=C"},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "http://"+tt.host+"/api/status?unit=C", nil)
+		w := httptest.NewRecorder()
+		redirectToHTTPS(tt.port).ServeHTTP(w, r)
+		if loc := w.Header().Get("Location"); w.Code != http.StatusTemporaryRedirect || loc != tt.want {
+			t.Errorf("%s to port %s = %d %q, want %q", tt.host, tt.port, w.Code, loc, tt.want)
+		}
+	}
+}
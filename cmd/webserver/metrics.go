@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/internal/metrics"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+// metricsTimeout bounds how long a scrape waits on the thermostats, inside
+// Prometheus' default 10s scrape timeout.
+const metricsTimeout = 8 * time.Second
+
+// requestDuration and requestErrors record every request to the
+// thermostats, through observeRequests.
+var (
+	requestDuration = metrics.NewHistogramVec("thermostat_request_duration_seconds",
+		"Time taken by each attempt at a request to a thermostat.",
+		metrics.DefaultBuckets, "device", "method", "path")
+	requestErrors = metrics.NewCounterVec("thermostat_request_errors_total",
+		"Attempts at a request to a thermostat that failed.",
+		"device", "method", "path")
+)
+
+// observeRequests returns a ct50.Client.Observe hook that records requests
+// to the named thermostat.
+func observeRequests(name string) func(method, path string, took time.Duration, err error) {
+	return func(method, path string, took time.Duration, err error) {
+		requestDuration.Observe(took.Seconds(), name, method, path)
+		if err != nil {
+			requestErrors.Inc(name, method, path)
+		}
+	}
+}
+
+// reading is what one scrape got from one thermostat.
+type reading struct {
+	stats    *ct50.Status
+	humidity float64
+}
+
+// celsius converts a temperature from degrees F without rounding, since
+// Prometheus metrics are in base units.
+func celsius(f float64) float64 {
+	return (f - 32) * 5 / 9
+}
+
+func boolGauge(v ct50.OnOff) float64 {
+	if v == ct50.On {
+		return 1
+	}
+	return 0
+}
+
+// handleMetrics reads every thermostat and writes its state, with the
+// request counters and latencies, in the Prometheus text format. A
+// thermostat that does not answer shows as thermostat_up 0.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), metricsTimeout)
+	defer cancel()
+
+	readings := make([]*reading, len(devices))
+	var wg sync.WaitGroup
+	for i, dev := range devices {
+		wg.Add(1)
+		go func(i int, dev *device) {
+			defer wg.Done()
+			stats, err := dev.client.Status(ctx)
+			if err != nil {
+				return
+			}
+			humidity, err := dev.client.Humidity(ctx)
+			if err != nil {
+				humidity = -1
+			}
+			readings[i] = &reading{stats: stats, humidity: humidity}
+		}(i, dev)
+	}
+	wg.Wait()
+
+	var g metrics.Gauges
+	g.Set("thermostat_webserver_info", "Web server version.", 1, "version", WebServerVersion)
+	for i, dev := range devices {
+		name := dev.Name
+		rd := readings[i]
+		if rd == nil {
+			g.Set("thermostat_up", "Whether the thermostat answered this scrape.", 0, "device", name)
+			continue
+		}
+		g.Set("thermostat_up", "Whether the thermostat answered this scrape.", 1, "device", name)
+
+		stats := rd.stats
+		g.Set("thermostat_temperature_celsius", "Indoor temperature.", celsius(stats.Temp), "device", name)
+		if stats.THeat != 0 {
+			g.Set("thermostat_heat_setpoint_celsius", "Heat setpoint, while the mode uses one.", celsius(stats.THeat), "device", name)
+		}
+		if stats.TCool != 0 {
+			g.Set("thermostat_cool_setpoint_celsius", "Cool setpoint, while the mode uses one.", celsius(stats.TCool), "device", name)
+		}
+		if rd.humidity >= 0 {
+			g.Set("thermostat_humidity_percent", "Relative humidity, on models with a sensor.", rd.humidity, "device", name)
+		}
+		g.Set("thermostat_tmode", "Operating mode: 0 off, 1 heat, 2 cool, 3 auto.", float64(stats.Tmode), "device", name)
+		g.Set("thermostat_tstate", "What the HVAC is doing: 0 idle, 1 heating, 2 cooling.", float64(stats.Tstate), "device", name)
+		g.Set("thermostat_fstate", "Whether the fan is running.", boolGauge(stats.Fstate), "device", name)
+		g.Set("thermostat_hold", "Whether the hold is on.", boolGauge(stats.Hold), "device", name)
+		g.Set("thermostat_override", "Whether a temporary override is on.", boolGauge(stats.Override), "device", name)
+	}
+
+	w.Header().Set("Content-Type", metrics.ContentType)
+	g.Write(w)
+	requestErrors.Write(w)
+	requestDuration.Write(w)
+}
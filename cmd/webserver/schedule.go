@@ -0,0 +1,797 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+// programCacheTTL is how long a program fetched from the thermostat is
+// reused before it is fetched again. Writes through this server invalidate
+// the cache immediately; the TTL only matters for changes made at the wall.
+const programCacheTTL = 10 * time.Minute
+
+// programRetryDelay is how long a program that could not be fetched is left
+// before it is tried again.
+const programRetryDelay = time.Minute
+
+// NextChange describes the next program period for the status card.
+type NextChange struct {
+	Program string  `json:"program"`
+	Day     string  `json:"day"`
+	Time    string  `json:"time"`
+	Temp    float64 `json:"temp"`
+}
+
+// ScheduleRequest is the body of GET and POST /api/schedule. Temperatures
+// are in the request's unit; GET also reports the unit and the range a
+// program temperature may take in it.
+type ScheduleRequest struct {
+	Heat    *ct50.Program `json:"heat"`
+	Cool    *ct50.Program `json:"cool"`
+	Unit    ct50.Unit     `json:"unit,omitempty"`
+	MinTemp float64       `json:"minTemp,omitempty"`
+	MaxTemp float64       `json:"maxTemp,omitempty"`
+}
+
+// programCache keeps the heat and cool programs so the status card can show
+// the next change without fetching them from the thermostat on every poll.
+// Callers that want a program while it is being fetched wait for that
+// fetch rather than starting their own, and a failed fetch is not tried
+// again for programRetryDelay.
+type programCache struct {
+	mu      sync.Mutex
+	entries map[ct50.ProgramMode]*programEntry
+
+	// gen counts invalidations, so a fetch that started before one does
+	// not store the program it replaced.
+	gen int
+}
+
+// programEntry is the cached state of one program.
+type programEntry struct {
+	prog    *ct50.Program
+	fetched time.Time
+
+	// err is why the last fetch failed, at failed.
+	err    error
+	failed time.Time
+
+	// fetching is closed when the fetch in flight is done. It is nil when
+	// there is none.
+	fetching chan struct{}
+}
+
+func newProgramCache() *programCache {
+	return &programCache{entries: make(map[ct50.ProgramMode]*programEntry)}
+}
+
+// get returns the program for mode, fetching it if the cached one is
+// missing or out of date. While a failed fetch is backing off, the last
+// program fetched is returned if there is one.
+func (c *programCache) get(ctx context.Context, client *ct50.Client, mode ct50.ProgramMode) (*ct50.Program, error) {
+	c.mu.Lock()
+	for {
+		e := c.entries[mode]
+		if e == nil {
+			e = &programEntry{}
+			c.entries[mode] = e
+		}
+		if e.prog != nil && time.Since(e.fetched) < programCacheTTL {
+			c.mu.Unlock()
+			return e.prog, nil
+		}
+		if e.err != nil && time.Since(e.failed) < programRetryDelay {
+			prog, err := e.prog, e.err
+			c.mu.Unlock()
+			if prog != nil {
+				return prog, nil
+			}
+			return nil, err
+		}
+		if e.fetching == nil {
+			return c.fetch(ctx, client, mode, e)
+		}
+
+		done := e.fetching
+		c.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		c.mu.Lock()
+	}
+}
+
+// fetch reads the program for mode from the thermostat into e. It is
+// called with c.mu held, and releases it.
+func (c *programCache) fetch(ctx context.Context, client *ct50.Client, mode ct50.ProgramMode, e *programEntry) (*ct50.Program, error) {
+	done := make(chan struct{})
+	e.fetching = done
+	gen := c.gen
+	c.mu.Unlock()
+
+	prog, err := client.Program(ctx, mode)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e.fetching = nil
+	close(done)
+
+	switch {
+	case c.gen != gen:
+		// Invalidated meanwhile: e is no longer in the cache, and the
+		// program may be out of date. The next get fetches it again.
+	case err == nil:
+		e.prog, e.fetched, e.err = prog, time.Now(), nil
+	case ctx.Err() == nil:
+		// Only the thermostat failing counts; a caller that went away
+		// leaves the next caller to fetch again.
+		e.err, e.failed = err, time.Now()
+		log.Printf("Error reading %s program: %v; trying again in %v", mode, err, programRetryDelay)
+	}
+
+	if err != nil && e.prog != nil {
+		return e.prog, nil
+	}
+	return prog, err
+}
+
+func (c *programCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[ct50.ProgramMode]*programEntry)
+	c.gen++
+}
+
+// nextChanges works out the next program period for each program the
+// current mode follows: heat, cool, both in Auto, and none when Off.
+func nextChanges(ctx context.Context, dev *device, stats *ct50.Status, unit ct50.Unit) []NextChange {
+	var modes []ct50.ProgramMode
+	switch stats.Tmode {
+	case ct50.ModeHeat:
+		modes = []ct50.ProgramMode{ct50.ProgramHeat}
+	case ct50.ModeCool:
+		modes = []ct50.ProgramMode{ct50.ProgramCool}
+	case ct50.ModeAuto:
+		modes = []ct50.ProgramMode{ct50.ProgramHeat, ct50.ProgramCool}
+	}
+
+	changes := []NextChange{}
+	for _, mode := range modes {
+		// The cache logs failed fetches.
+		prog, err := dev.programs.get(ctx, dev.client, mode)
+		if err != nil {
+			continue
+		}
+
+		day, period, ok := prog.Next(stats.Time)
+		if !ok {
+			continue
+		}
+
+		changes = append(changes, NextChange{
+			Program: capitalize(string(mode)),
+			Day:     capitalize(ct50.DayNames[day]),
+			Time:    period.Clock(),
+			Temp:    unit.Setpoint(period.Temp),
+		})
+	}
+
+	return changes
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// handleProgram reads (GET) or replaces (POST) the heat or cool program at
+// .../program/heat and .../program/cool.
+func handleProgram(w http.ResponseWriter, r *http.Request, dev *device) {
+	mode, err := ct50.ParseProgramMode(path.Base(r.URL.Path))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	unit, err := requestUnit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		prog, err := dev.client.Program(r.Context(), mode)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(prog.Convert(unit.Setpoint))
+
+	case http.MethodPost:
+		var prog ct50.Program
+		err := json.NewDecoder(r.Body).Decode(&prog)
+		if err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		// Programs are validated in degrees F, as the thermostat stores them.
+		devProg := prog.Convert(unit.ToDevice)
+		if err := devProg.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = dev.client.SetProgram(r.Context(), mode, devProg)
+		dev.programs.invalidate()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleSchedule reads (GET) or replaces (POST) both programs at once for
+// the schedule editor. Both programs are validated before either is written.
+func handleSchedule(w http.ResponseWriter, r *http.Request, dev *device) {
+	unit, err := requestUnit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		heat, err := dev.client.Program(r.Context(), ct50.ProgramHeat)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		cool, err := dev.client.Program(r.Context(), ct50.ProgramCool)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		resp := ScheduleRequest{
+			Heat:    heat.Convert(unit.Setpoint),
+			Cool:    cool.Convert(unit.Setpoint),
+			Unit:    unit,
+			MinTemp: lowerBound(unit, ct50.MinProgramTemp),
+			MaxTemp: upperBound(unit, ct50.MaxProgramTemp),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+
+	case http.MethodPost:
+		var req ScheduleRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil || req.Heat == nil || req.Cool == nil {
+			http.Error(w, "Invalid request: both heat and cool programs are required", http.StatusBadRequest)
+			return
+		}
+		req.Heat, req.Cool = req.Heat.Convert(unit.ToDevice), req.Cool.Convert(unit.ToDevice)
+
+		if err := req.Heat.Validate(); err != nil {
+			http.Error(w, "Heat program: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := req.Cool.Validate(); err != nil {
+			http.Error(w, "Cool program: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = dev.client.SetProgram(r.Context(), ct50.ProgramHeat, req.Heat)
+		if err == nil {
+			err = dev.client.SetProgram(r.Context(), ct50.ProgramCool, req.Cool)
+		}
+		dev.programs.invalidate()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleSchedulePage(w http.ResponseWriter, r *http.Request) {
+	tmpl := template.Must(template.New("schedule").Parse(scheduleHTML))
+	tmpl.Execute(w, nil)
+}
+
+const scheduleHTML = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Thermostat Schedule</title>
+    <script src="/auth.js"></script>
+    <style>
+        * {
+            margin: 0;
+            padding: 0;
+            box-sizing: border-box;
+        }
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Oxygen, Ubuntu, Cantarell, sans-serif;
+            background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
+            min-height: 100vh;
+            display: flex;
+            justify-content: center;
+            align-items: center;
+            padding: 20px;
+        }
+        .container {
+            background: white;
+            border-radius: 20px;
+            box-shadow: 0 20px 60px rgba(0, 0, 0, 0.3);
+            padding: 40px;
+            max-width: 1000px;
+            width: 100%;
+        }
+        h1 {
+            text-align: center;
+            color: #333;
+            margin-bottom: 30px;
+            font-size: 2em;
+        }
+        .nav-link {
+            display: inline-block;
+            margin-bottom: 20px;
+            color: #667eea;
+            text-decoration: none;
+            font-weight: 600;
+        }
+        .device-picker {
+            display: block;
+            margin: -15px auto 25px;
+            padding: 8px 12px;
+            border: 2px solid #ddd;
+            border-radius: 8px;
+            font-size: 1em;
+            background: white;
+        }
+        .program-tabs {
+            display: grid;
+            grid-template-columns: repeat(2, 1fr);
+            gap: 10px;
+            margin-bottom: 20px;
+        }
+        .mode-button {
+            padding: 15px;
+            border: 2px solid #ddd;
+            background: white;
+            border-radius: 10px;
+            cursor: pointer;
+            font-size: 1em;
+            font-weight: 600;
+            transition: all 0.3s;
+        }
+        .mode-button:hover {
+            border-color: #667eea;
+        }
+        .mode-button.active {
+            background: #667eea;
+            color: white;
+            border-color: #667eea;
+        }
+        .schedule-wrap {
+            overflow-x: auto;
+        }
+        table {
+            width: 100%;
+            border-collapse: separate;
+            border-spacing: 6px;
+        }
+        th {
+            color: #666;
+            font-size: 0.9em;
+            text-align: left;
+        }
+        td {
+            background: #f8f9fa;
+            border-radius: 10px;
+            padding: 8px;
+            vertical-align: middle;
+            min-width: 150px;
+        }
+        td.invalid {
+            background: #f8d7da;
+        }
+        .period {
+            display: flex;
+            align-items: center;
+            gap: 4px;
+        }
+        .period input {
+            border: 2px solid #ddd;
+            border-radius: 6px;
+            padding: 4px;
+            font-size: 0.95em;
+        }
+        .period input[type=number] {
+            width: 60px;
+        }
+        .small-button {
+            background: none;
+            border: none;
+            color: #999;
+            cursor: pointer;
+            font-size: 1.1em;
+        }
+        .small-button:hover {
+            color: #667eea;
+        }
+        .day-actions {
+            white-space: nowrap;
+        }
+        .save-button {
+            width: 100%;
+            padding: 15px;
+            background: #667eea;
+            color: white;
+            border: none;
+            border-radius: 10px;
+            font-size: 1.1em;
+            font-weight: 600;
+            cursor: pointer;
+            margin-top: 20px;
+            transition: all 0.3s;
+        }
+        .save-button:hover {
+            background: #5568d3;
+        }
+        .hint {
+            color: #666;
+            font-size: 0.9em;
+            margin-top: 10px;
+        }
+        .message {
+            padding: 15px;
+            border-radius: 10px;
+            margin-top: 15px;
+            text-align: center;
+            font-weight: 600;
+            display: none;
+        }
+        .message.success {
+            background: #d4edda;
+            color: #155724;
+        }
+        .message.error {
+            background: #f8d7da;
+            color: #721c24;
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <a class="nav-link" href="/">← Back to Thermostat</a>
+        <h1>📅 Weekly Schedule</h1>
+        <select class="device-picker" id="devicePicker" style="display: none;" onchange="selectDevice(this.value)"></select>
+
+        <div class="program-tabs">
+            <button class="mode-button active" data-program="heat" onclick="showProgram('heat')">Heat Program</button>
+            <button class="mode-button" data-program="cool" onclick="showProgram('cool')">Cool Program</button>
+        </div>
+
+        <div class="schedule-wrap">
+            <table>
+                <thead>
+                    <tr>
+                        <th>Day</th>
+                        <th>Period 1</th>
+                        <th>Period 2</th>
+                        <th>Period 3</th>
+                        <th>Period 4</th>
+                        <th></th>
+                    </tr>
+                </thead>
+                <tbody id="scheduleBody"></tbody>
+            </table>
+        </div>
+        <div class="hint">Each day needs 1 to 4 periods in time order, with setpoints between <span id="tempRange">35 and 95°F</span>. A period lasts until the next one starts.</div>
+
+        <button class="save-button" onclick="saveSchedule()">Save Schedule</button>
+        <div class="message" id="message"></div>
+    </div>
+
+    <script>
+        const dayNames = ['Monday', 'Tuesday', 'Wednesday', 'Thursday', 'Friday', 'Saturday', 'Sunday'];
+        const maxPeriods = 4;
+        const programs = { heat: null, cool: null };
+        let currentProgram = 'heat';
+        let currentDevice = new URLSearchParams(location.search).get('device') || localStorage.getItem('thermostatDevice') || '';
+        let minTemp = 35;
+        let maxTemp = 95;
+        let symbol = '°F';
+
+        // Once a schedule is loaded, currentUnit is the unit it was loaded
+        // in, so it is saved back in the same unit.
+        let currentUnit = localStorage.getItem('thermostatUnit') || '';
+
+        // api builds the URL for an action on the selected thermostat, with
+        // temperatures in the chosen unit.
+        function api(action) {
+            const url = '/api/devices/' + encodeURIComponent(currentDevice) + '/' + action;
+            return currentUnit ? url + '?unit=' + currentUnit : url;
+        }
+
+        async function loadDevices() {
+            const response = await fetch('/api/devices');
+            if (!response.ok) throw new Error('Failed to load thermostats');
+            const list = await response.json();
+
+            if (!list.some(d => d.name === currentDevice)) {
+                currentDevice = list.length > 0 ? list[0].name : '';
+            }
+
+            const picker = document.getElementById('devicePicker');
+            picker.innerHTML = '';
+            list.forEach(d => {
+                const option = document.createElement('option');
+                option.value = d.name;
+                option.textContent = d.name;
+                option.selected = d.name === currentDevice;
+                picker.appendChild(option);
+            });
+            picker.style.display = list.length > 1 ? 'block' : 'none';
+            document.querySelectorAll('.multi-device').forEach(el => {
+                el.style.display = list.length > 1 ? 'block' : 'none';
+            });
+        }
+
+        function selectDevice(name) {
+            currentDevice = name;
+            localStorage.setItem('thermostatDevice', name);
+            history.replaceState(null, '', '?device=' + encodeURIComponent(name));
+            loadSchedule();
+        }
+
+        function showMessage(text, type) {
+            const msg = document.getElementById('message');
+            msg.textContent = text;
+            msg.className = 'message ' + type;
+            msg.style.display = 'block';
+            setTimeout(() => {
+                msg.style.display = 'none';
+            }, 5000);
+        }
+
+        // The thermostat stores each day as a flat [minute, temp, minute, temp, ...] array.
+        function fromDevice(prog) {
+            const days = [];
+            for (let d = 0; d < 7; d++) {
+                const values = prog[String(d)] || [];
+                const periods = [];
+                for (let i = 0; i + 1 < values.length; i += 2) {
+                    periods.push({ minute: values[i], temp: values[i + 1] });
+                }
+                days.push(periods);
+            }
+            return days;
+        }
+
+        function toDevice(days) {
+            const prog = {};
+            days.forEach((periods, d) => {
+                prog[String(d)] = [];
+                periods.forEach(p => prog[String(d)].push(p.minute, p.temp));
+            });
+            return prog;
+        }
+
+        function toClock(minute) {
+            const h = Math.floor(minute / 60);
+            const m = minute % 60;
+            return String(h).padStart(2, '0') + ':' + String(m).padStart(2, '0');
+        }
+
+        function fromClock(clock) {
+            const parts = clock.split(':');
+            return parseInt(parts[0]) * 60 + parseInt(parts[1]);
+        }
+
+        function validateDay(periods) {
+            if (periods.length === 0) return 'needs at least one period';
+            if (periods.length > maxPeriods) return 'has more than ' + maxPeriods + ' periods';
+            for (let i = 0; i < periods.length; i++) {
+                const p = periods[i];
+                if (isNaN(p.minute)) return 'period ' + (i + 1) + ' has no start time';
+                if (isNaN(p.temp) || p.temp < minTemp || p.temp > maxTemp) return 'period ' + (i + 1) + ' setpoint must be between ' + minTemp + ' and ' + maxTemp + symbol;
+                if (i > 0 && p.minute <= periods[i - 1].minute) return 'period ' + (i + 1) + ' must start after period ' + i;
+            }
+            return null;
+        }
+
+        function validateProgram(name) {
+            for (let d = 0; d < 7; d++) {
+                const problem = validateDay(programs[name][d]);
+                if (problem) return name.charAt(0).toUpperCase() + name.slice(1) + ' program: ' + dayNames[d] + ' ' + problem;
+            }
+            return null;
+        }
+
+        function render() {
+            const tbody = document.getElementById('scheduleBody');
+            tbody.innerHTML = '';
+            const days = programs[currentProgram];
+            if (!days) return;
+
+            days.forEach((periods, d) => {
+                const row = document.createElement('tr');
+                const label = document.createElement('th');
+                label.textContent = dayNames[d];
+                row.appendChild(label);
+
+                const invalid = validateDay(periods) !== null;
+                for (let i = 0; i < maxPeriods; i++) {
+                    const cell = document.createElement('td');
+                    if (invalid) cell.classList.add('invalid');
+                    if (i < periods.length) {
+                        cell.appendChild(periodEditor(d, i));
+                    } else if (i === periods.length) {
+                        cell.appendChild(smallButton('＋ Add', 'Add a period', () => addPeriod(d)));
+                    }
+                    row.appendChild(cell);
+                }
+
+                const actions = document.createElement('td');
+                actions.className = 'day-actions';
+                actions.appendChild(smallButton('Copy to all', 'Copy this day to every day', () => copyDay(d)));
+                row.appendChild(actions);
+
+                tbody.appendChild(row);
+            });
+        }
+
+        function periodEditor(d, i) {
+            const period = programs[currentProgram][d][i];
+            const wrap = document.createElement('div');
+            wrap.className = 'period';
+
+            const time = document.createElement('input');
+            time.type = 'time';
+            time.value = toClock(period.minute);
+            time.addEventListener('change', () => {
+                period.minute = time.value ? fromClock(time.value) : NaN;
+                render();
+            });
+
+            const temp = document.createElement('input');
+            temp.type = 'number';
+            temp.min = minTemp;
+            temp.max = maxTemp;
+            temp.step = 0.5;
+            temp.value = period.temp;
+            temp.addEventListener('change', () => {
+                period.temp = Math.round(parseFloat(temp.value) * 2) / 2;
+                render();
+            });
+
+            wrap.appendChild(time);
+            wrap.appendChild(temp);
+            wrap.appendChild(smallButton('×', 'Remove this period', () => removePeriod(d, i)));
+            return wrap;
+        }
+
+        function smallButton(text, title, onClick) {
+            const btn = document.createElement('button');
+            btn.className = 'small-button';
+            btn.textContent = text;
+            btn.title = title;
+            btn.addEventListener('click', onClick);
+            return btn;
+        }
+
+        function addPeriod(d) {
+            const periods = programs[currentProgram][d];
+            const last = periods[periods.length - 1];
+            const minute = last ? Math.min(last.minute + 60, 23 * 60 + 59) : 6 * 60;
+            const temp = last ? last.temp : (symbol === '°C' ? 21 : 70);
+            periods.push({ minute: minute, temp: temp });
+            render();
+        }
+
+        function removePeriod(d, i) {
+            programs[currentProgram][d].splice(i, 1);
+            render();
+        }
+
+        function copyDay(d) {
+            const source = programs[currentProgram][d];
+            for (let i = 0; i < 7; i++) {
+                programs[currentProgram][i] = source.map(p => ({ minute: p.minute, temp: p.temp }));
+            }
+            render();
+        }
+
+        function showProgram(name) {
+            currentProgram = name;
+            document.querySelectorAll('.mode-button').forEach(btn => {
+                btn.classList.toggle('active', btn.getAttribute('data-program') === name);
+            });
+            render();
+        }
+
+        async function loadSchedule() {
+            try {
+                const response = await fetch(api('schedule'));
+                if (!response.ok) throw new Error(await response.text());
+
+                const data = await response.json();
+                currentUnit = data.unit;
+                symbol = data.unit === 'C' ? '°C' : '°F';
+                minTemp = data.minTemp;
+                maxTemp = data.maxTemp;
+                document.getElementById('tempRange').textContent = minTemp + ' and ' + maxTemp + symbol;
+                programs.heat = fromDevice(data.heat);
+                programs.cool = fromDevice(data.cool);
+                render();
+            } catch (error) {
+                showMessage('Failed to load schedule: ' + error.message, 'error');
+            }
+        }
+
+        async function saveSchedule() {
+            const problem = validateProgram('heat') || validateProgram('cool');
+            if (problem) {
+                showMessage(problem, 'error');
+                return;
+            }
+
+            try {
+                const response = await fetch(api('schedule'), {
+                    method: 'POST',
+                    headers: {
+                        'Content-Type': 'application/json',
+                    },
+                    body: JSON.stringify({ heat: toDevice(programs.heat), cool: toDevice(programs.cool) })
+                });
+
+                if (!response.ok) {
+                    const error = await response.text();
+                    throw new Error(error);
+                }
+
+                showMessage('Schedule saved', 'success');
+            } catch (error) {
+                showMessage('Failed to save schedule: ' + error.message, 'error');
+            }
+        }
+
+        loadDevices().then(loadSchedule).catch(error => showMessage(error.message, 'error'));
+    </script>
+</body>
+</html>
+`
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/internal/audit"
+	"github.com/EntropySynthetica/Thermostat/internal/scheduler"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+// schedulerInterval is how often the server-side schedules are run.
+const schedulerInterval = 30 * time.Second
+
+// schedules keeps each thermostat's server-side schedule.
+var schedules = scheduler.NewStore(scheduler.DefaultPath())
+
+var errBadUntil = errors.New("Hold time must be HH:MM or an RFC 3339 time in the future")
+
+// WallChange reports a setpoint that was changed by hand in the middle of
+// a scheduled period. The schedule leaves it alone until the next period.
+type WallChange struct {
+	At   time.Time `json:"at"`
+	Mode string    `json:"mode"`
+	Want float64   `json:"want"`
+	Got  float64   `json:"got"`
+
+	// Source is "thermostat" for a change made at the wall unit, or "web"
+	// for one made through this server.
+	Source string `json:"source"`
+}
+
+// SchedulerInfo describes a running server-side schedule for the status
+// card. Temperatures are in the request's unit.
+type SchedulerInfo struct {
+	Period     *scheduler.Change `json:"period,omitempty"`
+	HoldUntil  *time.Time        `json:"holdUntil,omitempty"`
+	WallChange *WallChange       `json:"wallChange,omitempty"`
+}
+
+// SchedulerResponse is the reply from GET .../scheduler.
+type SchedulerResponse struct {
+	Unit     ct50.Unit           `json:"unit"`
+	Schedule *scheduler.Schedule `json:"schedule"`
+	Status   *SchedulerInfo      `json:"status,omitempty"`
+}
+
+// schedRun is what the scheduler last pushed to one thermostat, so it can
+// tell a new period from a setpoint changed by hand.
+type schedRun struct {
+	mu sync.Mutex
+
+	// period is the start of the period last pushed. It is zero when
+	// nothing has been pushed, or the next run should push again.
+	period     time.Time
+	mode       ct50.Mode
+	heat, cool float64
+
+	// held is set once a push has turned the thermostat's hold on, until
+	// release turns it off again. reset leaves it alone.
+	held bool
+
+	// byWeb is set when the setpoint, mode or fan is changed through this
+	// server, so the change that shows up on the next run is not blamed on
+	// the wall unit, and a new mode is kept rather than pushed over.
+	byWeb bool
+
+	wall *WallChange
+}
+
+// reset makes the next run push the current period again.
+func (s *schedRun) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.period = time.Time{}
+	s.wall = nil
+}
+
+// changedByWeb notes that this server changed the thermostat outside the
+// schedule.
+func (s *schedRun) changedByWeb() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.byWeb = true
+}
+
+func (s *schedRun) wallChange() *WallChange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.wall
+}
+
+// watchSchedules runs every enabled schedule until ctx is done.
+func watchSchedules(ctx context.Context) {
+	ticker := time.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+
+	for {
+		runSchedules(ctx, time.Now())
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// runSchedules brings every thermostat with an enabled schedule in line
+// with it at now, and hands the rest back to their own programs.
+func runSchedules(ctx context.Context, now time.Time) {
+	all, err := schedules.All()
+	if err != nil {
+		log.Printf("scheduler: %v", err)
+		return
+	}
+
+	for _, dev := range devices {
+		sched := all[dev.Name]
+		if sched == nil || !sched.Enabled {
+			ctx := audit.WithOrigin(ctx, audit.Origin{Actor: automation("scheduler"), Action: "schedule off"})
+			if err := releaseSchedule(ctx, dev, now); err != nil {
+				log.Printf("scheduler: %s: %v", dev.Name, err)
+			}
+			continue
+		}
+		if sched.Held(now) {
+			dev.run.reset()
+			continue
+		}
+
+		// A boost or vacation has the thermostat for now; pick the
+		// schedule up again once it ends.
+		if b, err := boosts.Get(dev.Name); err != nil || b != nil || onVacation(dev, now) {
+			dev.run.reset()
+			continue
+		}
+
+		ctx := audit.WithOrigin(ctx, audit.Origin{Actor: automation("scheduler"), Action: "scheduled period"})
+		if err := runSchedule(ctx, dev, sched, now); err != nil {
+			log.Printf("scheduler: %s: %v", dev.Name, err)
+		}
+	}
+}
+
+// runSchedule pushes the period in effect at now if it has not been pushed
+// yet, or if the mode has changed since. Within a period it only watches
+// for setpoints changed by hand, and reports rather than undoes them.
+func runSchedule(ctx context.Context, dev *device, sched *scheduler.Schedule, now time.Time) error {
+	period, ok := sched.Current(now)
+	if !ok {
+		return nil
+	}
+
+	stats, err := dev.client.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	run := dev.run
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	if run.period.Equal(period.Start) && stats.Tmode != run.mode && run.byWeb {
+		run.keepMode(dev, stats, period, now)
+		return nil
+	}
+	if !run.period.Equal(period.Start) || stats.Tmode != run.mode {
+		return run.push(ctx, dev, stats, period)
+	}
+
+	mode, want, got, changed := run.changedSetpoint(stats)
+	if !changed {
+		return nil
+	}
+	if run.wall != nil && run.wall.Got == got {
+		return nil
+	}
+
+	source := "thermostat"
+	if run.byWeb {
+		source = "web"
+	}
+	run.byWeb = false
+	run.wall = &WallChange{At: now, Mode: mode.String(), Want: want, Got: got, Source: source}
+	log.Printf("scheduler: %s: %s setpoint changed by hand (%s) from %v to %v; leaving it until the next period", dev.Name, mode, source, want, got)
+	return nil
+}
+
+// push sends the setpoints of period for the thermostat's current mode,
+// with the hold on so the device program does not undo them. Callers must
+// hold s.mu.
+func (s *schedRun) push(ctx context.Context, dev *device, stats *ct50.Status, period scheduler.Change) error {
+	heat, cool := period.Heat, period.Cool
+	hold := ct50.On
+	u := ct50.Update{Hold: &hold}
+	switch stats.Tmode {
+	case ct50.ModeHeat:
+		u.THeat = &heat
+	case ct50.ModeCool:
+		u.TCool = &cool
+	case ct50.ModeAuto:
+		u.THeat, u.TCool = &heat, &cool
+	}
+
+	if _, err := dev.client.UpdateAndVerify(ctx, u); err != nil {
+		return err
+	}
+
+	s.period, s.mode = period.Start, stats.Tmode
+	s.heat, s.cool = heat, cool
+	s.held = true
+	s.byWeb = false
+	s.wall = nil
+	log.Printf("scheduler: %s: %s period from %s, heat %v cool %v", dev.Name, stats.Tmode, period.At, heat, cool)
+	dev.events.poke()
+	return nil
+}
+
+// releaseSchedule hands dev back to its own program once its schedule is
+// disabled or gone, by turning off the hold the schedule's pushes put on.
+// A boost or vacation that has the thermostat keeps it; the hold is
+// released after it ends.
+func releaseSchedule(ctx context.Context, dev *device, now time.Time) error {
+	dev.run.reset()
+	if b, err := boosts.Get(dev.Name); err != nil || b != nil || onVacation(dev, now) {
+		return err
+	}
+	return dev.run.release(ctx, dev)
+}
+
+// release turns off the hold if a push turned it on.
+func (s *schedRun) release(ctx context.Context, dev *device) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.held {
+		return nil
+	}
+	hold := ct50.Off
+	if _, err := dev.client.UpdateAndVerify(ctx, ct50.Update{Hold: &hold}); err != nil {
+		return err
+	}
+	s.held = false
+	log.Printf("scheduler: %s: schedule off, hold released; following the thermostat's program", dev.Name)
+	dev.events.poke()
+	return nil
+}
+
+// keepMode leaves a mode chosen through this server, and the setpoints
+// that came with it, alone until the next period, as runSchedule does for
+// a setpoint changed by hand. Callers must hold s.mu.
+func (s *schedRun) keepMode(dev *device, stats *ct50.Status, period scheduler.Change, now time.Time) {
+	s.mode = stats.Tmode
+	s.heat, s.cool = period.Heat, period.Cool
+	s.byWeb = false
+	s.wall = nil
+	if mode, want, got, changed := s.changedSetpoint(stats); changed {
+		s.wall = &WallChange{At: now, Mode: mode.String(), Want: want, Got: got, Source: "web"}
+	}
+	log.Printf("scheduler: %s: mode changed to %s here; leaving it until the next period", dev.Name, stats.Tmode)
+}
+
+// changedSetpoint compares stats with what was last pushed. Callers must
+// hold s.mu.
+func (s *schedRun) changedSetpoint(stats *ct50.Status) (mode ct50.Mode, want, got float64, changed bool) {
+	if (stats.Tmode == ct50.ModeHeat || stats.Tmode == ct50.ModeAuto) && stats.THeat != s.heat {
+		return ct50.ModeHeat, s.heat, stats.THeat, true
+	}
+	if (stats.Tmode == ct50.ModeCool || stats.Tmode == ct50.ModeAuto) && stats.TCool != s.cool {
+		return ct50.ModeCool, s.cool, stats.TCool, true
+	}
+	return 0, 0, 0, false
+}
+
+// schedulerInfo describes dev's schedule at now, or returns nil if it has
+// no enabled schedule.
+func schedulerInfo(dev *device, sched *scheduler.Schedule, unit ct50.Unit, now time.Time) *SchedulerInfo {
+	if sched == nil || !sched.Enabled {
+		return nil
+	}
+
+	info := &SchedulerInfo{}
+	if sched.Held(now) {
+		info.HoldUntil = sched.HoldUntil
+	}
+	if period, ok := sched.Current(now); ok {
+		period.Heat, period.Cool = unit.Setpoint(period.Heat), unit.Setpoint(period.Cool)
+		info.Period = &period
+	}
+	if wall := dev.run.wallChange(); wall != nil {
+		converted := *wall
+		converted.Want, converted.Got = unit.Setpoint(wall.Want), unit.Setpoint(wall.Got)
+		info.WallChange = &converted
+	}
+	return info
+}
+
+// scheduledChanges is nextChanges for a thermostat run by a server-side
+// schedule.
+func scheduledChanges(sched *scheduler.Schedule, stats *ct50.Status, unit ct50.Unit, now time.Time) []NextChange {
+	changes := []NextChange{}
+	next, ok := sched.Next(now)
+	if !ok {
+		return changes
+	}
+
+	add := func(program string, temp float64) {
+		changes = append(changes, NextChange{
+			Program: program,
+			Day:     next.Start.Format("Mon"),
+			Time:    next.At,
+			Temp:    unit.Setpoint(temp),
+		})
+	}
+	switch stats.Tmode {
+	case ct50.ModeHeat:
+		add("Heat", next.Heat)
+	case ct50.ModeCool:
+		add("Cool", next.Cool)
+	case ct50.ModeAuto:
+		add("Heat", next.Heat)
+		add("Cool", next.Cool)
+	}
+	return changes
+}
+
+// handleScheduler reads (GET) or replaces (PUT) the thermostat's
+// server-side schedule, in the request's unit.
+func handleScheduler(w http.ResponseWriter, r *http.Request, dev *device) {
+	unit, err := requestUnit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		sched, err := schedules.Get(dev.Name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		resp := SchedulerResponse{Unit: unit}
+		if sched != nil {
+			resp.Schedule = sched.Convert(unit.Setpoint)
+			resp.Status = schedulerInfo(dev, sched, unit, time.Now())
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+
+	case http.MethodPut:
+		var sched scheduler.Schedule
+		if err := json.NewDecoder(r.Body).Decode(&sched); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		// Schedules are kept and checked in degrees F, as they are pushed.
+		devSched := sched.Convert(unit.ToDevice)
+		if err := devSched.Validate(dev.client.Deadband); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := schedules.Put(dev.Name, devSched); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		dev.run.reset()
+		// The scheduler would release the hold on its next run anyway.
+		if !devSched.Enabled {
+			if err := releaseSchedule(r.Context(), dev, time.Now()); err != nil {
+				log.Printf("scheduler: %s: %v", dev.Name, err)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleSchedulerHold keeps the thermostat as it is until a time, with
+// POST {"until": "18:00"} or a full RFC 3339 time. DELETE ends the hold
+// and goes back to the schedule.
+func handleSchedulerHold(w http.ResponseWriter, r *http.Request, dev *device) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sched, err := schedules.Get(dev.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if sched == nil || !sched.Enabled {
+		http.Error(w, "Thermostat "+dev.Name+" has no server-side schedule", http.StatusConflict)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		var req struct {
+			Until string `json:"until"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		until, err := parseUntil(req.Until, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sched.HoldUntil = &until
+	} else {
+		sched.HoldUntil = nil
+	}
+
+	if err := schedules.Put(dev.Name, sched); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	dev.run.reset()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// parseUntil reads an RFC 3339 time, or HH:MM for the next time the clock
+// shows it after now.
+func parseUntil(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		if !t.After(now) {
+			return time.Time{}, errBadUntil
+		}
+		return t, nil
+	}
+
+	minute, err := ct50.ParseClock(s)
+	if err != nil {
+		return time.Time{}, errBadUntil
+	}
+	y, m, d := now.Date()
+	t := time.Date(y, m, d, minute/60, minute%60, 0, 0, now.Location())
+	if !t.After(now) {
+		t = time.Date(y, m, d+1, minute/60, minute%60, 0, 0, now.Location())
+	}
+	return t, nil
+}
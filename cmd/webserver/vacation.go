@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/internal/audit"
+	"github.com/EntropySynthetica/Thermostat/internal/vacation"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+// vacationCheckInterval is how often vacations are started, held and
+// ended.
+const vacationCheckInterval = 30 * time.Second
+
+// vacations keeps each thermostat's vacation, shared with the thermostat
+// CLI.
+var vacations = vacation.NewStore(vacation.DefaultPath())
+
+// VacationInfo describes a vacation for the status card. Temperatures are
+// in the request's unit.
+type VacationInfo struct {
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	RecoverAt time.Time `json:"recoverAt"`
+	Heat      float64   `json:"heat"`
+	Cool      float64   `json:"cool"`
+	Started   bool      `json:"started"`
+}
+
+func vacationInfo(v *vacation.Vacation, unit ct50.Unit) *VacationInfo {
+	return &VacationInfo{
+		From:      v.From,
+		To:        v.To,
+		RecoverAt: v.RecoverAt(),
+		Heat:      unit.Setpoint(v.Heat),
+		Cool:      unit.Setpoint(v.Cool),
+		Started:   v.Started(),
+	}
+}
+
+// onVacation reports whether dev's vacation has the thermostat at now, so
+// the server-side schedule should stay out of the way.
+func onVacation(dev *device, now time.Time) bool {
+	v, err := vacations.Get(dev.Name)
+	if err != nil {
+		log.Printf("vacation: %v", err)
+		return true
+	}
+	return v != nil && (v.Started() || v.Away(now))
+}
+
+// handleVacation sets a vacation with POST {"to": "2024-12-28", "heat": n,
+// "cool": n}, plus an optional "from" (default now) and "recover" lead
+// time such as "3h". Times may be dates, "2024-12-28T18:00" or RFC 3339.
+// GET shows the vacation and DELETE cancels it, putting the old setting
+// back if it has started.
+func handleVacation(w http.ResponseWriter, r *http.Request, dev *device) {
+	unit, err := requestUnit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	current, err := vacations.Get(dev.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		if current == nil {
+			json.NewEncoder(w).Encode(nil)
+			return
+		}
+		json.NewEncoder(w).Encode(vacationInfo(current, unit))
+
+	case http.MethodPost:
+		var req struct {
+			From    string   `json:"from"`
+			To      string   `json:"to"`
+			Heat    *float64 `json:"heat"`
+			Cool    *float64 `json:"cool"`
+			Recover string   `json:"recover"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Heat == nil || req.Cool == nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		v := &vacation.Vacation{
+			Device: dev.Name,
+			From:   now,
+			Heat:   unit.ToDevice(*req.Heat),
+			Cool:   unit.ToDevice(*req.Cool),
+		}
+		if req.From != "" {
+			if v.From, err = vacation.ParseTime(req.From, time.Local); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if v.To, err = vacation.ParseTime(req.To, time.Local); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Recover != "" {
+			if v.Recovery, err = time.ParseDuration(req.Recover); err != nil {
+				http.Error(w, "Recovery must be a duration such as 90m or 3h", http.StatusBadRequest)
+				return
+			}
+		}
+
+		if err := v.Validate(dev.client.Deadband, now); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := vacations.Set(r.Context(), dev.client, boosts, v, now); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		dev.run.reset()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+
+	case http.MethodDelete:
+		if current == nil {
+			http.Error(w, "No vacation set", http.StatusNotFound)
+			return
+		}
+
+		if err := vacations.Finish(r.Context(), dev.client, current); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		dev.run.reset()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// watchVacations starts, holds and ends vacations on time, until ctx is
+// done. It checks once straight away, so a vacation that began or ended
+// while the server was down is picked up at startup.
+func watchVacations(ctx context.Context) {
+	ticker := time.NewTicker(vacationCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		runVacations(ctx, time.Now())
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// runVacations brings every thermostat with a vacation in line with it at
+// now. A thermostat that cannot be reached is tried again next time.
+func runVacations(ctx context.Context, now time.Time) {
+	all, err := vacations.All()
+	if err != nil {
+		log.Printf("vacation: %v", err)
+		return
+	}
+
+	for name, v := range all {
+		dev := findDevice(name)
+		if dev == nil {
+			log.Printf("vacation: %s is no longer configured; leaving its vacation alone", name)
+			continue
+		}
+
+		ctx := audit.WithOrigin(ctx, audit.Origin{Actor: automation("vacation"), Action: "vacation"})
+		action, err := vacations.Run(ctx, dev.client, boosts, v, now)
+		if err == nil && action != vacation.Nothing {
+			dev.events.poke()
+		}
+		switch {
+		case err != nil:
+			log.Printf("vacation: %s: %v", name, err)
+		case action == vacation.Started:
+			log.Printf("vacation: %s: started, holding heat %v cool %v until %s", name, v.Heat, v.Cool, v.RecoverAt().Format(time.RFC3339))
+		case action == vacation.Reheld:
+			log.Printf("vacation: %s: setpoints had been changed; put the away setpoints back", name)
+		case action == vacation.Ended && v.Started():
+			log.Printf("vacation: %s: ended, restored %s mode", name, v.Prior.Tmode)
+			dev.run.reset()
+		case action == vacation.Ended:
+			log.Printf("vacation: %s: ended before it could start", name)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/AlecAivazis/survey/v2"
+
+	"github.com/EntropySynthetica/Thermostat/internal/config"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+// run_discover searches the network for thermostats and offers to add the
+// ones found to the config file.
+func run_discover(ctx context.Context, configFile string, args []string) error {
+	flags := flag.NewFlagSet("discover", flag.ContinueOnError)
+	timeout := flags.Duration("timeout", ct50.DefaultDiscoveryTimeout, "how long to listen for replies")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	fmt.Println("Searching for thermostats...")
+	found, err := ct50.Discover(ctx, ct50.DiscoverOptions{Timeout: *timeout})
+	if err != nil {
+		return err
+	}
+
+	if len(found) == 0 {
+		fmt.Println("No thermostats found. Check that this computer is on the same network as the thermostat.")
+		return nil
+	}
+
+	fmt.Printf("Found %d thermostat(s):\n", len(found))
+	for _, dev := range found {
+		fmt.Printf("  %-21s %-12s %-16s uuid %s\n", dev.Addr, dev.Model, dev.Name, dev.UUID)
+	}
+
+	configData, err := config.Load(configFile)
+	if errors.Is(err, os.ErrNotExist) {
+		configData = &config.Config{}
+	} else if err != nil {
+		return err
+	}
+
+	// Fold a legacy single-thermostat entry into the device list so new
+	// devices are added alongside it.
+	configData.Devices = configData.DeviceList()
+	configData.ThermostatIP = ""
+
+	existing := make(map[string]string)
+	for _, dev := range configData.Devices {
+		existing[dev.IP] = dev.Name
+	}
+
+	var options []string
+	byOption := make(map[string]ct50.Discovered)
+	for _, dev := range found {
+		if name, ok := existing[dev.Addr]; ok {
+			fmt.Printf("%s is already configured as %q\n", dev.Addr, name)
+			continue
+		}
+		option := dev.Addr + " (" + dev.Model + ")"
+		options = append(options, option)
+		byOption[option] = dev
+	}
+
+	if len(options) == 0 {
+		return nil
+	}
+
+	var selected []string
+	prompt := &survey.MultiSelect{
+		Message: "Add which thermostats to " + configFile + "?",
+		Options: options,
+		Default: options,
+	}
+	if err := survey.AskOne(prompt, &selected); err != nil {
+		return err
+	}
+
+	for _, option := range selected {
+		dev := byOption[option]
+
+		name := ""
+		namePrompt := &survey.Input{
+			Message: "Name for " + dev.Addr + ":",
+			Default: suggest_name(dev, len(configData.Devices)+1),
+		}
+		if err := survey.AskOne(namePrompt, &name, survey.WithValidator(survey.Required)); err != nil {
+			return err
+		}
+
+		configData.Devices = append(configData.Devices, config.Device{Name: name, IP: dev.Addr})
+	}
+
+	if err := configData.Validate(); err != nil {
+		return err
+	}
+
+	confirm := false
+	confirmPrompt := &survey.Confirm{
+		Message: fmt.Sprintf("Write %d thermostat(s) to %s?", len(configData.Devices), configFile),
+		Default: true,
+	}
+	survey.AskOne(confirmPrompt, &confirm)
+
+	if !confirm {
+		return nil
+	}
+
+	if err := configData.Save(configFile); err != nil {
+		return err
+	}
+	fmt.Println("Config file updated at " + configFile)
+	return nil
+}
+
+// suggest_name turns the name set on the thermostat into a config name, or
+// falls back to thermostat-N.
+func suggest_name(dev ct50.Discovered, n int) string {
+	name := strings.ToLower(strings.TrimSpace(dev.Name))
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		case r == ' ':
+			return '-'
+		default:
+			return -1
+		}
+	}, name)
+
+	if name == "" {
+		return fmt.Sprintf("thermostat-%d", n)
+	}
+	return name
+}
@@ -1,16 +1,24 @@
-module thermostat
+module github.com/EntropySynthetica/Thermostat
 
 go 1.19
 
-require github.com/AlecAivazis/survey/v2 v2.3.6
+require (
+	github.com/AlecAivazis/survey/v2 v2.3.6
+	github.com/eclipse/paho.mqtt.golang v1.4.3
+	go.etcd.io/bbolt v1.3.8
+	golang.org/x/crypto v0.11.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
-	github.com/joho/godotenv v1.4.0 // indirect
+	github.com/gorilla/websocket v1.5.0 // indirect
 	github.com/kballard/go-shellquote v0.0.0-20180428030007-95032a82bc51 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.17 // indirect
 	github.com/mgutz/ansi v0.0.0-20200706080929-d51e80ef957d // indirect
-	golang.org/x/sys v0.6.0 // indirect
-	golang.org/x/term v0.6.0 // indirect
-	golang.org/x/text v0.8.0 // indirect
+	golang.org/x/net v0.11.0 // indirect
+	golang.org/x/sync v0.2.0 // indirect
+	golang.org/x/sys v0.10.0 // indirect
+	golang.org/x/term v0.10.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
 )
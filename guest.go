@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/internal/auth"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+const guestUsage = `Usage:
+  thermostat guest create --name <name> --min <temp> --max <temp> [--days 7] [--allow-mode] [--url <address>]
+  thermostat guest [list] [--url <address>]
+  thermostat guest revoke <id>
+
+A guest link lets a house-sitter or relative use the thermostat picked with
+-d from a simple page, without an account, until it expires. They can set
+temperatures from --min to --max, and change the mode only with
+--allow-mode. Everything done through a link is in the web server's log
+and the audit log.
+
+--url is the web server's address, such as https://thermostat.local:8443,
+to print whole links; without it only their paths are printed. Links can be
+listed again to send them once more.`
+
+func run_guest(guests *auth.Guests, device string, unit ct50.Unit, args []string) error {
+	if len(args) > 0 && args[0] == "create" {
+		return guest_create(guests, device, unit, args[1:])
+	}
+	if len(args) > 0 && args[0] == "revoke" {
+		if len(args) != 2 {
+			return errors.New(guestUsage)
+		}
+		if err := guests.Revoke(args[1]); err != nil {
+			return err
+		}
+		fmt.Println("Revoked guest link " + args[1])
+		return nil
+	}
+	if len(args) > 0 && args[0] == "list" {
+		args = args[1:]
+	}
+
+	flags := flag.NewFlagSet("guest list", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Println(guestUsage) }
+	base := flags.String("url", "", "the web server's address")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return errors.New(guestUsage)
+	}
+	return guest_list(guests, unit, *base, time.Now())
+}
+
+func guest_create(guests *auth.Guests, device string, unit ct50.Unit, args []string) error {
+	flags := flag.NewFlagSet("guest create", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Println(guestUsage) }
+	name := flags.String("name", "", "who the link is for, such as sitter")
+	min := flags.Float64("min", 0, "lowest setpoint the guest may choose")
+	max := flags.Float64("max", 0, "highest setpoint the guest may choose")
+	days := flags.Int("days", 7, "how many days the link works for")
+	allowMode := flags.Bool("allow-mode", false, "let the guest change the mode too")
+	base := flags.String("url", "", "the web server's address")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 || *name == "" {
+		return errors.New(guestUsage)
+	}
+	if *min == 0 || *max == 0 {
+		return errors.New("guest create needs --min and --max")
+	}
+
+	now := time.Now()
+	g := &auth.Guest{
+		Name:    *name,
+		Device:  device,
+		MinTemp: unit.ToDevice(*min),
+		MaxTemp: unit.ToDevice(*max),
+		Mode:    *allowMode,
+		Created: now,
+		Expires: now.Add(time.Duration(*days) * 24 * time.Hour),
+	}
+	link, err := guests.Create(g)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Created guest link " + g.ID + " for " + g.Name + ": " + guest_summary(g, unit))
+	fmt.Println(guest_url(*base, link))
+	return nil
+}
+
+func guest_list(guests *auth.Guests, unit ct50.Unit, base string, now time.Time) error {
+	list, err := guests.List()
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		fmt.Println("No guest links")
+		return nil
+	}
+
+	for _, g := range list {
+		if !now.Before(g.Expires) {
+			fmt.Printf("%s  %-16s expired %s\n", g.ID, g.Name, g.Expires.Local().Format("2006-01-02 15:04"))
+			continue
+		}
+		link, err := guests.Link(g.ID)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s  %-16s %s\n", g.ID, g.Name, guest_summary(g, unit))
+		fmt.Println("    " + guest_url(base, link))
+	}
+	return nil
+}
+
+// guest_summary describes what a guest link allows, such as "upstairs,
+// 66°F to 74°F, no mode changes, until 2024-12-28 18:00".
+func guest_summary(g *auth.Guest, unit ct50.Unit) string {
+	mode := "no mode changes"
+	if g.Mode {
+		mode = "mode changes allowed"
+	}
+	return fmt.Sprintf("%s, %s to %s, %s, until %s", g.Device, unit.Format(unit.Setpoint(g.MinTemp)),
+		unit.Format(unit.Setpoint(g.MaxTemp)), mode, g.Expires.Local().Format("2006-01-02 15:04"))
+}
+
+// guest_url returns the address of a guest link on the web server at
+// base, or just its path if base is empty.
+func guest_url(base, link string) string {
+	return strings.TrimSuffix(base, "/") + "/guest/" + link
+}
@@ -0,0 +1,128 @@
+// Package audit records every change made to the thermostats, who made it
+// and from where, with the thermostat's state before and after, and reads
+// the record back.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+// Kind is the sort of actor behind a change.
+type Kind string
+
+const (
+	// User is someone signed in to the web server.
+	User Kind = "user"
+
+	// Token is a script or integration using an API token.
+	Token Kind = "token"
+
+	// Guest is someone using a guest link.
+	Guest Kind = "guest"
+
+	// Anonymous is someone using a web server that has no users.
+	Anonymous Kind = "anonymous"
+
+	// CLI is someone running the thermostat command. The name is their
+	// login on the machine it ran on.
+	CLI Kind = "cli"
+
+	// Automation is the web server acting on its own: ending a boost,
+	// holding a vacation or running the server-side schedule.
+	Automation Kind = "automation"
+
+	// Integration is a bridge to another system, such as MQTT.
+	Integration Kind = "integration"
+)
+
+// Actor is who or what made a change.
+type Actor struct {
+	Kind Kind   `json:"kind"`
+	Name string `json:"name,omitempty"`
+}
+
+func (a Actor) String() string {
+	if a.Kind == "" {
+		return "unknown"
+	}
+	if a.Name == "" {
+		return string(a.Kind)
+	}
+	return string(a.Kind) + " " + a.Name
+}
+
+// Origin is who is behind the changes made with a context, where they
+// asked from and what they asked for.
+type Origin struct {
+	Actor Actor
+
+	// IP is the address the request came from, if it came over the
+	// network.
+	IP string
+
+	// Action is what was asked for, such as "POST /api/settemp" or
+	// "boost ended".
+	Action string
+}
+
+type originKey struct{}
+
+// WithOrigin returns a context whose changes are recorded as made by o.
+func WithOrigin(ctx context.Context, o Origin) context.Context {
+	return context.WithValue(ctx, originKey{}, o)
+}
+
+// OriginFrom returns the origin of changes made with ctx. It is empty if
+// none was set, which is recorded as an unknown actor.
+func OriginFrom(ctx context.Context) Origin {
+	o, _ := ctx.Value(originKey{}).(Origin)
+	return o
+}
+
+// State is the part of a thermostat's state a change can touch.
+// Temperatures are in degrees F.
+type State struct {
+	Tmode ct50.Mode    `json:"tmode"`
+	THeat float64      `json:"t_heat,omitempty"`
+	TCool float64      `json:"t_cool,omitempty"`
+	Fmode ct50.FanMode `json:"fmode"`
+	Hold  ct50.OnOff   `json:"hold"`
+}
+
+// StateOf records the parts of stats a change can touch.
+func StateOf(stats *ct50.Status) *State {
+	return &State{
+		Tmode: stats.Tmode,
+		THeat: stats.THeat,
+		TCool: stats.TCool,
+		Fmode: stats.Fmode,
+		Hold:  stats.Hold,
+	}
+}
+
+// Entry is one change sent to a thermostat.
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Device string    `json:"device"`
+	Actor  Actor     `json:"actor"`
+	IP     string    `json:"ip,omitempty"`
+	Action string    `json:"action,omitempty"`
+
+	// Path and Change are the thermostat API path written to and the
+	// JSON sent to it.
+	Path   string          `json:"path"`
+	Change json.RawMessage `json:"change"`
+
+	// Before and After are the thermostat's state around the change.
+	// Either is missing if the thermostat could not be read, and After
+	// is missing if the change failed.
+	Before *State `json:"before,omitempty"`
+	After  *State `json:"after,omitempty"`
+
+	// Error is why the change failed, if it did.
+	Error string `json:"error,omitempty"`
+}
@@ -0,0 +1,143 @@
+package audit
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50sim"
+)
+
+func TestWatch(t *testing.T) {
+	dev := ct50sim.New(ct50sim.Options{})
+	srv := httptest.NewServer(dev)
+	t.Cleanup(srv.Close)
+	client := ct50.New(srv.URL)
+
+	l := NewLog(filepath.Join(t.TempDir(), "audit.log"))
+	l.Watch(client, "den")
+
+	before := dev.Status()
+	ctx := WithOrigin(context.Background(), Origin{Actor: Actor{Kind: User, Name: "alice"}, IP: "192.168.1.50", Action: "POST /api/settemp"})
+	if err := client.SetHeat(ctx, 72); err != nil {
+		t.Fatal(err)
+	}
+	// A change without an origin is still recorded.
+	if err := client.SetRange(context.Background(), 66, 60); err == nil {
+		t.Fatal("SetRange accepted a range inside out")
+	}
+	if err := client.SetFanMode(context.Background(), ct50.FanOn); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := l.Read(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2 (the bad range never reaches the thermostat): %+v", len(entries), entries)
+	}
+
+	fan, set := entries[0], entries[1]
+	if set.Device != "den" || set.Actor.String() != "user alice" || set.IP != "192.168.1.50" || set.Action != "POST /api/settemp" || set.Path != "/tstat" {
+		t.Errorf("entry = %+v, want alice's settemp on den", set)
+	}
+	if string(set.Change) != `{"tmode":1,"t_heat":72}` {
+		t.Errorf("change = %s", set.Change)
+	}
+	if set.Before == nil || set.Before.THeat != before.THeat || set.After == nil || set.After.THeat != 72 || set.After.Tmode != ct50.ModeHeat {
+		t.Errorf("before %+v after %+v, want t_heat %v then 72", set.Before, set.After, before.THeat)
+	}
+	if fan.Actor.String() != "unknown" || fan.After == nil || fan.After.Fmode != ct50.FanOn {
+		t.Errorf("fan entry = %+v, want an unknown actor turning the fan on", fan)
+	}
+
+	if got, _ := l.Read(Query{Limit: 1}); len(got) != 1 || got[0].Change == nil || string(got[0].Change) != string(fan.Change) {
+		t.Errorf("Read(Limit 1) = %+v, want the newest entry", got)
+	}
+	if got, _ := l.Read(Query{Device: "attic"}); len(got) != 0 {
+		t.Errorf("Read(attic) = %+v, want nothing", got)
+	}
+	if got, _ := l.Read(Query{Since: time.Now().Add(time.Minute)}); len(got) != 0 {
+		t.Errorf("Read(Since later) = %+v, want nothing", got)
+	}
+}
+
+func TestWatchFailedChange(t *testing.T) {
+	dev := ct50sim.New(ct50sim.Options{})
+	srv := httptest.NewServer(dev)
+	client := ct50.New(srv.URL)
+	client.Retries = 0
+	l := NewLog(filepath.Join(t.TempDir(), "audit.log"))
+	l.Watch(client, "den")
+	srv.Close()
+
+	err := client.SetMode(context.Background(), ct50.ModeCool)
+	if err == nil {
+		t.Fatal("SetMode worked with the thermostat gone")
+	}
+	entries, _ := l.Read(Query{})
+	if len(entries) != 1 || entries[0].Error == "" || entries[0].Before != nil || entries[0].After != nil {
+		t.Errorf("entries = %+v, want one failed change without states", entries)
+	}
+	if len(entries) == 1 && entries[0].Error != err.Error() {
+		t.Errorf("recorded error %q, returned %v", entries[0].Error, err)
+	}
+}
+
+func TestReadMissing(t *testing.T) {
+	entries, err := NewLog(filepath.Join(t.TempDir(), "audit.log")).Read(Query{})
+	if err != nil || len(entries) != 0 {
+		t.Errorf("Read of a new log = %v, %v, want nothing", entries, err)
+	}
+}
+
+func TestReadSkipsBadLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l := NewLog(path)
+	if err := l.Append(&Entry{Time: time.Now(), Device: "den", Path: "/tstat"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A crash part way through writing an entry.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"time":"2024-01-15T08:00:00Z","dev`)
+	f.Close()
+
+	if err := l.Append(&Entry{Time: time.Now(), Device: "attic", Path: "/tstat"}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := l.Read(Query{})
+	if err != nil || len(entries) != 2 || entries[0].Device != "attic" || entries[1].Device != "den" {
+		t.Errorf("Read = %+v, %v, want attic and den around the broken line", entries, err)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l := NewLog(path)
+	l.MaxSize = 500
+
+	for i := 0; i < 20; i++ {
+		if err := l.Append(&Entry{Time: time.Now(), Device: "den", Path: "/tstat", Action: strings.Repeat("x", 50)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{path, path + ".1"} {
+		if info, err := os.Stat(name); err != nil || info.Size() > l.MaxSize+200 {
+			t.Errorf("%s = %v, %v, want it kept near %d bytes", filepath.Base(name), info, err, l.MaxSize)
+		}
+	}
+	entries, err := l.Read(Query{})
+	if err != nil || len(entries) < 3 || len(entries) >= 20 {
+		t.Errorf("Read = %d entries, %v, want the newest few", len(entries), err)
+	}
+}
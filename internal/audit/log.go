@@ -0,0 +1,208 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/internal/statefile"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+// DefaultPath returns where the audit log is kept, next to the config
+// file.
+func DefaultPath() string {
+	return statefile.Path("audit.log")
+}
+
+// DefaultMaxSize is how large the audit log grows before it is rotated:
+// about five thousand changes.
+const DefaultMaxSize = 2 << 20
+
+// Log is an audit log kept as a file of JSON lines, one per change. The
+// CLI, the web server and the MQTT bridge all append to the same file;
+// each entry is a single write to a file opened for appending, so their
+// lines do not mix.
+//
+// Once the file is over MaxSize it is moved to the same name with ".1"
+// added, replacing the one before, and a new file is started. Reading
+// covers both, so the log keeps between one and two MaxSize of changes.
+type Log struct {
+	path string
+	mu   sync.Mutex
+
+	// MaxSize is the size in bytes the file is rotated at; 0 never
+	// rotates it.
+	MaxSize int64
+}
+
+// NewLog returns an audit log backed by the file at path, rotated at
+// DefaultMaxSize.
+func NewLog(path string) *Log {
+	return &Log{path: path, MaxSize: DefaultMaxSize}
+}
+
+// Append adds e to the log.
+func (l *Log) Append(e *Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+	if err := l.rotate(); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	// A crash part way through a write leaves a line without its end;
+	// finish it off so this entry is not lost along with it.
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if size := info.Size(); size > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, size-1); err == nil && last[0] != '\n' {
+			line = append([]byte{'\n'}, line...)
+		}
+	}
+
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rotate moves the log aside if it has grown past MaxSize.
+func (l *Log) rotate() error {
+	info, err := os.Stat(l.path)
+	if errors.Is(err, os.ErrNotExist) || l.MaxSize <= 0 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Size() < l.MaxSize {
+		return nil
+	}
+	// Another process may have just moved it aside, leaving nothing to
+	// move.
+	err = os.Rename(l.path, l.path+".1")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Query picks entries to read back. Zero fields match everything.
+type Query struct {
+	Device string
+	Since  time.Time
+
+	// Limit is the most entries to return.
+	Limit int
+}
+
+// Read returns the entries matching q, newest first. Lines that cannot be
+// read, such as one cut short by a crash, are logged and skipped.
+func (l *Log) Read(q Query) ([]Entry, error) {
+	var entries []Entry
+	for _, path := range []string{l.path + ".1", l.path} {
+		var err error
+		if entries, err = readFile(path, q, entries); err != nil {
+			return nil, err
+		}
+	}
+
+	newest := make([]Entry, 0, len(entries))
+	for i := len(entries) - 1; i >= 0 && (q.Limit <= 0 || len(newest) < q.Limit); i-- {
+		newest = append(newest, entries[i])
+	}
+	return newest, nil
+}
+
+// readFile appends the entries in the file at path that match q to
+// entries, oldest first. A missing file has none.
+func readFile(path string, q Query, entries []Entry) ([]Entry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for line := 1; ; line++ {
+		data, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(data)) > 0 {
+			var e Entry
+			if jsonErr := json.Unmarshal(data, &e); jsonErr != nil {
+				log.Printf("audit: %s line %d: %v; skipped", path, line, jsonErr)
+			} else if (q.Device == "" || e.Device == q.Device) && !e.Time.Before(q.Since) {
+				entries = append(entries, e)
+			}
+		}
+		if err == io.EOF {
+			return entries, nil
+		}
+	}
+}
+
+// Watch records every change client makes to the thermostat named device,
+// with the state before and after and the origin set on the request's
+// context. A change that cannot be recorded is still made, and the
+// failure is logged.
+func (l *Log) Watch(client *ct50.Client, device string) {
+	client.Audit = func(ctx context.Context, path string, body []byte, send func() error) error {
+		origin := OriginFrom(ctx)
+		e := &Entry{
+			Device: device,
+			Actor:  origin.Actor,
+			IP:     origin.IP,
+			Action: origin.Action,
+			Path:   path,
+			Change: body,
+		}
+		if stats, err := client.Status(ctx); err == nil {
+			e.Before = StateOf(stats)
+		}
+
+		err := send()
+		e.Time = time.Now()
+		if err != nil {
+			e.Error = err.Error()
+		} else if stats, err := client.Status(ctx); err == nil {
+			e.After = StateOf(stats)
+		}
+
+		if err := l.Append(e); err != nil {
+			log.Printf("audit: %s: %v", device, err)
+		}
+		return err
+	}
+}
@@ -0,0 +1,83 @@
+// Package auth checks webserver passwords and API tokens, and keeps track
+// of who is signed in.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"sync"
+
+	"github.com/EntropySynthetica/Thermostat/internal/statefile"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MinPasswordLength is the shortest password HashPassword accepts.
+const MinPasswordLength = 8
+
+// HashPassword returns a bcrypt hash of password for the config file.
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", errors.New("password must be at least 8 characters")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword reports whether password matches hash. An empty hash, for
+// a user that does not exist, is checked against a dummy hash so that a
+// wrong user name takes as long to reject as a wrong password.
+func CheckPassword(hash, password string) bool {
+	if hash == "" {
+		dummyOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+var (
+	dummyOnce sync.Once
+	dummyHash []byte
+)
+
+// randomToken returns 32 random bytes, base64 encoded for a cookie or URL.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is what a session or API token is filed under. Tokens are
+// random, so a fast hash is enough, and the file alone does not let
+// anyone in.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// lockFile takes mu and the lock other processes honour on the state file
+// at path, for a read, change and write of it. The CLI and the web server
+// both change the files, so without it one could undo the other's change.
+// It returns a function that releases both.
+func lockFile(mu *sync.Mutex, path string) (func(), error) {
+	mu.Lock()
+	unlock, err := statefile.Lock(path)
+	if err != nil {
+		mu.Unlock()
+		return nil, err
+	}
+	return func() {
+		unlock()
+		mu.Unlock()
+	}, nil
+}
@@ -0,0 +1,294 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPassword(t *testing.T) {
+	if _, err := HashPassword("short"); err == nil {
+		t.Error("HashPassword accepted a 5 character password")
+	}
+
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(hash, "correct horse") {
+		t.Fatalf("hash %q holds the password", hash)
+	}
+	if !CheckPassword(hash, "correct horse") {
+		t.Error("CheckPassword rejected the right password")
+	}
+	if CheckPassword(hash, "battery staple") {
+		t.Error("CheckPassword accepted the wrong password")
+	}
+	if CheckPassword("", "") {
+		t.Error("CheckPassword accepted an unknown user")
+	}
+}
+
+// checkPrivate fails t unless only its owner can read the file at path.
+func checkPrivate(t *testing.T, path string) {
+	t.Helper()
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("%s = %v, %v, want mode 0600", filepath.Base(path), info, err)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	store := NewSessions(path)
+	now := time.Now()
+
+	token, sess, err := store.Create("alice", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess.User != "alice" || sess.CSRF == "" || sess.CSRF == token {
+		t.Fatalf("session = %+v, want alice with a CSRF token of its own", sess)
+	}
+	checkPrivate(t, path)
+
+	// The file only has a hash of the cookie.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), token) {
+		t.Error("sessions file holds the cookie token")
+	}
+
+	got, err := NewSessions(path).Get(token, now)
+	if err != nil || got == nil || got.User != "alice" || got.CSRF != sess.CSRF {
+		t.Fatalf("Get = %+v, %v, want alice's session", got, err)
+	}
+	if got, _ := store.Get("not a token", now); got != nil {
+		t.Errorf("Get(unknown) = %+v, want nil", got)
+	}
+	if got, _ := store.Get(token, now.Add(SessionLifetime)); got != nil {
+		t.Errorf("Get after SessionLifetime = %+v, want nil", got)
+	}
+
+	other, _, err := store.Create("bob", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.Get(token, now); got != nil {
+		t.Error("alice is still signed in after DeleteUser")
+	}
+	if got, _ := store.Get(other, now); got == nil {
+		t.Fatal("DeleteUser(alice) signed bob out")
+	}
+
+	if err := store.Delete(other); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.Get(other, now); got != nil {
+		t.Error("bob is still signed in after Delete")
+	}
+}
+
+func TestTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	store := NewTokens(path)
+	now := time.Now()
+
+	if _, err := ParseScopes("status:read,everything"); err == nil {
+		t.Error("ParseScopes accepted an unknown scope")
+	}
+	scopes, err := ParseScopes("status:read, setpoint:write")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, token, err := store.Create("cron", scopes, []string{"upstairs"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPrivate(t, path)
+	if !strings.HasPrefix(secret, token.ID+".") {
+		t.Errorf("token %q does not start with its ID %s", secret, token.ID)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), strings.TrimPrefix(secret, token.ID+".")) {
+		t.Error("tokens file holds the secret")
+	}
+
+	for _, bad := range []string{"", "nodot", token.ID + ".wrong", "ffffffff." + strings.TrimPrefix(secret, token.ID+".")} {
+		if got, err := store.Check(bad, now); got != nil || err != nil {
+			t.Errorf("Check(%q) = %v, %v, want nil", bad, got, err)
+		}
+	}
+
+	used := now.Add(time.Hour)
+	checker := NewTokens(path)
+	got, err := checker.Check(secret, used)
+	if err != nil || got == nil || got.Name != "cron" {
+		t.Fatalf("Check = %+v, %v, want the cron token", got, err)
+	}
+	if list, err := store.List(); err != nil || len(list) != 1 || !list[0].LastUsed.IsZero() {
+		t.Fatalf("List before SaveUses = %+v, %v, want the use not written yet", list, err)
+	}
+	if err := checker.SaveUses(); err != nil {
+		t.Fatal(err)
+	}
+	list, err := store.List()
+	if err != nil || len(list) != 1 || !list[0].LastUsed.Equal(used) {
+		t.Fatalf("List = %+v, %v, want one token last used at %v", list, err, used)
+	}
+
+	for _, tt := range []struct {
+		scope  Scope
+		device string
+		want   bool
+	}{
+		{StatusRead, "upstairs", true},
+		{SetpointWrite, "upstairs", true},
+		{ModeWrite, "upstairs", false},
+		{StatusRead, "downstairs", false},
+		{StatusRead, "", false},
+	} {
+		if ok := got.Allows(tt.scope, tt.device); ok != tt.want {
+			t.Errorf("Allows(%s, %q) = %v, want %v", tt.scope, tt.device, ok, tt.want)
+		}
+	}
+	if all := (&Token{Scopes: []Scope{StatusRead}}); !all.Allows(StatusRead, "") {
+		t.Error("a token for every thermostat cannot read them all")
+	}
+
+	if _, err := checker.Check(secret, used.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Revoke(token.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := checker.SaveUses(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.Check(secret, now); got != nil {
+		t.Error("a revoked token still works")
+	}
+	if err := store.Revoke(token.ID); err == nil {
+		t.Error("revoked an unknown token")
+	}
+}
+
+// TestSharedFile changes one file from two stores at once, as the CLI and
+// the web server do, and expects none of the changes to be lost.
+func TestSharedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	stores := []*Tokens{NewTokens(path), NewTokens(path)}
+
+	var wg sync.WaitGroup
+	for _, store := range stores {
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(store *Tokens) {
+				defer wg.Done()
+				if _, _, err := store.Create("cron", []Scope{StatusRead}, nil, time.Now()); err != nil {
+					t.Error(err)
+				}
+			}(store)
+		}
+	}
+	wg.Wait()
+
+	list, err := stores[0].List()
+	if err != nil || len(list) != 20 {
+		t.Errorf("List = %d tokens, %v, want 20", len(list), err)
+	}
+}
+
+func TestGuests(t *testing.T) {
+	dir := t.TempDir()
+	store := NewGuests(filepath.Join(dir, "guests.json"), filepath.Join(dir, "guest-key"))
+	now := time.Now()
+
+	if _, err := store.Create(&Guest{Name: "sitter", Device: "upstairs", MinTemp: 74, MaxTemp: 66, Created: now, Expires: now.Add(time.Hour)}); err == nil {
+		t.Error("Create accepted a minimum above the maximum")
+	}
+
+	g := &Guest{Name: "sitter", Device: "upstairs", MinTemp: 66, MaxTemp: 74, Created: now, Expires: now.Add(DefaultGuestLifetime)}
+	link, err := store.Create(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(link, g.ID+".") {
+		t.Errorf("link %q does not start with its ID %s", link, g.ID)
+	}
+	checkPrivate(t, filepath.Join(dir, "guest-key"))
+	checkPrivate(t, filepath.Join(dir, "guests.json"))
+
+	// The link can be shown again, and works from a fresh store.
+	again, err := NewGuests(filepath.Join(dir, "guests.json"), filepath.Join(dir, "guest-key")).Link(g.ID)
+	if err != nil || again != link {
+		t.Errorf("Link = %q, %v, want %q", again, err, link)
+	}
+	got, err := store.Check(link, now)
+	if err != nil || got == nil || got.Name != "sitter" {
+		t.Fatalf("Check = %+v, %v, want the sitter's link", got, err)
+	}
+	if !got.Allows(66) || !got.Allows(74) || got.Allows(75) || got.Allows(65.5) {
+		t.Error("Allows does not keep to 66-74")
+	}
+
+	for _, bad := range []string{"", "nodot", g.ID + ".wrong", "ffffffff." + strings.TrimPrefix(link, g.ID+".")} {
+		if got, err := store.Check(bad, now); got != nil || err != nil {
+			t.Errorf("Check(%q) = %v, %v, want nil", bad, got, err)
+		}
+	}
+	if got, _ := store.Check(link, g.Expires); got != nil {
+		t.Error("an expired link still works")
+	}
+
+	if err := store.Revoke(g.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.Check(link, now); got != nil {
+		t.Error("a revoked link still works")
+	}
+	if err := store.Revoke(g.ID); err == nil {
+		t.Error("revoked an unknown link")
+	}
+}
+
+// TestGuestKeyRace makes the signing key from several stores at once, as
+// the CLI and the web server might, and expects them all to sign alike.
+func TestGuestKeyRace(t *testing.T) {
+	dir := t.TempDir()
+	links := make([]string, 8)
+	var wg sync.WaitGroup
+	for i := range links {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			links[i], err = NewGuests(filepath.Join(dir, "guests.json"), filepath.Join(dir, "guest-key")).Link("0a1b2c3d")
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, link := range links[1:] {
+		if link != links[0] {
+			t.Fatalf("links = %q, want them all the same", links)
+		}
+	}
+	checkPrivate(t, filepath.Join(dir, "guest-key"))
+	if tmp, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(tmp) > 0 {
+		t.Errorf("temporary files left behind: %q", tmp)
+	}
+}
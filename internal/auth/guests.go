@@ -0,0 +1,278 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/internal/statefile"
+)
+
+// DefaultGuestLifetime is how long a guest link lasts unless asked
+// otherwise.
+const DefaultGuestLifetime = 7 * 24 * time.Hour
+
+// DefaultGuestsPath returns where guest links are kept, next to the config
+// file.
+func DefaultGuestsPath() string {
+	return statefile.Path("guests.json")
+}
+
+// DefaultGuestKeyPath returns where the key guest links are signed with is
+// kept, next to the config file.
+func DefaultGuestKeyPath() string {
+	return statefile.Path("guest-key")
+}
+
+// Guest is a link that gives a house-sitter limited control of one
+// thermostat until it expires.
+type Guest struct {
+	// ID identifies the link to list and revoke it. It is also the start
+	// of the link.
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Device string `json:"device"`
+
+	// MinTemp and MaxTemp bound the setpoints the guest may choose, in
+	// degrees F.
+	MinTemp float64 `json:"minTemp"`
+	MaxTemp float64 `json:"maxTemp"`
+
+	// Mode lets the guest change the mode as well.
+	Mode bool `json:"mode,omitempty"`
+
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+// Validate checks the guest's limits.
+func (g *Guest) Validate() error {
+	if g.Name == "" {
+		return errors.New("a guest link needs a name")
+	}
+	if g.Device == "" {
+		return errors.New("a guest link needs a thermostat")
+	}
+	if g.MinTemp > g.MaxTemp {
+		return errors.New("the lowest setpoint is above the highest")
+	}
+	if !g.Expires.After(g.Created) {
+		return errors.New("a guest link must last some time")
+	}
+	return nil
+}
+
+// Allows reports whether the guest may set temp, in degrees F.
+func (g *Guest) Allows(temp float64) bool {
+	return temp >= g.MinTemp && temp <= g.MaxTemp
+}
+
+// Guests keeps guest links in a JSON file. A link is the guest's ID
+// signed with a key of the server's own, so the file alone does not let
+// anyone in, yet 'thermostat guest list' can show a link again to send it
+// once more. Every call reads the file afresh, so a link revoked with
+// 'thermostat guest revoke' stops working straight away.
+type Guests struct {
+	path    string
+	keyPath string
+	mu      sync.Mutex
+}
+
+// NewGuests returns a guest link store backed by the file at path, with
+// links signed by the key in keyPath. The key is made on first use.
+func NewGuests(path, keyPath string) *Guests {
+	return &Guests{path: path, keyPath: keyPath}
+}
+
+// Create files g under a new ID, dropping expired links along the way. It
+// returns the guest's link.
+func (s *Guests) Create(g *Guest) (string, error) {
+	if err := g.Validate(); err != nil {
+		return "", err
+	}
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	g.ID = hex.EncodeToString(id)
+
+	unlock, err := lockFile(&s.mu, s.path)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return "", err
+	}
+	for id, other := range all {
+		if !g.Created.Before(other.Expires) {
+			delete(all, id)
+		}
+	}
+	if all[g.ID] != nil {
+		return "", fmt.Errorf("guest ID %s is taken; try again", g.ID)
+	}
+
+	link, err := s.sign(g.ID)
+	if err != nil {
+		return "", err
+	}
+	all[g.ID] = g
+	if err := statefile.WritePrivate(s.path, all); err != nil {
+		return "", err
+	}
+	return link, nil
+}
+
+// List returns every guest link, expired or not, oldest first.
+func (s *Guests) List() ([]*Guest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*Guest, 0, len(all))
+	for _, g := range all {
+		list = append(list, g)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return list, nil
+}
+
+// Link returns the link for the guest with the given ID.
+func (s *Guests) Link(id string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sign(id)
+}
+
+// Revoke deletes the guest link with the given ID.
+func (s *Guests) Revoke(id string) error {
+	unlock, err := lockFile(&s.mu, s.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return err
+	}
+	if all[id] == nil {
+		return fmt.Errorf("unknown guest link %q", id)
+	}
+	delete(all, id)
+	return statefile.WritePrivate(s.path, all)
+}
+
+// Check returns the guest that link belongs to, or nil if it is not a
+// link, has been revoked or has expired.
+func (s *Guests) Check(link string, now time.Time) (*Guest, error) {
+	id, _, ok := strings.Cut(link, ".")
+	if !ok {
+		return nil, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	want, err := s.sign(id)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal([]byte(link), []byte(want)) {
+		return nil, nil
+	}
+
+	all, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	g := all[id]
+	if g == nil || !now.Before(g.Expires) {
+		return nil, nil
+	}
+	return g, nil
+}
+
+// sign returns the link for id: the ID and a truncated HMAC of it.
+func (s *Guests) sign(id string) (string, error) {
+	key, err := s.key()
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id))
+	return id + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16]), nil
+}
+
+// key reads the signing key, making it if there is none yet. Only its
+// owner may read it.
+func (s *Guests) key() ([]byte, error) {
+	key, err := os.ReadFile(s.keyPath)
+	if err == nil {
+		if len(key) < 32 {
+			return nil, fmt.Errorf("%s is too short to be a key; delete it to make a new one", s.keyPath)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.keyPath), 0755); err != nil {
+		return nil, err
+	}
+
+	// The CLI and the web server may both get here first. The key is
+	// written in full to a file of its own and then linked into place,
+	// so the loser never reads half a key; it reads the winner's.
+	f, err := os.CreateTemp(filepath.Dir(s.keyPath), filepath.Base(s.keyPath)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(key); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Link(f.Name(), s.keyPath); errors.Is(err, os.ErrExist) {
+		return s.key()
+	} else if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (s *Guests) load() (map[string]*Guest, error) {
+	all := make(map[string]*Guest)
+	if err := statefile.Read(s.path, &all); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/internal/statefile"
+)
+
+// SessionLifetime is how long a sign-in lasts.
+const SessionLifetime = 30 * 24 * time.Hour
+
+// DefaultSessionsPath returns where sign-ins are kept, next to the config
+// file.
+func DefaultSessionsPath() string {
+	return statefile.Path("sessions.json")
+}
+
+// Session is one signed-in browser.
+type Session struct {
+	User string `json:"user"`
+
+	// CSRF is the token every change made in this session must carry, so
+	// another site cannot make the browser send one.
+	CSRF string `json:"csrf"`
+
+	Expires time.Time `json:"expires"`
+}
+
+// Sessions keeps sign-ins in a JSON file, so they survive a restart of the
+// webserver. Sessions are filed under a hash of their cookie, so the file
+// alone does not let anyone in. Every call reads the file afresh, so
+// sessions ended by 'thermostat user' take effect straight away.
+type Sessions struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewSessions returns a session store backed by the file at path.
+func NewSessions(path string) *Sessions {
+	return &Sessions{path: path}
+}
+
+// Create signs user in until SessionLifetime from now. It returns the
+// session and the token for its cookie. Expired sessions are dropped
+// along the way.
+func (s *Sessions) Create(user string, now time.Time) (string, *Session, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", nil, err
+	}
+	csrf, err := randomToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	unlock, err := lockFile(&s.mu, s.path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return "", nil, err
+	}
+	for id, sess := range all {
+		if !now.Before(sess.Expires) {
+			delete(all, id)
+		}
+	}
+
+	sess := &Session{User: user, CSRF: csrf, Expires: now.Add(SessionLifetime)}
+	all[hashToken(token)] = sess
+	if err := statefile.WritePrivate(s.path, all); err != nil {
+		return "", nil, err
+	}
+	return token, sess, nil
+}
+
+// Get returns the session for a cookie token, or nil if there is none or
+// it has expired.
+func (s *Sessions) Get(token string, now time.Time) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	sess := all[hashToken(token)]
+	if sess == nil || !now.Before(sess.Expires) {
+		return nil, nil
+	}
+	return sess, nil
+}
+
+// Delete signs out the session with the cookie token.
+func (s *Sessions) Delete(token string) error {
+	return s.remove(func(id string, _ *Session) bool { return id == hashToken(token) })
+}
+
+// DeleteUser signs user out everywhere.
+func (s *Sessions) DeleteUser(user string) error {
+	return s.remove(func(_ string, sess *Session) bool { return sess.User == user })
+}
+
+func (s *Sessions) remove(match func(id string, sess *Session) bool) error {
+	unlock, err := lockFile(&s.mu, s.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return err
+	}
+	n := len(all)
+	for id, sess := range all {
+		if match(id, sess) {
+			delete(all, id)
+		}
+	}
+	if len(all) == n {
+		return nil
+	}
+	return statefile.WritePrivate(s.path, all)
+}
+
+func (s *Sessions) load() (map[string]*Session, error) {
+	all := make(map[string]*Session)
+	if err := statefile.Read(s.path, &all); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
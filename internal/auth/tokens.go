@@ -0,0 +1,285 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/internal/statefile"
+)
+
+// Scope is something an API token may do.
+type Scope string
+
+const (
+	// StatusRead allows every GET: status, events, history, schedules
+	// and metrics.
+	StatusRead Scope = "status:read"
+
+	// SetpointWrite allows changing the setpoints.
+	SetpointWrite Scope = "setpoint:write"
+
+	// ModeWrite allows changing the mode.
+	ModeWrite Scope = "mode:write"
+
+	// FanWrite allows changing the fan mode.
+	FanWrite Scope = "fan:write"
+
+	// ScheduleWrite allows changing the thermostat's programs and the
+	// server-side schedule.
+	ScheduleWrite Scope = "schedule:write"
+
+	// AuditRead allows reading the audit log, which names everyone who
+	// uses the thermostats and where from. Viewers cannot see it either.
+	AuditRead Scope = "audit:read"
+)
+
+// Scopes lists every scope.
+var Scopes = []Scope{StatusRead, SetpointWrite, ModeWrite, FanWrite, ScheduleWrite, AuditRead}
+
+// ParseScopes converts a comma-separated list of scope names.
+func ParseScopes(s string) ([]Scope, error) {
+	var scopes []Scope
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, scope := range Scopes {
+			if Scope(name) == scope {
+				scopes = append(scopes, scope)
+				found = true
+				break
+			}
+		}
+		if !found {
+			var names []string
+			for _, scope := range Scopes {
+				names = append(names, string(scope))
+			}
+			return nil, fmt.Errorf("unknown scope %q (use %s)", name, strings.Join(names, ", "))
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, errors.New("a token needs at least one scope")
+	}
+	return scopes, nil
+}
+
+// DefaultTokensPath returns where API tokens are kept, next to the config
+// file.
+func DefaultTokensPath() string {
+	return statefile.Path("tokens.json")
+}
+
+// Token is an API token for scripts and integrations. Only a hash of its
+// secret is kept; the token itself is shown once, when it is created.
+type Token struct {
+	// ID identifies the token to list and revoke it. It is also the
+	// start of the token.
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Hash string `json:"hash"`
+
+	Scopes []Scope `json:"scopes"`
+
+	// Devices limits the token to these thermostats. Empty means all.
+	Devices []string `json:"devices,omitempty"`
+
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"lastUsed"`
+}
+
+// Allows reports whether the token has scope on the named thermostat. An
+// empty device name means every thermostat, which only a token without a
+// device limit has.
+func (t *Token) Allows(scope Scope, device string) bool {
+	hasScope := false
+	for _, s := range t.Scopes {
+		if s == scope {
+			hasScope = true
+			break
+		}
+	}
+	if !hasScope {
+		return false
+	}
+
+	if len(t.Devices) == 0 {
+		return true
+	}
+	for _, d := range t.Devices {
+		if device != "" && d == device {
+			return true
+		}
+	}
+	return false
+}
+
+// Tokens keeps API tokens in a JSON file. Every call reads the file
+// afresh, so a token revoked with 'thermostat token revoke' stops working
+// straight away.
+type Tokens struct {
+	path string
+	mu   sync.Mutex
+
+	// used holds the uses Check has seen that SaveUses has not yet
+	// written, by token ID.
+	used map[string]time.Time
+}
+
+// NewTokens returns a token store backed by the file at path.
+func NewTokens(path string) *Tokens {
+	return &Tokens{path: path, used: make(map[string]time.Time)}
+}
+
+// Create makes a token with the given scopes, limited to devices if any
+// are given. It returns the token to hand out, which cannot be recovered
+// later.
+func (s *Tokens) Create(name string, scopes []Scope, devices []string, now time.Time) (string, *Token, error) {
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, err
+	}
+	secret, err := randomToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	unlock, err := lockFile(&s.mu, s.path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return "", nil, err
+	}
+
+	t := &Token{
+		ID:      hex.EncodeToString(id),
+		Name:    name,
+		Hash:    hashToken(secret),
+		Scopes:  scopes,
+		Devices: devices,
+		Created: now,
+	}
+	if all[t.ID] != nil {
+		return "", nil, fmt.Errorf("token ID %s is taken; try again", t.ID)
+	}
+	all[t.ID] = t
+	if err := statefile.WritePrivate(s.path, all); err != nil {
+		return "", nil, err
+	}
+	return t.ID + "." + secret, t, nil
+}
+
+// List returns every token, oldest first, with the uses this store has
+// seen but not yet saved.
+func (s *Tokens) List() ([]*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*Token, 0, len(all))
+	for id, t := range all {
+		if used := s.used[id]; used.After(t.LastUsed) {
+			t.LastUsed = used
+		}
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return list, nil
+}
+
+// Revoke deletes the token with the given ID.
+func (s *Tokens) Revoke(id string) error {
+	unlock, err := lockFile(&s.mu, s.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return err
+	}
+	if all[id] == nil {
+		return fmt.Errorf("unknown token %q", id)
+	}
+	delete(all, id)
+	return statefile.WritePrivate(s.path, all)
+}
+
+// Check returns the token that token is, or nil if it is not one, and
+// notes that it was used at now, for SaveUses to write. Checking a token
+// only reads the file.
+func (s *Tokens) Check(token string, now time.Time) (*Token, error) {
+	id, secret, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	t := all[id]
+	if t == nil || subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(t.Hash)) != 1 {
+		return nil, nil
+	}
+
+	t.LastUsed = now
+	s.used[id] = now
+	return t, nil
+}
+
+// SaveUses writes the uses Check has noted to the file. Tokens revoked
+// since are not brought back.
+func (s *Tokens) SaveUses() error {
+	unlock, err := lockFile(&s.mu, s.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if len(s.used) == 0 {
+		return nil
+	}
+	all, err := s.load()
+	if err != nil {
+		return err
+	}
+	for id, used := range s.used {
+		if t := all[id]; t != nil && used.After(t.LastUsed) {
+			t.LastUsed = used
+		}
+	}
+	if err := statefile.WritePrivate(s.path, all); err != nil {
+		return err
+	}
+	s.used = make(map[string]time.Time)
+	return nil
+}
+
+func (s *Tokens) load() (map[string]*Token, error) {
+	all := make(map[string]*Token)
+	if err := statefile.Read(s.path, &all); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
@@ -0,0 +1,116 @@
+// Package boost holds a thermostat at a temporary setpoint for a while and
+// then puts back what it was doing before. The CT50 itself only has a
+// permanent hold and an override that ends at the next program period.
+//
+// Running boosts are kept in a small JSON file shared by the thermostat CLI
+// and the webserver, so either can start one and the webserver ends it on
+// time, even across restarts.
+package boost
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+// MaxDuration is the longest a boost may last.
+const MaxDuration = 24 * time.Hour
+
+// ErrNoMode is returned by Start when the thermostat is off or in auto and
+// no heat or cool mode was asked for, so there is no single setpoint to
+// boost.
+var ErrNoMode = errors.New("boost: thermostat must be in heat or cool mode, or a mode must be given")
+
+// Boost is a temporary setpoint on one thermostat.
+type Boost struct {
+	// Device is the name of the thermostat in the config file.
+	Device string `json:"device"`
+
+	// Mode is heat or cool, and Temp the setpoint held in it, in degrees F.
+	Mode ct50.Mode `json:"mode"`
+	Temp float64   `json:"temp"`
+
+	// Until is when the boost ends.
+	Until time.Time `json:"until"`
+
+	// Prior is the state to put back when the boost ends.
+	Prior ct50.Snapshot `json:"prior"`
+}
+
+// Expired reports whether the boost has run its course at now.
+func (b *Boost) Expired(now time.Time) bool {
+	return !now.Before(b.Until)
+}
+
+// Remaining returns how long the boost has left at now, or zero.
+func (b *Boost) Remaining(now time.Time) time.Duration {
+	if b.Expired(now) {
+		return 0
+	}
+	return b.Until.Sub(now)
+}
+
+// Start holds the thermostat at temp degrees F in mode until until. A mode
+// of ct50.ModeOff keeps the thermostat's current mode, which must then be
+// heat or cool.
+//
+// If running is non-nil it is a boost on the same thermostat that has not
+// ended yet. The new boost replaces it but keeps its snapshot, so ending
+// the new one still puts back the state from before the first.
+func Start(ctx context.Context, client *ct50.Client, device string, mode ct50.Mode, temp float64, until time.Time, running *Boost) (*Boost, error) {
+	stats, err := client.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode == ct50.ModeOff {
+		mode = stats.Tmode
+	}
+	if mode != ct50.ModeHeat && mode != ct50.ModeCool {
+		return nil, ErrNoMode
+	}
+
+	b := &Boost{
+		Device: device,
+		Mode:   mode,
+		Temp:   temp,
+		Until:  until,
+		Prior:  stats.Snapshot(),
+	}
+	if running != nil {
+		b.Prior = running.Prior
+	}
+
+	hold := ct50.On
+	u := ct50.Update{Tmode: &mode, Hold: &hold}
+	if mode == ct50.ModeHeat {
+		u.THeat = &temp
+	} else {
+		u.TCool = &temp
+	}
+	if _, err := client.UpdateAndVerify(ctx, u); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// End puts back the state from before the boost. If someone has changed
+// the mode or setpoint since the boost started, say at the wall, their
+// change is left alone and restored is false.
+func (b *Boost) End(ctx context.Context, client *ct50.Client) (restored bool, err error) {
+	stats, err := client.Status(ctx)
+	if err != nil {
+		return false, err
+	}
+	if stats.Tmode != b.Mode || stats.Target() != b.Temp {
+		return false, nil
+	}
+
+	if err := client.Restore(ctx, b.Prior); err != nil {
+		return false, fmt.Errorf("boost: restoring %s: %w", b.Device, err)
+	}
+	return true, nil
+}
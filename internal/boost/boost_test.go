@@ -0,0 +1,125 @@
+package boost
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50sim"
+)
+
+func newSim(t *testing.T) (*ct50sim.Device, *ct50.Client) {
+	t.Helper()
+
+	dev := ct50sim.New(ct50sim.Options{})
+	srv := httptest.NewServer(dev)
+	t.Cleanup(srv.Close)
+	return dev, ct50.New(srv.URL)
+}
+
+func TestBoostRestoresHold(t *testing.T) {
+	dev, client := newSim(t)
+	ctx := context.Background()
+
+	if err := client.SetRange(ctx, 66, 78); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetHold(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := Start(ctx, client, "den", ct50.ModeHeat, 72, time.Now().Add(time.Hour), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Boosting again while the first is running keeps the original state.
+	b, err = Start(ctx, client, "den", ct50.ModeOff, 74, time.Now().Add(time.Hour), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := dev.Status(); stats.Tmode != ct50.ModeHeat || stats.THeat != 74 {
+		t.Fatalf("during boost = %v %v, want Heat 74", stats.Tmode, stats.THeat)
+	}
+
+	restored, err := b.End(ctx, client)
+	if err != nil || !restored {
+		t.Fatalf("End = %v, %v", restored, err)
+	}
+	if stats := dev.Status(); stats.Tmode != ct50.ModeAuto || stats.THeat != 66 || stats.TCool != 78 || stats.Hold != ct50.On {
+		t.Errorf("after boost = %v %v-%v hold %v, want Auto 66-78 hold on", stats.Tmode, stats.THeat, stats.TCool, stats.Hold)
+	}
+}
+
+func TestBoostNeedsMode(t *testing.T) {
+	_, client := newSim(t)
+	ctx := context.Background()
+
+	if err := client.SetMode(ctx, ct50.ModeOff); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Start(ctx, client, "den", ct50.ModeOff, 72, time.Now().Add(time.Hour), nil); err != ErrNoMode {
+		t.Errorf("Start while off = %v, want ErrNoMode", err)
+	}
+}
+
+func TestEndLeavesWallChanges(t *testing.T) {
+	dev, client := newSim(t)
+	ctx := context.Background()
+
+	b, err := Start(ctx, client, "den", ct50.ModeOff, 74, time.Now().Add(time.Hour), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Someone turns it down at the wall during the boost.
+	if err := client.SetHeat(ctx, 64); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := b.End(ctx, client)
+	if err != nil || restored {
+		t.Fatalf("End = %v, %v; want nothing restored", restored, err)
+	}
+	if stats := dev.Status(); stats.THeat != 64 {
+		t.Errorf("t_heat = %v, want the wall setting 64", stats.THeat)
+	}
+}
+
+func TestStore(t *testing.T) {
+	_, client := newSim(t)
+	store := NewStore(filepath.Join(t.TempDir(), "state", "boosts.json"))
+
+	if b, err := store.Get("den"); err != nil || b != nil {
+		t.Fatalf("Get on an empty store = %v, %v", b, err)
+	}
+
+	until := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	b := &Boost{Device: "den", Mode: ct50.ModeHeat, Temp: 74, Until: until, Prior: ct50.Snapshot{Tmode: ct50.ModeHeat, THeat: 68}}
+	if err := store.Put(b); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := NewStore(store.Path()).Get("den")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || *got != *b {
+		t.Fatalf("Get = %+v, want %+v", got, b)
+	}
+	if !got.Expired(until) || got.Remaining(until.Add(-time.Minute)) != time.Minute {
+		t.Errorf("Expired/Remaining wrong around %v", until)
+	}
+
+	// The emulator was never boosted, so Finish leaves it alone but still
+	// forgets the boost.
+	if restored, err := store.Finish(context.Background(), client, got); err != nil || restored {
+		t.Fatalf("Finish = %v, %v; want nothing restored", restored, err)
+	}
+	if all, _ := store.All(); len(all) != 0 {
+		t.Errorf("store still has %v after Finish", all)
+	}
+}
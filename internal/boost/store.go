@@ -0,0 +1,98 @@
+package boost
+
+import (
+	"context"
+	"sync"
+
+	"github.com/EntropySynthetica/Thermostat/internal/statefile"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+// DefaultPath returns where running boosts are kept, next to the config
+// file.
+func DefaultPath() string {
+	return statefile.Path("boosts.json")
+}
+
+// Store keeps running boosts in a JSON file, one per thermostat. Every
+// call reads the file afresh, so boosts started by another process are
+// seen straight away.
+type Store struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewStore returns a store backed by the file at path. The file is created
+// when the first boost is saved.
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// Path returns the file the store is backed by.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// All returns every running boost by thermostat name, including ones that
+// have expired but not yet been ended.
+func (s *Store) All() (map[string]*Boost, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+// Get returns the boost on the named thermostat, or nil if there is none.
+func (s *Store) Get(device string) (*Boost, error) {
+	all, err := s.All()
+	if err != nil {
+		return nil, err
+	}
+	return all[device], nil
+}
+
+// Put saves b, replacing any earlier boost on the same thermostat.
+func (s *Store) Put(b *Boost) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return err
+	}
+	all[b.Device] = b
+	return statefile.Write(s.path, all)
+}
+
+// Delete forgets the boost on the named thermostat.
+func (s *Store) Delete(device string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := all[device]; !ok {
+		return nil
+	}
+	delete(all, device)
+	return statefile.Write(s.path, all)
+}
+
+func (s *Store) load() (map[string]*Boost, error) {
+	all := make(map[string]*Boost)
+	if err := statefile.Read(s.path, &all); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+// Finish ends b and removes it from the store. If the thermostat cannot be
+// reached b stays in the store, so ending it can be tried again later.
+func (s *Store) Finish(ctx context.Context, client *ct50.Client, b *Boost) (restored bool, err error) {
+	restored, err = b.End(ctx, client)
+	if err != nil {
+		return false, err
+	}
+	return restored, s.Delete(b.Device)
+}
@@ -0,0 +1,271 @@
+// Package config loads and saves the config file shared by the thermostat
+// CLI and the webserver, ~/.config/thermostat/config.json by default.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+// DefaultDeviceName is the name given to a thermostat configured with the
+// single ThermostatIP field from older config files.
+const DefaultDeviceName = "thermostat"
+
+// Config represents the application configuration
+type Config struct {
+	// ThermostatIP is the single-thermostat setting from older config
+	// files. It is still read, and treated as a device named
+	// DefaultDeviceName when Devices is empty.
+	ThermostatIP string `json:"ThermostatIP,omitempty"`
+
+	// Devices lists every thermostat by name. The first one is the default
+	// when no device is selected.
+	Devices []Device `json:"Devices,omitempty"`
+
+	// Unit is the temperature scale to show and enter temperatures in:
+	// "F" (the default) or "C".
+	Unit string `json:"Unit,omitempty"`
+
+	// Deadband is the smallest gap allowed between the heat and cool
+	// setpoints in auto mode, in degrees of Unit. Zero means
+	// ct50.DefaultDeadband.
+	Deadband float64 `json:"Deadband,omitempty"`
+
+	// Users are the accounts allowed to sign in to the webserver. With
+	// none, the webserver lets anyone on the network use it.
+	Users []User `json:"Users,omitempty"`
+}
+
+// Device is one named thermostat.
+type Device struct {
+	Name string `json:"Name"`
+	IP   string `json:"IP"`
+}
+
+// User is a webserver account, as written by 'thermostat user add'.
+type User struct {
+	Name string `json:"Name"`
+
+	// PasswordHash is a bcrypt hash of the password. The password itself
+	// is never stored.
+	PasswordHash string `json:"PasswordHash"`
+
+	// Role is what the account may do: "viewer" or "controller".
+	Role Role `json:"Role"`
+}
+
+// Role is what a webserver account may do.
+type Role string
+
+const (
+	// Viewer may read the thermostats but not change them.
+	Viewer Role = "viewer"
+
+	// Controller may also change the mode, setpoints and schedules.
+	Controller Role = "controller"
+)
+
+// ParseRole converts a role name to a Role.
+func ParseRole(s string) (Role, error) {
+	switch Role(s) {
+	case Viewer:
+		return Viewer, nil
+	case Controller:
+		return Controller, nil
+	}
+	return "", fmt.Errorf("unknown role %q (use viewer or controller)", s)
+}
+
+// CanWrite reports whether the role may change thermostats.
+func (r Role) CanWrite() bool {
+	return r == Controller
+}
+
+// Dir returns the directory holding the config file and the webserver's
+// state, ~/.config/thermostat.
+func Dir() string {
+	homedir, _ := os.UserHomeDir()
+	return filepath.Join(homedir, ".config", "thermostat")
+}
+
+// DefaultPath returns the default config file location.
+func DefaultPath() string {
+	return filepath.Join(Dir(), "config.json")
+}
+
+// Load reads and validates the config file at path.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("error in %s: %w", path, err)
+	}
+
+	return &config, nil
+}
+
+// Save writes the config to path, creating its directory if needed. Only
+// its owner can read it, since it holds the users' password hashes. It is
+// written through a temporary file and a rename, so the webserver, which
+// reloads it when it changes, never reads it half written.
+func (c *Config) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(c, "", " ")
+	if err != nil {
+		return err
+	}
+
+	// The temporary file is made owner-only, so the file that replaces
+	// one from an older version, which may be readable by everyone, is too.
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Validate checks that device names are present, unique and usable in a URL
+// path, that the unit is known, that the deadband is not negative and that
+// every user has a unique name, a password and a known role.
+func (c *Config) Validate() error {
+	seen := make(map[string]bool)
+	for i, dev := range c.Devices {
+		if dev.Name == "" {
+			return fmt.Errorf("device %d has no Name", i+1)
+		}
+		if strings.ContainsAny(dev.Name, "/?#") {
+			return fmt.Errorf("device name %q may not contain '/', '?' or '#'", dev.Name)
+		}
+		if seen[dev.Name] {
+			return fmt.Errorf("device name %q is used more than once", dev.Name)
+		}
+		if dev.IP == "" {
+			return fmt.Errorf("device %q has no IP", dev.Name)
+		}
+		seen[dev.Name] = true
+	}
+
+	if c.Unit != "" {
+		if _, err := ct50.ParseUnit(c.Unit); err != nil {
+			return err
+		}
+	}
+
+	if c.Deadband < 0 {
+		return errors.New("Deadband cannot be negative")
+	}
+
+	users := make(map[string]bool)
+	for i, u := range c.Users {
+		if u.Name == "" {
+			return fmt.Errorf("user %d has no Name", i+1)
+		}
+		if users[u.Name] {
+			return fmt.Errorf("user name %q is used more than once", u.Name)
+		}
+		if u.PasswordHash == "" {
+			return fmt.Errorf("user %q has no PasswordHash", u.Name)
+		}
+		if _, err := ParseRole(string(u.Role)); err != nil {
+			return fmt.Errorf("user %q: %w", u.Name, err)
+		}
+		users[u.Name] = true
+	}
+	return nil
+}
+
+// TempUnit returns the configured temperature unit, Fahrenheit by default.
+func (c *Config) TempUnit() ct50.Unit {
+	unit, err := ct50.ParseUnit(c.Unit)
+	if err != nil {
+		return ct50.Fahrenheit
+	}
+	return unit
+}
+
+// MinDeadband returns the configured deadband in degrees F, as ct50.Client
+// expects it, or ct50.DefaultDeadband if none is set.
+func (c *Config) MinDeadband() float64 {
+	if c.Deadband == 0 {
+		return ct50.DefaultDeadband
+	}
+	return c.TempUnit().DeltaToDevice(c.Deadband)
+}
+
+// DeviceList returns every configured thermostat, including one built from
+// the legacy ThermostatIP field.
+func (c *Config) DeviceList() []Device {
+	if len(c.Devices) > 0 {
+		return c.Devices
+	}
+	if c.ThermostatIP != "" {
+		return []Device{{Name: DefaultDeviceName, IP: c.ThermostatIP}}
+	}
+	return nil
+}
+
+// Device looks up a thermostat by name. An empty name selects the first
+// configured device.
+func (c *Config) Device(name string) (Device, error) {
+	list := c.DeviceList()
+	if len(list) == 0 {
+		return Device{}, errors.New("no thermostats configured")
+	}
+
+	if name == "" {
+		return list[0], nil
+	}
+
+	var names []string
+	for _, dev := range list {
+		if dev.Name == name {
+			return dev, nil
+		}
+		names = append(names, dev.Name)
+	}
+
+	return Device{}, fmt.Errorf("unknown thermostat %q (configured: %s)", name, strings.Join(names, ", "))
+}
+
+// User looks up a webserver account by name.
+func (c *Config) User(name string) (User, bool) {
+	for _, u := range c.Users {
+		if u.Name == name {
+			return u, true
+		}
+	}
+	return User{}, false
+}
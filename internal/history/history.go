@@ -0,0 +1,128 @@
+// Package history records thermostat status samples in an embedded bbolt
+// database and reads them back by time range, averaged into buckets so a
+// month of minute-by-minute samples fits in a chart.
+package history
+
+import (
+	"math"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+// Sample is one reading of a thermostat's status. Temperatures are in
+// degrees F, as the thermostat reports them.
+type Sample struct {
+	Time   time.Time  `json:"time"`
+	Temp   float64    `json:"temp"`
+	THeat  float64    `json:"t_heat,omitempty"`
+	TCool  float64    `json:"t_cool,omitempty"`
+	Tmode  ct50.Mode  `json:"tmode"`
+	Tstate ct50.State `json:"tstate"`
+	Fstate ct50.OnOff `json:"fstate"`
+	Hold   ct50.OnOff `json:"hold"`
+}
+
+// NewSample records stats as read at t.
+func NewSample(stats *ct50.Status, t time.Time) Sample {
+	return Sample{
+		Time:   t,
+		Temp:   stats.Temp,
+		THeat:  stats.THeat,
+		TCool:  stats.TCool,
+		Tmode:  stats.Tmode,
+		Tstate: stats.Tstate,
+		Fstate: stats.Fstate,
+		Hold:   stats.Hold,
+	}
+}
+
+// Point sums up the samples in one bucket of a downsampled series.
+type Point struct {
+	// Time is the start of the bucket.
+	Time time.Time `json:"time"`
+
+	// Temp is the mean temperature over the bucket, and MinTemp and
+	// MaxTemp the extremes.
+	Temp    float64 `json:"temp"`
+	MinTemp float64 `json:"minTemp"`
+	MaxTemp float64 `json:"maxTemp"`
+
+	// THeat, TCool, Tmode and Hold are as of the last sample in the
+	// bucket, so setpoint changes show as steps.
+	THeat float64    `json:"t_heat,omitempty"`
+	TCool float64    `json:"t_cool,omitempty"`
+	Tmode ct50.Mode  `json:"tmode"`
+	Hold  ct50.OnOff `json:"hold"`
+
+	// Heating, Cooling and Fan are the share of samples, from 0 to 1, in
+	// which the thermostat was heating or cooling, or the fan was running.
+	Heating float64 `json:"heating"`
+	Cooling float64 `json:"cooling"`
+	Fan     float64 `json:"fan"`
+
+	// Samples is how many samples went into the bucket.
+	Samples int `json:"samples"`
+}
+
+// Downsample sums up samples, which must be in time order, into buckets
+// step long starting at from. Buckets with no samples are left out, so
+// gaps in recording show as gaps. A step of zero or less gives one point
+// per sample.
+func Downsample(samples []Sample, from time.Time, step time.Duration) []Point {
+	points := []Point{}
+	var (
+		p                      *Point
+		sum                    float64
+		heating, cooling, fans int
+	)
+	finish := func() {
+		if p == nil {
+			return
+		}
+		n := float64(p.Samples)
+		p.Temp = round(sum / n)
+		p.Heating, p.Cooling, p.Fan = round(float64(heating)/n), round(float64(cooling)/n), round(float64(fans)/n)
+	}
+
+	for _, s := range samples {
+		start := s.Time
+		if step > 0 {
+			start = from.Add(s.Time.Sub(from) / step * step)
+		}
+		if p == nil || !start.Equal(p.Time) {
+			finish()
+			points = append(points, Point{Time: start, MinTemp: s.Temp, MaxTemp: s.Temp})
+			p = &points[len(points)-1]
+			sum, heating, cooling, fans = 0, 0, 0, 0
+		}
+
+		p.Samples++
+		sum += s.Temp
+		if s.Temp < p.MinTemp {
+			p.MinTemp = s.Temp
+		}
+		if s.Temp > p.MaxTemp {
+			p.MaxTemp = s.Temp
+		}
+		p.THeat, p.TCool, p.Tmode, p.Hold = s.THeat, s.TCool, s.Tmode, s.Hold
+
+		switch s.Tstate {
+		case ct50.StateHeating:
+			heating++
+		case ct50.StateCooling:
+			cooling++
+		}
+		if s.Fstate == ct50.On {
+			fans++
+		}
+	}
+	finish()
+	return points
+}
+
+// round keeps two decimal places, which is plenty for a chart and keeps
+// the JSON short.
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
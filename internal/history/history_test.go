@@ -0,0 +1,109 @@
+package history
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+var start = time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC)
+
+// minutes returns one sample a minute from start, heating for the first
+// half of them with the temperature rising a tenth of a degree each time.
+func minutes(n int) []Sample {
+	samples := make([]Sample, n)
+	for i := range samples {
+		samples[i] = Sample{
+			Time:  start.Add(time.Duration(i) * time.Minute),
+			Temp:  68 + float64(i)/10,
+			THeat: 70,
+			Tmode: ct50.ModeHeat,
+		}
+		if i < n/2 {
+			samples[i].Tstate = ct50.StateHeating
+			samples[i].Fstate = ct50.On
+		}
+	}
+	return samples
+}
+
+func TestDownsample(t *testing.T) {
+	samples := minutes(20)
+	samples[19].THeat = 72
+
+	points := Downsample(samples, start, 10*time.Minute)
+	if len(points) != 2 {
+		t.Fatalf("got %d points, want 2: %+v", len(points), points)
+	}
+
+	first, second := points[0], points[1]
+	if !first.Time.Equal(start) || first.Samples != 10 || first.Temp != 68.45 || first.MinTemp != 68 || first.MaxTemp != 68.9 {
+		t.Errorf("first point = %+v, want 10 samples averaging 68.45", first)
+	}
+	if first.Heating != 1 || first.Fan != 1 || second.Heating != 0 || second.Cooling != 0 {
+		t.Errorf("heating = %v then %v, want 1 then 0", first.Heating, second.Heating)
+	}
+	if !second.Time.Equal(start.Add(10*time.Minute)) || second.THeat != 72 {
+		t.Errorf("second point = %+v, want the setpoint from its last sample", second)
+	}
+
+	// A gap in recording leaves a gap in the points.
+	gappy := append(minutes(5), Sample{Time: start.Add(time.Hour), Temp: 70})
+	if points := Downsample(gappy, start, 10*time.Minute); len(points) != 2 || !points[1].Time.Equal(start.Add(time.Hour)) {
+		t.Errorf("with a gap got %+v", points)
+	}
+
+	if points := Downsample(samples, start, 0); len(points) != len(samples) {
+		t.Errorf("step 0 gave %d points, want %d", len(points), len(samples))
+	}
+}
+
+func TestStore(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, s := range minutes(60) {
+		if err := store.Add("den", s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Add("attic", Sample{Time: start, Temp: 80}); err != nil {
+		t.Fatal(err)
+	}
+
+	samples, err := store.Samples("den", start.Add(10*time.Minute), start.Add(20*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 10 || !samples[0].Time.Equal(start.Add(10*time.Minute)) || samples[0].THeat != 70 {
+		t.Errorf("samples = %+v, want the ten from 07:10", samples)
+	}
+
+	points, err := store.Query("den", start, start.Add(time.Hour), 15*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 4 || points[0].Samples != 15 {
+		t.Errorf("points = %+v, want 4 of 15 samples", points)
+	}
+
+	if samples, err := store.Samples("garage", start, start.Add(time.Hour)); err != nil || len(samples) != 0 {
+		t.Errorf("unknown device = %v, %v, want no samples", samples, err)
+	}
+
+	removed, err := store.Prune(start.Add(30 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 31 {
+		t.Errorf("pruned %d samples, want 31", removed)
+	}
+	if samples, _ := store.Samples("den", start, start.Add(time.Hour)); len(samples) != 30 {
+		t.Errorf("%d samples left after pruning, want 30", len(samples))
+	}
+}
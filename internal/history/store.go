@@ -0,0 +1,121 @@
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/EntropySynthetica/Thermostat/internal/statefile"
+)
+
+// DefaultPath returns where history is kept, next to the config file.
+func DefaultPath() string {
+	return statefile.Path("history.db")
+}
+
+// Store keeps samples in a bbolt database, in one bucket per thermostat
+// keyed by sample time, so a time range is a single cursor walk.
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens the database at path, creating it if needed. Only one process
+// can have it open at a time; Open gives up after a second rather than
+// wait for another.
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("history: opening %s: %w", path, err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// key orders samples by time. Times before 1970 do not come up.
+func key(t time.Time) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(t.UnixNano()))
+	return k
+}
+
+// Add records a sample for the named thermostat. A sample at the same time
+// as an earlier one replaces it.
+func (s *Store) Add(device string, sample Sample) error {
+	value, err := json.Marshal(sample)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(device))
+		if err != nil {
+			return err
+		}
+		return b.Put(key(sample.Time), value)
+	})
+}
+
+// Samples returns the named thermostat's samples from from up to but not
+// including to, in time order.
+func (s *Store) Samples(device string, from, to time.Time) ([]Sample, error) {
+	samples := []Sample{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(device))
+		if b == nil {
+			return nil
+		}
+
+		end := key(to)
+		c := b.Cursor()
+		for k, v := c.Seek(key(from)); k != nil && string(k) < string(end); k, v = c.Next() {
+			var sample Sample
+			if err := json.Unmarshal(v, &sample); err != nil {
+				return fmt.Errorf("history: %s sample at %x: %w", device, k, err)
+			}
+			samples = append(samples, sample)
+		}
+		return nil
+	})
+	return samples, err
+}
+
+// Query returns the named thermostat's samples from from up to to, summed
+// up into buckets step long. See Downsample.
+func (s *Store) Query(device string, from, to time.Time, step time.Duration) ([]Point, error) {
+	samples, err := s.Samples(device, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return Downsample(samples, from, step), nil
+}
+
+// Prune removes every sample older than before, from every thermostat, and
+// returns how many it removed.
+func (s *Store) Prune(before time.Time) (int, error) {
+	removed := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		end := key(before)
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			c := b.Cursor()
+			for k, _ := c.First(); k != nil && string(k) < string(end); k, _ = c.Next() {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+				removed++
+			}
+			return nil
+		})
+	})
+	return removed, err
+}
@@ -0,0 +1,251 @@
+// Package metrics keeps counters and histograms and writes them, along
+// with gauges read at scrape time, in the Prometheus text exposition
+// format. It covers only what the webserver's /metrics endpoint needs, so
+// the project does not depend on the Prometheus client library.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the media type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// series is one set of label values within a metric.
+type series struct {
+	labels []string
+
+	value float64
+
+	// counts, sum and count are for histograms. counts[i] is the number
+	// of observations in bucket i alone; Write adds them up.
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// vec holds the series of one metric, keyed by their label values.
+type vec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func newVec(name, help string, labels []string) vec {
+	return vec{name: name, help: help, labels: labels, series: make(map[string]*series)}
+}
+
+// get returns the series for values, creating it if need be. Callers must
+// hold v.mu.
+func (v *vec) get(values []string) *series {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s := v.series[key]
+	if s == nil {
+		s = &series{labels: append([]string(nil), values...)}
+		v.series[key] = s
+	}
+	return s
+}
+
+// Reset drops every series.
+func (v *vec) Reset() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.series = make(map[string]*series)
+}
+
+// sorted returns the series in label order, so output is stable. Callers
+// must hold v.mu.
+func (v *vec) sorted() []*series {
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	out := make([]*series, len(keys))
+	for i, k := range keys {
+		out[i] = v.series[k]
+	}
+	return out
+}
+
+// CounterVec is a counter with labels.
+type CounterVec struct {
+	vec
+}
+
+// NewCounterVec returns a counter named name with the given label names.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{newVec(name, help, labels)}
+}
+
+// Inc adds one to the series with the given label values.
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds n to the series with the given label values.
+func (c *CounterVec) Add(n float64, values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.get(values).value += n
+}
+
+// Write writes the counter in the text format.
+func (c *CounterVec) Write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	header(w, c.name, c.help, "counter")
+	for _, s := range c.sorted() {
+		sample(w, c.name, c.labels, s.labels, "", "", s.value)
+	}
+}
+
+// DefaultBuckets suit requests to a slow device on the local network, in
+// seconds.
+var DefaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 15}
+
+// HistogramVec is a histogram with labels.
+type HistogramVec struct {
+	vec
+	buckets []float64
+}
+
+// NewHistogramVec returns a histogram named name with the given bucket
+// upper bounds, in increasing order, and label names.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{newVec(name, help, labels), buckets}
+}
+
+// Observe records v in the series with the given label values.
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.get(values)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(h.buckets))
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+}
+
+// Write writes the histogram in the text format.
+func (h *HistogramVec) Write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	header(w, h.name, h.help, "histogram")
+	for _, s := range h.sorted() {
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			sample(w, h.name+"_bucket", h.labels, s.labels, "le", formatFloat(le), float64(cumulative))
+		}
+		sample(w, h.name+"_bucket", h.labels, s.labels, "le", "+Inf", float64(s.count))
+		sample(w, h.name+"_sum", h.labels, s.labels, "", "", s.sum)
+		sample(w, h.name+"_count", h.labels, s.labels, "", "", float64(s.count))
+	}
+}
+
+// Gauges collects gauge values read at scrape time. Values for the same
+// name are written together, under one header, in the order first set.
+type Gauges struct {
+	order []string
+	vecs  map[string]*vec
+}
+
+// Set records value for the gauge name. labels are name, value pairs and
+// must use the same names every time name is set.
+func (g *Gauges) Set(name, help string, value float64, labels ...string) {
+	if g.vecs == nil {
+		g.vecs = make(map[string]*vec)
+	}
+
+	names := make([]string, 0, len(labels)/2)
+	values := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		names = append(names, labels[i])
+		values = append(values, labels[i+1])
+	}
+
+	v := g.vecs[name]
+	if v == nil {
+		nv := newVec(name, help, names)
+		v = &nv
+		g.vecs[name] = v
+		g.order = append(g.order, name)
+	}
+	v.get(values).value = value
+}
+
+// Write writes every gauge in the text format.
+func (g *Gauges) Write(w io.Writer) {
+	for _, name := range g.order {
+		v := g.vecs[name]
+		header(w, v.name, v.help, "gauge")
+		for _, s := range v.sorted() {
+			sample(w, v.name, v.labels, s.labels, "", "", s.value)
+		}
+	}
+}
+
+func header(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer("\\", `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+// sample writes one line. extraName and extraValue add a label after the
+// series' own, such as a histogram bucket's "le".
+func sample(w io.Writer, name string, names, values []string, extraName, extraValue string, v float64) {
+	var b strings.Builder
+	b.WriteString(name)
+
+	pairs := make([]string, 0, len(names)+1)
+	for i, n := range names {
+		pairs = append(pairs, n+`="`+escape(values[i])+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+	if len(pairs) > 0 {
+		b.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+
+	b.WriteString(" " + formatFloat(v) + "\n")
+	io.WriteString(w, b.String())
+}
+
+var labelEscaper = strings.NewReplacer("\\", `\\`, "\"", `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
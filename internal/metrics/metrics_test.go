@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCounterVec(t *testing.T) {
+	c := NewCounterVec("errors_total", "Errors by device.", "device")
+	c.Inc("upstairs")
+	c.Inc("upstairs")
+	c.Add(0.5, `say "hi"`)
+
+	var b strings.Builder
+	c.Write(&b)
+	want := `# HELP errors_total Errors by device.
+# TYPE errors_total counter
+errors_total{device="say \"hi\""} 0.5
+errors_total{device="upstairs"} 2
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+
+	c.Reset()
+	b.Reset()
+	c.Write(&b)
+	if want := "# HELP errors_total Errors by device.\n# TYPE errors_total counter\n"; b.String() != want {
+		t.Errorf("after Reset got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	h := NewHistogramVec("took_seconds", "How long it took.", []float64{0.1, 1}, "path")
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		h.Observe(v, "/tstat")
+	}
+
+	var b strings.Builder
+	h.Write(&b)
+	want := `# HELP took_seconds How long it took.
+# TYPE took_seconds histogram
+took_seconds_bucket{path="/tstat",le="0.1"} 2
+took_seconds_bucket{path="/tstat",le="1"} 3
+took_seconds_bucket{path="/tstat",le="+Inf"} 4
+took_seconds_sum{path="/tstat"} 3.65
+took_seconds_count{path="/tstat"} 4
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestGauges(t *testing.T) {
+	var g Gauges
+	g.Set("up", "Whether it answered.", 1, "device", "upstairs")
+	g.Set("info", "Build info.", 1)
+	g.Set("up", "Whether it answered.", 0, "device", "downstairs")
+
+	var b strings.Builder
+	g.Write(&b)
+	want := `# HELP up Whether it answered.
+# TYPE up gauge
+up{device="downstairs"} 0
+up{device="upstairs"} 1
+# HELP info Build info.
+# TYPE info gauge
+info 1
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
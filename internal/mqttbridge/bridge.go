@@ -0,0 +1,479 @@
+// Package mqttbridge publishes the state of each thermostat to an MQTT
+// broker and takes commands from it, so home automation systems such as
+// Home Assistant can watch and control the CT50s without talking to them
+// directly.
+//
+// Every topic is under Options.Prefix ("thermostat" by default), with each
+// thermostat named by its slug, the lower-cased config name:
+//
+//	thermostat/status                 "online" while the bridge is connected
+//	thermostat/<slug>/availability    "online" while the thermostat answers
+//	thermostat/<slug>/state           JSON State
+//	thermostat/<slug>/set/mode        off, heat, cool or auto
+//	thermostat/<slug>/set/setpoint    the setpoint for heat or cool mode
+//	thermostat/<slug>/set/heat        the heat setpoint of the auto range
+//	thermostat/<slug>/set/cool        the cool setpoint of the auto range
+//	thermostat/<slug>/set/fan         auto, circulate or on
+//	thermostat/<slug>/set/hold        on or off
+//
+// Status, availability and state are retained. The bridge's last will sets
+// status to "offline" if it drops off the broker without saying goodbye.
+// Temperatures are in Options.Unit both ways.
+package mqttbridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/EntropySynthetica/Thermostat/internal/audit"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+const (
+	// DefaultPrefix is the topic prefix when Options.Prefix is empty.
+	DefaultPrefix = "thermostat"
+
+	// DefaultClientID is the MQTT client ID when Options.ClientID is
+	// empty. Two bridges on one broker need different IDs.
+	DefaultClientID = "thermostat-mqttbridge"
+
+	// DefaultInterval is how often thermostats are polled when
+	// Options.Interval is zero.
+	DefaultInterval = 30 * time.Second
+)
+
+// MinSetpoint and MaxSetpoint bound the setpoints accepted on the set
+// topics, in degrees F. They match the web interface.
+const (
+	MinSetpoint = 50
+	MaxSetpoint = 90
+)
+
+const (
+	online  = "online"
+	offline = "offline"
+)
+
+// deviceTimeout bounds one poll or command on a thermostat, retries
+// included.
+const deviceTimeout = time.Minute
+
+// publishTimeout is how long to wait for the broker to take a message.
+const publishTimeout = 10 * time.Second
+
+// Options configures a Bridge.
+type Options struct {
+	// Broker is the broker URL, such as "tcp://localhost:1883". The
+	// ssl://, ws:// and wss:// schemes work too.
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+
+	// Prefix is the start of every state and command topic.
+	Prefix string
+
+	// DiscoveryPrefix is where Home Assistant looks for MQTT discovery
+	// config, usually "homeassistant". Empty turns discovery off.
+	DiscoveryPrefix string
+
+	// Interval is how often every thermostat is polled. A command also
+	// polls the thermostat it changed straight away.
+	Interval time.Duration
+
+	// Unit is the temperature unit of states and commands.
+	Unit ct50.Unit
+}
+
+// Device is a thermostat to bridge.
+type Device struct {
+	Name   string
+	Client *ct50.Client
+}
+
+// bridged is a Device and what was last published for it.
+type bridged struct {
+	Device
+	slug string
+
+	mu     sync.Mutex
+	state  []byte
+	status string
+}
+
+// Bridge connects thermostats to an MQTT broker.
+type Bridge struct {
+	opts    Options
+	devices []*bridged
+	bySlug  map[string]*bridged
+	client  mqtt.Client
+
+	// refresh asks Run to poll and republish everything, after a
+	// (re)connect.
+	refresh chan struct{}
+}
+
+var slugInvalid = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// Slug returns the name of a thermostat as used in topics and Home
+// Assistant IDs: its config name in lower case, with anything other than
+// letters, digits, '-' and '_' replaced by '_'.
+func Slug(name string) string {
+	return slugInvalid.ReplaceAllString(strings.ToLower(name), "_")
+}
+
+// New returns a Bridge for devices. It does not connect until Run.
+func New(opts Options, devices []Device) (*Bridge, error) {
+	if opts.Broker == "" {
+		return nil, errors.New("mqttbridge: no broker given")
+	}
+	if len(devices) == 0 {
+		return nil, errors.New("mqttbridge: no thermostats to bridge")
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = DefaultPrefix
+	}
+	if opts.ClientID == "" {
+		opts.ClientID = DefaultClientID
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+	if opts.Unit == "" {
+		opts.Unit = ct50.Fahrenheit
+	}
+
+	b := &Bridge{
+		opts:    opts,
+		bySlug:  make(map[string]*bridged),
+		refresh: make(chan struct{}, 1),
+	}
+	for _, dev := range devices {
+		d := &bridged{Device: dev, slug: Slug(dev.Name)}
+		if other, ok := b.bySlug[d.slug]; ok {
+			return nil, fmt.Errorf("mqttbridge: thermostats %q and %q would share the topic %s", other.Name, dev.Name, b.topic(d, ""))
+		}
+		b.devices = append(b.devices, d)
+		b.bySlug[d.slug] = d
+	}
+
+	mo := mqtt.NewClientOptions().
+		AddBroker(opts.Broker).
+		SetClientID(opts.ClientID).
+		SetUsername(opts.Username).
+		SetPassword(opts.Password).
+		SetWill(b.statusTopic(), offline, 1, true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(5 * time.Second).
+		SetMaxReconnectInterval(time.Minute).
+		SetOrderMatters(false).
+		SetOnConnectHandler(b.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("mqtt: connection to %s lost: %v", opts.Broker, err)
+		})
+	b.client = mqtt.NewClient(mo)
+	return b, nil
+}
+
+func (b *Bridge) statusTopic() string {
+	return b.opts.Prefix + "/status"
+}
+
+// topic returns the topic for one thermostat, such as
+// "thermostat/upstairs/state" for "state".
+func (b *Bridge) topic(d *bridged, suffix string) string {
+	t := b.opts.Prefix + "/" + d.slug
+	if suffix != "" {
+		t += "/" + suffix
+	}
+	return t
+}
+
+// Run connects to the broker and keeps every thermostat's state published
+// until ctx is done. It then marks the bridge offline and disconnects. A
+// broker that cannot be reached, or goes away, is retried in the
+// background.
+func (b *Bridge) Run(ctx context.Context) error {
+	log.Printf("mqtt: connecting to %s", b.opts.Broker)
+	connect := b.client.Connect()
+	select {
+	case <-connect.Done():
+		if err := connect.Error(); err != nil {
+			return fmt.Errorf("mqttbridge: connecting to %s: %w", b.opts.Broker, err)
+		}
+	case <-ctx.Done():
+		b.client.Disconnect(0)
+		return nil
+	}
+
+	ticker := time.NewTicker(b.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-b.refresh:
+		case <-ctx.Done():
+			if err := b.publish(b.statusTopic(), []byte(offline)); err != nil {
+				log.Printf("mqtt: %v", err)
+			}
+			b.client.Disconnect(250)
+			return nil
+		}
+		b.pollAll(ctx)
+	}
+}
+
+// onConnect runs on every connection to the broker, including reconnects.
+// The session starts clean, so the command subscription is made again,
+// and everything is republished in case the broker lost its retained
+// messages.
+func (b *Bridge) onConnect(c mqtt.Client) {
+	log.Printf("mqtt: connected to %s", b.opts.Broker)
+
+	if err := b.publish(b.statusTopic(), []byte(online)); err != nil {
+		log.Printf("mqtt: %v", err)
+	}
+
+	filter := b.opts.Prefix + "/+/set/+"
+	sub := c.Subscribe(filter, 1, b.handleCommand)
+	if !sub.WaitTimeout(publishTimeout) {
+		log.Printf("mqtt: timed out subscribing to %s", filter)
+	} else if err := sub.Error(); err != nil {
+		log.Printf("mqtt: subscribing to %s: %v", filter, err)
+	}
+
+	for _, d := range b.devices {
+		if b.opts.DiscoveryPrefix != "" {
+			if err := b.publishDiscovery(d); err != nil {
+				log.Printf("mqtt: %s: %v", d.Name, err)
+			}
+		}
+
+		d.mu.Lock()
+		d.state, d.status = nil, ""
+		d.mu.Unlock()
+	}
+
+	select {
+	case b.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// publish sends a retained message. It fails straight away while the
+// bridge is not connected; onConnect republishes everything once it is.
+func (b *Bridge) publish(topic string, payload []byte) error {
+	if !b.client.IsConnectionOpen() {
+		return fmt.Errorf("not connected, dropped message to %s", topic)
+	}
+
+	token := b.client.Publish(topic, 1, true, payload)
+	if !token.WaitTimeout(publishTimeout) {
+		return fmt.Errorf("timed out publishing to %s", topic)
+	}
+	return token.Error()
+}
+
+// pollAll polls every thermostat at once, so a slow one does not hold up
+// the rest.
+func (b *Bridge) pollAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, d := range b.devices {
+		wg.Add(1)
+		go func(d *bridged) {
+			defer wg.Done()
+			b.poll(ctx, d)
+		}(d)
+	}
+	wg.Wait()
+}
+
+// poll reads one thermostat and publishes its availability and, if it
+// changed, its state.
+func (b *Bridge) poll(ctx context.Context, d *bridged) {
+	ctx, cancel := context.WithTimeout(ctx, deviceTimeout)
+	defer cancel()
+
+	stats, err := d.Client.Status(ctx)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	status := online
+	if err != nil {
+		status = offline
+	}
+	if status != d.status {
+		if err != nil {
+			log.Printf("mqtt: %s: %v", d.Name, err)
+		}
+		if err := b.publish(b.topic(d, "availability"), []byte(status)); err != nil {
+			log.Printf("mqtt: %v", err)
+			return
+		}
+		d.status = status
+	}
+	if stats == nil {
+		return
+	}
+
+	state, err := json.Marshal(NewState(stats, b.opts.Unit))
+	if err != nil {
+		log.Printf("mqtt: %s: %v", d.Name, err)
+		return
+	}
+	if bytes.Equal(state, d.state) {
+		return
+	}
+	if err := b.publish(b.topic(d, "state"), state); err != nil {
+		log.Printf("mqtt: %v", err)
+		return
+	}
+	d.state = state
+}
+
+// handleCommand carries out a message on a set topic and publishes the
+// thermostat's new state.
+func (b *Bridge) handleCommand(_ mqtt.Client, msg mqtt.Message) {
+	// A retained command is left over from some time ago, and would be
+	// replayed on every reconnect.
+	if msg.Retained() {
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(msg.Topic(), b.opts.Prefix+"/"), "/")
+	if len(parts) != 3 || parts[1] != "set" {
+		return
+	}
+	d, ok := b.bySlug[parts[0]]
+	if !ok {
+		log.Printf("mqtt: %s: no such thermostat", msg.Topic())
+		return
+	}
+
+	payload := strings.TrimSpace(string(msg.Payload()))
+	ctx, cancel := context.WithTimeout(context.Background(), deviceTimeout)
+	defer cancel()
+	ctx = audit.WithOrigin(ctx, audit.Origin{
+		Actor:  audit.Actor{Kind: audit.Integration, Name: "mqtt"},
+		Action: msg.Topic() + " " + payload,
+	})
+
+	if err := b.command(ctx, d, parts[2], payload); err != nil {
+		log.Printf("mqtt: %s: set %s to %q: %v", d.Name, parts[2], payload, err)
+	} else {
+		log.Printf("mqtt: %s: set %s to %s", d.Name, parts[2], payload)
+	}
+	b.poll(ctx, d)
+}
+
+// command changes one setting on a thermostat.
+func (b *Bridge) command(ctx context.Context, d *bridged, setting, payload string) error {
+	var u ct50.Update
+
+	switch setting {
+	case "mode":
+		mode, err := parseMode(payload)
+		if err != nil {
+			return err
+		}
+		u.Tmode = &mode
+
+	case "setpoint":
+		temp, err := b.parseSetpoint(payload)
+		if err != nil {
+			return err
+		}
+		return d.Client.SetTarget(ctx, temp)
+
+	case "heat", "cool":
+		temp, err := b.parseSetpoint(payload)
+		if err != nil {
+			return err
+		}
+		stats, err := d.Client.Status(ctx)
+		if err != nil {
+			return err
+		}
+		// In auto the other setpoint is kept, and the pair must still
+		// clear the deadband.
+		if stats.Tmode == ct50.ModeAuto {
+			if setting == "heat" {
+				return d.Client.SetRange(ctx, temp, stats.TCool)
+			}
+			return d.Client.SetRange(ctx, stats.THeat, temp)
+		}
+		if setting == "heat" {
+			u.THeat = &temp
+		} else {
+			u.TCool = &temp
+		}
+
+	case "fan":
+		fan, err := ct50.ParseFanMode(payload)
+		if err != nil {
+			return err
+		}
+		u.Fmode = &fan
+
+	case "hold":
+		hold, err := parseHold(payload)
+		if err != nil {
+			return err
+		}
+		u.Hold = &hold
+
+	default:
+		return fmt.Errorf("unknown setting %q", setting)
+	}
+
+	_, err := d.Client.UpdateAndVerify(ctx, u)
+	return err
+}
+
+// parseMode accepts Home Assistant's "heat_cool" for auto, as well as the
+// thermostat's own mode names.
+func parseMode(s string) (ct50.Mode, error) {
+	if strings.EqualFold(s, "heat_cool") {
+		return ct50.ModeAuto, nil
+	}
+	return ct50.ParseMode(s)
+}
+
+// parseHold accepts Home Assistant's "hold" and "none" presets, as well as
+// on and off.
+func parseHold(s string) (ct50.OnOff, error) {
+	switch strings.ToLower(s) {
+	case "hold":
+		return ct50.On, nil
+	case "none":
+		return ct50.Off, nil
+	}
+	return ct50.ParseOnOff(s)
+}
+
+// parseSetpoint reads a setpoint in the bridge's unit and returns it in
+// degrees F.
+func (b *Bridge) parseSetpoint(s string) (float64, error) {
+	var v float64
+	if _, err := fmt.Sscan(s, &v); err != nil {
+		return 0, fmt.Errorf("setpoint %q is not a number", s)
+	}
+	temp := b.opts.Unit.ToDevice(v)
+	if temp < MinSetpoint || temp > MaxSetpoint {
+		return 0, fmt.Errorf("setpoint must be between %s and %s", b.opts.Unit.Format(b.opts.Unit.Setpoint(MinSetpoint)), b.opts.Unit.Format(b.opts.Unit.Setpoint(MaxSetpoint)))
+	}
+	return temp, nil
+}
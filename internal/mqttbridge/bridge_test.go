@@ -0,0 +1,309 @@
+package mqttbridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/EntropySynthetica/Thermostat/internal/audit"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50sim"
+)
+
+// waitTimeout bounds every wait for a message or a change on a thermostat.
+const waitTimeout = 5 * time.Second
+
+// brokerURL returns the broker to test against: the one in
+// MQTT_TEST_BROKER, such as tcp://localhost:1883 for a local mosquitto,
+// or else an in-process test broker.
+func brokerURL(t *testing.T) string {
+	if url := os.Getenv("MQTT_TEST_BROKER"); url != "" {
+		return url
+	}
+	return newTestBroker(t).url()
+}
+
+// testPrefix returns a topic prefix of the test's own, so runs against a
+// shared broker do not see each other's retained messages.
+func testPrefix(t *testing.T) string {
+	return fmt.Sprintf("%s-%d", t.Name(), time.Now().UnixNano())
+}
+
+type testDevice struct {
+	sim *ct50sim.Device
+	srv *httptest.Server
+}
+
+func newDevices(t *testing.T, names ...string) (map[string]testDevice, []Device) {
+	t.Helper()
+
+	sims := make(map[string]testDevice)
+	var list []Device
+	for _, name := range names {
+		sim := ct50sim.New(ct50sim.Options{Name: name})
+		srv := httptest.NewServer(sim)
+		t.Cleanup(srv.Close)
+
+		client := ct50.New(srv.URL)
+		client.Retries = 0
+		sims[name] = testDevice{sim: sim, srv: srv}
+		list = append(list, Device{Name: name, Client: client})
+	}
+	return sims, list
+}
+
+// startBridge runs a bridge until the test ends.
+func startBridge(t *testing.T, opts Options, devices []Device) {
+	t.Helper()
+
+	b, err := New(opts, devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- b.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+// watcher is a second MQTT client that keeps the last message on every
+// topic it subscribes to.
+type watcher struct {
+	client mqtt.Client
+
+	mu   sync.Mutex
+	last map[string]string
+}
+
+func newWatcher(t *testing.T, broker string, filters ...string) *watcher {
+	t.Helper()
+
+	w := &watcher{last: make(map[string]string)}
+	opts := mqtt.NewClientOptions().AddBroker(broker).SetClientID(testPrefix(t) + "-watcher")
+	w.client = mqtt.NewClient(opts)
+	if token := w.client.Connect(); !token.WaitTimeout(waitTimeout) || token.Error() != nil {
+		t.Fatalf("connecting watcher: %v", token.Error())
+	}
+	t.Cleanup(func() { w.client.Disconnect(0) })
+
+	for _, f := range filters {
+		token := w.client.Subscribe(f, 1, func(_ mqtt.Client, msg mqtt.Message) {
+			w.mu.Lock()
+			w.last[msg.Topic()] = string(msg.Payload())
+			w.mu.Unlock()
+		})
+		if !token.WaitTimeout(waitTimeout) || token.Error() != nil {
+			t.Fatalf("subscribing to %s: %v", f, token.Error())
+		}
+	}
+	return w
+}
+
+// wait waits until the last message on topic satisfies ok, and returns it.
+func (w *watcher) wait(t *testing.T, topic string, ok func(payload string) bool) string {
+	t.Helper()
+
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		w.mu.Lock()
+		payload, seen := w.last[topic]
+		w.mu.Unlock()
+		if seen && ok(payload) {
+			return payload
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: last message %q", topic, payload)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (w *watcher) waitFor(t *testing.T, topic, want string) {
+	t.Helper()
+	w.wait(t, topic, func(payload string) bool { return payload == want })
+}
+
+// waitState waits for a state on topic that satisfies ok.
+func (w *watcher) waitState(t *testing.T, topic string, ok func(State) bool) State {
+	t.Helper()
+
+	var s State
+	w.wait(t, topic, func(payload string) bool {
+		s = State{}
+		return json.Unmarshal([]byte(payload), &s) == nil && ok(s)
+	})
+	return s
+}
+
+func (w *watcher) send(t *testing.T, topic, payload string) {
+	t.Helper()
+	if token := w.client.Publish(topic, 1, false, payload); !token.WaitTimeout(waitTimeout) || token.Error() != nil {
+		t.Fatalf("publishing to %s: %v", topic, token.Error())
+	}
+}
+
+// waitDevice waits until the thermostat's status satisfies ok.
+func waitDevice(t *testing.T, sim *ct50sim.Device, ok func(ct50.Status) bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(waitTimeout)
+	for !ok(sim.Status()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("thermostat status %+v", sim.Status())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBridge(t *testing.T) {
+	broker := brokerURL(t)
+	prefix := testPrefix(t)
+	sims, devices := newDevices(t, "Upstairs", "downstairs")
+	changes := audit.NewLog(filepath.Join(t.TempDir(), "audit.log"))
+	changes.Watch(devices[0].Client, "Upstairs")
+	startBridge(t, Options{
+		Broker:          broker,
+		ClientID:        prefix + "-bridge",
+		Prefix:          prefix,
+		DiscoveryPrefix: prefix + "-ha",
+		Interval:        time.Hour,
+	}, devices)
+
+	w := newWatcher(t, broker, prefix+"/#", prefix+"-ha/#")
+	w.waitFor(t, prefix+"/status", "online")
+	w.waitFor(t, prefix+"/upstairs/availability", "online")
+	w.waitFor(t, prefix+"/downstairs/availability", "online")
+
+	var cfg map[string]interface{}
+	payload := w.wait(t, prefix+"-ha/climate/"+Slug(prefix)+"_upstairs/config", func(string) bool { return true })
+	if err := json.Unmarshal([]byte(payload), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]interface{}{
+		"unique_id":                     Slug(prefix) + "_upstairs",
+		"mode_command_topic":            prefix + "/upstairs/set/mode",
+		"temperature_command_topic":     prefix + "/upstairs/set/setpoint",
+		"temperature_low_command_topic": prefix + "/upstairs/set/heat",
+		"current_temperature_topic":     prefix + "/upstairs/state",
+		"temperature_unit":              "F",
+	} {
+		if cfg[key] != want {
+			t.Errorf("discovery %s = %v, want %v", key, cfg[key], want)
+		}
+	}
+	if device, _ := cfg["device"].(map[string]interface{}); device["name"] != "Upstairs" {
+		t.Errorf("discovery device = %v, want the name Upstairs", cfg["device"])
+	}
+
+	state := prefix + "/upstairs/state"
+	temp := ct50.Fahrenheit.Reading(sims["Upstairs"].sim.Status().Temp)
+	w.waitState(t, state, func(s State) bool { return s.Temp == temp && s.Unit == ct50.Fahrenheit })
+
+	w.send(t, prefix+"/upstairs/set/mode", "cool")
+	w.waitState(t, state, func(s State) bool { return s.Mode == "cool" })
+	entries, err := changes.Read(audit.Query{})
+	if err != nil || len(entries) != 1 || entries[0].Actor.String() != "integration mqtt" || entries[0].Action != prefix+"/upstairs/set/mode cool" {
+		t.Errorf("audit log = %+v, %v, want the mode command from mqtt", entries, err)
+	}
+
+	w.send(t, prefix+"/upstairs/set/setpoint", "76")
+	w.waitState(t, state, func(s State) bool { return s.Target != nil && *s.Target == 76 })
+	waitDevice(t, sims["Upstairs"].sim, func(s ct50.Status) bool { return s.TCool == 76 })
+
+	w.send(t, prefix+"/upstairs/set/mode", "heat_cool")
+	w.waitState(t, state, func(s State) bool { return s.Mode == "auto" && s.Target == nil })
+	w.send(t, prefix+"/upstairs/set/heat", "66")
+	waitDevice(t, sims["Upstairs"].sim, func(s ct50.Status) bool { return s.Tmode == ct50.ModeAuto && s.THeat == 66 })
+
+	w.send(t, prefix+"/upstairs/set/fan", "on")
+	w.send(t, prefix+"/upstairs/set/hold", "hold")
+	w.waitState(t, state, func(s State) bool { return s.Fan == "on" && s.Hold })
+
+	// Out of range, so the setpoint stays where it was.
+	w.send(t, prefix+"/upstairs/set/heat", "120")
+	w.send(t, prefix+"/upstairs/set/cool", "81")
+	w.waitState(t, state, func(s State) bool { return s.Cool == 81 })
+	if got := sims["Upstairs"].sim.Status().THeat; got != 66 {
+		t.Errorf("heat setpoint = %v after an out of range command, want 66", got)
+	}
+
+	if got := sims["downstairs"].sim.Status().Tmode; got == ct50.ModeAuto {
+		t.Errorf("downstairs mode = %v, want it untouched", got)
+	}
+}
+
+func TestBridgeReconnect(t *testing.T) {
+	broker := newTestBroker(t)
+	prefix := testPrefix(t)
+	sims, devices := newDevices(t, "upstairs", "downstairs")
+	startBridge(t, Options{Broker: broker.url(), Prefix: prefix, Interval: time.Hour}, devices)
+
+	waitRetained := func(topic, want string) {
+		t.Helper()
+		deadline := time.Now().Add(waitTimeout)
+		for {
+			got, _ := broker.retainedMessage(topic)
+			if got == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s = %q, want %q", topic, got, want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	waitRetained(prefix+"/status", "online")
+	if will := broker.wills()[prefix+"/status"]; will != "offline" {
+		t.Fatalf("will = %q, want offline on %s/status", will, prefix)
+	}
+	waitRetained(prefix+"/downstairs/availability", "online")
+
+	// Cut the bridge off, with downstairs gone too by the time it is back.
+	sims["downstairs"].srv.Close()
+	broker.dropAll()
+
+	waitRetained(prefix+"/downstairs/availability", "offline")
+	waitRetained(prefix+"/status", "online")
+	if got := strings.Join(broker.messages(prefix+"/status"), " "); got != "online offline online" {
+		t.Errorf("%s/status went %s, want online offline online", prefix, got)
+	}
+	waitRetained(prefix+"/upstairs/availability", "online")
+
+	// The command subscription came back with the connection.
+	broker.publish(brokerMessage{topic: prefix + "/upstairs/set/mode", payload: []byte("off")})
+	waitDevice(t, sims["upstairs"].sim, func(s ct50.Status) bool { return s.Tmode == ct50.ModeOff })
+}
+
+func TestSlug(t *testing.T) {
+	for name, want := range map[string]string{
+		"upstairs":    "upstairs",
+		"Living Room": "living_room",
+		"a+b":         "a_b",
+		"kid's room":  "kid_s_room",
+	} {
+		if got := Slug(name); got != want {
+			t.Errorf("Slug(%q) = %q, want %q", name, got, want)
+		}
+	}
+
+	_, devices := newDevices(t, "Living Room", "living room")
+	if _, err := New(Options{Broker: "tcp://localhost:1883"}, devices); err == nil {
+		t.Error("New accepted two thermostats with the same slug")
+	}
+}
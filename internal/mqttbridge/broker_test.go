@@ -0,0 +1,338 @@
+package mqttbridge
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testBroker is just enough of an MQTT 3.1.1 broker to test the bridge
+// without an external one: retained messages, wildcards and last wills.
+// Everything is delivered at QoS 0.
+type testBroker struct {
+	ln net.Listener
+
+	mu        sync.Mutex
+	conns     map[*brokerConn]bool
+	retained  map[string]string
+	published map[string][]string
+}
+
+type brokerConn struct {
+	net.Conn
+	wmu  sync.Mutex
+	subs []string
+	will *brokerMessage
+}
+
+type brokerMessage struct {
+	topic   string
+	payload []byte
+	retain  bool
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &testBroker{
+		ln:        ln,
+		conns:     make(map[*brokerConn]bool),
+		retained:  make(map[string]string),
+		published: make(map[string][]string),
+	}
+	t.Cleanup(func() {
+		ln.Close()
+		b.dropAll()
+	})
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(&brokerConn{Conn: c})
+		}
+	}()
+	return b
+}
+
+func (b *testBroker) url() string {
+	return "tcp://" + b.ln.Addr().String()
+}
+
+// retainedMessage returns the retained message on topic.
+func (b *testBroker) retainedMessage(topic string) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	payload, ok := b.retained[topic]
+	return payload, ok
+}
+
+// messages returns every payload published on topic, in order.
+func (b *testBroker) messages(topic string) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.published[topic]...)
+}
+
+// wills returns the will topic and payload of every connected client.
+func (b *testBroker) wills() map[string]string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wills := make(map[string]string)
+	for c := range b.conns {
+		if c.will != nil {
+			wills[c.will.topic] = string(c.will.payload)
+		}
+	}
+	return wills
+}
+
+// dropAll cuts every client off without a DISCONNECT, as a network failure
+// would, so their wills are published.
+func (b *testBroker) dropAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.conns {
+		c.Close()
+	}
+}
+
+func (b *testBroker) serve(c *brokerConn) {
+	b.mu.Lock()
+	b.conns[c] = true
+	b.mu.Unlock()
+
+	defer func() {
+		c.Close()
+		b.mu.Lock()
+		delete(b.conns, c)
+		will := c.will
+		b.mu.Unlock()
+		if will != nil {
+			b.publish(*will)
+		}
+	}()
+
+	r := bufio.NewReader(c)
+	for {
+		header, body, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		p := &packetReader{body: body}
+
+		switch header >> 4 {
+		case 1: // CONNECT
+			p.string() // protocol name
+			p.byte()   // protocol level
+			flags := p.byte()
+			p.uint16() // keep alive
+			p.string() // client ID
+			if flags&0x04 != 0 {
+				will := &brokerMessage{topic: p.string(), payload: []byte(p.string()), retain: flags&0x20 != 0}
+				b.mu.Lock()
+				c.will = will
+				b.mu.Unlock()
+			}
+			c.write(0x20, []byte{0, 0})
+
+		case 3: // PUBLISH
+			msg := brokerMessage{topic: p.string(), retain: header&0x01 != 0}
+			if qos := header >> 1 & 0x03; qos > 0 {
+				id := p.uint16()
+				c.write(0x40, binary.BigEndian.AppendUint16(nil, id))
+			}
+			msg.payload = p.rest()
+			b.publish(msg)
+
+		case 8: // SUBSCRIBE
+			id := p.uint16()
+			ack := binary.BigEndian.AppendUint16(nil, id)
+			var filters []string
+			for len(p.body) > 0 {
+				filters = append(filters, p.string())
+				p.byte() // requested QoS
+				ack = append(ack, 0)
+			}
+			b.mu.Lock()
+			c.subs = append(c.subs, filters...)
+			var retained []brokerMessage
+			for topic, payload := range b.retained {
+				for _, f := range filters {
+					if topicMatches(f, topic) {
+						retained = append(retained, brokerMessage{topic: topic, payload: []byte(payload), retain: true})
+						break
+					}
+				}
+			}
+			b.mu.Unlock()
+			c.write(0x90, ack)
+			for _, msg := range retained {
+				c.deliver(msg)
+			}
+
+		case 10: // UNSUBSCRIBE
+			c.write(0xB0, binary.BigEndian.AppendUint16(nil, p.uint16()))
+
+		case 12: // PINGREQ
+			c.write(0xD0, nil)
+
+		case 14: // DISCONNECT
+			b.mu.Lock()
+			c.will = nil
+			b.mu.Unlock()
+			return
+		}
+	}
+}
+
+// publish keeps msg if it is retained and sends it to every subscriber.
+func (b *testBroker) publish(msg brokerMessage) {
+	b.mu.Lock()
+	b.published[msg.topic] = append(b.published[msg.topic], string(msg.payload))
+	if msg.retain {
+		if len(msg.payload) == 0 {
+			delete(b.retained, msg.topic)
+		} else {
+			b.retained[msg.topic] = string(msg.payload)
+		}
+	}
+	var to []*brokerConn
+	for c := range b.conns {
+		for _, f := range c.subs {
+			if topicMatches(f, msg.topic) {
+				to = append(to, c)
+				break
+			}
+		}
+	}
+	b.mu.Unlock()
+
+	msg.retain = false
+	for _, c := range to {
+		c.deliver(msg)
+	}
+}
+
+func (c *brokerConn) deliver(msg brokerMessage) {
+	header := byte(0x30)
+	if msg.retain {
+		header |= 0x01
+	}
+	c.write(header, append(appendString(nil, msg.topic), msg.payload...))
+}
+
+func (c *brokerConn) write(header byte, body []byte) {
+	packet := []byte{header}
+	n := len(body)
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if n == 0 {
+			break
+		}
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.Write(append(packet, body...))
+}
+
+func readPacket(r *bufio.Reader) (header byte, body []byte, err error) {
+	if header, err = r.ReadByte(); err != nil {
+		return 0, nil, err
+	}
+	length, shift := 0, 0
+	for {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length |= int(digit&0x7f) << shift
+		if digit&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+	}
+	body = make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	return header, body, err
+}
+
+// packetReader takes fields off the front of a packet body. A short
+// packet reads as zeros.
+type packetReader struct {
+	body []byte
+}
+
+func (p *packetReader) byte() byte {
+	if len(p.body) < 1 {
+		return 0
+	}
+	v := p.body[0]
+	p.body = p.body[1:]
+	return v
+}
+
+func (p *packetReader) uint16() uint16 {
+	if len(p.body) < 2 {
+		p.body = nil
+		return 0
+	}
+	v := binary.BigEndian.Uint16(p.body)
+	p.body = p.body[2:]
+	return v
+}
+
+func (p *packetReader) string() string {
+	n := int(p.uint16())
+	if n > len(p.body) {
+		n = len(p.body)
+	}
+	s := string(p.body[:n])
+	p.body = p.body[n:]
+	return s
+}
+
+func (p *packetReader) rest() []byte {
+	v := p.body
+	p.body = nil
+	return v
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// topicMatches reports whether topic matches filter, with its + and #
+// wildcards.
+func topicMatches(filter, topic string) bool {
+	f, t := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i >= len(t) || (level != "+" && level != t[i]) {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
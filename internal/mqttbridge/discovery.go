@@ -0,0 +1,208 @@
+package mqttbridge
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+// State is the JSON published on a thermostat's state topic. Temperatures
+// are in Unit. Target is the setpoint for heat or cool mode, and null in
+// off and auto.
+type State struct {
+	Unit       ct50.Unit `json:"unit"`
+	Temp       float64   `json:"temp"`
+	Mode       string    `json:"mode"`
+	Target     *float64  `json:"target"`
+	Heat       float64   `json:"heat"`
+	Cool       float64   `json:"cool"`
+	Action     string    `json:"action"`
+	Fan        string    `json:"fan"`
+	FanRunning bool      `json:"fanRunning"`
+	Hold       bool      `json:"hold"`
+	Override   bool      `json:"override"`
+}
+
+// NewState converts a thermostat status to a State in unit.
+func NewState(stats *ct50.Status, unit ct50.Unit) State {
+	s := State{
+		Unit:       unit,
+		Temp:       unit.Reading(stats.Temp),
+		Mode:       strings.ToLower(stats.Tmode.String()),
+		Heat:       unit.Setpoint(stats.THeat),
+		Cool:       unit.Setpoint(stats.TCool),
+		Action:     action(stats),
+		Fan:        strings.ToLower(stats.Fmode.String()),
+		FanRunning: stats.Fstate.Bool(),
+		Hold:       stats.Hold.Bool(),
+		Override:   stats.Override.Bool(),
+	}
+	if target := stats.Target(); target != 0 {
+		t := unit.Setpoint(target)
+		s.Target = &t
+	}
+	return s
+}
+
+// action describes what the system is doing in Home Assistant's terms.
+func action(stats *ct50.Status) string {
+	switch {
+	case stats.Tmode == ct50.ModeOff:
+		return "off"
+	case stats.Tstate == ct50.StateHeating:
+		return "heating"
+	case stats.Tstate == ct50.StateCooling:
+		return "cooling"
+	case stats.Fstate.Bool():
+		return "fan"
+	default:
+		return "idle"
+	}
+}
+
+// discoveryConfig is the Home Assistant MQTT discovery payload for a
+// climate entity.
+type discoveryConfig struct {
+	// Name is null so the entity takes the device's name.
+	Name     *string         `json:"name"`
+	UniqueID string          `json:"unique_id"`
+	ObjectID string          `json:"object_id"`
+	Device   discoveryDevice `json:"device"`
+
+	Availability     []discoveryAvailability `json:"availability"`
+	AvailabilityMode string                  `json:"availability_mode"`
+
+	CurrentTemperatureTopic    string `json:"current_temperature_topic"`
+	CurrentTemperatureTemplate string `json:"current_temperature_template"`
+
+	ModeStateTopic    string   `json:"mode_state_topic"`
+	ModeStateTemplate string   `json:"mode_state_template"`
+	ModeCommandTopic  string   `json:"mode_command_topic"`
+	Modes             []string `json:"modes"`
+
+	TemperatureStateTopic    string `json:"temperature_state_topic"`
+	TemperatureStateTemplate string `json:"temperature_state_template"`
+	TemperatureCommandTopic  string `json:"temperature_command_topic"`
+
+	TemperatureLowStateTopic    string `json:"temperature_low_state_topic"`
+	TemperatureLowStateTemplate string `json:"temperature_low_state_template"`
+	TemperatureLowCommandTopic  string `json:"temperature_low_command_topic"`
+
+	TemperatureHighStateTopic    string `json:"temperature_high_state_topic"`
+	TemperatureHighStateTemplate string `json:"temperature_high_state_template"`
+	TemperatureHighCommandTopic  string `json:"temperature_high_command_topic"`
+
+	FanModeStateTopic    string   `json:"fan_mode_state_topic"`
+	FanModeStateTemplate string   `json:"fan_mode_state_template"`
+	FanModeCommandTopic  string   `json:"fan_mode_command_topic"`
+	FanModes             []string `json:"fan_modes"`
+
+	// The manual hold is offered as a "hold" preset.
+	PresetModeStateTopic    string   `json:"preset_mode_state_topic"`
+	PresetModeValueTemplate string   `json:"preset_mode_value_template"`
+	PresetModeCommandTopic  string   `json:"preset_mode_command_topic"`
+	PresetModes             []string `json:"preset_modes"`
+
+	ActionTopic    string `json:"action_topic"`
+	ActionTemplate string `json:"action_template"`
+
+	TemperatureUnit string  `json:"temperature_unit"`
+	MinTemp         float64 `json:"min_temp"`
+	MaxTemp         float64 `json:"max_temp"`
+	TempStep        float64 `json:"temp_step"`
+	Precision       float64 `json:"precision"`
+}
+
+type discoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+}
+
+type discoveryAvailability struct {
+	Topic string `json:"topic"`
+}
+
+// discoveryTopic returns where Home Assistant looks for a thermostat's
+// discovery config.
+func (b *Bridge) discoveryTopic(d *bridged) string {
+	return b.opts.DiscoveryPrefix + "/climate/" + b.objectID(d) + "/config"
+}
+
+// objectID identifies a thermostat to Home Assistant. The prefix keeps
+// two bridges on one broker apart.
+func (b *Bridge) objectID(d *bridged) string {
+	return Slug(b.opts.Prefix) + "_" + d.slug
+}
+
+// discovery returns the Home Assistant discovery config for a thermostat.
+// The thermostat's auto mode is Home Assistant's heat_cool.
+func (b *Bridge) discovery(d *bridged) discoveryConfig {
+	unit := b.opts.Unit
+	state := b.topic(d, "state")
+	id := b.objectID(d)
+
+	return discoveryConfig{
+		UniqueID: id,
+		ObjectID: id,
+		Device: discoveryDevice{
+			Identifiers:  []string{id},
+			Name:         d.Name,
+			Manufacturer: "Radio Thermostat",
+			Model:        "CT50",
+		},
+
+		Availability:     []discoveryAvailability{{Topic: b.statusTopic()}, {Topic: b.topic(d, "availability")}},
+		AvailabilityMode: "all",
+
+		CurrentTemperatureTopic:    state,
+		CurrentTemperatureTemplate: "{{ value_json.temp }}",
+
+		ModeStateTopic:    state,
+		ModeStateTemplate: "{{ 'heat_cool' if value_json.mode == 'auto' else value_json.mode }}",
+		ModeCommandTopic:  b.topic(d, "set/mode"),
+		Modes:             []string{"off", "heat", "cool", "heat_cool"},
+
+		TemperatureStateTopic:    state,
+		TemperatureStateTemplate: "{{ value_json.target }}",
+		TemperatureCommandTopic:  b.topic(d, "set/setpoint"),
+
+		TemperatureLowStateTopic:    state,
+		TemperatureLowStateTemplate: "{{ value_json.heat }}",
+		TemperatureLowCommandTopic:  b.topic(d, "set/heat"),
+
+		TemperatureHighStateTopic:    state,
+		TemperatureHighStateTemplate: "{{ value_json.cool }}",
+		TemperatureHighCommandTopic:  b.topic(d, "set/cool"),
+
+		FanModeStateTopic:    state,
+		FanModeStateTemplate: "{{ value_json.fan }}",
+		FanModeCommandTopic:  b.topic(d, "set/fan"),
+		FanModes:             []string{"auto", "circulate", "on"},
+
+		PresetModeStateTopic:    state,
+		PresetModeValueTemplate: "{{ 'hold' if value_json.hold else 'none' }}",
+		PresetModeCommandTopic:  b.topic(d, "set/hold"),
+		PresetModes:             []string{"hold"},
+
+		ActionTopic:    state,
+		ActionTemplate: "{{ value_json.action }}",
+
+		TemperatureUnit: string(unit),
+		MinTemp:         unit.Setpoint(MinSetpoint),
+		MaxTemp:         unit.Setpoint(MaxSetpoint),
+		TempStep:        ct50.SetpointStep,
+		Precision:       0.1,
+	}
+}
+
+// publishDiscovery announces a thermostat to Home Assistant.
+func (b *Bridge) publishDiscovery(d *bridged) error {
+	payload, err := json.Marshal(b.discovery(d))
+	if err != nil {
+		return err
+	}
+	return b.publish(b.discoveryTopic(d), payload)
+}
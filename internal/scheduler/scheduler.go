@@ -0,0 +1,262 @@
+// Package scheduler works out which setpoints a thermostat should have at
+// a given time from rules richer than the device program allows: any
+// number of periods a day, holidays, one-off exceptions for single dates
+// and holds until a set time. The webserver runs the rules and pushes the
+// setpoints to the thermostat.
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+// DateLayout is the format of the dates in Holidays and Exceptions.
+const DateLayout = "2006-01-02"
+
+// yearlyLayout is the format of holidays that fall on the same date every
+// year, such as "12-25".
+const yearlyLayout = "01-02"
+
+// ErrInvalidSchedule is wrapped by every error returned from
+// Schedule.Validate.
+var ErrInvalidSchedule = errors.New("scheduler: invalid schedule")
+
+// Period sets the heat and cool setpoints from At until the next period
+// starts. Setpoints are in degrees F.
+type Period struct {
+	At   string  `json:"at"`
+	Heat float64 `json:"heat"`
+	Cool float64 `json:"cool"`
+}
+
+// minute returns the period start in minutes after midnight. Periods are
+// checked by Validate, so a bad time only shows up in an unvalidated
+// schedule and is treated as midnight.
+func (p Period) minute() int {
+	m, _ := ct50.ParseClock(p.At)
+	return m
+}
+
+// Schedule is the rule set for one thermostat.
+type Schedule struct {
+	// Enabled turns the schedule on. A disabled schedule is kept but not
+	// run, and the thermostat follows its own program.
+	Enabled bool `json:"enabled"`
+
+	// Week holds each weekday's periods, keyed by the short day names in
+	// ct50.DayNames. A day may have any number of periods, in time order.
+	// A day with none keeps the setpoints from the day before.
+	Week map[string][]Period `json:"week"`
+
+	// Holidays are dates that follow the Holiday periods instead of their
+	// weekday's, either one date ("2024-11-28") or the same date every
+	// year ("12-25"). With no Holiday periods they follow Sunday's.
+	Holidays []string `json:"holidays,omitempty"`
+	Holiday  []Period `json:"holiday,omitempty"`
+
+	// Exceptions replace the periods for single dates, keyed by date
+	// ("2024-03-15"). They win over holidays.
+	Exceptions map[string][]Period `json:"exceptions,omitempty"`
+
+	// HoldUntil, when set, keeps the thermostat as it is, without
+	// following the schedule, until that time.
+	HoldUntil *time.Time `json:"holdUntil,omitempty"`
+}
+
+// Validate checks every period's time, order and setpoints. Cool must be
+// at least deadband above heat in every period, since auto mode uses both.
+func (s *Schedule) Validate(deadband float64) error {
+	for name := range s.Week {
+		if !isDayName(name) {
+			return fmt.Errorf("%w: unknown day %q (want one of %s)", ErrInvalidSchedule, name, strings.Join(ct50.DayNames[:], ", "))
+		}
+	}
+	for _, name := range ct50.DayNames {
+		if err := validatePeriods(name, s.Week[name], deadband); err != nil {
+			return err
+		}
+	}
+
+	for _, date := range s.Holidays {
+		if !validDate(date, DateLayout) && !validDate(date, yearlyLayout) {
+			return fmt.Errorf("%w: holiday %q is not a date (want 2006-01-02 or 01-02)", ErrInvalidSchedule, date)
+		}
+	}
+	if err := validatePeriods("holiday", s.Holiday, deadband); err != nil {
+		return err
+	}
+
+	for date, periods := range s.Exceptions {
+		if !validDate(date, DateLayout) {
+			return fmt.Errorf("%w: exception %q is not a date (want 2006-01-02)", ErrInvalidSchedule, date)
+		}
+		if err := validatePeriods(date, periods, deadband); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isDayName(name string) bool {
+	for _, day := range ct50.DayNames {
+		if name == day {
+			return true
+		}
+	}
+	return false
+}
+
+func validDate(s, layout string) bool {
+	_, err := time.Parse(layout, s)
+	return err == nil
+}
+
+func validatePeriods(name string, periods []Period, deadband float64) error {
+	last := -1
+	for i, p := range periods {
+		minute, err := ct50.ParseClock(p.At)
+		if err != nil {
+			return fmt.Errorf("%w: %s period %d: %v", ErrInvalidSchedule, name, i+1, err)
+		}
+		if minute <= last {
+			return fmt.Errorf("%w: %s period %d starts before the one before it", ErrInvalidSchedule, name, i+1)
+		}
+		last = minute
+
+		for _, temp := range []float64{p.Heat, p.Cool} {
+			if temp < ct50.MinProgramTemp || temp > ct50.MaxProgramTemp {
+				return fmt.Errorf("%w: %s period %d: setpoint %v out of range %d-%d", ErrInvalidSchedule, name, i+1, temp, ct50.MinProgramTemp, ct50.MaxProgramTemp)
+			}
+		}
+		if p.Cool-p.Heat < deadband {
+			return fmt.Errorf("%w: %s period %d: cool must be at least %v above heat", ErrInvalidSchedule, name, i+1, deadband)
+		}
+	}
+	return nil
+}
+
+// Convert returns a copy of s with every setpoint passed through fn, such
+// as Unit.Setpoint to show a schedule in Celsius.
+func (s *Schedule) Convert(fn func(float64) float64) *Schedule {
+	convert := func(periods []Period) []Period {
+		out := make([]Period, len(periods))
+		for i, p := range periods {
+			out[i] = Period{At: p.At, Heat: fn(p.Heat), Cool: fn(p.Cool)}
+		}
+		return out
+	}
+
+	out := *s
+	out.Week = make(map[string][]Period, len(s.Week))
+	for name, periods := range s.Week {
+		out.Week[name] = convert(periods)
+	}
+	out.Holiday = convert(s.Holiday)
+	if s.Exceptions != nil {
+		out.Exceptions = make(map[string][]Period, len(s.Exceptions))
+		for date, periods := range s.Exceptions {
+			out.Exceptions[date] = convert(periods)
+		}
+	}
+	out.Holidays = append([]string(nil), s.Holidays...)
+	return &out
+}
+
+// Held reports whether a hold keeps the schedule from running at t.
+func (s *Schedule) Held(t time.Time) bool {
+	return s.HoldUntil != nil && t.Before(*s.HoldUntil)
+}
+
+// IsHoliday reports whether the date of t is in Holidays.
+func (s *Schedule) IsHoliday(t time.Time) bool {
+	date, yearly := t.Format(DateLayout), t.Format(yearlyLayout)
+	for _, h := range s.Holidays {
+		if h == date || h == yearly {
+			return true
+		}
+	}
+	return false
+}
+
+// Day returns the periods that apply on the date of t: its exception if it
+// has one, the holiday periods on a holiday, or its weekday's periods.
+func (s *Schedule) Day(t time.Time) []Period {
+	if periods, ok := s.Exceptions[t.Format(DateLayout)]; ok {
+		return periods
+	}
+	if s.IsHoliday(t) {
+		if len(s.Holiday) > 0 {
+			return s.Holiday
+		}
+		return s.Week["sun"]
+	}
+	return s.Week[dayName(t)]
+}
+
+// dayName returns the ct50.DayNames entry for the weekday of t.
+func dayName(t time.Time) string {
+	return ct50.DayNames[(int(t.Weekday())+6)%7]
+}
+
+// Change is a period as it falls on a particular date.
+type Change struct {
+	Period
+	Start time.Time `json:"start"`
+}
+
+// searchDays is how far Current and Next look for a period. A week and a
+// day covers every weekday even when exceptions empty some dates.
+const searchDays = 8
+
+// Current returns the period in effect at t and when it started. A period
+// from an earlier day stays in effect until the next one starts, so ok is
+// false only when there are no periods in the days before t.
+func (s *Schedule) Current(t time.Time) (c Change, ok bool) {
+	for offset := 0; offset < searchDays; offset++ {
+		day := midnight(t).AddDate(0, 0, -offset)
+		periods := s.Day(day)
+		for i := len(periods) - 1; i >= 0; i-- {
+			start := clock(day, periods[i].minute())
+			if start.After(t) {
+				continue
+			}
+			return Change{Period: periods[i], Start: start}, true
+		}
+	}
+	return Change{}, false
+}
+
+// Next returns the first period that starts after t.
+func (s *Schedule) Next(t time.Time) (c Change, ok bool) {
+	for offset := 0; offset < searchDays; offset++ {
+		day := midnight(t).AddDate(0, 0, offset)
+		periods := s.Day(day)
+		starts := make([]time.Time, len(periods))
+		for i, p := range periods {
+			starts[i] = clock(day, p.minute())
+		}
+		i := sort.Search(len(starts), func(i int) bool { return starts[i].After(t) })
+		if i < len(starts) {
+			return Change{Period: periods[i], Start: starts[i]}, true
+		}
+	}
+	return Change{}, false
+}
+
+// midnight returns the start of the day t falls on, in t's location.
+func midnight(t time.Time) time.Time {
+	return clock(t, 0)
+}
+
+// clock returns the given minute after midnight on the day t falls on, by
+// the wall clock, so periods keep their times across daylight saving
+// changes.
+func clock(t time.Time, minute int) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, minute/60, minute%60, 0, 0, t.Location())
+}
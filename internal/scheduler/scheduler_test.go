@@ -0,0 +1,134 @@
+package scheduler
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func testSchedule() *Schedule {
+	workday := []Period{
+		{At: "06:00", Heat: 68, Cool: 76},
+		{At: "08:00", Heat: 62, Cool: 82},
+		{At: "12:00", Heat: 64, Cool: 80},
+		{At: "17:00", Heat: 69, Cool: 75},
+		{At: "22:30", Heat: 60, Cool: 78},
+	}
+	return &Schedule{
+		Enabled: true,
+		Week: map[string][]Period{
+			"mon": workday, "tue": workday, "wed": workday, "thu": workday, "fri": workday,
+			"sat": {{At: "08:00", Heat: 70, Cool: 74}, {At: "23:00", Heat: 61, Cool: 78}},
+		},
+		Holidays:   []string{"12-25", "2024-01-15"},
+		Holiday:    []Period{{At: "09:00", Heat: 71, Cool: 74}},
+		Exceptions: map[string][]Period{"2024-01-17": {{At: "10:00", Heat: 66, Cool: 79}}},
+	}
+}
+
+func at(s string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestCurrent(t *testing.T) {
+	s := testSchedule()
+	tests := []struct {
+		when  string
+		want  float64
+		start string
+	}{
+		// Monday 2024-01-01, a workday with five periods.
+		{"2024-01-01 07:00", 68, "2024-01-01 06:00"},
+		{"2024-01-01 12:00", 64, "2024-01-01 12:00"},
+		{"2024-01-01 23:59", 60, "2024-01-01 22:30"},
+		// Before the first period the day before carries on.
+		{"2024-01-02 05:00", 60, "2024-01-01 22:30"},
+		// Sunday has no periods, so Saturday night carries on all day.
+		{"2024-01-07 15:00", 61, "2024-01-06 23:00"},
+		// A dated holiday and a yearly one.
+		{"2024-01-15 10:00", 71, "2024-01-15 09:00"},
+		{"2024-12-25 10:00", 71, "2024-12-25 09:00"},
+		// An exception wins over the weekday.
+		{"2024-01-17 09:00", 60, "2024-01-16 22:30"},
+		{"2024-01-17 13:00", 66, "2024-01-17 10:00"},
+	}
+	for _, tt := range tests {
+		c, ok := s.Current(at(tt.when))
+		if !ok || c.Heat != tt.want || !c.Start.Equal(at(tt.start)) {
+			t.Errorf("Current(%s) = %v from %v, %v; want %v from %s", tt.when, c.Heat, c.Start, ok, tt.want, tt.start)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	s := testSchedule()
+	tests := []struct {
+		when, want string
+	}{
+		{"2024-01-01 06:00", "2024-01-01 08:00"},
+		{"2024-01-01 23:00", "2024-01-02 06:00"},
+		{"2024-01-06 23:30", "2024-01-08 06:00"},
+		{"2024-01-14 12:00", "2024-01-15 09:00"},
+	}
+	for _, tt := range tests {
+		c, ok := s.Next(at(tt.when))
+		if !ok || !c.Start.Equal(at(tt.want)) {
+			t.Errorf("Next(%s) = %v, %v; want %s", tt.when, c.Start, ok, tt.want)
+		}
+	}
+
+	if _, ok := (&Schedule{}).Current(at("2024-01-01 12:00")); ok {
+		t.Error("Current found a period in an empty schedule")
+	}
+}
+
+func TestHeld(t *testing.T) {
+	s := testSchedule()
+	until := at("2024-01-01 18:00")
+	s.HoldUntil = &until
+
+	if !s.Held(at("2024-01-01 17:59")) || s.Held(until) {
+		t.Error("hold should last until 18:00 and no longer")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := testSchedule().Validate(3); err != nil {
+		t.Fatalf("valid schedule: %v", err)
+	}
+
+	tests := []func(s *Schedule){
+		func(s *Schedule) { s.Week["monday"] = nil },
+		func(s *Schedule) { s.Week["mon"] = []Period{{At: "25:00", Heat: 68, Cool: 76}} },
+		func(s *Schedule) {
+			s.Week["mon"] = []Period{{At: "09:00", Heat: 68, Cool: 76}, {At: "08:00", Heat: 68, Cool: 76}}
+		},
+		func(s *Schedule) { s.Week["mon"] = []Period{{At: "09:00", Heat: 20, Cool: 76}} },
+		func(s *Schedule) { s.Week["mon"] = []Period{{At: "09:00", Heat: 72, Cool: 73}} },
+		func(s *Schedule) { s.Holidays = []string{"Christmas"} },
+		func(s *Schedule) { s.Exceptions["12-25"] = nil },
+	}
+	for i, breakIt := range tests {
+		s := testSchedule()
+		breakIt(s)
+		if err := s.Validate(3); !errors.Is(err, ErrInvalidSchedule) {
+			t.Errorf("case %d: Validate = %v, want ErrInvalidSchedule", i+1, err)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	s := testSchedule()
+	c := s.Convert(func(f float64) float64 { return f + 1 })
+
+	if c.Week["mon"][0].Heat != 69 || c.Holiday[0].Cool != 75 || c.Exceptions["2024-01-17"][0].Heat != 67 {
+		t.Errorf("Convert did not reach every period: %+v", c)
+	}
+	if s.Week["mon"][0].Heat != 68 {
+		t.Error("Convert changed the original schedule")
+	}
+}
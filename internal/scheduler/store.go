@@ -0,0 +1,63 @@
+package scheduler
+
+import (
+	"sync"
+
+	"github.com/EntropySynthetica/Thermostat/internal/statefile"
+)
+
+// DefaultPath returns where schedules are kept, next to the config file.
+func DefaultPath() string {
+	return statefile.Path("schedules.json")
+}
+
+// Store keeps each thermostat's schedule in a JSON file. The file can be
+// edited by hand while the webserver is stopped.
+type Store struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewStore returns a store backed by the file at path. The file is created
+// when the first schedule is saved.
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// All returns every schedule by thermostat name.
+func (s *Store) All() (map[string]*Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+// Get returns the schedule for the named thermostat, or nil if it has
+// none.
+func (s *Store) Get(device string) (*Schedule, error) {
+	all, err := s.All()
+	if err != nil {
+		return nil, err
+	}
+	return all[device], nil
+}
+
+// Put saves the schedule for the named thermostat.
+func (s *Store) Put(device string, sched *Schedule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return err
+	}
+	all[device] = sched
+	return statefile.Write(s.path, all)
+}
+
+func (s *Store) load() (map[string]*Schedule, error) {
+	all := make(map[string]*Schedule)
+	if err := statefile.Read(s.path, &all); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
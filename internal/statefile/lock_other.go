@@ -0,0 +1,9 @@
+//go:build !unix
+
+package statefile
+
+// lock does nothing where flock is not available; each store still locks
+// against itself within one process.
+func lock(path string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,105 @@
+// Package statefile reads and writes the small JSON files the thermostat
+// CLI and the webserver keep their shared state in, next to the config
+// file.
+package statefile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/EntropySynthetica/Thermostat/internal/config"
+)
+
+// Path returns the location of the named state file, in the same directory
+// as the default config file.
+func Path(name string) string {
+	return filepath.Join(config.Dir(), name)
+}
+
+// Read decodes the JSON file at path into v. A missing file is not an
+// error; v is left as it is.
+func Read(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("error reading %s: %w", path, err)
+	}
+	return nil
+}
+
+// Lock takes a lock on the state file at path that other processes
+// honour too, for a read, change and write that must not lose a change
+// they make in between. It returns a function that releases the lock.
+func Lock(path string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return lock(path + ".lock")
+}
+
+// Write encodes v as JSON to path, creating its directory if needed. The
+// file is written through a temporary file and a rename, so another
+// process never reads it half written.
+func Write(path string, v interface{}) error {
+	return write(path, v, 0644)
+}
+
+// WritePrivate is Write for files that hold secrets, such as sessions and
+// tokens. Only their owner can read them.
+func WritePrivate(path string, v interface{}) error {
+	return write(path, v, 0600)
+}
+
+func write(path string, v interface{}, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(v, "", " ")
+	if err != nil {
+		return err
+	}
+
+	// Each writer has a temporary file of its own, so two processes
+	// writing at once cannot mix their contents.
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if err := writeFile(f, data, perm); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// writeFile writes data to f with the given mode, and makes sure it is on
+// disk before f is closed.
+func writeFile(f *os.File, data []byte, perm os.FileMode) error {
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
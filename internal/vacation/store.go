@@ -0,0 +1,187 @@
+package vacation
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/internal/boost"
+	"github.com/EntropySynthetica/Thermostat/internal/statefile"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+// DefaultPath returns where vacations are kept, next to the config file.
+func DefaultPath() string {
+	return statefile.Path("vacations.json")
+}
+
+// Store keeps vacations in a JSON file, one per thermostat. Every call
+// reads the file afresh, so vacations set by another process are seen
+// straight away.
+type Store struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewStore returns a store backed by the file at path. The file is created
+// when the first vacation is saved.
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// Path returns the file the store is backed by.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// All returns every vacation by thermostat name, including ones that are
+// over but not yet ended.
+func (s *Store) All() (map[string]*Vacation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+// Get returns the vacation on the named thermostat, or nil if there is
+// none.
+func (s *Store) Get(device string) (*Vacation, error) {
+	all, err := s.All()
+	if err != nil {
+		return nil, err
+	}
+	return all[device], nil
+}
+
+// Put saves v, replacing any earlier vacation on the same thermostat.
+func (s *Store) Put(v *Vacation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return err
+	}
+	all[v.Device] = v
+	return statefile.Write(s.path, all)
+}
+
+// Delete forgets the vacation on the named thermostat.
+func (s *Store) Delete(device string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := all[device]; !ok {
+		return nil
+	}
+	delete(all, device)
+	return statefile.Write(s.path, all)
+}
+
+func (s *Store) load() (map[string]*Vacation, error) {
+	all := make(map[string]*Vacation)
+	if err := statefile.Read(s.path, &all); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+// Action is what Run did to a thermostat.
+type Action int
+
+const (
+	// Nothing means the thermostat was left as it was.
+	Nothing Action = iota
+
+	// Started means the away setpoints were applied for the first time.
+	Started
+
+	// Reheld means the away setpoints had been changed and were put back.
+	Reheld
+
+	// Ended means the vacation was over, the prior state was put back and
+	// the vacation was removed from the store.
+	Ended
+)
+
+// Set saves v and runs it at now, so a vacation that has already begun
+// starts straight away. If it replaces a vacation that has started, the
+// state from before that one is kept if v is under way, or put back now if
+// v has not begun yet.
+func (s *Store) Set(ctx context.Context, client *ct50.Client, boosts *boost.Store, v *Vacation, now time.Time) (Action, error) {
+	running, err := s.Get(v.Device)
+	if err != nil {
+		return Nothing, err
+	}
+	if running != nil && running.Started() {
+		if v.Away(now) {
+			v.Prior = running.Prior
+		} else if err := running.End(ctx, client); err != nil {
+			return Nothing, err
+		}
+	}
+
+	if err := s.Put(v); err != nil {
+		return Nothing, err
+	}
+	return s.Run(ctx, client, boosts, v, now)
+}
+
+// Run brings the thermostat in line with v at now. It starts v once it
+// begins, taking over from any boost, and holds the away setpoints while
+// it lasts. A boost started during the vacation is left to run. Once v is
+// over it ends it.
+func (s *Store) Run(ctx context.Context, client *ct50.Client, boosts *boost.Store, v *Vacation, now time.Time) (Action, error) {
+	if v.Over(now) {
+		if err := s.Finish(ctx, client, v); err != nil {
+			return Nothing, err
+		}
+		return Ended, nil
+	}
+	if !v.Away(now) {
+		return Nothing, nil
+	}
+
+	b, err := boosts.Get(v.Device)
+	if err != nil {
+		return Nothing, err
+	}
+
+	if v.Started() {
+		if b != nil {
+			return Nothing, nil
+		}
+		changed, err := v.Hold(ctx, client)
+		if err != nil || !changed {
+			return Nothing, err
+		}
+		return Reheld, nil
+	}
+
+	// A boost from before the vacation would otherwise be taken as the
+	// state to put back.
+	if b != nil {
+		if _, err := boosts.Finish(ctx, client, b); err != nil {
+			return Nothing, err
+		}
+	}
+	if err := v.Start(ctx, client); err != nil {
+		return Nothing, err
+	}
+	if err := s.Put(v); err != nil {
+		return Nothing, err
+	}
+	return Started, nil
+}
+
+// Finish ends v and removes it from the store. If the thermostat cannot be
+// reached v stays in the store, so ending it can be tried again later.
+func (s *Store) Finish(ctx context.Context, client *ct50.Client, v *Vacation) error {
+	if err := v.End(ctx, client); err != nil {
+		return err
+	}
+	return s.Delete(v.Device)
+}
@@ -0,0 +1,167 @@
+// Package vacation holds a thermostat at away setpoints for a date range
+// and puts back what it was doing before once the range is over. Recovery
+// can start early, so the house is comfortable again by the time everyone
+// gets home.
+//
+// Vacations are kept in a small JSON file shared by the thermostat CLI and
+// the webserver, like boosts. The webserver starts and ends them on time.
+package vacation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+// ErrInvalidVacation is wrapped by every error returned from
+// Vacation.Validate.
+var ErrInvalidVacation = errors.New("vacation: invalid vacation")
+
+// timeLayouts are the forms ParseTime accepts, besides RFC 3339. The
+// second is what an HTML datetime-local input sends.
+var timeLayouts = []string{
+	"2006-01-02",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+}
+
+// ParseTime reads a date, a date and time, or an RFC 3339 time. Times
+// without a zone are in loc, and a date on its own means midnight at the
+// start of that day.
+func ParseTime(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("vacation: %q is not a date (want 2006-01-02, 2006-01-02 15:04 or RFC 3339)", s)
+}
+
+// Vacation holds one thermostat at away setpoints from From until To.
+type Vacation struct {
+	// Device is the name of the thermostat in the config file.
+	Device string `json:"device"`
+
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+
+	// Heat and Cool are the away setpoints, in degrees F. Only the ones
+	// the thermostat's mode uses are set.
+	Heat float64 `json:"heat"`
+	Cool float64 `json:"cool"`
+
+	// Recovery is how long before To the away setpoints are dropped, so
+	// the house has time to get back to temperature.
+	Recovery time.Duration `json:"recovery,omitempty"`
+
+	// Prior is the state to put back when the vacation ends. It is nil
+	// until the vacation starts.
+	Prior *ct50.Snapshot `json:"prior,omitempty"`
+}
+
+// Validate checks the range and setpoints of v at now. Cool must be at
+// least deadband above heat, since auto mode uses both.
+func (v *Vacation) Validate(deadband float64, now time.Time) error {
+	if !v.To.After(v.From) {
+		return fmt.Errorf("%w: the end must be after the start", ErrInvalidVacation)
+	}
+	if !v.To.After(now) {
+		return fmt.Errorf("%w: the end is already past", ErrInvalidVacation)
+	}
+	if v.Recovery < 0 || !v.RecoverAt().After(v.From) {
+		return fmt.Errorf("%w: recovery must be shorter than the vacation", ErrInvalidVacation)
+	}
+	for _, temp := range []float64{v.Heat, v.Cool} {
+		if temp < ct50.MinProgramTemp || temp > ct50.MaxProgramTemp {
+			return fmt.Errorf("%w: setpoint %v out of range %d-%d", ErrInvalidVacation, temp, ct50.MinProgramTemp, ct50.MaxProgramTemp)
+		}
+	}
+	if v.Cool-v.Heat < deadband {
+		return fmt.Errorf("%w: cool must be at least %v above heat", ErrInvalidVacation, deadband)
+	}
+	return nil
+}
+
+// RecoverAt returns when the away setpoints are dropped.
+func (v *Vacation) RecoverAt() time.Time {
+	return v.To.Add(-v.Recovery)
+}
+
+// Away reports whether the away setpoints should be held at now.
+func (v *Vacation) Away(now time.Time) bool {
+	return !now.Before(v.From) && now.Before(v.RecoverAt())
+}
+
+// Over reports whether the vacation, recovery included, has begun at now,
+// so the thermostat should be back as it was.
+func (v *Vacation) Over(now time.Time) bool {
+	return !now.Before(v.RecoverAt())
+}
+
+// Started reports whether the away setpoints have been applied.
+func (v *Vacation) Started() bool {
+	return v.Prior != nil
+}
+
+// Start records the thermostat's state and applies the away setpoints.
+func (v *Vacation) Start(ctx context.Context, client *ct50.Client) error {
+	stats, err := client.Status(ctx)
+	if err != nil {
+		return err
+	}
+	prior := stats.Snapshot()
+
+	if _, err := v.Hold(ctx, client); err != nil {
+		return err
+	}
+	v.Prior = &prior
+	return nil
+}
+
+// Hold puts the away setpoints for the thermostat's current mode back,
+// with the hold on, if anything has moved them. It reports whether it
+// changed anything.
+func (v *Vacation) Hold(ctx context.Context, client *ct50.Client) (changed bool, err error) {
+	stats, err := client.Status(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	// The hold goes with every change, since a setpoint set without one
+	// only lasts until the next program period.
+	heat, cool, hold := v.Heat, v.Cool, ct50.On
+	u := ct50.Update{Hold: &hold}
+	changed = stats.Hold != ct50.On
+	if (stats.Tmode == ct50.ModeHeat || stats.Tmode == ct50.ModeAuto) && stats.THeat != heat {
+		u.THeat, changed = &heat, true
+	}
+	if (stats.Tmode == ct50.ModeCool || stats.Tmode == ct50.ModeAuto) && stats.TCool != cool {
+		u.TCool, changed = &cool, true
+	}
+	if !changed {
+		return false, nil
+	}
+
+	if _, err := client.UpdateAndVerify(ctx, u); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// End puts back the state from before the vacation started. It does
+// nothing for a vacation that never started.
+func (v *Vacation) End(ctx context.Context, client *ct50.Client) error {
+	if !v.Started() {
+		return nil
+	}
+	if err := client.Restore(ctx, *v.Prior); err != nil {
+		return fmt.Errorf("vacation: restoring %s: %w", v.Device, err)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package vacation
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/internal/boost"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50sim"
+)
+
+func newSim(t *testing.T) (*ct50sim.Device, *ct50.Client) {
+	t.Helper()
+
+	dev := ct50sim.New(ct50sim.Options{})
+	srv := httptest.NewServer(dev)
+	t.Cleanup(srv.Close)
+	return dev, ct50.New(srv.URL)
+}
+
+func newStores(t *testing.T) (*Store, *boost.Store) {
+	dir := t.TempDir()
+	return NewStore(filepath.Join(dir, "vacations.json")), boost.NewStore(filepath.Join(dir, "boosts.json"))
+}
+
+func TestRun(t *testing.T) {
+	dev, client := newSim(t)
+	store, boosts := newStores(t)
+	ctx := context.Background()
+
+	if err := client.SetRange(ctx, 68, 76); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	v := &Vacation{Device: "den", From: now.Add(time.Hour), To: now.Add(48 * time.Hour), Heat: 55, Cool: 85, Recovery: 2 * time.Hour}
+	if action, err := store.Set(ctx, client, boosts, v, now); err != nil || action != Nothing {
+		t.Fatalf("Set before the start = %v, %v, want Nothing", action, err)
+	}
+	if stats := dev.Status(); stats.THeat != 68 {
+		t.Fatalf("before the vacation heat = %v, want 68", stats.THeat)
+	}
+
+	away := now.Add(2 * time.Hour)
+	if action, err := store.Run(ctx, client, boosts, v, away); err != nil || action != Started {
+		t.Fatalf("Run = %v, %v, want Started", action, err)
+	}
+	if stats := dev.Status(); stats.THeat != 55 || stats.TCool != 85 || stats.Hold != ct50.On {
+		t.Fatalf("away = %v-%v hold %v, want 55-85 hold on", stats.THeat, stats.TCool, stats.Hold)
+	}
+	if saved, _ := store.Get("den"); saved == nil || !saved.Started() {
+		t.Fatalf("stored vacation = %+v, want started", saved)
+	}
+
+	// A change at the wall is put back.
+	if err := client.SetHeat(ctx, 70); err != nil {
+		t.Fatal(err)
+	}
+	if action, err := store.Run(ctx, client, boosts, v, away); err != nil || action != Reheld {
+		t.Fatalf("Run after a wall change = %v, %v, want Reheld", action, err)
+	}
+	if stats := dev.Status(); stats.THeat != 55 {
+		t.Fatalf("after Reheld heat = %v, want 55", stats.THeat)
+	}
+
+	// A boost during the vacation is left to run.
+	b, err := boost.Start(ctx, client, "den", ct50.ModeHeat, 72, away.Add(time.Hour), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := boosts.Put(b); err != nil {
+		t.Fatal(err)
+	}
+	if action, err := store.Run(ctx, client, boosts, v, away); err != nil || action != Nothing {
+		t.Fatalf("Run during a boost = %v, %v, want Nothing", action, err)
+	}
+	if _, err := boosts.Finish(ctx, client, b); err != nil {
+		t.Fatal(err)
+	}
+
+	// Recovery starts two hours before the end.
+	if action, err := store.Run(ctx, client, boosts, v, v.To.Add(-time.Hour)); err != nil || action != Ended {
+		t.Fatalf("Run in recovery = %v, %v, want Ended", action, err)
+	}
+	if stats := dev.Status(); stats.Tmode != ct50.ModeAuto || stats.Hold != ct50.Off || stats.THeat == 55 {
+		t.Errorf("after the vacation = %v %v-%v hold %v, want Auto on the program", stats.Tmode, stats.THeat, stats.TCool, stats.Hold)
+	}
+	if saved, _ := store.Get("den"); saved != nil {
+		t.Errorf("vacation still stored after it ended: %+v", saved)
+	}
+}
+
+func TestStartEndsBoost(t *testing.T) {
+	dev, client := newSim(t)
+	store, boosts := newStores(t)
+	ctx := context.Background()
+
+	if err := client.SetMode(ctx, ct50.ModeHeat); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetHeat(ctx, 67); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetHold(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	b, err := boost.Start(ctx, client, "den", ct50.ModeOff, 74, now.Add(time.Hour), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := boosts.Put(b); err != nil {
+		t.Fatal(err)
+	}
+
+	v := &Vacation{Device: "den", From: now, To: now.Add(24 * time.Hour), Heat: 58, Cool: 82}
+	if action, err := store.Set(ctx, client, boosts, v, now); err != nil || action != Started {
+		t.Fatalf("Set = %v, %v, want Started", action, err)
+	}
+	if running, _ := boosts.Get("den"); running != nil {
+		t.Errorf("boost still running after the vacation started")
+	}
+	if v.Prior.THeat != 67 {
+		t.Errorf("prior heat = %v, want 67 from before the boost", v.Prior.THeat)
+	}
+
+	// Cancelling puts back the state from before the boost.
+	if err := store.Finish(ctx, client, v); err != nil {
+		t.Fatal(err)
+	}
+	if stats := dev.Status(); stats.THeat != 67 || stats.Hold != ct50.On {
+		t.Errorf("after cancel = %v hold %v, want 67 hold on", stats.THeat, stats.Hold)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Date(2024, 12, 1, 12, 0, 0, 0, time.UTC)
+	from := time.Date(2024, 12, 20, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 12, 28, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		v    Vacation
+		ok   bool
+	}{
+		{"ok", Vacation{From: from, To: to, Heat: 55, Cool: 85, Recovery: 3 * time.Hour}, true},
+		{"backwards", Vacation{From: to, To: from, Heat: 55, Cool: 85}, false},
+		{"past", Vacation{From: from.AddDate(0, -1, 0), To: to.AddDate(0, -1, 0), Heat: 55, Cool: 85}, false},
+		{"long recovery", Vacation{From: from, To: to, Heat: 55, Cool: 85, Recovery: 9 * 24 * time.Hour}, false},
+		{"too cold", Vacation{From: from, To: to, Heat: 30, Cool: 85}, false},
+		{"deadband", Vacation{From: from, To: to, Heat: 70, Cool: 71}, false},
+	}
+	for _, tt := range tests {
+		err := tt.v.Validate(3, now)
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrInvalidVacation) {
+			t.Errorf("%s: err = %v, want ErrInvalidVacation", tt.name, err)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	loc := time.FixedZone("test", -5*3600)
+	tests := map[string]time.Time{
+		"2024-12-20":                time.Date(2024, 12, 20, 0, 0, 0, 0, loc),
+		"2024-12-20T08:30":          time.Date(2024, 12, 20, 8, 30, 0, 0, loc),
+		"2024-12-20 08:30":          time.Date(2024, 12, 20, 8, 30, 0, 0, loc),
+		"2024-12-20T08:30:00-05:00": time.Date(2024, 12, 20, 8, 30, 0, 0, loc),
+	}
+	for s, want := range tests {
+		got, err := ParseTime(s, loc)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("ParseTime(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+	if _, err := ParseTime("next week", loc); err == nil {
+		t.Error("ParseTime(\"next week\") succeeded")
+	}
+}
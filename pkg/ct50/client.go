@@ -0,0 +1,211 @@
+// Package ct50 is a client for the local HTTP API of Radio Thermostat CT50
+// thermostats.
+//
+// A Client talks to a single device. Every call takes a context so callers
+// can bound how long they are willing to wait on a slow thermostat, and
+// failures are reported as errors (see StatusError and DeviceError) rather
+// than by exiting the process.
+//
+// CT50s are flaky: they drop connections, answer with HTTP 500 or garbled
+// JSON, and sometimes report a temperature of -1. Clients created with New
+// retry those failures a few times before giving up.
+package ct50
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout is the HTTP timeout used by clients created with New.
+// The CT50 is slow to answer, so this is intentionally generous.
+const DefaultTimeout = 15 * time.Second
+
+// DefaultDeadband is the smallest gap, in degrees F, that clients created
+// with New allow between the heat and cool setpoints in auto mode.
+const DefaultDeadband = 3.0
+
+// DefaultRetries and DefaultRetryDelay are the retry settings used by
+// clients created with New.
+const (
+	DefaultRetries    = 2
+	DefaultRetryDelay = 500 * time.Millisecond
+)
+
+// Client is a connection to one CT50 thermostat.
+type Client struct {
+	// BaseURL is the root of the thermostat API, e.g. "http://192.168.1.100".
+	BaseURL string
+
+	// HTTPClient is used for all requests. It defaults to a client with
+	// DefaultTimeout.
+	HTTPClient *http.Client
+
+	// Retries is how many more times a request is tried after a failure
+	// the thermostat may recover from: a dropped connection, an HTTP 5xx,
+	// an unreadable reply or a missing temperature reading. A request the
+	// thermostat rejects with an error object is never retried.
+	Retries int
+
+	// RetryDelay is the wait before the first retry. It doubles with each
+	// further attempt.
+	RetryDelay time.Duration
+
+	// Deadband is the smallest gap SetRange allows between the heat and
+	// cool setpoints, so that heating and cooling do not fight each other.
+	Deadband float64
+
+	// Observe, if set, is called after every attempt at a request with
+	// how long it took and the error it failed with, if any. Each retry
+	// is a separate attempt. It is meant for metrics and must not block.
+	Observe func(method, path string, took time.Duration, err error)
+
+	// Audit, if set, is called for every request that changes the
+	// thermostat, with its path and JSON body. It must call send, which
+	// makes the request with its retries, and return send's error. It is
+	// meant for recording who changed what.
+	Audit func(ctx context.Context, path string, body []byte, send func() error) error
+}
+
+// New returns a Client for the thermostat at addr. addr may be a bare host
+// or IP ("192.168.1.100"), a host:port pair, or a full http:// URL.
+func New(addr string) *Client {
+	base := strings.TrimRight(addr, "/")
+	if !strings.HasPrefix(base, "http://") && !strings.HasPrefix(base, "https://") {
+		base = "http://" + base
+	}
+
+	return &Client{
+		BaseURL:    base,
+		HTTPClient: &http.Client{Timeout: DefaultTimeout},
+		Retries:    DefaultRetries,
+		RetryDelay: DefaultRetryDelay,
+		Deadband:   DefaultDeadband,
+	}
+}
+
+// get fetches path and decodes the JSON reply into v.
+func (c *Client) get(ctx context.Context, path string, v interface{}) error {
+	return c.do(ctx, http.MethodGet, path, nil, v)
+}
+
+// post sends body as JSON to path. The reply is checked for an error
+// object and, if v is non-nil, decoded into v.
+func (c *Client) post(ctx context.Context, path string, body interface{}, v interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("ct50: encode %s: %w", path, err)
+	}
+
+	send := func() error { return c.do(ctx, http.MethodPost, path, payload, v) }
+	if c.Audit != nil {
+		return c.Audit(ctx, path, payload, send)
+	}
+	return send()
+}
+
+// do sends the request, retrying failures that may be transient.
+func (c *Client) do(ctx context.Context, method, path string, payload []byte, v interface{}) error {
+	delay := c.RetryDelay
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		retry, err := c.once(ctx, method, path, payload, v)
+		if c.Observe != nil {
+			c.Observe(method, path, time.Since(start), err)
+		}
+		if err == nil || !retry || attempt >= c.Retries || ctx.Err() != nil {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// once makes a single attempt at the request. retry reports whether a
+// failure is one worth trying again.
+func (c *Client) once(ctx context.Context, method, path string, payload []byte, v interface{}) (retry bool, err error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return false, fmt.Errorf("ct50: %s %s: %w", method, path, err)
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("ct50: %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return true, fmt.Errorf("ct50: %s %s: read reply: %w", method, path, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode >= 500, &StatusError{Method: method, Path: path, Code: resp.StatusCode}
+	}
+
+	if err := checkReply(path, data); err != nil {
+		return false, err
+	}
+
+	if v == nil {
+		return false, nil
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return true, fmt.Errorf("ct50: %s %s: decode reply: %w", method, path, err)
+	}
+
+	// Replies that decode but carry a bad reading are retried too.
+	if r, ok := v.(interface{ check() error }); ok {
+		if err := r.check(); err != nil {
+			return true, err
+		}
+	}
+
+	return false, nil
+}
+
+// checkReply looks for the {"error": ...} object the thermostat uses to
+// reject a request.
+func checkReply(path string, data []byte) error {
+	var reply struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(data, &reply) != nil || reply.Error == nil {
+		return nil
+	}
+
+	reason := string(reply.Error)
+	var s string
+	if json.Unmarshal(reply.Error, &s) == nil {
+		reason = s
+	}
+
+	return &DeviceError{Path: path, Reason: reason}
+}
@@ -0,0 +1,185 @@
+package ct50
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SearchTarget is the SSDP search target Radio Thermostats answer to.
+const SearchTarget = "com.marvell.wm.system:1.0"
+
+// DefaultDiscoveryAddr is the standard SSDP multicast group.
+const DefaultDiscoveryAddr = "239.255.255.250:1900"
+
+// DefaultDiscoveryTimeout is how long Discover listens for replies when no
+// timeout is given.
+const DefaultDiscoveryTimeout = 3 * time.Second
+
+// verifyTimeout bounds how long each candidate gets to answer as a
+// thermostat, so one unresponsive device cannot stall discovery.
+const verifyTimeout = 5 * time.Second
+
+// DiscoverOptions controls Discover. The zero value probes the standard
+// multicast group for DefaultDiscoveryTimeout.
+type DiscoverOptions struct {
+	// Addr is where the search probe is sent. It defaults to
+	// DefaultDiscoveryAddr.
+	Addr string
+
+	// Timeout is how long to listen for replies.
+	Timeout time.Duration
+}
+
+// Discovered is a thermostat that answered the search probe and was then
+// confirmed by reading /tstat/model and /sys.
+type Discovered struct {
+	// Addr is the host or host:port to pass to New.
+	Addr      string
+	Model     string
+	UUID      string
+	Name      string
+	FWVersion string
+}
+
+// Discover sends an SSDP search for Radio Thermostats and returns every
+// device that replied and answered /tstat/model and /sys. Replies from
+// anything that does not answer as a thermostat are dropped.
+func Discover(ctx context.Context, opts DiscoverOptions) ([]Discovered, error) {
+	if opts.Addr == "" {
+		opts.Addr = DefaultDiscoveryAddr
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultDiscoveryTimeout
+	}
+
+	candidates, err := search(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []Discovered
+	for _, addr := range candidates {
+		dev, err := verify(ctx, addr)
+		if err != nil {
+			continue
+		}
+		found = append(found, *dev)
+	}
+
+	return found, nil
+}
+
+// search sends the probe and collects the host of every LOCATION header
+// in the replies, without duplicates.
+func search(ctx context.Context, opts DiscoverOptions) ([]string, error) {
+	dst, err := net.ResolveUDPAddr("udp4", opts.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("ct50: discovery address: %w", err)
+	}
+
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, fmt.Errorf("ct50: discovery: %w", err)
+	}
+	defer conn.Close()
+
+	probe := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + DefaultDiscoveryAddr + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n" +
+		"ST: " + SearchTarget + "\r\n" +
+		"\r\n"
+
+	// UDP is lossy, so send the probe twice.
+	for i := 0; i < 2; i++ {
+		if _, err := conn.WriteToUDP([]byte(probe), dst); err != nil {
+			return nil, fmt.Errorf("ct50: discovery: %w", err)
+		}
+	}
+
+	deadline := time.Now().Add(opts.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+
+	seen := make(map[string]bool)
+	var hosts []string
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// The read deadline ends the search.
+			break
+		}
+
+		host, ok := parseSearchReply(buf[:n])
+		if !ok || seen[host] {
+			continue
+		}
+		seen[host] = true
+		hosts = append(hosts, host)
+	}
+
+	sort.Strings(hosts)
+	return hosts, nil
+}
+
+// parseSearchReply extracts the host from the LOCATION header of an SSDP
+// search reply.
+func parseSearchReply(data []byte) (string, bool) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), nil)
+	if err != nil {
+		return "", false
+	}
+	resp.Body.Close()
+
+	if st := resp.Header.Get("ST"); st != "" && !strings.EqualFold(st, SearchTarget) {
+		return "", false
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || location.Host == "" {
+		return "", false
+	}
+
+	return location.Host, true
+}
+
+// verify confirms a candidate is a thermostat by reading its model and
+// system information.
+func verify(ctx context.Context, addr string) (*Discovered, error) {
+	ctx, cancel := context.WithTimeout(ctx, verifyTimeout)
+	defer cancel()
+
+	client := New(addr)
+
+	model, err := client.Model(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := client.SysInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Older firmware has no /sys/name, so the name is optional.
+	name, _ := client.Name(ctx)
+
+	return &Discovered{
+		Addr:      addr,
+		Model:     model,
+		UUID:      info.UUID,
+		Name:      name,
+		FWVersion: info.FWVersion,
+	}, nil
+}
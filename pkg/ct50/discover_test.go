@@ -0,0 +1,133 @@
+package ct50
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeResponder answers SSDP searches on a local UDP port with one reply per
+// location, the way thermostats on the LAN answer the multicast probe.
+func fakeResponder(t *testing.T, locations ...string) string {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			req := string(buf[:n])
+			if !strings.HasPrefix(req, "M-SEARCH") || !strings.Contains(req, "ST: "+SearchTarget) {
+				continue
+			}
+			for _, location := range locations {
+				reply := "HTTP/1.1 200 OK\r\n" +
+					"CACHE-CONTROL: max-age=300\r\n" +
+					"ST: " + SearchTarget + "\r\n" +
+					"LOCATION: " + location + "\r\n" +
+					"\r\n"
+				conn.WriteToUDP([]byte(reply), from)
+			}
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func fakeThermostat(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tstat/model", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"model":"CT50 V1.94"}`))
+	})
+	mux.HandleFunc("/sys", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"uuid":"5cdad4000001","api_version":113,"fw_version":"1.04.84","wlan_fw_version":"v10.105576"}`))
+	})
+	mux.HandleFunc("/sys/name", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"name":"Hallway"}`))
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDiscover(t *testing.T) {
+	thermostat := fakeThermostat(t)
+
+	// A UPnP device that replies to the probe but is not a thermostat.
+	other := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(other.Close)
+
+	addr := fakeResponder(t,
+		thermostat.URL+"/sys",
+		thermostat.URL+"/sys", // duplicate replies are common
+		other.URL+"/description.xml",
+	)
+
+	found, err := Discover(context.Background(), DiscoverOptions{Addr: addr, Timeout: 500 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(found) != 1 {
+		t.Fatalf("found %d devices, want 1: %+v", len(found), found)
+	}
+
+	want := Discovered{
+		Addr:      strings.TrimPrefix(thermostat.URL, "http://"),
+		Model:     "CT50 V1.94",
+		UUID:      "5cdad4000001",
+		Name:      "Hallway",
+		FWVersion: "1.04.84",
+	}
+	if found[0] != want {
+		t.Errorf("found %+v, want %+v", found[0], want)
+	}
+}
+
+func TestDiscoverNoReplies(t *testing.T) {
+	addr := fakeResponder(t)
+
+	found, err := Discover(context.Background(), DiscoverOptions{Addr: addr, Timeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 0 {
+		t.Errorf("found %+v, want nothing", found)
+	}
+}
+
+func TestParseSearchReply(t *testing.T) {
+	tests := []struct {
+		reply string
+		host  string
+		ok    bool
+	}{
+		{"HTTP/1.1 200 OK\r\nST: " + SearchTarget + "\r\nLOCATION: http://192.168.1.20/sys\r\n\r\n", "192.168.1.20", true},
+		{"HTTP/1.1 200 OK\r\nLOCATION: http://192.168.1.21:8080/sys\r\n\r\n", "192.168.1.21:8080", true},
+		{"HTTP/1.1 200 OK\r\nST: upnp:rootdevice\r\nLOCATION: http://192.168.1.22/\r\n\r\n", "", false},
+		{"HTTP/1.1 200 OK\r\nST: " + SearchTarget + "\r\n\r\n", "", false},
+		{"garbage", "", false},
+	}
+
+	for _, tt := range tests {
+		host, ok := parseSearchReply([]byte(tt.reply))
+		if host != tt.host || ok != tt.ok {
+			t.Errorf("parseSearchReply(%q) = %q, %v; want %q, %v", tt.reply, host, ok, tt.host, tt.ok)
+		}
+	}
+}
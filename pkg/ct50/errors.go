@@ -0,0 +1,61 @@
+package ct50
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNoTarget is returned by SetTarget when the thermostat is in a mode
+// that has no single setpoint to change. Use SetRange in auto mode.
+var ErrNoTarget = errors.New("ct50: thermostat must be in heat or cool mode to set temperature")
+
+// ErrNoReading is returned by Status when the thermostat keeps reporting a
+// temperature of -1, which it does while its sensor reading is stale.
+var ErrNoReading = errors.New("ct50: thermostat has no temperature reading")
+
+// StatusError is returned when the thermostat answers with a non-200 HTTP
+// status.
+type StatusError struct {
+	Method string
+	Path   string
+	Code   int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("ct50: %s %s: unexpected HTTP status %d", e.Method, e.Path, e.Code)
+}
+
+// DeviceError is returned when the thermostat rejects a request with an
+// {"error": ...} reply.
+type DeviceError struct {
+	Path   string
+	Reason string
+}
+
+func (e *DeviceError) Error() string {
+	return fmt.Sprintf("ct50: %s: device returned error: %s", e.Path, e.Reason)
+}
+
+// VerifyError is returned by UpdateAndVerify when the thermostat accepted
+// a change but reading the status back shows a different value.
+type VerifyError struct {
+	Field string
+	Want  string
+	Got   string
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("ct50: %s did not take effect: wanted %s, thermostat reports %s", e.Field, e.Want, e.Got)
+}
+
+// DeadbandError is returned by SetRange when the cool setpoint is not far
+// enough above the heat setpoint.
+type DeadbandError struct {
+	Heat float64
+	Cool float64
+	Min  float64
+}
+
+func (e *DeadbandError) Error() string {
+	return fmt.Sprintf("ct50: cool setpoint %s must be at least %s degrees above heat setpoint %s", formatTemp(e.Cool), formatTemp(e.Min), formatTemp(e.Heat))
+}
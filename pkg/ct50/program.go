@@ -0,0 +1,244 @@
+package ct50
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Limits the thermostat enforces on a program.
+const (
+	MaxPeriods     = 4
+	MinProgramTemp = 35
+	MaxProgramTemp = 95
+	minutesPerDay  = 24 * 60
+)
+
+// ErrInvalidProgram is wrapped by every error returned from Program.Validate.
+var ErrInvalidProgram = errors.New("ct50: invalid program")
+
+// ProgramMode selects the heat or cool program.
+type ProgramMode string
+
+const (
+	ProgramHeat ProgramMode = "heat"
+	ProgramCool ProgramMode = "cool"
+)
+
+// ParseProgramMode converts "heat" or "cool" to a ProgramMode.
+func ParseProgramMode(s string) (ProgramMode, error) {
+	switch ProgramMode(strings.ToLower(s)) {
+	case ProgramHeat:
+		return ProgramHeat, nil
+	case ProgramCool:
+		return ProgramCool, nil
+	default:
+		return "", fmt.Errorf("ct50: unknown program %q (want heat or cool)", s)
+	}
+}
+
+// DayNames are the short weekday names the thermostat uses, indexed the
+// same way as Program and Time.Day (Monday first).
+var DayNames = [7]string{"mon", "tue", "wed", "thu", "fri", "sat", "sun"}
+
+// ParseDay converts a short or long weekday name to its index in a Program.
+func ParseDay(s string) (int, error) {
+	s = strings.ToLower(s)
+	for i, name := range DayNames {
+		if len(s) >= 3 && strings.HasPrefix(s, name) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("ct50: unknown day %q", s)
+}
+
+// Period is one program entry: from Minute (minutes after midnight) until
+// the next period, the setpoint is Temp.
+type Period struct {
+	Minute int
+	Temp   float64
+}
+
+// Clock formats the period start as HH:MM.
+func (p Period) Clock() string {
+	return fmt.Sprintf("%02d:%02d", p.Minute/60, p.Minute%60)
+}
+
+// ParseClock converts HH:MM to minutes after midnight.
+func ParseClock(s string) (int, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("ct50: invalid time %q (want HH:MM)", s)
+	}
+	h, err := strconv.Atoi(parts[0])
+	if err != nil || h < 0 || h > 23 {
+		return 0, fmt.Errorf("ct50: invalid time %q (want HH:MM)", s)
+	}
+	m, err := strconv.Atoi(parts[1])
+	if err != nil || m < 0 || m > 59 {
+		return 0, fmt.Errorf("ct50: invalid time %q (want HH:MM)", s)
+	}
+	return h*60 + m, nil
+}
+
+// Day is the list of periods for one day, in start-time order.
+type Day []Period
+
+// Program is a 7-day heat or cool program. Index 0 is Monday.
+//
+// It marshals to and from the thermostat's own format, an object keyed by
+// day number whose values are flat [minute, temp, minute, temp, ...] arrays.
+type Program [7]Day
+
+// Validate checks p against the limits the thermostat enforces.
+func (p *Program) Validate() error {
+	for d, day := range p {
+		if err := day.validate(DayNames[d]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (day Day) validate(name string) error {
+	if len(day) == 0 {
+		return fmt.Errorf("%w: %s has no periods", ErrInvalidProgram, name)
+	}
+	if len(day) > MaxPeriods {
+		return fmt.Errorf("%w: %s has %d periods, at most %d are allowed", ErrInvalidProgram, name, len(day), MaxPeriods)
+	}
+	for i, period := range day {
+		if period.Minute < 0 || period.Minute >= minutesPerDay {
+			return fmt.Errorf("%w: %s period %d starts at minute %d", ErrInvalidProgram, name, i+1, period.Minute)
+		}
+		if i > 0 && period.Minute <= day[i-1].Minute {
+			return fmt.Errorf("%w: %s period %d (%s) does not start after period %d (%s)", ErrInvalidProgram, name, i+1, period.Clock(), i, day[i-1].Clock())
+		}
+		if period.Temp < MinProgramTemp || period.Temp > MaxProgramTemp {
+			return fmt.Errorf("%w: %s period %d setpoint %s is outside %d-%d", ErrInvalidProgram, name, i+1, formatTemp(period.Temp), MinProgramTemp, MaxProgramTemp)
+		}
+	}
+	return nil
+}
+
+// MarshalJSON encodes p in the thermostat's format.
+func (p Program) MarshalJSON() ([]byte, error) {
+	out := make(map[string][]float64, len(p))
+	for d, day := range p {
+		out[strconv.Itoa(d)] = day.flatten()
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON decodes p from the thermostat's format.
+func (p *Program) UnmarshalJSON(data []byte) error {
+	var in map[string][]float64
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+
+	var prog Program
+	for key, values := range in {
+		d, err := strconv.Atoi(key)
+		if err != nil || d < 0 || d >= len(prog) {
+			return fmt.Errorf("ct50: program has unknown day %q", key)
+		}
+		if len(values)%2 != 0 {
+			return fmt.Errorf("ct50: program day %s has an odd number of values", key)
+		}
+		for i := 0; i < len(values); i += 2 {
+			prog[d] = append(prog[d], Period{Minute: int(values[i]), Temp: values[i+1]})
+		}
+	}
+
+	*p = prog
+	return nil
+}
+
+func (d Day) flatten() []float64 {
+	values := make([]float64, 0, 2*len(d))
+	for _, period := range d {
+		values = append(values, float64(period.Minute), period.Temp)
+	}
+	return values
+}
+
+// Program returns the heat or cool program from GET /tstat/program/{mode}.
+func (c *Client) Program(ctx context.Context, mode ProgramMode) (*Program, error) {
+	var prog Program
+	if err := c.get(ctx, "/tstat/program/"+string(mode), &prog); err != nil {
+		return nil, err
+	}
+	return &prog, nil
+}
+
+// SetProgram validates prog and writes it as the heat or cool program.
+func (c *Client) SetProgram(ctx context.Context, mode ProgramMode, prog *Program) error {
+	if err := prog.Validate(); err != nil {
+		return err
+	}
+	return c.post(ctx, "/tstat/program/"+string(mode), prog, nil)
+}
+
+// SetProgramDay writes a single day of the heat or cool program.
+func (c *Client) SetProgramDay(ctx context.Context, mode ProgramMode, day int, periods Day) error {
+	if day < 0 || day >= len(DayNames) {
+		return fmt.Errorf("%w: day %d out of range", ErrInvalidProgram, day)
+	}
+
+	if err := periods.validate(DayNames[day]); err != nil {
+		return err
+	}
+
+	body := map[string][]float64{strconv.Itoa(day): periods.flatten()}
+	return c.post(ctx, "/tstat/program/"+string(mode)+"/"+DayNames[day], body, nil)
+}
+
+// Next returns the first period that starts after t, along with the day it
+// falls on. It looks up to a week ahead, so ok is false only when the
+// program has no periods at all.
+func (p *Program) Next(t Time) (day int, period Period, ok bool) {
+	now := t.Hour*60 + t.Minute
+	for offset := 0; offset <= len(p); offset++ {
+		d := (t.Day + offset) % len(p)
+		for _, candidate := range p[d] {
+			if offset == 0 && candidate.Minute <= now {
+				continue
+			}
+			return d, candidate, true
+		}
+	}
+	return 0, Period{}, false
+}
+
+// Current returns the period in effect at t and the day it started on. A
+// period started late on an earlier day stays in effect until the next
+// one begins, so ok is false only when the program has no periods at all.
+func (p *Program) Current(t Time) (day int, period Period, ok bool) {
+	now := t.Hour*60 + t.Minute
+	for offset := 0; offset <= len(p); offset++ {
+		d := ((t.Day-offset)%len(p) + len(p)) % len(p)
+		for i := len(p[d]) - 1; i >= 0; i-- {
+			if offset == 0 && p[d][i].Minute > now {
+				continue
+			}
+			return d, p[d][i], true
+		}
+	}
+	return 0, Period{}, false
+}
+
+// Convert returns a copy of p with every period temperature passed through
+// fn, such as Unit.Setpoint to show a program in Celsius.
+func (p *Program) Convert(fn func(float64) float64) *Program {
+	var out Program
+	for d, day := range p {
+		for _, period := range day {
+			out[d] = append(out[d], Period{Minute: period.Minute, Temp: fn(period.Temp)})
+		}
+	}
+	return &out
+}
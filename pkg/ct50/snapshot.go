@@ -0,0 +1,65 @@
+package ct50
+
+import "context"
+
+// Snapshot is the part of a thermostat's state that temporary changes such
+// as a boost or a vacation hold touch: the mode, setpoints and hold.
+type Snapshot struct {
+	Tmode Mode    `json:"tmode"`
+	THeat float64 `json:"t_heat"`
+	TCool float64 `json:"t_cool"`
+	Hold  OnOff   `json:"hold"`
+}
+
+// Snapshot records the parts of s that Restore puts back.
+func (s *Status) Snapshot() Snapshot {
+	return Snapshot{
+		Tmode: s.Tmode,
+		THeat: s.THeat,
+		TCool: s.TCool,
+		Hold:  s.Hold,
+	}
+}
+
+// Restore puts the thermostat back in the mode and hold recorded in snap.
+// Without a hold the thermostat was following its program, which has
+// likely moved on since the snapshot, so the setpoints come from the
+// program period in effect now rather than from snap.
+func (c *Client) Restore(ctx context.Context, snap Snapshot) error {
+	heat, cool := snap.THeat, snap.TCool
+	if snap.Hold == Off {
+		stats, err := c.Status(ctx)
+		if err != nil {
+			return err
+		}
+		heat = c.programTemp(ctx, ProgramHeat, stats.Time, heat)
+		cool = c.programTemp(ctx, ProgramCool, stats.Time, cool)
+	}
+
+	u := Update{Tmode: &snap.Tmode, Hold: &snap.Hold}
+	switch snap.Tmode {
+	case ModeHeat:
+		u.THeat = &heat
+	case ModeCool:
+		u.TCool = &cool
+	case ModeAuto:
+		u.THeat, u.TCool = &heat, &cool
+	}
+
+	_, err := c.UpdateAndVerify(ctx, u)
+	return err
+}
+
+// programTemp returns the setpoint of the program period in effect at t,
+// or fallback if the program cannot be read.
+func (c *Client) programTemp(ctx context.Context, mode ProgramMode, t Time, fallback float64) float64 {
+	prog, err := c.Program(ctx, mode)
+	if err != nil {
+		return fallback
+	}
+	_, period, ok := prog.Current(t)
+	if !ok {
+		return fallback
+	}
+	return period.Temp
+}
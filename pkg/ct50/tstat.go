@@ -0,0 +1,177 @@
+package ct50
+
+import "context"
+
+// Status returns the current thermostat status from GET /tstat.
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	var stats Status
+	if err := c.get(ctx, "/tstat", &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// Update sends a partial change to POST /tstat.
+func (c *Client) Update(ctx context.Context, u Update) error {
+	return c.post(ctx, "/tstat", u, nil)
+}
+
+// SetMode sets the operating mode.
+func (c *Client) SetMode(ctx context.Context, mode Mode) error {
+	return c.Update(ctx, Update{Tmode: &mode})
+}
+
+// SetFanMode sets the fan mode.
+func (c *Client) SetFanMode(ctx context.Context, fan FanMode) error {
+	return c.Update(ctx, Update{Fmode: &fan})
+}
+
+// SetHold turns the permanent hold on or off.
+func (c *Client) SetHold(ctx context.Context, hold bool) error {
+	h := OnOffFrom(hold)
+	return c.Update(ctx, Update{Hold: &h})
+}
+
+// SetHeat sets the heating setpoint and switches the thermostat to heat.
+func (c *Client) SetHeat(ctx context.Context, temp float64) error {
+	mode := ModeHeat
+	return c.Update(ctx, Update{Tmode: &mode, THeat: &temp})
+}
+
+// SetCool sets the cooling setpoint and switches the thermostat to cool.
+func (c *Client) SetCool(ctx context.Context, temp float64) error {
+	mode := ModeCool
+	return c.Update(ctx, Update{Tmode: &mode, TCool: &temp})
+}
+
+// SetTarget changes the setpoint for whichever of heat or cool the
+// thermostat is currently in. It returns ErrNoTarget in any other mode.
+func (c *Client) SetTarget(ctx context.Context, temp float64) error {
+	stats, err := c.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch stats.Tmode {
+	case ModeHeat:
+		return c.SetHeat(ctx, temp)
+	case ModeCool:
+		return c.SetCool(ctx, temp)
+	default:
+		return ErrNoTarget
+	}
+}
+
+// SetRange sets the heat and cool setpoints and switches the thermostat to
+// auto, where it heats below heat and cools above cool. It returns a
+// DeadbandError unless cool is at least c.Deadband above heat.
+func (c *Client) SetRange(ctx context.Context, heat, cool float64) error {
+	if cool-heat < c.Deadband {
+		return &DeadbandError{Heat: heat, Cool: cool, Min: c.Deadband}
+	}
+
+	mode := ModeAuto
+	return c.Update(ctx, Update{Tmode: &mode, THeat: &heat, TCool: &cool})
+}
+
+// Model returns the thermostat model string from GET /tstat/model.
+func (c *Client) Model(ctx context.Context) (string, error) {
+	var m Model
+	if err := c.get(ctx, "/tstat/model", &m); err != nil {
+		return "", err
+	}
+	return m.Model, nil
+}
+
+// SysInfo returns firmware and identity details from GET /sys.
+func (c *Client) SysInfo(ctx context.Context) (*SysInfo, error) {
+	var info SysInfo
+	if err := c.get(ctx, "/sys", &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Name returns the user-assigned device name from GET /sys/name.
+func (c *Client) Name(ctx context.Context) (string, error) {
+	var reply struct {
+		Name string `json:"name"`
+	}
+	if err := c.get(ctx, "/sys/name", &reply); err != nil {
+		return "", err
+	}
+	return reply.Name, nil
+}
+
+// SetName changes the user-assigned device name.
+func (c *Client) SetName(ctx context.Context, name string) error {
+	return c.post(ctx, "/sys/name", map[string]string{"name": name}, nil)
+}
+
+// Humidity returns the relative humidity from GET /tstat/humidity. Models
+// without a humidity sensor report -1.
+func (c *Client) Humidity(ctx context.Context) (float64, error) {
+	var reply struct {
+		Humidity float64 `json:"humidity"`
+	}
+	if err := c.get(ctx, "/tstat/humidity", &reply); err != nil {
+		return 0, err
+	}
+	return reply.Humidity, nil
+}
+
+// DataLog returns today's and yesterday's HVAC run time.
+func (c *Client) DataLog(ctx context.Context) (*DataLog, error) {
+	var dl DataLog
+	if err := c.get(ctx, "/tstat/datalog", &dl); err != nil {
+		return nil, err
+	}
+	return &dl, nil
+}
+
+// SetTime sets the thermostat clock.
+func (c *Client) SetTime(ctx context.Context, t Time) error {
+	return c.post(ctx, "/tstat", map[string]Time{"time": t}, nil)
+}
+
+// SetRemoteTemp makes the thermostat use temp as its sensor reading
+// instead of the built-in sensor.
+func (c *Client) SetRemoteTemp(ctx context.Context, temp float64) error {
+	return c.post(ctx, "/tstat/remote_temp", map[string]float64{"rem_temp": temp}, nil)
+}
+
+// ClearRemoteTemp returns the thermostat to its built-in sensor.
+func (c *Client) ClearRemoteTemp(ctx context.Context) error {
+	return c.post(ctx, "/tstat/remote_temp", map[string]int{"rem_mode": 0}, nil)
+}
+
+// SetMessage shows msg on the thermostat's price message area (line 0-3).
+func (c *Client) SetMessage(ctx context.Context, line int, msg string) error {
+	return c.post(ctx, "/tstat/pma", map[string]interface{}{"mode": 2, "line": line, "message": msg}, nil)
+}
+
+// ClearMessage clears the price message area.
+func (c *Client) ClearMessage(ctx context.Context) error {
+	return c.post(ctx, "/tstat/pma", map[string]int{"mode": 0}, nil)
+}
+
+// SetSaveEnergy turns the energy-saving setback on or off.
+func (c *Client) SetSaveEnergy(ctx context.Context, on bool) error {
+	return c.post(ctx, "/tstat/save_energy", map[string]OnOff{"mode": OnOffFrom(on)}, nil)
+}
+
+// UpdateAndVerify sends u and then reads the status back to confirm that
+// every field in u took effect. A field that did not is reported as a
+// *VerifyError. The status read back is returned either way.
+func (c *Client) UpdateAndVerify(ctx context.Context, u Update) (*Status, error) {
+	if err := c.Update(ctx, u); err != nil {
+		return nil, err
+	}
+
+	stats, err := c.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, u.verify(stats)
+}
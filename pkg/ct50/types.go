@@ -0,0 +1,271 @@
+package ct50
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Mode is the thermostat operating mode (the tmode field).
+type Mode int
+
+const (
+	ModeOff Mode = iota
+	ModeHeat
+	ModeCool
+	ModeAuto
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeOff:
+		return "Off"
+	case ModeHeat:
+		return "Heat"
+	case ModeCool:
+		return "Cool"
+	case ModeAuto:
+		return "Auto"
+	default:
+		return "Unknown"
+	}
+}
+
+// Valid reports whether m is a mode the thermostat accepts.
+func (m Mode) Valid() bool {
+	return m >= ModeOff && m <= ModeAuto
+}
+
+// ParseMode converts a mode name such as "heat" to a Mode.
+func ParseMode(s string) (Mode, error) {
+	switch strings.ToLower(s) {
+	case "off":
+		return ModeOff, nil
+	case "heat":
+		return ModeHeat, nil
+	case "cool":
+		return ModeCool, nil
+	case "auto":
+		return ModeAuto, nil
+	default:
+		return 0, fmt.Errorf("ct50: unknown mode %q (want off, heat, cool or auto)", s)
+	}
+}
+
+// FanMode is the fan setting (the fmode field).
+type FanMode int
+
+const (
+	FanAuto FanMode = iota
+	FanCirculate
+	FanOn
+)
+
+func (f FanMode) String() string {
+	switch f {
+	case FanAuto:
+		return "Auto"
+	case FanCirculate:
+		return "Circulate"
+	case FanOn:
+		return "On"
+	default:
+		return "Unknown"
+	}
+}
+
+// Valid reports whether f is a fan mode the thermostat accepts.
+func (f FanMode) Valid() bool {
+	return f >= FanAuto && f <= FanOn
+}
+
+// ParseFanMode converts a fan mode name such as "circulate" to a FanMode.
+func ParseFanMode(s string) (FanMode, error) {
+	switch strings.ToLower(s) {
+	case "auto":
+		return FanAuto, nil
+	case "circulate":
+		return FanCirculate, nil
+	case "on":
+		return FanOn, nil
+	default:
+		return 0, fmt.Errorf("ct50: unknown fan mode %q (want auto, circulate or on)", s)
+	}
+}
+
+// State is what the HVAC system is doing right now (the tstate field).
+type State int
+
+const (
+	StateOff State = iota
+	StateHeating
+	StateCooling
+)
+
+func (s State) String() string {
+	switch s {
+	case StateOff:
+		return "Off"
+	case StateHeating:
+		return "Heating"
+	case StateCooling:
+		return "Cooling"
+	default:
+		return "Unknown"
+	}
+}
+
+// OnOff is a boolean flag as the thermostat reports it: 0 for off, 1 for on.
+// It is used for the hold, override and fstate fields.
+type OnOff int
+
+const (
+	Off OnOff = 0
+	On  OnOff = 1
+)
+
+func (o OnOff) String() string {
+	switch o {
+	case Off:
+		return "Off"
+	case On:
+		return "On"
+	default:
+		return "Unknown"
+	}
+}
+
+// Bool reports whether o is On.
+func (o OnOff) Bool() bool {
+	return o == On
+}
+
+// OnOffFrom converts a bool to an OnOff.
+func OnOffFrom(b bool) OnOff {
+	if b {
+		return On
+	}
+	return Off
+}
+
+// ParseOnOff converts "on" or "off" to an OnOff.
+func ParseOnOff(s string) (OnOff, error) {
+	switch strings.ToLower(s) {
+	case "off":
+		return Off, nil
+	case "on":
+		return On, nil
+	default:
+		return 0, fmt.Errorf("ct50: unknown value %q (want on or off)", s)
+	}
+}
+
+// Time is the thermostat's clock. Day runs from 0 (Monday) to 6 (Sunday).
+type Time struct {
+	Day    int `json:"day"`
+	Hour   int `json:"hour"`
+	Minute int `json:"minute"`
+}
+
+// Status is the reply from GET /tstat.
+type Status struct {
+	Temp      float64 `json:"temp"`
+	Tmode     Mode    `json:"tmode"`
+	Fmode     FanMode `json:"fmode"`
+	Override  OnOff   `json:"override"`
+	Hold      OnOff   `json:"hold"`
+	THeat     float64 `json:"t_heat"`
+	TCool     float64 `json:"t_cool"`
+	Tstate    State   `json:"tstate"`
+	Fstate    OnOff   `json:"fstate"`
+	Time      Time    `json:"time"`
+	TTypePost int     `json:"t_type_post"`
+}
+
+// Target returns the setpoint for the current mode: THeat in heat and
+// TCool in cool. It returns 0 in off, and in auto, where the thermostat
+// holds the temperature between THeat and TCool.
+func (s *Status) Target() float64 {
+	switch s.Tmode {
+	case ModeHeat:
+		return s.THeat
+	case ModeCool:
+		return s.TCool
+	default:
+		return 0
+	}
+}
+
+// check rejects the -1 temperature the thermostat reports while it has no
+// fresh sensor reading.
+func (s *Status) check() error {
+	if s.Temp == -1 {
+		return ErrNoReading
+	}
+	return nil
+}
+
+// Update is a partial change sent with POST /tstat. Only non-nil fields are
+// sent to the thermostat.
+type Update struct {
+	Tmode *Mode    `json:"tmode,omitempty"`
+	Fmode *FanMode `json:"fmode,omitempty"`
+	Hold  *OnOff   `json:"hold,omitempty"`
+	THeat *float64 `json:"t_heat,omitempty"`
+	TCool *float64 `json:"t_cool,omitempty"`
+}
+
+// Model is the reply from GET /tstat/model.
+type Model struct {
+	Model string `json:"model"`
+}
+
+// SysInfo is the reply from GET /sys.
+type SysInfo struct {
+	UUID          string `json:"uuid"`
+	APIVersion    int    `json:"api_version"`
+	FWVersion     string `json:"fw_version"`
+	WLANFWVersion string `json:"wlan_fw_version"`
+}
+
+// Runtime is an amount of HVAC run time as reported in the data log.
+type Runtime struct {
+	Hour   int `json:"hour"`
+	Minute int `json:"minute"`
+}
+
+// DayLog is one day of HVAC run time.
+type DayLog struct {
+	HeatRuntime Runtime `json:"heat_runtime"`
+	CoolRuntime Runtime `json:"cool_runtime"`
+}
+
+// DataLog is the reply from GET /tstat/datalog.
+type DataLog struct {
+	Today     DayLog `json:"today"`
+	Yesterday DayLog `json:"yesterday"`
+}
+
+// verify checks that every field set in u matches stats.
+func (u Update) verify(stats *Status) error {
+	if u.Tmode != nil && *u.Tmode != stats.Tmode {
+		return &VerifyError{Field: "tmode", Want: u.Tmode.String(), Got: stats.Tmode.String()}
+	}
+	if u.Fmode != nil && *u.Fmode != stats.Fmode {
+		return &VerifyError{Field: "fmode", Want: u.Fmode.String(), Got: stats.Fmode.String()}
+	}
+	if u.Hold != nil && *u.Hold != stats.Hold {
+		return &VerifyError{Field: "hold", Want: u.Hold.String(), Got: stats.Hold.String()}
+	}
+	if u.THeat != nil && *u.THeat != stats.THeat {
+		return &VerifyError{Field: "t_heat", Want: formatTemp(*u.THeat), Got: formatTemp(stats.THeat)}
+	}
+	if u.TCool != nil && *u.TCool != stats.TCool {
+		return &VerifyError{Field: "t_cool", Want: formatTemp(*u.TCool), Got: formatTemp(stats.TCool)}
+	}
+	return nil
+}
+
+func formatTemp(t float64) string {
+	return strconv.FormatFloat(t, 'f', -1, 64)
+}
@@ -0,0 +1,110 @@
+package ct50
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Unit is a temperature scale for showing and entering temperatures. The
+// thermostat itself always works in degrees Fahrenheit, so every
+// conversion to or from it goes through a Unit. The zero value is
+// Fahrenheit.
+type Unit string
+
+const (
+	Fahrenheit Unit = "F"
+	Celsius    Unit = "C"
+)
+
+// Resolution is the smallest setpoint step the thermostat accepts, in
+// degrees F.
+const Resolution = 0.5
+
+// SetpointStep is the step setpoints are shown and entered in, in either
+// unit. A half degree Celsius is almost a whole degree Fahrenheit, so
+// every half-degree Celsius setpoint survives the trip to the thermostat
+// and back.
+const SetpointStep = 0.5
+
+// ParseUnit parses "F", "C", "fahrenheit" or "celsius", in any case.
+func ParseUnit(s string) (Unit, error) {
+	switch strings.ToLower(strings.TrimPrefix(s, "°")) {
+	case "f", "fahrenheit":
+		return Fahrenheit, nil
+	case "c", "celsius":
+		return Celsius, nil
+	default:
+		return "", fmt.Errorf("unknown temperature unit %q (want F or C)", s)
+	}
+}
+
+// Symbol returns "°F" or "°C".
+func (u Unit) Symbol() string {
+	if u == Celsius {
+		return "°C"
+	}
+	return "°F"
+}
+
+// Reading converts a temperature reading from degrees F to u, to one
+// decimal place.
+func (u Unit) Reading(f float64) float64 {
+	return round(u.fromF(f), 0.1)
+}
+
+// Setpoint converts a setpoint from degrees F to u, to the nearest
+// SetpointStep.
+func (u Unit) Setpoint(f float64) float64 {
+	return round(u.fromF(f), SetpointStep)
+}
+
+// ToDevice converts a temperature in u to degrees F, to the nearest
+// Resolution the thermostat accepts.
+func (u Unit) ToDevice(v float64) float64 {
+	if u == Celsius {
+		v = v*9/5 + 32
+	}
+	return round(v, Resolution)
+}
+
+// Delta converts a temperature difference, such as a deadband, from
+// degrees F to u, to one decimal place.
+func (u Unit) Delta(f float64) float64 {
+	if u == Celsius {
+		f = f * 5 / 9
+	}
+	return round(f, 0.1)
+}
+
+// DeltaToDevice converts a temperature difference in u to degrees F,
+// rounded down to the thermostat's Resolution.
+func (u Unit) DeltaToDevice(v float64) float64 {
+	if u == Celsius {
+		v = v * 9 / 5
+	}
+	// The slack absorbs float error in the conversion, so a difference
+	// that should land exactly on a step is not rounded down past it.
+	return math.Floor(v/Resolution+1e-9) * Resolution
+}
+
+// Format formats a temperature that is already in u with its symbol, such
+// as "21.5°C".
+func (u Unit) Format(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64) + u.Symbol()
+}
+
+func (u Unit) fromF(f float64) float64 {
+	if u == Celsius {
+		return (f - 32) * 5 / 9
+	}
+	return f
+}
+
+// round rounds v to the nearest multiple of step. Dividing by the inverse
+// keeps results such as 21.4 free of float noise.
+func round(v, step float64) float64 {
+	inv := 1 / step
+	return math.Round(v*inv) / inv
+}
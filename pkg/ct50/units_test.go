@@ -0,0 +1,66 @@
+package ct50
+
+import "testing"
+
+func TestUnitConversions(t *testing.T) {
+	tests := []struct {
+		unit     Unit
+		device   float64 // degrees F
+		reading  float64
+		setpoint float64
+	}{
+		{Fahrenheit, 71.5, 71.5, 71.5},
+		{Fahrenheit, 70.24, 70.2, 70},
+		{Celsius, 70, 21.1, 21},
+		{Celsius, 70.5, 21.4, 21.5},
+		{Celsius, 32, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.unit.Reading(tt.device); got != tt.reading {
+			t.Errorf("%s.Reading(%v) = %v, want %v", tt.unit, tt.device, got, tt.reading)
+		}
+		if got := tt.unit.Setpoint(tt.device); got != tt.setpoint {
+			t.Errorf("%s.Setpoint(%v) = %v, want %v", tt.unit, tt.device, got, tt.setpoint)
+		}
+	}
+}
+
+func TestHalfDegreeCelsiusRoundTrip(t *testing.T) {
+	// Every half-degree Celsius setpoint in the thermostat's range must come
+	// back unchanged after rounding to the device's half-degree F.
+	for c := 2.0; c <= 35; c += SetpointStep {
+		f := Celsius.ToDevice(c)
+		if f != round(f, Resolution) {
+			t.Fatalf("ToDevice(%v°C) = %v, not a multiple of %v", c, f, Resolution)
+		}
+		if back := Celsius.Setpoint(f); back != c {
+			t.Errorf("%v°C -> %v°F -> %v°C", c, f, back)
+		}
+	}
+}
+
+func TestDeltas(t *testing.T) {
+	if got := Celsius.Delta(3); got != 1.7 {
+		t.Errorf("Celsius.Delta(3) = %v, want 1.7", got)
+	}
+	if got := Celsius.DeltaToDevice(1.5); got != 2.5 {
+		t.Errorf("Celsius.DeltaToDevice(1.5) = %v, want 2.5", got)
+	}
+	if got := Celsius.DeltaToDevice(5.0 / 3); got != 3 {
+		t.Errorf("Celsius.DeltaToDevice(5/3) = %v, want 3", got)
+	}
+	if got := Fahrenheit.DeltaToDevice(3); got != 3 {
+		t.Errorf("Fahrenheit.DeltaToDevice(3) = %v, want 3", got)
+	}
+}
+
+func TestParseUnit(t *testing.T) {
+	for in, want := range map[string]Unit{"F": Fahrenheit, "c": Celsius, "Celsius": Celsius, "°F": Fahrenheit} {
+		if got, err := ParseUnit(in); err != nil || got != want {
+			t.Errorf("ParseUnit(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := ParseUnit("K"); err == nil {
+		t.Error("ParseUnit(K) succeeded")
+	}
+}
@@ -0,0 +1,42 @@
+package ct50sim
+
+import (
+	"sync"
+	"time"
+)
+
+// Accelerated returns a clock for Options.Now that starts at start and
+// runs speed times faster than real time. A speed of 1440 passes a
+// simulated day in a minute.
+func Accelerated(start time.Time, speed float64) func() time.Time {
+	begin := time.Now()
+	return func() time.Time {
+		return start.Add(time.Duration(float64(time.Since(begin)) * speed))
+	}
+}
+
+// ManualClock is a clock that only moves when told to, for stepping a
+// Device through simulated time in tests.
+type ManualClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+// NewManualClock returns a clock stopped at start.
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{t: start}
+}
+
+// Now returns the clock's current time. Pass it as Options.Now.
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+// Advance moves the clock forward by d.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
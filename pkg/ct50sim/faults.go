@@ -0,0 +1,294 @@
+package ct50sim
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FaultAction is how a request goes wrong when a Fault fires.
+type FaultAction string
+
+const (
+	// FaultNone only delays the reply by the fault's latency.
+	FaultNone FaultAction = ""
+
+	// FaultReset drops the connection without replying.
+	FaultReset FaultAction = "reset"
+
+	// FaultMalformed handles the request but cuts the JSON reply short.
+	FaultMalformed FaultAction = "malformed"
+
+	// FaultStatus answers with an HTTP error status, 500 by default.
+	FaultStatus FaultAction = "status"
+
+	// FaultError rejects the request with an {"error": ...} reply.
+	FaultError FaultAction = "error"
+
+	// FaultStale reports a temperature of -1 from GET /tstat, as the
+	// thermostat does while its sensor reading is stale.
+	FaultStale FaultAction = "stale"
+)
+
+// Scenario scripts faults into an emulated thermostat's replies. It is
+// usually loaded from a YAML file:
+//
+//	seed: 1
+//	faults:
+//	  - path: /tstat
+//	    method: GET
+//	    count: 2
+//	    action: status
+//	    status: 500
+//	  - latency: 3s
+//	    jitter: 2s
+//	    probability: 0.1
+type Scenario struct {
+	// Seed makes random faults repeatable. Zero picks a different seed
+	// every run.
+	Seed int64 `yaml:"seed"`
+
+	Faults []Fault `yaml:"faults"`
+}
+
+// Fault is one rule in a Scenario. Each request is checked against the
+// rules in order, and the first one that fires decides how the request
+// goes wrong.
+type Fault struct {
+	// Path limits the rule to request paths that start with it, such as
+	// "/tstat". Empty matches every path.
+	Path string `yaml:"path"`
+
+	// Method limits the rule to one HTTP method. Empty matches any.
+	Method string `yaml:"method"`
+
+	// After lets the first After matching requests through untouched.
+	After int `yaml:"after"`
+
+	// Count retires the rule once it has fired Count times. Zero means
+	// no limit.
+	Count int `yaml:"count"`
+
+	// Probability is the chance that the rule fires on a matching
+	// request. Zero means it always fires.
+	Probability float64 `yaml:"probability"`
+
+	// Latency delays the reply, plus a random extra of up to Jitter.
+	Latency time.Duration `yaml:"latency"`
+	Jitter  time.Duration `yaml:"jitter"`
+
+	Action FaultAction `yaml:"action"`
+
+	// Status is the HTTP status sent by FaultStatus.
+	Status int `yaml:"status"`
+
+	// Error is the reason given by FaultError. It defaults to "busy".
+	Error string `yaml:"error"`
+
+	seen  int
+	fired int
+}
+
+// ParseScenario reads a scenario from YAML.
+func ParseScenario(data []byte) (*Scenario, error) {
+	var s Scenario
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("ct50sim: scenario: %w", err)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// LoadScenario reads a scenario from a YAML file.
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ct50sim: scenario: %w", err)
+	}
+	return ParseScenario(data)
+}
+
+// Validate checks every rule in the scenario.
+func (s *Scenario) Validate() error {
+	for i, f := range s.Faults {
+		switch f.Action {
+		case FaultNone, FaultReset, FaultMalformed, FaultStatus, FaultError, FaultStale:
+		default:
+			return fmt.Errorf("ct50sim: fault %d: unknown action %q", i+1, f.Action)
+		}
+		if f.Probability < 0 || f.Probability > 1 {
+			return fmt.Errorf("ct50sim: fault %d: probability must be between 0 and 1", i+1)
+		}
+		if f.After < 0 || f.Count < 0 || f.Latency < 0 || f.Jitter < 0 {
+			return fmt.Errorf("ct50sim: fault %d: after, count, latency and jitter cannot be negative", i+1)
+		}
+		if f.Action == FaultStatus && f.Status != 0 && (f.Status < 100 || f.Status > 599) {
+			return fmt.Errorf("ct50sim: fault %d: invalid status %d", i+1, f.Status)
+		}
+	}
+	return nil
+}
+
+// SetScenario replaces the device's fault scenario and resets its rule
+// counters. A nil scenario turns fault injection off.
+func (d *Device) SetScenario(s *Scenario) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.faults = nil
+	if s == nil {
+		return
+	}
+
+	d.faults = append([]Fault(nil), s.Faults...)
+	for i := range d.faults {
+		d.faults[i].seen, d.faults[i].fired = 0, 0
+	}
+
+	seed := s.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	d.rand = rand.New(rand.NewSource(seed))
+}
+
+// Fired returns how many times each rule in the current scenario has
+// fired, in scenario order.
+func (d *Device) Fired() []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	counts := make([]int, len(d.faults))
+	for i, f := range d.faults {
+		counts[i] = f.fired
+	}
+	return counts
+}
+
+// pickFault returns the first rule that fires for r, or nil. Callers must
+// hold d.mu.
+func (d *Device) pickFault(r *http.Request) *Fault {
+	for i := range d.faults {
+		f := &d.faults[i]
+		if !strings.HasPrefix(r.URL.Path, f.Path) || (f.Method != "" && !strings.EqualFold(f.Method, r.Method)) {
+			continue
+		}
+		if f.Count > 0 && f.fired >= f.Count {
+			continue
+		}
+
+		f.seen++
+		if f.seen <= f.After {
+			continue
+		}
+		if f.Probability > 0 && d.rand.Float64() >= f.Probability {
+			continue
+		}
+
+		f.fired++
+		fired := *f
+		if f.Jitter > 0 {
+			fired.Latency += time.Duration(d.rand.Int63n(int64(f.Jitter)))
+		}
+		d.logf("fault %d: %s %s: %s", i+1, r.Method, r.URL.Path, describe(fired))
+		return &fired
+	}
+	return nil
+}
+
+func describe(f Fault) string {
+	action := string(f.Action)
+	switch f.Action {
+	case FaultNone:
+		action = "delay"
+	case FaultStatus:
+		action = fmt.Sprintf("status %d", f.statusCode())
+	}
+	if f.Latency > 0 {
+		action += fmt.Sprintf(" after %v", f.Latency)
+	}
+	return action
+}
+
+func (f *Fault) statusCode() int {
+	if f.Status == 0 {
+		return http.StatusInternalServerError
+	}
+	return f.Status
+}
+
+// sleep waits out a fault's latency, giving up if the client goes away.
+func sleep(r *http.Request, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+
+	// The server only notices a client hanging up once the request body
+	// has been read, so read it up front.
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// resetConnection drops the client's connection without a reply. On TCP
+// the close is sent as a reset rather than a clean shutdown.
+func resetConnection(w http.ResponseWriter) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		panic(http.ErrAbortHandler)
+	}
+
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// truncatedWriter collects a reply so that only the first half of its body
+// is sent, leaving the client with JSON it cannot parse.
+type truncatedWriter struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (t *truncatedWriter) Header() http.Header         { return t.header }
+func (t *truncatedWriter) Write(b []byte) (int, error) { return t.body.Write(b) }
+func (t *truncatedWriter) WriteHeader(code int)        { t.code = code }
+
+func (t *truncatedWriter) flush(w http.ResponseWriter) {
+	for k, v := range t.header {
+		w.Header()[k] = v
+	}
+	if t.code != 0 {
+		w.WriteHeader(t.code)
+	}
+	body := bytes.TrimSpace(t.body.Bytes())
+	w.Write(body[:len(body)/2])
+}
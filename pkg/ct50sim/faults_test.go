@@ -0,0 +1,167 @@
+package ct50sim
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseScenario(t *testing.T) {
+	s, err := ParseScenario([]byte(`
+seed: 7
+faults:
+  - path: /tstat
+    method: GET
+    after: 1
+    count: 2
+    action: status
+    status: 503
+  - latency: 250ms
+    jitter: 1s
+    probability: 0.5
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Scenario{
+		Seed: 7,
+		Faults: []Fault{
+			{Path: "/tstat", Method: "GET", After: 1, Count: 2, Action: FaultStatus, Status: 503},
+			{Latency: 250 * time.Millisecond, Jitter: time.Second, Probability: 0.5},
+		},
+	}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("ParseScenario = %+v, want %+v", s, want)
+	}
+
+	for _, bad := range []string{
+		"faults: [{action: explode}]",
+		"faults: [{probability: 2}]",
+		"faults: [{action: status, status: 42}]",
+		"faults: [{latency: soon}]",
+	} {
+		if _, err := ParseScenario([]byte(bad)); err == nil {
+			t.Errorf("ParseScenario(%q) succeeded, want an error", bad)
+		}
+	}
+}
+
+// get fetches path from the device and returns the status and body. A
+// dropped connection is returned as err.
+func get(srv *httptest.Server, path string) (int, string, error) {
+	resp, err := http.Get(srv.URL + path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body), err
+}
+
+func TestFaultActions(t *testing.T) {
+	tests := []struct {
+		action FaultAction
+		check  func(code int, body string, err error) bool
+	}{
+		{FaultReset, func(code int, body string, err error) bool {
+			return err != nil
+		}},
+		{FaultStatus, func(code int, body string, err error) bool {
+			return err == nil && code == http.StatusInternalServerError
+		}},
+		{FaultError, func(code int, body string, err error) bool {
+			return err == nil && code == http.StatusOK && strings.TrimSpace(body) == `{"error":"busy"}`
+		}},
+		{FaultMalformed, func(code int, body string, err error) bool {
+			var v map[string]interface{}
+			return err == nil && code == http.StatusOK && body != "" && json.Unmarshal([]byte(body), &v) != nil
+		}},
+		{FaultStale, func(code int, body string, err error) bool {
+			var v struct{ Temp float64 }
+			return err == nil && json.Unmarshal([]byte(body), &v) == nil && v.Temp == -1
+		}},
+	}
+
+	for _, tt := range tests {
+		dev := New(Options{Scenario: &Scenario{Faults: []Fault{{Path: "/tstat", Action: tt.action}}}})
+		srv := httptest.NewServer(dev)
+
+		code, body, err := get(srv, "/tstat")
+		if !tt.check(code, body, err) {
+			t.Errorf("%s: got %d %q %v", tt.action, code, body, err)
+		}
+
+		// Paths the rule does not cover are untouched.
+		if code, body, err := get(srv, "/sys"); err != nil || code != http.StatusOK || !strings.Contains(body, "uuid") {
+			t.Errorf("%s: /sys got %d %q %v", tt.action, code, body, err)
+		}
+
+		srv.Close()
+	}
+}
+
+func TestFaultAfterAndCount(t *testing.T) {
+	dev := New(Options{Scenario: &Scenario{Faults: []Fault{
+		{Path: "/tstat", Method: "GET", After: 1, Count: 2, Action: FaultStatus, Status: 503},
+	}}})
+	srv := httptest.NewServer(dev)
+	defer srv.Close()
+
+	var codes []int
+	for i := 0; i < 5; i++ {
+		code, _, err := get(srv, "/tstat")
+		if err != nil {
+			t.Fatal(err)
+		}
+		codes = append(codes, code)
+	}
+
+	want := []int{200, 503, 503, 200, 200}
+	if !reflect.DeepEqual(codes, want) {
+		t.Errorf("status codes = %v, want %v", codes, want)
+	}
+	if fired := dev.Fired(); !reflect.DeepEqual(fired, []int{2}) {
+		t.Errorf("Fired() = %v, want [2]", fired)
+	}
+}
+
+func TestFaultLatency(t *testing.T) {
+	dev := New(Options{Scenario: &Scenario{Faults: []Fault{{Latency: 100 * time.Millisecond}}}})
+	srv := httptest.NewServer(dev)
+	defer srv.Close()
+
+	start := time.Now()
+	if code, _, err := get(srv, "/tstat"); err != nil || code != http.StatusOK {
+		t.Fatalf("got %d %v", code, err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("reply took %v, want at least 100ms", elapsed)
+	}
+}
+
+func TestFaultProbabilityIsRepeatable(t *testing.T) {
+	run := func() []int {
+		dev := New(Options{Scenario: &Scenario{Seed: 42, Faults: []Fault{{Probability: 0.5, Action: FaultStatus}}}})
+		srv := httptest.NewServer(dev)
+		defer srv.Close()
+
+		var codes []int
+		for i := 0; i < 20; i++ {
+			code, _, _ := get(srv, "/tstat")
+			codes = append(codes, code)
+		}
+		return codes
+	}
+
+	first, second := run(), run()
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("same seed gave different faults:\n%v\n%v", first, second)
+	}
+}
@@ -0,0 +1,421 @@
+package ct50sim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+// ServeHTTP implements the CT50 local API, with any faults from the
+// device's scenario applied.
+func (d *Device) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	fault := d.pickFault(r)
+	d.mu.Unlock()
+
+	if fault == nil {
+		d.serve(w, r, false)
+		return
+	}
+
+	if !sleep(r, fault.Latency) {
+		return
+	}
+
+	switch fault.Action {
+	case FaultReset:
+		resetConnection(w)
+	case FaultStatus:
+		code := fault.statusCode()
+		http.Error(w, http.StatusText(code), code)
+	case FaultError:
+		reason := fault.Error
+		if reason == "" {
+			reason = "busy"
+		}
+		replyError(w, reason)
+	case FaultMalformed:
+		tw := &truncatedWriter{header: make(http.Header)}
+		d.serve(tw, r, false)
+		tw.flush(w)
+	default:
+		d.serve(w, r, fault.Action == FaultStale)
+	}
+}
+
+// serve answers a request. stale makes GET /tstat report no temperature.
+func (d *Device) serve(w http.ResponseWriter, r *http.Request, stale bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.tick()
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case path == "/tstat":
+		d.handleTstat(w, r, stale)
+	case path == "/tstat/model":
+		d.handleGet(w, r, ct50.Model{Model: d.model})
+	case path == "/sys":
+		d.handleGet(w, r, ct50.SysInfo{UUID: d.uuid, APIVersion: 113, FWVersion: "1.04.84", WLANFWVersion: "v10.105576"})
+	case path == "/sys/name":
+		d.handleName(w, r)
+	case path == "/tstat/humidity":
+		d.handleGet(w, r, map[string]float64{"humidity": d.humidity})
+	case path == "/tstat/datalog":
+		d.handleGet(w, r, d.dataLog())
+	case path == "/tstat/remote_temp":
+		d.handleRemoteTemp(w, r)
+	case path == "/tstat/pma":
+		d.handlePMA(w, r)
+	case path == "/tstat/save_energy":
+		d.handleSaveEnergy(w, r)
+	case strings.HasPrefix(path, "/tstat/program/"):
+		d.handleProgram(w, r, strings.TrimPrefix(path, "/tstat/program/"))
+	case path == "/sim/trace":
+		d.handleTrace(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func reply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func replySuccess(w http.ResponseWriter) {
+	reply(w, map[string]int{"success": 0})
+}
+
+// replyError answers the way the thermostat rejects a request: HTTP 200
+// with an error object.
+func replyError(w http.ResponseWriter, reason string) {
+	reply(w, map[string]string{"error": reason})
+}
+
+func (d *Device) handleGet(w http.ResponseWriter, r *http.Request, v interface{}) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	reply(w, v)
+}
+
+// tstatReply is GET /tstat. Like the real thermostat it only reports the
+// setpoints that apply to the current mode.
+type tstatReply struct {
+	Temp      float64      `json:"temp"`
+	Tmode     ct50.Mode    `json:"tmode"`
+	Fmode     ct50.FanMode `json:"fmode"`
+	Override  ct50.OnOff   `json:"override"`
+	Hold      ct50.OnOff   `json:"hold"`
+	THeat     *float64     `json:"t_heat,omitempty"`
+	TCool     *float64     `json:"t_cool,omitempty"`
+	Tstate    ct50.State   `json:"tstate"`
+	Fstate    ct50.OnOff   `json:"fstate"`
+	Time      ct50.Time    `json:"time"`
+	TTypePost int          `json:"t_type_post"`
+}
+
+func (d *Device) tstat() tstatReply {
+	s := d.status
+	out := tstatReply{
+		Temp:     d.reading(),
+		Tmode:    s.Tmode,
+		Fmode:    s.Fmode,
+		Override: s.Override,
+		Hold:     s.Hold,
+		Tstate:   s.Tstate,
+		Fstate:   s.Fstate,
+		Time:     s.Time,
+	}
+	if s.Tmode == ct50.ModeHeat || s.Tmode == ct50.ModeAuto {
+		out.THeat = &s.THeat
+	}
+	if s.Tmode == ct50.ModeCool || s.Tmode == ct50.ModeAuto {
+		out.TCool = &s.TCool
+	}
+	return out
+}
+
+func (d *Device) handleTstat(w http.ResponseWriter, r *http.Request, stale bool) {
+	switch r.Method {
+	case http.MethodGet:
+		out := d.tstat()
+		if stale {
+			out.Temp = -1
+		}
+		reply(w, out)
+	case http.MethodPost:
+		var fields map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+			replyError(w, "Invalid JSON")
+			return
+		}
+		if err := d.applyTstat(fields); err != nil {
+			replyError(w, err.Error())
+			return
+		}
+		d.updateState()
+		replySuccess(w)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// applyTstat applies a POST /tstat body. Every field is checked before any
+// of them is applied, so a bad request changes nothing.
+func (d *Device) applyTstat(fields map[string]json.RawMessage) error {
+	next := d.status
+	offset := d.clockOffset
+	setpointChanged := false
+
+	for key, raw := range fields {
+		switch key {
+		case "tmode":
+			var v ct50.Mode
+			if json.Unmarshal(raw, &v) != nil || !v.Valid() {
+				return fmt.Errorf("invalid tmode %s", raw)
+			}
+			next.Tmode = v
+		case "fmode":
+			var v ct50.FanMode
+			if json.Unmarshal(raw, &v) != nil || !v.Valid() {
+				return fmt.Errorf("invalid fmode %s", raw)
+			}
+			next.Fmode = v
+		case "hold":
+			var v ct50.OnOff
+			if json.Unmarshal(raw, &v) != nil || (v != ct50.Off && v != ct50.On) {
+				return fmt.Errorf("invalid hold %s", raw)
+			}
+			next.Hold = v
+		case "t_heat", "t_cool":
+			var v float64
+			if json.Unmarshal(raw, &v) != nil || v < ct50.MinProgramTemp || v > ct50.MaxProgramTemp {
+				return fmt.Errorf("invalid %s %s", key, raw)
+			}
+			if key == "t_heat" {
+				next.THeat = v
+			} else {
+				next.TCool = v
+			}
+			setpointChanged = true
+		case "time":
+			var v ct50.Time
+			if json.Unmarshal(raw, &v) != nil || v.Day < 0 || v.Day > 6 || v.Hour < 0 || v.Hour > 23 || v.Minute < 0 || v.Minute > 59 {
+				return fmt.Errorf("invalid time %s", raw)
+			}
+			offset = clockOffset(d.now(), v)
+		}
+	}
+
+	// A setpoint change without a hold lasts until the next program period.
+	if setpointChanged {
+		next.Override = ct50.OnOffFrom(next.Hold == ct50.Off)
+	}
+	if next.Hold == ct50.On {
+		next.Override = ct50.Off
+	}
+
+	d.status = next
+	d.clockOffset = offset
+	return nil
+}
+
+// clockOffset works out how far to move the device clock so that it reads
+// t now, keeping the current seconds.
+func clockOffset(now time.Time, t ct50.Time) time.Duration {
+	current := deviceTime(now)
+	minutes := (t.Day-current.Day)*24*60 + (t.Hour-current.Hour)*60 + (t.Minute - current.Minute)
+	return time.Duration(minutes) * time.Minute
+}
+
+func (d *Device) handleName(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		reply(w, map[string]string{"name": d.name})
+	case http.MethodPost:
+		var req struct {
+			Name string `json:"name"`
+		}
+		if json.NewDecoder(r.Body).Decode(&req) != nil || req.Name == "" {
+			replyError(w, "Invalid name")
+			return
+		}
+		d.name = req.Name
+		replySuccess(w)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (d *Device) dataLog() ct50.DataLog {
+	return ct50.DataLog{
+		Today:     ct50.DayLog{HeatRuntime: toRuntime(d.today.heat), CoolRuntime: toRuntime(d.today.cool)},
+		Yesterday: ct50.DayLog{HeatRuntime: toRuntime(d.yesterday.heat), CoolRuntime: toRuntime(d.yesterday.cool)},
+	}
+}
+
+func toRuntime(t time.Duration) ct50.Runtime {
+	minutes := int(t / time.Minute)
+	return ct50.Runtime{Hour: minutes / 60, Minute: minutes % 60}
+}
+
+func (d *Device) handleRemoteTemp(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		out := map[string]float64{"rem_mode": 0}
+		if d.remoteTemp != nil {
+			out["rem_mode"] = 1
+			out["rem_temp"] = *d.remoteTemp
+		}
+		reply(w, out)
+	case http.MethodPost:
+		var req struct {
+			RemMode *int     `json:"rem_mode"`
+			RemTemp *float64 `json:"rem_temp"`
+		}
+		if json.NewDecoder(r.Body).Decode(&req) != nil {
+			replyError(w, "Invalid JSON")
+			return
+		}
+		switch {
+		case req.RemTemp != nil:
+			temp := *req.RemTemp
+			d.remoteTemp = &temp
+		case req.RemMode != nil && *req.RemMode == 0:
+			d.remoteTemp = nil
+		default:
+			replyError(w, "Invalid remote temp")
+			return
+		}
+		d.updateState()
+		replySuccess(w)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (d *Device) handlePMA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Mode    int    `json:"mode"`
+		Message string `json:"message"`
+	}
+	if json.NewDecoder(r.Body).Decode(&req) != nil {
+		replyError(w, "Invalid JSON")
+		return
+	}
+
+	if req.Mode == 0 {
+		d.message = ""
+	} else {
+		d.message = req.Message
+	}
+	replySuccess(w)
+}
+
+func (d *Device) handleSaveEnergy(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		reply(w, map[string]ct50.OnOff{"mode": ct50.OnOffFrom(d.saveEnergy)})
+	case http.MethodPost:
+		var req struct {
+			Mode ct50.OnOff `json:"mode"`
+		}
+		if json.NewDecoder(r.Body).Decode(&req) != nil {
+			replyError(w, "Invalid JSON")
+			return
+		}
+		d.saveEnergy = req.Mode == ct50.On
+		replySuccess(w)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleProgram serves /tstat/program/{heat,cool} and, for POST only,
+// /tstat/program/{heat,cool}/{day}.
+func (d *Device) handleProgram(w http.ResponseWriter, r *http.Request, rest string) {
+	modeName, dayName, perDay := strings.Cut(rest, "/")
+	mode, err := ct50.ParseProgramMode(modeName)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	prog := d.programs[mode]
+
+	if r.Method == http.MethodGet {
+		if perDay {
+			day, err := ct50.ParseDay(dayName)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			reply(w, map[string][]float64{strconv.Itoa(day): flatten(prog[day])})
+			return
+		}
+		reply(w, prog)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// The body uses the same day-keyed format whether it carries one day
+	// or the whole week. Days not in the body are left alone.
+	var days map[string][]float64
+	if err := json.NewDecoder(r.Body).Decode(&days); err != nil {
+		replyError(w, "Invalid JSON")
+		return
+	}
+
+	next := *prog
+	for key, values := range days {
+		day, err := strconv.Atoi(key)
+		if err != nil || day < 0 || day >= len(next) || len(values)%2 != 0 {
+			replyError(w, "Invalid program day "+key)
+			return
+		}
+		if perDay {
+			if day, err = ct50.ParseDay(dayName); err != nil {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		next[day] = nil
+		for i := 0; i < len(values); i += 2 {
+			next[day] = append(next[day], ct50.Period{Minute: int(values[i]), Temp: values[i+1]})
+		}
+	}
+
+	if err := next.Validate(); err != nil {
+		replyError(w, err.Error())
+		return
+	}
+
+	*prog = next
+	replySuccess(w)
+}
+
+func flatten(day ct50.Day) []float64 {
+	values := make([]float64, 0, 2*len(day))
+	for _, period := range day {
+		values = append(values, float64(period.Minute), period.Temp)
+	}
+	return values
+}
@@ -0,0 +1,160 @@
+package ct50sim
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+// maxTrace is how many trace points a Device keeps: a week at one point a
+// minute.
+const maxTrace = 7 * 24 * 60
+
+// House is a simple thermal model of the building the thermostat sits in.
+// Indoor temperature changes at
+//
+//	(HVAC output - Loss * (indoor - outdoor)) / ThermalMass
+//
+// degrees F per hour, where the HVAC output is FurnaceBTU while heating,
+// -ACBTU while cooling and zero otherwise.
+type House struct {
+	// ThermalMass is the heat needed to warm the house by one degree F,
+	// in BTU/°F.
+	ThermalMass float64
+
+	// Loss is the heat lost through the walls and roof per hour for each
+	// degree F the house is warmer than outside, in BTU/h/°F.
+	Loss float64
+
+	// FurnaceBTU and ACBTU are the heating and cooling capacity in BTU/h.
+	FurnaceBTU float64
+	ACBTU      float64
+
+	// Outdoor gives the outdoor temperature at a point in time.
+	Outdoor func(t time.Time) float64
+}
+
+// DefaultHouse returns a model of a typical two-storey house with a 60,000
+// BTU/h furnace and a three ton air conditioner, on a day that swings
+// between low and high degrees F.
+func DefaultHouse(low, high float64) *House {
+	return &House{
+		ThermalMass: 8000,
+		Loss:        500,
+		FurnaceBTU:  60000,
+		ACBTU:       36000,
+		Outdoor:     DailyOutdoor(low, high),
+	}
+}
+
+// DailyOutdoor returns an outdoor temperature curve that bottoms out at
+// low around 5am and peaks at high around 5pm.
+func DailyOutdoor(low, high float64) func(time.Time) float64 {
+	mid := (low + high) / 2
+	amplitude := (high - low) / 2
+	return func(t time.Time) float64 {
+		hours := float64(t.Hour()) + float64(t.Minute())/60 + float64(t.Second())/3600
+		return mid - amplitude*math.Cos(2*math.Pi*(hours-5)/24)
+	}
+}
+
+// TracePoint is the state of the house and thermostat at one moment.
+type TracePoint struct {
+	Time    time.Time
+	Indoor  float64
+	Outdoor float64
+	THeat   float64
+	TCool   float64
+	Tmode   ct50.Mode
+	Tstate  ct50.State
+}
+
+// warm moves the indoor temperature on by dt of heating, cooling and loss
+// to outdoors. Callers must hold d.mu.
+func (d *Device) warm(dt time.Duration, t time.Time) {
+	h := d.house
+	outdoor := h.Outdoor(t)
+
+	var output float64
+	switch d.status.Tstate {
+	case ct50.StateHeating:
+		output = h.FurnaceBTU
+	case ct50.StateCooling:
+		output = -h.ACBTU
+	}
+
+	d.indoor += (output - h.Loss*(d.indoor-outdoor)) * dt.Hours() / h.ThermalMass
+
+	// Like the real sensor, the thermostat reads to the nearest half degree.
+	d.status.Temp = math.Round(d.indoor*2) / 2
+
+	d.trace = append(d.trace, TracePoint{
+		Time:    t,
+		Indoor:  d.indoor,
+		Outdoor: outdoor,
+		THeat:   d.status.THeat,
+		TCool:   d.status.TCool,
+		Tmode:   d.status.Tmode,
+		Tstate:  d.status.Tstate,
+	})
+	// Trim in batches so a long run does not copy the trace every step.
+	if len(d.trace) > 2*maxTrace {
+		d.trace = append(d.trace[:0], d.trace[len(d.trace)-maxTrace:]...)
+	}
+}
+
+// Trace returns the temperature trace recorded by the house model, oldest
+// first, with points at most a simulated minute apart. It is empty when
+// the device has no House.
+func (d *Device) Trace() []TracePoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.tick()
+	return d.recentTrace()
+}
+
+// recentTrace returns a copy of the last maxTrace points. Callers must
+// hold d.mu.
+func (d *Device) recentTrace() []TracePoint {
+	trace := d.trace
+	if len(trace) > maxTrace {
+		trace = trace[len(trace)-maxTrace:]
+	}
+	return append([]TracePoint(nil), trace...)
+}
+
+// handleTrace serves GET /sim/trace, the house model's trace as CSV. The
+// real thermostat has no such endpoint.
+func (d *Device) handleTrace(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	WriteTraceCSV(w, d.recentTrace())
+}
+
+// WriteTraceCSV writes points as CSV with a header row.
+func WriteTraceCSV(w io.Writer, points []TracePoint) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"time", "indoor", "outdoor", "t_heat", "t_cool", "tmode", "tstate"})
+	for _, p := range points {
+		out.Write([]string{
+			p.Time.Format(time.RFC3339),
+			strconv.FormatFloat(p.Indoor, 'f', 2, 64),
+			strconv.FormatFloat(p.Outdoor, 'f', 2, 64),
+			strconv.FormatFloat(p.THeat, 'f', -1, 64),
+			strconv.FormatFloat(p.TCool, 'f', -1, 64),
+			p.Tmode.String(),
+			p.Tstate.String(),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,161 @@
+package ct50sim
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+// monday is midnight at the start of Monday 1 January 2024.
+var monday = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func constant(temp float64) func(time.Time) float64 {
+	return func(time.Time) float64 { return temp }
+}
+
+// at returns the trace point closest to hour:minute on the first day.
+func at(trace []TracePoint, hour, minute int) TracePoint {
+	want := monday.Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+	for _, p := range trace {
+		if !p.Time.Before(want) {
+			return p
+		}
+	}
+	return trace[len(trace)-1]
+}
+
+func TestHouseFollowsHeatProgram(t *testing.T) {
+	clock := NewManualClock(monday)
+	dev := New(Options{Temp: 62, Now: clock.Now, House: DefaultHouse(25, 45)})
+
+	clock.Advance(24 * time.Hour)
+	trace := dev.Trace()
+	if len(trace) < 24*60 {
+		t.Fatalf("trace has %d points, want one a minute for a day", len(trace))
+	}
+
+	// The furnace brings the house up from the 62 night setback by the
+	// end of the 6:00 wake period.
+	if p := at(trace, 7, 55); p.Indoor < 69 {
+		t.Errorf("07:55 indoor = %.1f, want close to the wake setpoint of 70", p.Indoor)
+	}
+
+	// After the morning the house cools to the 62 leave setpoint and is
+	// held there.
+	for _, p := range trace {
+		hour := p.Time.Sub(monday).Hours()
+		if hour >= 12 && hour < 18 && (p.Indoor < 61 || p.Indoor > 63.5) {
+			t.Errorf("%s indoor = %.1f, want about 62 during the leave period", p.Time.Format("15:04"), p.Indoor)
+			break
+		}
+		if p.Indoor > 71.5 {
+			t.Errorf("%s indoor = %.1f, overshot the 70 setpoint", p.Time.Format("15:04"), p.Indoor)
+			break
+		}
+	}
+
+	if p := at(trace, 21, 0); math.Abs(p.Indoor-70) > 1 {
+		t.Errorf("21:00 indoor = %.1f, want about 70 in the return period", p.Indoor)
+	}
+
+	log, err := ct50.New(serve(t, dev)).DataLog(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.Yesterday.HeatRuntime.Hour < 2 {
+		t.Errorf("heat runtime = %+v, want hours of heating on a cold day", log.Yesterday.HeatRuntime)
+	}
+}
+
+func TestHouseDriftsToOutdoor(t *testing.T) {
+	clock := NewManualClock(monday)
+	house := DefaultHouse(0, 0)
+	house.Outdoor = constant(40)
+	dev := New(Options{Temp: 70, Now: clock.Now, House: house})
+
+	client := ct50.New(serve(t, dev))
+	if err := client.SetMode(context.Background(), ct50.ModeOff); err != nil {
+		t.Fatal(err)
+	}
+
+	// One time constant (thermal mass / loss) closes 63% of the gap.
+	clock.Advance(16 * time.Hour)
+	want := 40 + 30*math.Exp(-1)
+	trace := dev.Trace()
+	if got := trace[len(trace)-1].Indoor; math.Abs(got-want) > 0.5 {
+		t.Errorf("indoor after 16h = %.2f, want %.2f", got, want)
+	}
+
+	stats := dev.Status()
+	if stats.Temp != math.Round(want*2)/2 {
+		t.Errorf("reported temp = %v, want %.2f to the nearest half degree", stats.Temp, want)
+	}
+}
+
+func TestHouseCools(t *testing.T) {
+	clock := NewManualClock(monday.Add(12 * time.Hour))
+	house := DefaultHouse(0, 0)
+	house.Outdoor = constant(95)
+	dev := New(Options{Temp: 85, Now: clock.Now, House: house})
+
+	client := ct50.New(serve(t, dev))
+	hold := ct50.On
+	if _, err := client.UpdateAndVerify(context.Background(), ct50.Update{Hold: &hold}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetCool(context.Background(), 75); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(6 * time.Hour)
+	trace := dev.Trace()
+	last := trace[len(trace)-1]
+	if last.Indoor < 74 || last.Indoor > 76 {
+		t.Errorf("indoor after 6h = %.1f, want it held near 75", last.Indoor)
+	}
+
+	cycles := 0
+	for i := 1; i < len(trace); i++ {
+		if trace[i].Tstate == ct50.StateCooling && trace[i-1].Tstate != ct50.StateCooling {
+			cycles++
+		}
+	}
+	if cycles < 2 {
+		t.Errorf("AC started %d times, want it to cycle against the heat outside", cycles)
+	}
+}
+
+func TestAccelerated(t *testing.T) {
+	now := Accelerated(monday, 3600)
+	time.Sleep(20 * time.Millisecond)
+
+	if elapsed := now().Sub(monday); elapsed < time.Minute || elapsed > time.Hour {
+		t.Errorf("20ms at 3600x moved the clock %v, want about 72s", elapsed)
+	}
+}
+
+func TestWriteTraceCSV(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteTraceCSV(&buf, []TracePoint{{Time: monday, Indoor: 68.25, Outdoor: 30, THeat: 70, Tmode: ct50.ModeHeat, Tstate: ct50.StateHeating}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "time,indoor,outdoor,t_heat,t_cool,tmode,tstate\n2024-01-01T00:00:00Z,68.25,30.00,70,0,Heat,Heating\n"
+	if got := buf.String(); got != want {
+		t.Errorf("CSV =\n%s\nwant\n%s", got, want)
+	}
+}
+
+// serve starts an httptest server for dev and returns its URL.
+func serve(t *testing.T, dev *Device) string {
+	t.Helper()
+	srv := httptest.NewServer(dev)
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
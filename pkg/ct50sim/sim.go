@@ -0,0 +1,352 @@
+// Package ct50sim emulates a Radio Thermostat CT50 for development and
+// testing.
+//
+// A Device is an http.Handler that serves the same local API as the real
+// thermostat, so it can be wrapped in an httptest.Server and pointed at
+// with ct50.New:
+//
+//	dev := ct50sim.New(ct50sim.Options{})
+//	srv := httptest.NewServer(dev)
+//	defer srv.Close()
+//	client := ct50.New(srv.URL)
+//
+// The emulated thermostat follows its heat and cool programs, honours hold
+// and temporary overrides, and switches tstate and fstate as the room
+// temperature crosses the setpoints.
+//
+// With a House the room temperature is no longer fixed: it is modelled from
+// the building's thermal mass, its loss to an outdoor temperature curve and
+// the furnace and air conditioner capacity. Paired with an Accelerated or
+// ManualClock, a simulated day passes in seconds and Trace shows how the
+// house responded to the program.
+//
+// A Scenario makes the emulator misbehave the way real thermostats do:
+// slow replies, dropped connections, garbled JSON, HTTP 500s, error
+// replies and missing temperature readings.
+package ct50sim
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+// swing is how far the temperature must drift past a setpoint before the
+// emulated thermostat starts heating or cooling.
+const swing = 0.5
+
+// step is the longest stretch of simulated time the device moves through in
+// one go, so that programs, heating and cooling react between requests
+// just as they would on a real thermostat.
+const step = time.Minute
+
+// maxCatchUp bounds how much missed time is stepped through. A bigger jump,
+// such as the clock being set, is taken in one step.
+const maxCatchUp = 31 * 24 * time.Hour
+
+// Options configures a Device. Zero values are replaced with defaults.
+type Options struct {
+	Model    string
+	UUID     string
+	Name     string
+	Temp     float64
+	Humidity float64
+
+	// Now is the clock the device runs on. It defaults to time.Now, and
+	// can be replaced to make tests deterministic or to run time faster.
+	Now func() time.Time
+
+	// House models the building the thermostat heats and cools. Without
+	// one the temperature only changes through SetTemp.
+	House *House
+
+	// Scenario scripts faults into the device's replies. It can be
+	// changed later with SetScenario.
+	Scenario *Scenario
+
+	// Logf, if set, is called each time a fault fires.
+	Logf func(format string, args ...interface{})
+}
+
+// Device is an emulated CT50.
+type Device struct {
+	mu sync.Mutex
+
+	model    string
+	uuid     string
+	name     string
+	humidity float64
+	now      func() time.Time
+	logf     func(format string, args ...interface{})
+
+	status      ct50.Status
+	programs    map[ct50.ProgramMode]*ct50.Program
+	clockOffset time.Duration
+	remoteTemp  *float64
+	saveEnergy  bool
+	message     string
+
+	lastTick   time.Time
+	lastPeriod map[ct50.ProgramMode]periodKey
+	today      runtimeLog
+	yesterday  runtimeLog
+	logDay     time.Time
+
+	house  *House
+	indoor float64
+	trace  []TracePoint
+
+	faults []Fault
+	rand   *rand.Rand
+}
+
+// periodKey identifies a program period, so the device can tell when a new
+// one begins.
+type periodKey struct {
+	day    int
+	minute int
+}
+
+// runtimeLog counts HVAC run time for one day.
+type runtimeLog struct {
+	heat time.Duration
+	cool time.Duration
+}
+
+// DefaultHeatProgram and DefaultCoolProgram are the factory programs: wake,
+// leave, return and sleep periods every day.
+var (
+	DefaultHeatProgram = everyDay(ct50.Day{{Minute: 360, Temp: 70}, {Minute: 480, Temp: 62}, {Minute: 1080, Temp: 70}, {Minute: 1320, Temp: 62}})
+	DefaultCoolProgram = everyDay(ct50.Day{{Minute: 360, Temp: 75}, {Minute: 480, Temp: 85}, {Minute: 1080, Temp: 75}, {Minute: 1320, Temp: 78}})
+)
+
+func everyDay(day ct50.Day) ct50.Program {
+	var prog ct50.Program
+	for i := range prog {
+		prog[i] = append(ct50.Day(nil), day...)
+	}
+	return prog
+}
+
+// New returns an emulated thermostat in heat mode, following its program.
+func New(opts Options) *Device {
+	if opts.Model == "" {
+		opts.Model = "CT50 V1.94"
+	}
+	if opts.UUID == "" {
+		opts.UUID = "5cdad4000000"
+	}
+	if opts.Name == "" {
+		opts.Name = "Thermostat"
+	}
+	if opts.Temp == 0 {
+		opts.Temp = 68
+	}
+	if opts.Humidity == 0 {
+		opts.Humidity = 45
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.Logf == nil {
+		opts.Logf = func(string, ...interface{}) {}
+	}
+
+	heat, cool := DefaultHeatProgram, DefaultCoolProgram
+	d := &Device{
+		model:    opts.Model,
+		uuid:     opts.UUID,
+		name:     opts.Name,
+		humidity: opts.Humidity,
+		now:      opts.Now,
+		logf:     opts.Logf,
+		status: ct50.Status{
+			Temp:  opts.Temp,
+			Tmode: ct50.ModeHeat,
+		},
+		programs: map[ct50.ProgramMode]*ct50.Program{
+			ct50.ProgramHeat: &heat,
+			ct50.ProgramCool: &cool,
+		},
+		lastPeriod: make(map[ct50.ProgramMode]periodKey),
+		house:      opts.House,
+		indoor:     opts.Temp,
+	}
+
+	d.mu.Lock()
+	d.tick()
+	d.mu.Unlock()
+
+	d.SetScenario(opts.Scenario)
+	return d
+}
+
+// Status returns the device state as GET /tstat would, with both
+// setpoints filled in.
+func (d *Device) Status() ct50.Status {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.tick()
+	return d.status
+}
+
+// SetTemp sets the temperature the built-in sensor reads. With a House it
+// sets the indoor temperature the model carries on from.
+func (d *Device) SetTemp(temp float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.tick()
+	d.status.Temp = temp
+	d.indoor = temp
+	d.updateState()
+}
+
+// Program returns a copy of the heat or cool program.
+func (d *Device) Program(mode ct50.ProgramMode) ct50.Program {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return *d.programs[mode]
+}
+
+// clock returns the device's notion of the current time, which may have
+// been moved by a POST of "time".
+func (d *Device) clock() time.Time {
+	return d.now().Add(d.clockOffset)
+}
+
+// deviceTime converts t to the thermostat's day/hour/minute form.
+func deviceTime(t time.Time) ct50.Time {
+	// time.Weekday counts from Sunday; the thermostat counts from Monday.
+	return ct50.Time{
+		Day:    (int(t.Weekday()) + 6) % 7,
+		Hour:   t.Hour(),
+		Minute: t.Minute(),
+	}
+}
+
+// tick brings the device up to the current time, stepping through any
+// time that has passed since the last tick. Callers must hold d.mu.
+func (d *Device) tick() {
+	now := d.clock()
+	if !d.lastTick.IsZero() && now.Sub(d.lastTick) <= maxCatchUp {
+		for t := d.lastTick.Add(step); t.Before(now); t = t.Add(step) {
+			d.advance(t)
+		}
+	}
+	d.advance(now)
+}
+
+// advance moves the device on to t: it warms or cools the house, records
+// run time, starts the next program period if one has begun, and updates
+// the operating state.
+func (d *Device) advance(t time.Time) {
+	if d.house != nil && !d.lastTick.IsZero() && t.After(d.lastTick) {
+		d.warm(t.Sub(d.lastTick), t)
+	}
+	d.recordRuntime(t)
+	d.status.Time = deviceTime(t)
+	d.followProgram()
+	d.updateState()
+	d.lastTick = t
+}
+
+func (d *Device) recordRuntime(now time.Time) {
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if d.logDay.IsZero() {
+		d.logDay = day
+	}
+
+	if !d.lastTick.IsZero() && now.After(d.lastTick) {
+		elapsed := now.Sub(d.lastTick)
+		switch d.status.Tstate {
+		case ct50.StateHeating:
+			d.today.heat += elapsed
+		case ct50.StateCooling:
+			d.today.cool += elapsed
+		}
+	}
+
+	if day.After(d.logDay) {
+		if day.Sub(d.logDay) <= 24*time.Hour {
+			d.yesterday = d.today
+		} else {
+			d.yesterday = runtimeLog{}
+		}
+		d.today = runtimeLog{}
+		d.logDay = day
+	}
+}
+
+// followProgram applies the program setpoints when a new period begins. A
+// hold keeps the current setpoints; an override lasts only until the next
+// period.
+func (d *Device) followProgram() {
+	for _, mode := range []ct50.ProgramMode{ct50.ProgramHeat, ct50.ProgramCool} {
+		day, period, ok := d.programs[mode].Current(d.status.Time)
+		if !ok {
+			continue
+		}
+
+		key := periodKey{day: day, minute: period.Minute}
+		last, seen := d.lastPeriod[mode]
+		d.lastPeriod[mode] = key
+		if seen && last == key {
+			continue
+		}
+
+		if d.status.Hold == ct50.On {
+			continue
+		}
+
+		if mode == ct50.ProgramHeat {
+			d.status.THeat = period.Temp
+		} else {
+			d.status.TCool = period.Temp
+		}
+		d.status.Override = ct50.Off
+	}
+}
+
+// reading is the temperature the thermostat acts on: the remote sensor
+// when one is set, otherwise the built-in one.
+func (d *Device) reading() float64 {
+	if d.remoteTemp != nil {
+		return *d.remoteTemp
+	}
+	return d.status.Temp
+}
+
+// updateState works out tstate and fstate from the mode, setpoints and
+// temperature.
+func (d *Device) updateState() {
+	temp := d.reading()
+	heating := d.status.Tstate == ct50.StateHeating
+	cooling := d.status.Tstate == ct50.StateCooling
+
+	wantHeat := d.status.Tmode == ct50.ModeHeat || d.status.Tmode == ct50.ModeAuto
+	wantCool := d.status.Tmode == ct50.ModeCool || d.status.Tmode == ct50.ModeAuto
+
+	switch {
+	case wantHeat && (temp < d.status.THeat-swing || heating && temp < d.status.THeat):
+		d.status.Tstate = ct50.StateHeating
+	case wantCool && (temp > d.status.TCool+swing || cooling && temp > d.status.TCool):
+		d.status.Tstate = ct50.StateCooling
+	default:
+		d.status.Tstate = ct50.StateOff
+	}
+
+	running := d.status.Tstate != ct50.StateOff
+	switch d.status.Fmode {
+	case ct50.FanOn:
+		running = true
+	case ct50.FanCirculate:
+		// Circulate runs the fan for part of every hour.
+		running = running || d.status.Time.Minute < 20
+	}
+	d.status.Fstate = ct50.OnOffFrom(running)
+}
@@ -0,0 +1,271 @@
+package ct50sim
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+// newTestDevice starts an emulator at 07:00 on Monday 1 January 2024, in the
+// wake period of the default programs.
+func newTestDevice(t *testing.T, temp float64) (*Device, *ct50.Client, *ManualClock) {
+	t.Helper()
+
+	clock := NewManualClock(time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC))
+	dev := New(Options{Temp: temp, Now: clock.Now})
+	srv := httptest.NewServer(dev)
+	t.Cleanup(srv.Close)
+
+	return dev, ct50.New(srv.URL), clock
+}
+
+func TestStatusFollowsProgram(t *testing.T) {
+	_, client, clock := newTestDevice(t, 68)
+	ctx := context.Background()
+
+	stats, err := client.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Time != (ct50.Time{Day: 0, Hour: 7, Minute: 0}) {
+		t.Errorf("time = %+v, want Monday 07:00", stats.Time)
+	}
+	if stats.THeat != 70 {
+		t.Errorf("t_heat = %v, want 70 from the wake period", stats.THeat)
+	}
+	if stats.TCool != 0 {
+		t.Errorf("t_cool = %v, want it omitted in heat mode", stats.TCool)
+	}
+
+	// 09:00 is in the leave period.
+	clock.Advance(2 * time.Hour)
+	stats, err = client.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.THeat != 62 {
+		t.Errorf("t_heat = %v, want 62 from the leave period", stats.THeat)
+	}
+}
+
+func TestTstateFollowsTemperature(t *testing.T) {
+	dev, client, _ := newTestDevice(t, 65)
+	ctx := context.Background()
+
+	stats, err := client.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Tstate != ct50.StateHeating || stats.Fstate != ct50.On {
+		t.Errorf("at 65 with t_heat 70: tstate %v fstate %v, want Heating and fan on", stats.Tstate, stats.Fstate)
+	}
+
+	dev.SetTemp(71)
+	stats, err = client.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Tstate != ct50.StateOff || stats.Fstate != ct50.Off {
+		t.Errorf("at 71 with t_heat 70: tstate %v fstate %v, want Off", stats.Tstate, stats.Fstate)
+	}
+
+	// Cool mode with the room above the cool setpoint.
+	if err := client.SetCool(ctx, 70); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = client.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Tstate != ct50.StateCooling {
+		t.Errorf("at 71 with t_cool 70: tstate %v, want Cooling", stats.Tstate)
+	}
+}
+
+func TestOverrideLastsUntilNextPeriod(t *testing.T) {
+	_, client, clock := newTestDevice(t, 68)
+	ctx := context.Background()
+
+	if err := client.SetHeat(ctx, 74); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := client.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.THeat != 74 || stats.Override != ct50.On {
+		t.Errorf("after set: t_heat %v override %v, want 74 and override on", stats.THeat, stats.Override)
+	}
+
+	clock.Advance(2 * time.Hour)
+	stats, err = client.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.THeat != 62 || stats.Override != ct50.Off {
+		t.Errorf("next period: t_heat %v override %v, want 62 and override off", stats.THeat, stats.Override)
+	}
+}
+
+func TestHoldKeepsSetpoint(t *testing.T) {
+	_, client, clock := newTestDevice(t, 68)
+	ctx := context.Background()
+
+	hold := ct50.On
+	temp := 74.0
+	stats, err := client.UpdateAndVerify(ctx, ct50.Update{Hold: &hold, THeat: &temp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Override != ct50.Off {
+		t.Errorf("override = %v, want off under hold", stats.Override)
+	}
+
+	clock.Advance(2 * time.Hour)
+	stats, err = client.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.THeat != 74 {
+		t.Errorf("t_heat = %v, want 74 kept by hold", stats.THeat)
+	}
+}
+
+func TestRejectsInvalidValues(t *testing.T) {
+	dev, client, _ := newTestDevice(t, 68)
+	ctx := context.Background()
+
+	err := client.SetMode(ctx, ct50.Mode(7))
+	var devErr *ct50.DeviceError
+	if !errors.As(err, &devErr) {
+		t.Fatalf("SetMode(7) error = %v, want a *ct50.DeviceError", err)
+	}
+
+	// A bad field means nothing in the request is applied.
+	fan := ct50.FanOn
+	heat := 200.0
+	if err := client.Update(ctx, ct50.Update{Fmode: &fan, THeat: &heat}); !errors.As(err, &devErr) {
+		t.Fatalf("Update with t_heat 200 error = %v, want a *ct50.DeviceError", err)
+	}
+	if got := dev.Status().Fmode; got != ct50.FanAuto {
+		t.Errorf("fmode = %v after rejected request, want Auto", got)
+	}
+}
+
+func TestProgramRoundTrip(t *testing.T) {
+	dev, client, _ := newTestDevice(t, 68)
+	ctx := context.Background()
+
+	prog, err := client.Program(ctx, ct50.ProgramCool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*prog, DefaultCoolProgram) {
+		t.Errorf("cool program = %v, want the default", prog)
+	}
+
+	saturday := ct50.Day{{Minute: 540, Temp: 76}, {Minute: 1380, Temp: 79}}
+	if err := client.SetProgramDay(ctx, ct50.ProgramCool, 5, saturday); err != nil {
+		t.Fatal(err)
+	}
+
+	got := dev.Program(ct50.ProgramCool)
+	if len(got[5]) != 2 || got[5][0] != saturday[0] || got[5][1] != saturday[1] {
+		t.Errorf("saturday = %v, want %v", got[5], saturday)
+	}
+	if len(got[4]) != 4 {
+		t.Errorf("friday = %v, want it left alone", got[4])
+	}
+
+	// The emulator validates programs the client did not check.
+	srv := httptest.NewServer(dev)
+	defer srv.Close()
+	resp, err := http.Post(srv.URL+"/tstat/program/heat", "application/json", strings.NewReader(`{"0":[600,70,300,62]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := dev.Program(ct50.ProgramHeat); !reflect.DeepEqual(got, DefaultHeatProgram) {
+		t.Errorf("heat program changed by an invalid write: %v", got)
+	}
+}
+
+func TestDataLogCountsRuntime(t *testing.T) {
+	_, client, clock := newTestDevice(t, 60)
+	ctx := context.Background()
+
+	// Heating from 07:00; 08:00 drops the setpoint to 62, still above 60.
+	clock.Advance(90 * time.Minute)
+	log, err := client.DataLog(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.Today.HeatRuntime != (ct50.Runtime{Hour: 1, Minute: 30}) {
+		t.Errorf("heat runtime today = %+v, want 1h30m", log.Today.HeatRuntime)
+	}
+
+	clock.Advance(24 * time.Hour)
+	log, err = client.DataLog(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.Yesterday.HeatRuntime.Hour < 1 {
+		t.Errorf("heat runtime yesterday = %+v, want yesterday's run time rolled over", log.Yesterday.HeatRuntime)
+	}
+}
+
+func TestDeviceInfo(t *testing.T) {
+	_, client, _ := newTestDevice(t, 68)
+	ctx := context.Background()
+
+	model, err := client.Model(ctx)
+	if err != nil || model != "CT50 V1.94" {
+		t.Errorf("Model() = %q, %v", model, err)
+	}
+
+	if err := client.SetName(ctx, "Hallway"); err != nil {
+		t.Fatal(err)
+	}
+	name, err := client.Name(ctx)
+	if err != nil || name != "Hallway" {
+		t.Errorf("Name() = %q, %v; want Hallway", name, err)
+	}
+
+	humidity, err := client.Humidity(ctx)
+	if err != nil || humidity != 45 {
+		t.Errorf("Humidity() = %v, %v; want 45", humidity, err)
+	}
+}
+
+func TestAutoMode(t *testing.T) {
+	dev, client, _ := newTestDevice(t, 72)
+	ctx := context.Background()
+
+	if err := client.SetRange(ctx, 68, 76); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := client.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.THeat != 68 || stats.TCool != 76 || stats.Tstate != ct50.StateOff {
+		t.Errorf("at 72 in 68-76: %+v, want both setpoints and idle", stats)
+	}
+
+	dev.SetTemp(66)
+	if got := dev.Status().Tstate; got != ct50.StateHeating {
+		t.Errorf("at 66: tstate %v, want Heating", got)
+	}
+	dev.SetTemp(78)
+	if got := dev.Status().Tstate; got != ct50.StateCooling {
+		t.Errorf("at 78: tstate %v, want Cooling", got)
+	}
+}
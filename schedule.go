@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+const scheduleUsage = `Usage:
+  thermostat schedule show [heat|cool]
+  thermostat schedule set <heat|cool> <day> HH:MM=temp [HH:MM=temp ...]
+  thermostat schedule export [file]
+  thermostat schedule import <file>
+
+<day> is mon-sun, weekdays, weekend or all. Each day takes 1 to 4 periods.
+Temperatures are shown and entered in the configured unit.
+Export writes both programs as JSON (to stdout if no file is given), and
+import reads the same format back. The file always holds degrees F, as the
+thermostat stores them.`
+
+// scheduleFile is the format used by schedule import and export.
+type scheduleFile struct {
+	Heat *ct50.Program `json:"heat,omitempty"`
+	Cool *ct50.Program `json:"cool,omitempty"`
+}
+
+func run_schedule(ctx context.Context, client *ct50.Client, unit ct50.Unit, args []string) error {
+	if len(args) == 0 {
+		return errors.New(scheduleUsage)
+	}
+
+	switch args[0] {
+	case "show":
+		return schedule_show(ctx, client, unit, args[1:])
+	case "set":
+		return schedule_set(ctx, client, unit, args[1:])
+	case "export":
+		return schedule_export(ctx, client, args[1:])
+	case "import":
+		return schedule_import(ctx, client, args[1:])
+	default:
+		return errors.New(scheduleUsage)
+	}
+}
+
+func schedule_show(ctx context.Context, client *ct50.Client, unit ct50.Unit, args []string) error {
+	modes := []ct50.ProgramMode{ct50.ProgramHeat, ct50.ProgramCool}
+	if len(args) > 0 {
+		mode, err := ct50.ParseProgramMode(args[0])
+		if err != nil {
+			return err
+		}
+		modes = []ct50.ProgramMode{mode}
+	}
+
+	for i, mode := range modes {
+		prog, err := client.Program(ctx, mode)
+		if err != nil {
+			return err
+		}
+
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Println(capitalize(string(mode)) + " program")
+		for d, day := range prog {
+			fmt.Print("  " + capitalize(ct50.DayNames[d]))
+			for _, period := range day {
+				fmt.Print("  " + period.Clock() + " " + strconv.FormatFloat(unit.Setpoint(period.Temp), 'f', -1, 64))
+			}
+			fmt.Println()
+		}
+	}
+
+	return nil
+}
+
+func schedule_set(ctx context.Context, client *ct50.Client, unit ct50.Unit, args []string) error {
+	if len(args) < 3 {
+		return errors.New(scheduleUsage)
+	}
+
+	mode, err := ct50.ParseProgramMode(args[0])
+	if err != nil {
+		return err
+	}
+
+	days, err := parse_days(args[1])
+	if err != nil {
+		return err
+	}
+
+	var periods ct50.Day
+	for _, arg := range args[2:] {
+		period, err := parse_period(arg, unit)
+		if err != nil {
+			return err
+		}
+		periods = append(periods, period)
+	}
+
+	prog, err := client.Program(ctx, mode)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range days {
+		prog[d] = periods
+	}
+
+	// SetProgram validates the whole program before anything is written.
+	if err := client.SetProgram(ctx, mode, prog); err != nil {
+		return err
+	}
+
+	fmt.Println("Updated " + string(mode) + " program for " + args[1])
+	return nil
+}
+
+func schedule_export(ctx context.Context, client *ct50.Client, args []string) error {
+	var file scheduleFile
+	var err error
+
+	file.Heat, err = client.Program(ctx, ct50.ProgramHeat)
+	if err != nil {
+		return err
+	}
+	file.Cool, err = client.Program(ctx, ct50.ProgramCool)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(file, "", " ")
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if err := ioutil.WriteFile(args[0], data, 0644); err != nil {
+		return err
+	}
+	fmt.Println("Schedule exported to " + args[0])
+	return nil
+}
+
+func schedule_import(ctx context.Context, client *ct50.Client, args []string) error {
+	if len(args) != 1 {
+		return errors.New(scheduleUsage)
+	}
+
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	var file scheduleFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("error reading %s: %w", args[0], err)
+	}
+
+	if file.Heat == nil && file.Cool == nil {
+		return fmt.Errorf("%s contains no heat or cool program", args[0])
+	}
+
+	// Validate both programs before writing either, so a bad file never
+	// leaves the thermostat half updated.
+	for _, prog := range []*ct50.Program{file.Heat, file.Cool} {
+		if prog == nil {
+			continue
+		}
+		if err := prog.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if file.Heat != nil {
+		if err := client.SetProgram(ctx, ct50.ProgramHeat, file.Heat); err != nil {
+			return err
+		}
+		fmt.Println("Imported heat program")
+	}
+	if file.Cool != nil {
+		if err := client.SetProgram(ctx, ct50.ProgramCool, file.Cool); err != nil {
+			return err
+		}
+		fmt.Println("Imported cool program")
+	}
+
+	return nil
+}
+
+// parse_days expands a day argument to program day indexes.
+func parse_days(s string) ([]int, error) {
+	switch strings.ToLower(s) {
+	case "all":
+		return []int{0, 1, 2, 3, 4, 5, 6}, nil
+	case "weekdays":
+		return []int{0, 1, 2, 3, 4}, nil
+	case "weekend":
+		return []int{5, 6}, nil
+	}
+
+	d, err := ct50.ParseDay(s)
+	if err != nil {
+		return nil, err
+	}
+	return []int{d}, nil
+}
+
+// parse_period parses a HH:MM=temp argument, with temp in unit, into a
+// period in degrees F.
+func parse_period(s string, unit ct50.Unit) (ct50.Period, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 {
+		return ct50.Period{}, fmt.Errorf("invalid period %q (want HH:MM=temp)", s)
+	}
+
+	minute, err := ct50.ParseClock(parts[0])
+	if err != nil {
+		return ct50.Period{}, err
+	}
+
+	temp, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return ct50.Period{}, fmt.Errorf("invalid temp in period %q", s)
+	}
+
+	return ct50.Period{Minute: minute, Temp: unit.ToDevice(temp)}, nil
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
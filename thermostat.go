@@ -1,46 +1,29 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
-	"strconv"
-	"strings"
 
 	"github.com/AlecAivazis/survey/v2"
+
+	"github.com/EntropySynthetica/Thermostat/internal/audit"
+	"github.com/EntropySynthetica/Thermostat/internal/auth"
+	"github.com/EntropySynthetica/Thermostat/internal/boost"
+	"github.com/EntropySynthetica/Thermostat/internal/config"
+	"github.com/EntropySynthetica/Thermostat/internal/vacation"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
 )
 
 const Version = "1.1.0"
 
-type thermo_stats struct {
-	Temp     float64 `json:"temp"`
-	Tmode    int     `json:"tmode"`
-	Fmode    int     `json:"fmode"`
-	Override int     `json:"override"`
-	Hold     int     `json:"hold"`
-	THeat    float64 `json:"t_heat"`
-	TCool    float64 `json:"t_cool"`
-	Tstate   int     `json:"tstate"`
-	Fstate   int     `json:"fstate"`
-	Time     struct {
-		Day    int `json:"day"`
-		Hour   int `json:"hour"`
-		Minute int `json:"minute"`
-	} `json:"time"`
-	TTypePost int `json:"t_type_post"`
-}
-
-type Config struct {
-	ThermostatIP string `json:"ThermostatIP"`
-}
-
 func NewFile(configFile string) {
-	configData := Config{}
-	configData.ThermostatIP = "192.168.168.100"
+	configData := config.Config{
+		Devices: []config.Device{{Name: config.DefaultDeviceName, IP: "192.168.168.100"}},
+	}
 
 	if _, err := os.Stat(configFile); err == nil {
 		fmt.Println(configFile + " already exists!")
@@ -50,181 +33,155 @@ func NewFile(configFile string) {
 		}
 		survey.AskOne(prompt, &confirm)
 
-		if confirm == true {
-			file, _ := json.MarshalIndent(configData, "", " ")
-			_ = ioutil.WriteFile(configFile, file, 0644)
-			fmt.Println("New hosts file created at " + configFile)
+		if !confirm {
+			return
 		}
-
-	} else {
-		// Check if config directory exists, and make it if it does not.
-		homedir, _ := os.UserHomeDir()
-		_, err := os.Stat(homedir + "/.config/thermostat")
-		if os.IsNotExist(err) {
-			err := os.Mkdir(homedir+"/.config/thermostat", 0755)
-			if err != nil {
-				log.Fatal(err)
-			}
-		}
-
-		file, _ := json.MarshalIndent(configData, "", " ")
-		_ = ioutil.WriteFile(configFile, file, 0644)
-		fmt.Println("New hosts file created at " + configFile)
 	}
 
-}
-
-func get_stats(ip string) {
-	poll_url := "http://" + ip + "/tstat"
-
-	// Poll the API
-	response, err := http.Get(poll_url)
-
-	if err != nil {
-		fmt.Print(err.Error())
-		os.Exit(1)
+	// Save creates the config directory if it does not exist.
+	if err := configData.Save(configFile); err != nil {
+		log.Fatal(err)
 	}
+	fmt.Println("New config file created at " + configFile)
+	fmt.Println("Edit it to set your thermostat's IP, or run 'thermostat discover' to find it on the network.")
+}
 
-	response_data, err := ioutil.ReadAll(response.Body)
+func get_stats(ctx context.Context, client *ct50.Client, unit ct50.Unit) error {
+	stats, err := client.Status(ctx)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	// Parse the JSON
-	var response_stats thermo_stats
-	json.Unmarshal([]byte(response_data), &response_stats)
-
-	// Determine the Thermostat Mode
-	var Tmode_text string
-	switch response_stats.Tmode {
-	case 0:
-		Tmode_text = "Off"
-	case 1:
-		Tmode_text = "Heat"
-	case 2:
-		Tmode_text = "Cool"
-	case 3:
-		Tmode_text = "Auto"
-	default:
-		Tmode_text = "Unknown"
-	}
-	fmt.Println("Thermostat Mode = " + Tmode_text)
+	fmt.Println("Thermostat Mode = " + stats.Tmode.String())
 
 	// Show current Temp
-	fmt.Println("Current Temp = " + strconv.FormatFloat(response_stats.Temp, 'f', -1, 64))
-
-	// The target temp is returned from a different var depending on if the thermostat is in heat or cool mode.  Lets check both and return which ever is not empty
-	var target_temp string
-	switch {
-	case response_stats.THeat != 0:
-		target_temp = strconv.FormatFloat(response_stats.THeat, 'f', -1, 64)
-	case response_stats.TCool != 0:
-		target_temp = strconv.FormatFloat(response_stats.TCool, 'f', -1, 64)
-	default:
-		target_temp = "Unknown"
+	fmt.Println("Current Temp = " + unit.Format(unit.Reading(stats.Temp)))
+
+	// Auto mode holds the temp between two setpoints; heat and cool modes have a single target.
+	if stats.Tmode == ct50.ModeAuto {
+		fmt.Println("Heat Setpoint = " + unit.Format(unit.Setpoint(stats.THeat)))
+		fmt.Println("Cool Setpoint = " + unit.Format(unit.Setpoint(stats.TCool)))
+	} else {
+		target_temp := "Unknown"
+		if target := stats.Target(); target != 0 {
+			target_temp = unit.Format(unit.Setpoint(target))
+		}
+		fmt.Println("Target Temp = " + target_temp)
 	}
-	fmt.Println("Target Temp = " + target_temp)
 
 	// Show the Operational State
-	var Tstate_text string
-	switch response_stats.Tstate {
-	case 0:
-		Tstate_text = "Off"
-	case 1:
-		Tstate_text = "Heating"
-	case 2:
-		Tstate_text = "Cooling"
-	default:
-		Tstate_text = "Unknown"
-	}
-	fmt.Println("Operating Status = " + Tstate_text)
+	fmt.Println("Operating Status = " + stats.Tstate.String())
+
+	// Show the fan setting and whether it is running
+	fmt.Println("Fan Mode = " + stats.Fmode.String() + " (" + stats.Fstate.String() + ")")
 
 	// Show if an Override is active
-	var Override_text string
-	switch response_stats.Override {
-	case 0:
-		Override_text = "Off"
-	case 1:
-		Override_text = "On"
-	default:
-		Override_text = "Unknown"
-	}
-	fmt.Println("Override " + Override_text)
+	fmt.Println("Override " + stats.Override.String())
 
 	// Show if a manual hold is active
-	var Hold_text string
-	switch response_stats.Hold {
-	case 0:
-		Hold_text = "Off"
-	case 1:
-		Hold_text = "On"
-	default:
-		Hold_text = "Unknown"
-	}
-	fmt.Println("Manual Hold " + Hold_text)
+	fmt.Println("Manual Hold " + stats.Hold.String())
 
+	return nil
 }
 
-func set_temp(ip string, temp int) {
-
-	poll_url := "http://" + ip + "/tstat"
-
-	// Poll the API to find the Thermostat Mode.
-	response, err := http.Get(poll_url)
-
+func set_temp(ctx context.Context, client *ct50.Client, temp float64, unit ct50.Unit) error {
+	// The thermostat picks t_heat or t_cool to match the mode it is currently in.
+	target := unit.ToDevice(temp)
+	err := client.SetTarget(ctx, target)
+	if errors.Is(err, ct50.ErrNoTarget) {
+		return fmt.Errorf("%w; in auto mode set -heat and -cool instead", err)
+	}
 	if err != nil {
-		fmt.Print(err.Error())
-		os.Exit(1)
+		return err
 	}
 
-	response_data, err := ioutil.ReadAll(response.Body)
+	fmt.Println("Set Temp to " + unit.Format(unit.Setpoint(target)))
+	return nil
+}
+
+// set_range switches to auto mode with the given heat and cool setpoints.
+func set_range(ctx context.Context, client *ct50.Client, heat, cool float64, unit ct50.Unit) error {
+	heatF, coolF := unit.ToDevice(heat), unit.ToDevice(cool)
+	err := client.SetRange(ctx, heatF, coolF)
+	var deadbandErr *ct50.DeadbandError
+	if errors.As(err, &deadbandErr) {
+		return fmt.Errorf("%w (cool must be at least %s above heat)", err, unit.Format(unit.Delta(deadbandErr.Min)))
+	}
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	var response_stats thermo_stats
-	json.Unmarshal([]byte(response_data), &response_stats)
+	fmt.Println("Set Auto range to " + unit.Format(unit.Setpoint(heatF)) + " - " + unit.Format(unit.Setpoint(coolF)))
+	return nil
+}
 
-	// We will craft our payload to match the mode the thermostat is currently in.
-	var query string
-	if response_stats.Tmode == 1 {
-		query = ("{\"tmode\":1,\"t_heat\":" + strconv.Itoa(temp) + "}")
-	} else if response_stats.Tmode == 2 {
-		query = ("{\"tmode\":2,\"t_cool\":" + strconv.Itoa(temp) + "}")
-	}
+// set_modes applies any mode, fan and hold changes from the command line in a
+// single request and reads the thermostat back to confirm they took.
+func set_modes(ctx context.Context, client *ct50.Client, mode, fan, hold string) error {
+	var update ct50.Update
 
-	// Send the temp set request to the Thermostat
-	payload := strings.NewReader(query)
+	if mode != "none" {
+		tmode, err := ct50.ParseMode(mode)
+		if err != nil {
+			return err
+		}
+		update.Tmode = &tmode
+	}
 
-	req, err := http.NewRequest("POST", poll_url, payload)
+	if fan != "none" {
+		fmode, err := ct50.ParseFanMode(fan)
+		if err != nil {
+			return err
+		}
+		update.Fmode = &fmode
+	}
 
-	if err != nil {
-		fmt.Print(err.Error())
-		os.Exit(1)
+	if hold != "none" {
+		h, err := ct50.ParseOnOff(hold)
+		if err != nil {
+			return err
+		}
+		update.Hold = &h
 	}
 
-	req.Header.Add("Content-Type", "application/json")
+	if update == (ct50.Update{}) {
+		return nil
+	}
 
-	post_response, err := http.DefaultClient.Do(req)
+	stats, err := client.UpdateAndVerify(ctx, update)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	defer post_response.Body.Close()
+	if update.Tmode != nil {
+		fmt.Println("Set Mode to " + stats.Tmode.String())
+	}
+	if update.Fmode != nil {
+		fmt.Println("Set Fan to " + stats.Fmode.String())
+	}
+	if update.Hold != nil {
+		fmt.Println("Set Manual Hold " + stats.Hold.String())
+	}
 
-	fmt.Println("Set Temp to " + strconv.Itoa(temp))
+	return nil
 }
 
 func main() {
-	homedir, _ := os.UserHomeDir()
 	var configFile string
+	var deviceName string
 
 	// Parse CLI Flags
-	tempPtr := flag.Int("temp", 0, "Thermostat temp to set in degrees F")
-	modePtr := flag.String("mode", "none", "Operating Mode, Cool or Heat")
+	tempPtr := flag.Float64("temp", 0, "Thermostat temp to set, in whole or half degrees")
+	heatPtr := flag.Float64("heat", 0, "Auto mode heat setpoint (use with -cool)")
+	coolPtr := flag.Float64("cool", 0, "Auto mode cool setpoint (use with -heat)")
+	unitPtr := flag.String("unit", "", "Temperature unit: F or C (default: from config file, else F)")
+	modePtr := flag.String("mode", "none", "Operating Mode: off, heat, cool or auto")
+	fanPtr := flag.String("fan", "none", "Fan Mode: auto, circulate or on")
+	holdPtr := flag.String("hold", "none", "Manual Hold: on or off")
 	newFile := flag.Bool("new", false, "Create a new config file")
 	showVer := flag.Bool("v", false, "Show Version")
-	flag.StringVar(&configFile, "c", homedir+"/.config/thermostat/config.json", "specify path of config file")
+	flag.StringVar(&configFile, "c", config.DefaultPath(), "specify path of config file")
+	flag.StringVar(&deviceName, "d", "", "name of the thermostat to use (default: first in config file)")
 	flag.Parse()
 
 	// Print Version of app
@@ -238,36 +195,149 @@ func main() {
 		return
 	}
 
+	ctx := context.Background()
+
+	// Discovery works before there is a config file to read.
+	if flag.Arg(0) == "discover" {
+		if err := run_discover(ctx, configFile, flag.Args()[1:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Users and tokens only need the config file, not a thermostat.
+	if flag.Arg(0) == "user" {
+		sessions := auth.NewSessions(auth.DefaultSessionsPath())
+		if err := run_user(configFile, sessions, flag.Args()[1:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.Arg(0) == "token" {
+		tokens := auth.NewTokens(auth.DefaultTokensPath())
+		if err := run_token(configFile, tokens, flag.Args()[1:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Get vars from config file
-	jsonFile, err := os.Open(configFile)
+	configData, err := config.Load(configFile)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
+	device, err := configData.Device(deviceName)
 	if err != nil {
 		fmt.Println(err)
-		return
+		os.Exit(1)
 	}
 
-	// Unmarshal the JSON from the file
-	byteValue, _ := ioutil.ReadAll(jsonFile)
+	client := ct50.New(device.IP)
+	client.Deadband = configData.MinDeadband()
 
-	var jsonResults Config
-	err = json.Unmarshal(byteValue, &jsonResults)
+	// Every change made from here on is recorded in the audit log.
+	auditLog := audit.NewLog(audit.DefaultPath())
+	auditLog.Watch(client, device.Name)
+	ctx = audit.WithOrigin(ctx, cli_origin())
 
-	if err != nil {
-		fmt.Println("Error reading hosts.json ", err)
+	unit := configData.TempUnit()
+	if *unitPtr != "" {
+		if unit, err = ct50.ParseUnit(*unitPtr); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+	}
+
+	// Subcommands take over from the flags entirely.
+	switch flag.Arg(0) {
+	case "":
+	case "devices":
+		for _, dev := range configData.DeviceList() {
+			fmt.Println(dev.Name + " = " + dev.IP)
+		}
+		return
+	case "schedule":
+		if err := run_schedule(ctx, client, unit, flag.Args()[1:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	case "boost":
+		store := boost.NewStore(boost.DefaultPath())
+		if err := run_boost(ctx, client, store, device.Name, unit, flag.Args()[1:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	case "guest":
+		guests := auth.NewGuests(auth.DefaultGuestsPath(), auth.DefaultGuestKeyPath())
+		if err := run_guest(guests, device.Name, unit, flag.Args()[1:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	case "audit":
+		// Without -d, the changes to every thermostat are listed.
+		filter := ""
+		if deviceName != "" {
+			filter = device.Name
+		}
+		if err := run_audit(auditLog, filter, unit, flag.Args()[1:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 		return
+	case "vacation":
+		store := vacation.NewStore(vacation.DefaultPath())
+		boosts := boost.NewStore(boost.DefaultPath())
+		if err := run_vacation(ctx, client, store, boosts, device.Name, unit, flag.Args()[1:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	default:
+		fmt.Println("Unknown command " + flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
 	}
 
-	jsonFile.Close()
+	if (*heatPtr != 0) != (*coolPtr != 0) {
+		fmt.Println("-heat and -cool must be set together")
+		os.Exit(2)
+	}
+
+	// Mode changes come first so a new temp is applied to the new mode.
+	if err := set_modes(ctx, client, *modePtr, *fanPtr, *holdPtr); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
-	thermostat_ip := jsonResults.ThermostatIP
+	// Auto mode takes a heat and cool setpoint pair.
+	if *heatPtr != 0 {
+		if err := set_range(ctx, client, *heatPtr, *coolPtr, unit); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
 
 	// If the temp flag was set lets adjust the temp.
 	if *tempPtr != 0 {
-		set_temp(thermostat_ip, *tempPtr)
+		if err := set_temp(ctx, client, *tempPtr, unit); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 	}
 
 	// If no arguments were entered poll the thermostat for stats and return them.
-	if *tempPtr == 0 && *modePtr == "none" {
-		get_stats(thermostat_ip)
+	if *tempPtr == 0 && *heatPtr == 0 && *coolPtr == 0 && *modePtr == "none" && *fanPtr == "none" && *holdPtr == "none" {
+		if err := get_stats(ctx, client, unit); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 	}
 }
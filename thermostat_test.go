@@ -0,0 +1,551 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/internal/audit"
+	"github.com/EntropySynthetica/Thermostat/internal/auth"
+	"github.com/EntropySynthetica/Thermostat/internal/boost"
+	"github.com/EntropySynthetica/Thermostat/internal/config"
+	"github.com/EntropySynthetica/Thermostat/internal/vacation"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50sim"
+)
+
+// newSim starts an emulated thermostat and returns a client for it that
+// retries without the usual delay.
+func newSim(t *testing.T, opts ct50sim.Options) (*ct50sim.Device, *ct50.Client) {
+	t.Helper()
+
+	dev := ct50sim.New(opts)
+	srv := httptest.NewServer(dev)
+	t.Cleanup(srv.Close)
+
+	client := ct50.New(srv.URL)
+	client.RetryDelay = time.Millisecond
+	return dev, client
+}
+
+// faults returns emulator options that inject the given faults.
+func faults(f ...ct50sim.Fault) ct50sim.Options {
+	return ct50sim.Options{Scenario: &ct50sim.Scenario{Faults: f}}
+}
+
+// captureOutput runs fn with stdout redirected and returns what it printed.
+func captureOutput(t *testing.T, fn func() error) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	done := make(chan string)
+	go func() {
+		out, _ := io.ReadAll(r)
+		done <- string(out)
+	}()
+
+	fnErr := fn()
+	w.Close()
+	out := <-done
+
+	if fnErr != nil {
+		t.Fatal(fnErr)
+	}
+	return out
+}
+
+func TestGetStats(t *testing.T) {
+	dev, client := newSim(t, ct50sim.Options{})
+	dev.SetTemp(71.5)
+
+	out := captureOutput(t, func() error { return get_stats(context.Background(), client, ct50.Fahrenheit) })
+
+	for _, want := range []string{"Thermostat Mode = Heat", "Current Temp = 71.5", "Fan Mode = Auto", "Manual Hold Off"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestSetModesAndTemp(t *testing.T) {
+	dev, client := newSim(t, ct50sim.Options{})
+	ctx := context.Background()
+
+	captureOutput(t, func() error { return set_modes(ctx, client, "cool", "on", "on") })
+	captureOutput(t, func() error { return set_temp(ctx, client, 72, ct50.Fahrenheit) })
+
+	stats := dev.Status()
+	if stats.Tmode != ct50.ModeCool || stats.Fmode != ct50.FanOn || stats.Hold != ct50.On {
+		t.Errorf("got mode %v fan %v hold %v, want Cool, On, On", stats.Tmode, stats.Fmode, stats.Hold)
+	}
+	if stats.TCool != 72 {
+		t.Errorf("t_cool = %v, want 72", stats.TCool)
+	}
+
+	if err := set_modes(ctx, client, "sideways", "none", "none"); err == nil {
+		t.Error("set_modes accepted an unknown mode")
+	}
+}
+
+func TestSetTempWhenOff(t *testing.T) {
+	_, client := newSim(t, ct50sim.Options{})
+	ctx := context.Background()
+
+	captureOutput(t, func() error { return set_modes(ctx, client, "off", "none", "none") })
+	if err := set_temp(ctx, client, 70, ct50.Fahrenheit); !errors.Is(err, ct50.ErrNoTarget) {
+		t.Errorf("set_temp in off mode = %v, want ErrNoTarget", err)
+	}
+}
+
+func TestScheduleSetAndShow(t *testing.T) {
+	dev, client := newSim(t, ct50sim.Options{})
+	ctx := context.Background()
+
+	captureOutput(t, func() error {
+		return run_schedule(ctx, client, ct50.Fahrenheit, []string{"set", "heat", "weekend", "07:30=68", "23:00=60"})
+	})
+
+	prog := dev.Program(ct50.ProgramHeat)
+	want := ct50.Day{{Minute: 450, Temp: 68}, {Minute: 1380, Temp: 60}}
+	for _, d := range []int{5, 6} {
+		if len(prog[d]) != 2 || prog[d][0] != want[0] || prog[d][1] != want[1] {
+			t.Errorf("%s = %v, want %v", ct50.DayNames[d], prog[d], want)
+		}
+	}
+
+	out := captureOutput(t, func() error { return run_schedule(ctx, client, ct50.Fahrenheit, []string{"show", "heat"}) })
+	if !strings.Contains(out, "Sat  07:30 68  23:00 60") {
+		t.Errorf("show output missing the new Saturday:\n%s", out)
+	}
+
+	// A period before the previous one is rejected before anything is sent.
+	if err := run_schedule(ctx, client, ct50.Fahrenheit, []string{"set", "heat", "mon", "09:00=70", "08:00=62"}); err == nil {
+		t.Error("schedule set accepted periods out of order")
+	}
+}
+
+func TestScheduleExportImport(t *testing.T) {
+	_, from := newSim(t, ct50sim.Options{})
+	to, toClient := newSim(t, ct50sim.Options{})
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "schedule.json")
+
+	captureOutput(t, func() error {
+		return run_schedule(ctx, from, ct50.Fahrenheit, []string{"set", "cool", "all", "06:00=74", "22:00=77"})
+	})
+	captureOutput(t, func() error { return run_schedule(ctx, from, ct50.Fahrenheit, []string{"export", file}) })
+	captureOutput(t, func() error { return run_schedule(ctx, toClient, ct50.Fahrenheit, []string{"import", file}) })
+
+	prog := to.Program(ct50.ProgramCool)
+	for d, day := range prog {
+		if len(day) != 2 || day[1].Temp != 77 {
+			t.Errorf("imported %s = %v", ct50.DayNames[d], day)
+		}
+	}
+}
+
+func TestGetStatsRetriesFlakyDevice(t *testing.T) {
+	dev, client := newSim(t, faults(
+		ct50sim.Fault{Path: "/tstat", Method: "GET", Count: 1, Action: ct50sim.FaultStatus, Status: 500},
+		ct50sim.Fault{Path: "/tstat", Method: "GET", Count: 1, Action: ct50sim.FaultMalformed},
+	))
+	dev.SetTemp(69)
+
+	out := captureOutput(t, func() error { return get_stats(context.Background(), client, ct50.Fahrenheit) })
+	if !strings.Contains(out, "Current Temp = 69") {
+		t.Errorf("output after retries:\n%s", out)
+	}
+	if fired := dev.Fired(); fired[0] != 1 || fired[1] != 1 {
+		t.Errorf("faults fired %v, want each once", fired)
+	}
+}
+
+func TestGetStatsStaleReading(t *testing.T) {
+	// One stale reading is retried past.
+	dev, client := newSim(t, faults(ct50sim.Fault{Path: "/tstat", Count: 1, Action: ct50sim.FaultStale}))
+	dev.SetTemp(67)
+
+	out := captureOutput(t, func() error { return get_stats(context.Background(), client, ct50.Fahrenheit) })
+	if !strings.Contains(out, "Current Temp = 67") {
+		t.Errorf("output after a stale reading:\n%s", out)
+	}
+
+	// A sensor that stays stale is an error, not a temperature of -1.
+	_, client = newSim(t, faults(ct50sim.Fault{Path: "/tstat", Action: ct50sim.FaultStale}))
+	if err := get_stats(context.Background(), client, ct50.Fahrenheit); !errors.Is(err, ct50.ErrNoReading) {
+		t.Errorf("get_stats with a stale sensor = %v, want ErrNoReading", err)
+	}
+}
+
+func TestGetStatsGivesUp(t *testing.T) {
+	dev, client := newSim(t, faults(ct50sim.Fault{Action: ct50sim.FaultReset}))
+
+	if err := get_stats(context.Background(), client, ct50.Fahrenheit); err == nil {
+		t.Fatal("get_stats succeeded against a device that drops every connection")
+	}
+	if fired := dev.Fired()[0]; fired < 1+ct50.DefaultRetries {
+		t.Errorf("device saw %d attempts, want at least %d", fired, 1+ct50.DefaultRetries)
+	}
+}
+
+func TestSetTempRetriesSlowDevice(t *testing.T) {
+	dev, client := newSim(t, faults(ct50sim.Fault{Path: "/tstat", Method: "POST", Count: 1, Latency: time.Second}))
+	client.HTTPClient.Timeout = 100 * time.Millisecond
+
+	captureOutput(t, func() error { return set_temp(context.Background(), client, 72, ct50.Fahrenheit) })
+
+	if got := dev.Status().THeat; got != 72 {
+		t.Errorf("t_heat = %v, want 72 after the timed out request was retried", got)
+	}
+}
+
+func TestSetTempDeviceError(t *testing.T) {
+	dev, client := newSim(t, faults(ct50sim.Fault{Path: "/tstat", Method: "POST", Action: ct50sim.FaultError, Error: "busy"}))
+
+	err := set_temp(context.Background(), client, 72, ct50.Fahrenheit)
+	var devErr *ct50.DeviceError
+	if !errors.As(err, &devErr) || devErr.Reason != "busy" {
+		t.Fatalf("set_temp = %v, want a DeviceError saying busy", err)
+	}
+
+	// The thermostat said no, so asking again will not help.
+	if fired := dev.Fired()[0]; fired != 1 {
+		t.Errorf("rejected request was sent %d times, want 1", fired)
+	}
+}
+
+func TestSetRange(t *testing.T) {
+	dev, client := newSim(t, ct50sim.Options{})
+	ctx := context.Background()
+
+	captureOutput(t, func() error { return set_range(ctx, client, 68, 76, ct50.Fahrenheit) })
+
+	stats := dev.Status()
+	if stats.Tmode != ct50.ModeAuto || stats.THeat != 68 || stats.TCool != 76 {
+		t.Errorf("got mode %v heat %v cool %v, want Auto 68-76", stats.Tmode, stats.THeat, stats.TCool)
+	}
+
+	out := captureOutput(t, func() error { return get_stats(ctx, client, ct50.Fahrenheit) })
+	if !strings.Contains(out, "Heat Setpoint = 68") || !strings.Contains(out, "Cool Setpoint = 76") {
+		t.Errorf("auto mode output missing the setpoint pair:\n%s", out)
+	}
+
+	// A single target makes no sense in auto.
+	if err := set_temp(ctx, client, 70, ct50.Fahrenheit); err == nil || !strings.Contains(err.Error(), "-heat and -cool") {
+		t.Errorf("set_temp in auto mode = %v, want advice to use -heat and -cool", err)
+	}
+
+	client.Deadband = 4
+	var deadbandErr *ct50.DeadbandError
+	if err := set_range(ctx, client, 70, 73, ct50.Fahrenheit); !errors.As(err, &deadbandErr) {
+		t.Errorf("set_range inside the deadband = %v, want a DeadbandError", err)
+	}
+	if got := dev.Status().THeat; got != 68 {
+		t.Errorf("t_heat = %v after a rejected range, want 68 untouched", got)
+	}
+}
+
+func TestCelsius(t *testing.T) {
+	dev, client := newSim(t, ct50sim.Options{})
+	dev.SetTemp(68)
+	ctx := context.Background()
+
+	out := captureOutput(t, func() error { return set_temp(ctx, client, 21.5, ct50.Celsius) })
+	if !strings.Contains(out, "Set Temp to 21.5°C") {
+		t.Errorf("set_temp output = %q", out)
+	}
+	if stats := dev.Status(); stats.THeat != 70.5 {
+		t.Errorf("t_heat = %v, want 70.5", stats.THeat)
+	}
+
+	out = captureOutput(t, func() error { return get_stats(ctx, client, ct50.Celsius) })
+	for _, want := range []string{"Current Temp = 20°C", "Target Temp = 21.5°C"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("get_stats output missing %q:\n%s", want, out)
+		}
+	}
+
+	captureOutput(t, func() error {
+		return run_schedule(ctx, client, ct50.Celsius, []string{"set", "heat", "mon", "06:00=20.5", "22:00=16"})
+	})
+	if prog := dev.Program(ct50.ProgramHeat); prog[0][0].Temp != 69 || prog[0][1].Temp != 61 {
+		t.Errorf("Monday = %v, want 69 and 61", prog[0])
+	}
+}
+
+func TestBoost(t *testing.T) {
+	dev, client := newSim(t, ct50sim.Options{})
+	store := boost.NewStore(filepath.Join(t.TempDir(), "boosts.json"))
+	ctx := context.Background()
+	before := dev.Status()
+
+	out := captureOutput(t, func() error {
+		return run_boost(ctx, client, store, "upstairs", ct50.Fahrenheit, []string{"--temp", "74", "--for", "2h"})
+	})
+	if !strings.Contains(out, "Boosting Heat to 74°F until") {
+		t.Errorf("boost output = %q", out)
+	}
+	if stats := dev.Status(); stats.THeat != 74 || stats.Hold != ct50.On {
+		t.Errorf("during boost t_heat %v hold %v, want 74 and on", stats.THeat, stats.Hold)
+	}
+
+	out = captureOutput(t, func() error { return run_boost(ctx, client, store, "upstairs", ct50.Fahrenheit, nil) })
+	if !strings.Contains(out, "Boosted Heat to 74°F until") || !strings.Contains(out, "2h0m0s left") {
+		t.Errorf("boost status output = %q", out)
+	}
+
+	out = captureOutput(t, func() error {
+		return run_boost(ctx, client, store, "upstairs", ct50.Fahrenheit, []string{"cancel"})
+	})
+	if !strings.Contains(out, "restored Heat mode") {
+		t.Errorf("cancel output = %q", out)
+	}
+	if stats := dev.Status(); stats.THeat != before.THeat || stats.Hold != before.Hold {
+		t.Errorf("after cancel t_heat %v hold %v, want %v and %v", stats.THeat, stats.Hold, before.THeat, before.Hold)
+	}
+
+	for _, args := range [][]string{{"--temp", "74"}, {"--temp", "74", "--for", "48h"}, {"--temp", "74", "--for", "1h", "--mode", "auto"}} {
+		if err := run_boost(ctx, client, store, "upstairs", ct50.Fahrenheit, args); err == nil {
+			t.Errorf("boost %v was accepted", args)
+		}
+	}
+}
+
+func TestVacation(t *testing.T) {
+	dev, client := newSim(t, ct50sim.Options{})
+	dir := t.TempDir()
+	store := vacation.NewStore(filepath.Join(dir, "vacations.json"))
+	boosts := boost.NewStore(filepath.Join(dir, "boosts.json"))
+	ctx := context.Background()
+	before := dev.Status()
+
+	to := time.Now().AddDate(0, 0, 7).Format("2006-01-02")
+	out := captureOutput(t, func() error {
+		return run_vacation(ctx, client, store, boosts, "upstairs", ct50.Fahrenheit, []string{"--to", to, "--heat", "55", "--cool", "85", "--recover", "3h"})
+	})
+	if !strings.Contains(out, "Vacation set: heat 55°F, cool 85°F") || !strings.Contains(out, "recovering from") {
+		t.Errorf("vacation output = %q", out)
+	}
+	if stats := dev.Status(); stats.THeat != 55 || stats.Hold != ct50.On {
+		t.Errorf("on vacation t_heat %v hold %v, want 55 and on", stats.THeat, stats.Hold)
+	}
+
+	out = captureOutput(t, func() error { return run_vacation(ctx, client, store, boosts, "upstairs", ct50.Fahrenheit, nil) })
+	if !strings.Contains(out, "On vacation: heat 55°F") {
+		t.Errorf("vacation status output = %q", out)
+	}
+
+	out = captureOutput(t, func() error {
+		return run_vacation(ctx, client, store, boosts, "upstairs", ct50.Fahrenheit, []string{"cancel"})
+	})
+	if !strings.Contains(out, "restored Heat mode") {
+		t.Errorf("cancel output = %q", out)
+	}
+	if stats := dev.Status(); stats.Hold != before.Hold {
+		t.Errorf("after cancel hold %v, want %v", stats.Hold, before.Hold)
+	}
+
+	for _, args := range [][]string{{"--heat", "55", "--cool", "85"}, {"--to", "2001-01-01", "--heat", "55", "--cool", "85"}, {"--to", to, "--heat", "70", "--cool", "71"}} {
+		if err := run_vacation(ctx, client, store, boosts, "upstairs", ct50.Fahrenheit, args); err == nil {
+			t.Errorf("vacation %v was accepted", args)
+		}
+	}
+}
+
+func TestUser(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.json")
+	cfg := &config.Config{Devices: []config.Device{{Name: "upstairs", IP: "192.168.1.20"}}}
+	if err := cfg.Save(configFile); err != nil {
+		t.Fatal(err)
+	}
+	// As an older version left it.
+	if err := os.Chmod(configFile, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sessions := auth.NewSessions(filepath.Join(dir, "sessions.json"))
+
+	password := "correct horse"
+	ask_password = func(string) (string, error) { return password, nil }
+	t.Cleanup(func() {
+		ask_password = func(string) (string, error) { return "", errors.New("no terminal") }
+	})
+
+	user := func(args ...string) string {
+		t.Helper()
+		return captureOutput(t, func() error { return run_user(configFile, sessions, args) })
+	}
+
+	user("add", "alice", "--role", "controller")
+	user("add", "guest")
+	if out := user(); out != "alice = controller\nguest = viewer\n" {
+		t.Errorf("user list = %q", out)
+	}
+	if err := run_user(configFile, sessions, []string{"add", "alice"}); err == nil {
+		t.Error("added alice twice")
+	}
+	if err := run_user(configFile, sessions, []string{"add", "bob", "--role", "admin"}); err == nil {
+		t.Error("added a user with an unknown role")
+	}
+
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, _ := cfg.User("alice")
+	if strings.Contains(alice.PasswordHash, password) || !auth.CheckPassword(alice.PasswordHash, password) {
+		t.Errorf("alice's PasswordHash = %q, want a bcrypt hash of the password", alice.PasswordHash)
+	}
+	if info, err := os.Stat(configFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("config file = %v, %v, want mode 0600 now it holds password hashes", info, err)
+	}
+
+	// A new password signs alice out.
+	token, _, err := sessions.Create("alice", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	password = "battery staple"
+	user("passwd", "alice")
+	if sess, _ := sessions.Get(token, time.Now()); sess != nil {
+		t.Error("alice is still signed in after a new password")
+	}
+	cfg, _ = config.Load(configFile)
+	if alice, _ := cfg.User("alice"); !auth.CheckPassword(alice.PasswordHash, password) {
+		t.Error("passwd did not change alice's password")
+	}
+
+	user("role", "guest", "controller")
+	user("remove", "alice")
+	if out := user("list"); out != "guest = controller\n" {
+		t.Errorf("user list = %q", out)
+	}
+}
+
+func TestToken(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.json")
+	cfg := &config.Config{Devices: []config.Device{{Name: "upstairs", IP: "192.168.1.20"}}}
+	if err := cfg.Save(configFile); err != nil {
+		t.Fatal(err)
+	}
+	tokens := auth.NewTokens(filepath.Join(dir, "tokens.json"))
+
+	out := captureOutput(t, func() error {
+		return run_token(configFile, tokens, []string{"create", "--name", "cron", "--scope", "status:read,setpoint:write", "--device", "upstairs"})
+	})
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	token, err := tokens.Check(lines[len(lines)-1], time.Now())
+	if err != nil || token == nil {
+		t.Fatalf("the printed token %q does not check out: %v", lines[len(lines)-1], err)
+	}
+
+	for _, args := range [][]string{
+		{"create", "--scope", "status:read"},
+		{"create", "--name", "x", "--scope", "everything:write"},
+		{"create", "--name", "x", "--scope", "status:read", "--device", "attic"},
+	} {
+		if err := run_token(configFile, tokens, args); err == nil {
+			t.Errorf("token %v was accepted", args)
+		}
+	}
+
+	out = captureOutput(t, func() error { return run_token(configFile, tokens, nil) })
+	if !strings.Contains(out, token.ID+"  cron") || !strings.Contains(out, "status:read,setpoint:write on upstairs") || !strings.Contains(out, "last used") {
+		t.Errorf("token list = %q", out)
+	}
+
+	captureOutput(t, func() error { return run_token(configFile, tokens, []string{"revoke", token.ID}) })
+	if out := captureOutput(t, func() error { return run_token(configFile, tokens, []string{"list"}) }); out != "No tokens\n" {
+		t.Errorf("token list after revoke = %q", out)
+	}
+}
+
+func TestGuest(t *testing.T) {
+	dir := t.TempDir()
+	guests := auth.NewGuests(filepath.Join(dir, "guests.json"), filepath.Join(dir, "guest-key"))
+
+	out := captureOutput(t, func() error {
+		return run_guest(guests, "upstairs", ct50.Fahrenheit, []string{"create", "--name", "sitter", "--min", "66", "--max", "74", "--url", "https://thermostat.local:8443/"})
+	})
+	if !strings.Contains(out, "upstairs, 66°F to 74°F, no mode changes") {
+		t.Errorf("guest create = %q", out)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	link := strings.TrimPrefix(lines[len(lines)-1], "https://thermostat.local:8443/guest/")
+	g, err := guests.Check(link, time.Now())
+	if err != nil || g == nil || g.MinTemp != 66 || g.MaxTemp != 74 || g.Mode {
+		t.Fatalf("the printed link %q checks out as %+v, %v", lines[len(lines)-1], g, err)
+	}
+	if days := g.Expires.Sub(g.Created); days != 7*24*time.Hour {
+		t.Errorf("link lasts %v, want 7 days", days)
+	}
+
+	for _, args := range [][]string{
+		{"create", "--min", "66", "--max", "74"},
+		{"create", "--name", "x", "--min", "66"},
+		{"create", "--name", "x", "--min", "74", "--max", "66"},
+		{"create", "--name", "x", "--min", "66", "--max", "74", "--days", "0"},
+	} {
+		if err := run_guest(guests, "upstairs", ct50.Fahrenheit, args); err == nil {
+			t.Errorf("guest %v was accepted", args)
+		}
+	}
+
+	// Listed again, the link is the same.
+	out = captureOutput(t, func() error { return run_guest(guests, "upstairs", ct50.Celsius, nil) })
+	if !strings.Contains(out, g.ID+"  sitter") || !strings.Contains(out, "19°C to 23.5°C") || !strings.Contains(out, "/guest/"+link) {
+		t.Errorf("guest list = %q", out)
+	}
+
+	captureOutput(t, func() error { return run_guest(guests, "upstairs", ct50.Fahrenheit, []string{"revoke", g.ID}) })
+	if out := captureOutput(t, func() error { return run_guest(guests, "upstairs", ct50.Fahrenheit, []string{"list"}) }); out != "No guest links\n" {
+		t.Errorf("guest list after revoke = %q", out)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	dev, client := newSim(t, ct50sim.Options{})
+	before := dev.Status()
+	log := audit.NewLog(filepath.Join(t.TempDir(), "audit.log"))
+	log.Watch(client, "upstairs")
+
+	if out := captureOutput(t, func() error { return run_audit(log, "", ct50.Fahrenheit, nil) }); out != "No changes\n" {
+		t.Errorf("audit of an empty log = %q", out)
+	}
+
+	ctx := audit.WithOrigin(context.Background(), audit.Origin{
+		Actor:  audit.Actor{Kind: audit.CLI, Name: "alice@den"},
+		Action: "thermostat -temp 72",
+	})
+	captureOutput(t, func() error { return set_temp(ctx, client, 72, ct50.Fahrenheit) })
+	if dev.Status().THeat != 72 {
+		t.Fatalf("t_heat = %v, want 72", dev.Status().THeat)
+	}
+
+	out := captureOutput(t, func() error { return run_audit(log, "upstairs", ct50.Fahrenheit, []string{"--since", "1h"}) })
+	want := fmt.Sprintf("upstairs     cli alice@den: thermostat -temp 72\n    Heat %v°F → 72°F", before.THeat)
+	if !strings.Contains(out, want) {
+		t.Errorf("audit = %q, want %q", out, want)
+	}
+	if out := captureOutput(t, func() error { return run_audit(log, "downstairs", ct50.Fahrenheit, nil) }); out != "No changes\n" {
+		t.Errorf("audit -d downstairs = %q", out)
+	}
+	if err := run_audit(log, "", ct50.Fahrenheit, []string{"extra"}); err == nil {
+		t.Error("audit accepted an extra argument")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/internal/auth"
+	"github.com/EntropySynthetica/Thermostat/internal/config"
+)
+
+const tokenUsage = `Usage:
+  thermostat token create --name <name> --scope <scope,...> [--device <name,...>]
+  thermostat token [list]
+  thermostat token revoke <id>
+
+API tokens let scripts call the web server without signing in. Send one in
+an Authorization header: "Authorization: Bearer <token>". The token is
+shown once, when it is created; only a hash of it is kept.
+
+Scopes are status:read (every GET but the audit log), setpoint:write,
+mode:write, fan:write, schedule:write and audit:read. Boosts and vacations need both setpoint:write and
+mode:write. --device limits the token to those thermostats; without it the
+token works on all of them.`
+
+func run_token(configFile string, tokens *auth.Tokens, args []string) error {
+	if len(args) == 0 || args[0] == "list" {
+		return token_list(tokens)
+	}
+
+	switch args[0] {
+	case "create":
+		return token_create(configFile, tokens, args[1:])
+	case "revoke":
+		if len(args) != 2 {
+			return errors.New(tokenUsage)
+		}
+		if err := tokens.Revoke(args[1]); err != nil {
+			return err
+		}
+		fmt.Println("Revoked token " + args[1])
+		return nil
+	default:
+		return errors.New(tokenUsage)
+	}
+}
+
+func token_create(configFile string, tokens *auth.Tokens, args []string) error {
+	flags := flag.NewFlagSet("token create", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Println(tokenUsage) }
+	name := flags.String("name", "", "what the token is for, such as cron")
+	scopeList := flags.String("scope", "", "comma-separated scopes")
+	deviceList := flags.String("device", "", "comma-separated thermostats (default all)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 || *name == "" {
+		return errors.New(tokenUsage)
+	}
+
+	scopes, err := auth.ParseScopes(*scopeList)
+	if err != nil {
+		return err
+	}
+
+	var devices []string
+	if *deviceList != "" {
+		configData, err := config.Load(configFile)
+		if err != nil {
+			return err
+		}
+		for _, d := range strings.Split(*deviceList, ",") {
+			if d = strings.TrimSpace(d); d == "" {
+				continue
+			}
+			dev, err := configData.Device(d)
+			if err != nil {
+				return err
+			}
+			devices = append(devices, dev.Name)
+		}
+	}
+
+	token, t, err := tokens.Create(*name, scopes, devices, time.Now())
+	if err != nil {
+		return err
+	}
+	fmt.Println("Created token " + t.ID + " (" + t.Name + "). Copy it now, it is not shown again:")
+	fmt.Println(token)
+	return nil
+}
+
+func token_list(tokens *auth.Tokens) error {
+	list, err := tokens.List()
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		fmt.Println("No tokens")
+		return nil
+	}
+
+	for _, t := range list {
+		var scopes []string
+		for _, s := range t.Scopes {
+			scopes = append(scopes, string(s))
+		}
+		devices := "all thermostats"
+		if len(t.Devices) > 0 {
+			devices = strings.Join(t.Devices, ", ")
+		}
+		lastUsed := "never used"
+		if !t.LastUsed.IsZero() {
+			lastUsed = "last used " + t.LastUsed.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Printf("%s  %-16s %s on %s, created %s, %s\n", t.ID, t.Name, strings.Join(scopes, ","), devices,
+			t.Created.Local().Format("2006-01-02"), lastUsed)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/AlecAivazis/survey/v2"
+
+	"github.com/EntropySynthetica/Thermostat/internal/auth"
+	"github.com/EntropySynthetica/Thermostat/internal/config"
+)
+
+const userUsage = `Usage:
+  thermostat user [list]
+  thermostat user add <name> [--role viewer|controller]
+  thermostat user passwd <name>
+  thermostat user role <name> <viewer|controller>
+  thermostat user remove <name>
+
+Users sign in to the web server. A viewer can only look at the thermostats;
+a controller can also change the mode, setpoints and schedules. New users
+are viewers unless --role says otherwise.
+
+Passwords are asked for and stored in the config file as bcrypt hashes.
+Changing a password, changing a role or removing a user signs them out of
+the web server everywhere. Once the first user is added, restart the web
+server so it starts asking for a sign in.`
+
+// ask_password prompts for a password. Tests replace it.
+var ask_password = func(message string) (string, error) {
+	var password string
+	err := survey.AskOne(&survey.Password{Message: message}, &password)
+	return password, err
+}
+
+func run_user(configFile string, sessions *auth.Sessions, args []string) error {
+	configData, err := config.Load(configFile)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 || args[0] == "list" {
+		user_list(configData)
+		return nil
+	}
+	if len(args) < 2 {
+		return errors.New(userUsage)
+	}
+
+	switch args[0] {
+	case "add":
+		err = user_add(configData, args[1:])
+	case "passwd":
+		err = user_passwd(configData, args[1])
+	case "role":
+		if len(args) != 3 {
+			return errors.New(userUsage)
+		}
+		err = user_role(configData, args[1], args[2])
+	case "remove":
+		err = user_remove(configData, args[1])
+	default:
+		return errors.New(userUsage)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := configData.Validate(); err != nil {
+		return err
+	}
+	if err := configData.Save(configFile); err != nil {
+		return err
+	}
+
+	// Whatever changed, the user's old sessions no longer match it.
+	if args[0] != "add" {
+		return sessions.DeleteUser(args[1])
+	}
+	return nil
+}
+
+func user_list(configData *config.Config) {
+	if len(configData.Users) == 0 {
+		fmt.Println("No users. The web server lets anyone on the network in.")
+		return
+	}
+	for _, u := range configData.Users {
+		fmt.Println(u.Name + " = " + string(u.Role))
+	}
+}
+
+func user_add(configData *config.Config, args []string) error {
+	name := args[0]
+	flags := flag.NewFlagSet("user add", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Println(userUsage) }
+	roleFlag := flags.String("role", string(config.Viewer), "viewer or controller")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return errors.New(userUsage)
+	}
+
+	role, err := config.ParseRole(*roleFlag)
+	if err != nil {
+		return err
+	}
+	if _, ok := configData.User(name); ok {
+		return fmt.Errorf("user %q already exists", name)
+	}
+
+	hash, err := new_password(name)
+	if err != nil {
+		return err
+	}
+	configData.Users = append(configData.Users, config.User{Name: name, PasswordHash: hash, Role: role})
+	fmt.Println("Added " + string(role) + " " + name)
+	return nil
+}
+
+func user_passwd(configData *config.Config, name string) error {
+	u, err := find_user(configData, name)
+	if err != nil {
+		return err
+	}
+	if u.PasswordHash, err = new_password(name); err != nil {
+		return err
+	}
+	fmt.Println("Changed the password for " + name)
+	return nil
+}
+
+func user_role(configData *config.Config, name, roleName string) error {
+	u, err := find_user(configData, name)
+	if err != nil {
+		return err
+	}
+	if u.Role, err = config.ParseRole(roleName); err != nil {
+		return err
+	}
+	fmt.Println(name + " is now a " + string(u.Role))
+	return nil
+}
+
+func user_remove(configData *config.Config, name string) error {
+	if _, err := find_user(configData, name); err != nil {
+		return err
+	}
+	var kept []config.User
+	for _, u := range configData.Users {
+		if u.Name != name {
+			kept = append(kept, u)
+		}
+	}
+	configData.Users = kept
+	fmt.Println("Removed " + name)
+	return nil
+}
+
+// find_user returns the named user for editing in place.
+func find_user(configData *config.Config, name string) (*config.User, error) {
+	for i := range configData.Users {
+		if configData.Users[i].Name == name {
+			return &configData.Users[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown user %q", name)
+}
+
+// new_password asks for a password twice and returns its hash.
+func new_password(name string) (string, error) {
+	password, err := ask_password("Password for " + name + ":")
+	if err != nil {
+		return "", err
+	}
+	again, err := ask_password("Repeat the password:")
+	if err != nil {
+		return "", err
+	}
+	if password != again {
+		return "", errors.New("the passwords do not match")
+	}
+	return auth.HashPassword(password)
+}
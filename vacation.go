@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/EntropySynthetica/Thermostat/internal/boost"
+	"github.com/EntropySynthetica/Thermostat/internal/vacation"
+	"github.com/EntropySynthetica/Thermostat/pkg/ct50"
+)
+
+const vacationUsage = `Usage:
+  thermostat vacation --to 2024-12-28 --heat 55 --cool 85 [--from 2024-12-20] [--recover 3h]
+  thermostat vacation
+  thermostat vacation cancel
+
+A vacation holds the thermostat at the away setpoints from --from (default
+now) until --to, and then puts back the mode, setpoints and hold it had
+before. Dates may have a time, as in "2024-12-28 18:00"; on their own they
+mean midnight. --recover drops the away setpoints that long before --to, so
+the house is comfortable again on your return.
+
+With no flags, vacation shows the vacation. Cancel ends it now, putting the
+old setting back if it has started.
+
+The web server starts and ends vacations on time. Without it, run
+'thermostat vacation' once the vacation starts and again once it is over.`
+
+func run_vacation(ctx context.Context, client *ct50.Client, store *vacation.Store, boosts *boost.Store, device string, unit ct50.Unit, args []string) error {
+	if len(args) > 0 && args[0] == "cancel" {
+		return vacation_cancel(ctx, client, store, device)
+	}
+
+	flags := flag.NewFlagSet("vacation", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Println(vacationUsage) }
+	from := flags.String("from", "", "when the vacation starts (default now)")
+	to := flags.String("to", "", "when you are back")
+	heat := flags.Float64("heat", 0, "away heat setpoint")
+	cool := flags.Float64("cool", 0, "away cool setpoint")
+	recovery := flags.Duration("recover", 0, "how long before --to to go back to normal, such as 3h")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return errors.New(vacationUsage)
+	}
+
+	now := time.Now()
+	if flags.NFlag() == 0 {
+		return vacation_show(ctx, client, store, boosts, device, unit, now)
+	}
+	if *to == "" || *heat == 0 || *cool == 0 {
+		return errors.New("vacation needs --to, --heat and --cool")
+	}
+
+	v := &vacation.Vacation{
+		Device:   device,
+		From:     now,
+		Heat:     unit.ToDevice(*heat),
+		Cool:     unit.ToDevice(*cool),
+		Recovery: *recovery,
+	}
+	var err error
+	if *from != "" {
+		if v.From, err = vacation.ParseTime(*from, time.Local); err != nil {
+			return err
+		}
+	}
+	if v.To, err = vacation.ParseTime(*to, time.Local); err != nil {
+		return err
+	}
+	if err := v.Validate(client.Deadband, now); err != nil {
+		return err
+	}
+
+	if _, err := store.Set(ctx, client, boosts, v, now); err != nil {
+		return err
+	}
+	fmt.Println("Vacation set: " + vacation_summary(v, unit))
+	return nil
+}
+
+// vacation_show shows the vacation, first starting or ending it if that
+// is due.
+func vacation_show(ctx context.Context, client *ct50.Client, store *vacation.Store, boosts *boost.Store, device string, unit ct50.Unit, now time.Time) error {
+	v, err := store.Get(device)
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		fmt.Println("No vacation set")
+		return nil
+	}
+
+	action, err := store.Run(ctx, client, boosts, v, now)
+	if err != nil {
+		return err
+	}
+	if action == vacation.Ended {
+		fmt.Println("Vacation over; restored " + v.Prior.Tmode.String() + " mode")
+		return nil
+	}
+
+	state := "Vacation set: "
+	if v.Started() {
+		state = "On vacation: "
+	}
+	fmt.Println(state + vacation_summary(v, unit))
+	return nil
+}
+
+func vacation_cancel(ctx context.Context, client *ct50.Client, store *vacation.Store, device string) error {
+	v, err := store.Get(device)
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		fmt.Println("No vacation set")
+		return nil
+	}
+
+	if err := store.Finish(ctx, client, v); err != nil {
+		return err
+	}
+	if v.Started() {
+		fmt.Println("Vacation cancelled; restored " + v.Prior.Tmode.String() + " mode")
+	} else {
+		fmt.Println("Vacation cancelled")
+	}
+	return nil
+}
+
+func vacation_summary(v *vacation.Vacation, unit ct50.Unit) string {
+	const layout = "Mon Jan 2 15:04"
+	s := "heat " + unit.Format(unit.Setpoint(v.Heat)) + ", cool " + unit.Format(unit.Setpoint(v.Cool)) +
+		" from " + v.From.Format(layout) + " to " + v.To.Format(layout)
+	if v.Recovery > 0 {
+		s += ", recovering from " + v.RecoverAt().Format(layout)
+	}
+	return s
+}